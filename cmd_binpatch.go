@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/binpatch"
+)
+
+var binpatchCmd = &cobra.Command{
+	Use:   "binpatch",
+	Short: "IPS/BPS binary patch utilities",
+	Long:  "Create and apply patches in the IPS and BPS formats, against whole images or files extracted with 'efi extract' / 'efi get'.",
+}
+
+var binpatchFormat string
+
+var binpatchApplyCmd = &cobra.Command{
+	Use:   "apply [input] [patch] [output]",
+	Short: "Apply an IPS or BPS patch",
+	Long:  "Apply an IPS or BPS patch (detected automatically) to a file.",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("could not read input: %w", err)
+		}
+		patch, err := os.ReadFile(args[1])
+		if err != nil {
+			return fmt.Errorf("could not read patch: %w", err)
+		}
+		res, err := binpatch.Apply(src, patch)
+		if err != nil {
+			return fmt.Errorf("could not apply patch: %w", err)
+		}
+		if err := os.WriteFile(args[2], res, 0600); err != nil {
+			return fmt.Errorf("could not write output: %w", err)
+		}
+		return nil
+	},
+}
+
+var binpatchCreateCmd = &cobra.Command{
+	Use:   "create [original] [modified] [patch]",
+	Short: "Create an IPS or BPS patch",
+	Long:  "Create a patch which turns the original file into the modified file.",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("could not read original: %w", err)
+		}
+		dst, err := os.ReadFile(args[1])
+		if err != nil {
+			return fmt.Errorf("could not read modified: %w", err)
+		}
+		var patch []byte
+		switch binpatchFormat {
+		case "ips":
+			patch, err = binpatch.CreateIPS(src, dst)
+			if err != nil {
+				return fmt.Errorf("could not create patch: %w", err)
+			}
+		case "bps":
+			patch = binpatch.CreateBPS(src, dst)
+		default:
+			return fmt.Errorf("unknown patch format %q", binpatchFormat)
+		}
+		if err := os.WriteFile(args[2], patch, 0600); err != nil {
+			return fmt.Errorf("could not write patch: %w", err)
+		}
+		return nil
+	},
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
-	"time"
+	"path/filepath"
+	"strings"
 
-	"github.com/freemyipod/wInd3x/pkg/exploit/decrypt"
-	"github.com/freemyipod/wInd3x/pkg/image"
 	"github.com/golang/glog"
+	"github.com/hashicorp/go-multierror"
 	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/exploit/decrypt"
+	"github.com/freemyipod/wInd3x/pkg/img1"
 )
 
 var decryptRecovery string
@@ -18,132 +21,180 @@ var decryptRecovery string
 var decryptCmd = &cobra.Command{
 	Use:   "decrypt [input] [output]",
 	Short: "Decrypt DFU image",
-	Long:  "Uses a connected device to decrypt a DFU image into a Haxed DFU compatible plaintext DFU image.",
-	Args:  cobra.ExactArgs(2),
+	Long: `Uses a connected device to decrypt a DFU image into a Haxed DFU compatible plaintext DFU image.
+
+If input is a directory (eg. an unpacked IPSW), all encrypted images for the
+connected device within it are decrypted instead, and written alongside the
+originals as <name>.dec<extension>.`,
+	Args: cobra.RangeArgs(1, 2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		f, err := os.Open(args[0])
+		st, err := os.Stat(args[0])
 		if err != nil {
 			return fmt.Errorf("could not open input: %w", err)
 		}
+		if st.IsDir() {
+			if len(args) != 1 {
+				return fmt.Errorf("output cannot be given when decrypting a directory")
+			}
+			if decryptRecovery != "" {
+				return fmt.Errorf("recovery cannot be used when decrypting a directory")
+			}
+			return decryptDir(cmd.Context(), args[0])
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("output must be given")
+		}
 
-		img, err := image.Read(f)
+		img, err := readIMG1File(args[0])
 		if err != nil {
-			return fmt.Errorf("could not read image: %w", err)
+			return err
+		}
+		if !img.Encrypted() {
+			return fmt.Errorf("can only decrypt encrypted images")
 		}
+		glog.Infof("Parsed %s image.", img.Kind)
 
-		app, err := newApp()
+		app, err := newExploitApp()
 		if err != nil {
 			return err
 		}
 		defer app.close()
 
-		if app.desc.Kind != img.DeviceKind {
-			return fmt.Errorf("image is for %s, but %s is connected", img.DeviceKind, app.desc.Kind)
-		}
-
-		glog.Infof("Decrypting 0x%x bytes...", len(img.Body))
-
-		w := bytes.NewBuffer(nil)
-
-		// Create a temporary file that we can use to continue decryption from
-		// after restarting the program.
-		var recovery io.WriteCloser
-		if decryptRecovery != "" {
-			st, err := os.Stat(decryptRecovery)
-			if err == nil {
-				glog.Infof("Using recovery buffer at %s...", decryptRecovery)
-				sz := st.Size()
-				if (sz % 0x30) != 0 {
-					return fmt.Errorf("recovery buffer invalid size (%x)", sz)
-				}
-				f, err := os.Open(decryptRecovery)
-				if err != nil {
-					return fmt.Errorf("could not open recovery buffer: %w", err)
-				}
-				if _, err := io.Copy(w, f); err != nil {
-					return fmt.Errorf("could not read recovery buffer: %w", err)
-				}
-				f.Close()
-			} else if os.IsNotExist(err) {
-				glog.Infof("Creating recovery buffer at %s...", decryptRecovery)
-			} else {
-				return fmt.Errorf("could not access recoveyr buffer: %w", err)
-			}
-			recovery, err = os.OpenFile(decryptRecovery, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				return fmt.Errorf("could not open recovery buffer for append: %w", err)
-			}
+		if app.desc.Kind != img.Kind {
+			return fmt.Errorf("image is for %s, but %s is connected", img.Kind, app.desc.Kind)
 		}
 
-		ix := w.Len()
-		for {
-			glog.Infof("Decrypting 0x%x (%.3f%%)...", ix, float64(ix*100)/float64(len(img.Body)))
+		wrapped, err := decryptImage(cmd.Context(), app, img, decryptRecovery)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[1], wrapped, 0600); err != nil {
+			return fmt.Errorf("could not write image: %w", err)
+		}
 
-			// Get plaintext block, pad to 0x30.
-			ixe := ix + 0x30
-			if ixe > len(img.Body) {
-				ixe = len(img.Body)
-			}
-			b := img.Body[ix:ixe]
-			b = append(b, bytes.Repeat([]byte{0}, 0x30-len(b))...)
-
-			tries := 10
-			var res []byte
-			for {
-				data := make([]byte, 0x40)
-				// We need to feed the previous 0x10 bytes of ciphertext for...
-				// some reason. Unless we're the first block.
-				if ix == 0 {
-					copy(data[:0x30], b)
-				} else {
-					copy(data[:0x10], img.Body[ix-0x10:ix])
-					copy(data[0x10:0x40], b)
-				}
-
-				res, err = decrypt.Trigger(app.usb, app.ep, data)
-				if err == nil {
-					break
-				}
-				if tries < 1 {
-					return fmt.Errorf("decryption failed, and out of retries: %w", err)
-				} else {
-					glog.Infof("Decryption failed (%v), retrying...", err)
-					time.Sleep(100 * time.Millisecond)
-					tries -= 1
-				}
-			}
+		glog.Infof("Done!")
 
-			plaintext := res[0x10:0x40]
-			if ix == 0 {
-				plaintext = res[0x00:0x30]
-			}
-			if recovery != nil {
-				if _, err := recovery.Write(plaintext); err != nil {
-					return fmt.Errorf("write to recovery failed: %w", err)
-				}
-			}
-			if _, err := w.Write(plaintext); err != nil {
-				return fmt.Errorf("write failed: %w", err)
-			}
+		return nil
+	},
+}
 
-			ix += 0x30
-			if ix >= len(img.Body) {
-				break
+// decryptImage decrypts an image with a connected device, optionally using a
+// recovery buffer, and returns it wrapped into a plaintext DFU image.
+func decryptImage(ctx context.Context, app *app, img *img1.Image, recoveryPath string) ([]byte, error) {
+	glog.Infof("Decrypting 0x%x bytes...", len(img.Body))
+
+	// Use a temporary file that we can use to continue decryption from
+	// after restarting the program.
+	var done []byte
+	var onChunk func([]byte) error
+	if recoveryPath != "" {
+		var err error
+		done, err = os.ReadFile(recoveryPath)
+		if err == nil {
+			glog.Infof("Using recovery buffer at %s...", recoveryPath)
+		} else if os.IsNotExist(err) {
+			glog.Infof("Creating recovery buffer at %s...", recoveryPath)
+		} else {
+			return nil, fmt.Errorf("could not access recovery buffer: %w", err)
+		}
+		recovery, err := os.OpenFile(recoveryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open recovery buffer for append: %w", err)
+		}
+		defer recovery.Close()
+		onChunk = func(plaintext []byte) error {
+			if _, err := recovery.Write(plaintext); err != nil {
+				return fmt.Errorf("write to recovery failed: %w", err)
 			}
+			return nil
 		}
+	}
+
+	plaintext, err := decrypt.Body(ctx, app.usb, app.ep, img.Body, done, onChunk)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := img1.NewUnsigned(img.Kind, img.Header.Entrypoint, plaintext[:len(img.Body)]).Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("could not make image: %w", err)
+	}
+	return wrapped, nil
+}
+
+// decryptedPath returns the path at which the decrypted copy of an image is
+// written when decrypting a directory, eg. WTF.x1225.release.dec.dfu.
+func decryptedPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".dec" + ext
+}
 
-		// Write image.
-		wrapped, err := image.MakeUnsigned(img.DeviceKind, img.Header.Entrypoint, w.Bytes())
+// decryptDir decrypts all encrypted images for the connected device within a
+// directory tree. Images which were already decrypted are skipped, so that an
+// interrupted run can be continued.
+func decryptDir(ctx context.Context, dir string) error {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return fmt.Errorf("could not make image: %w", err)
+			return err
 		}
-
-		if err := os.WriteFile(args[1], wrapped, 0600); err != nil {
-			return fmt.Errorf("could not write image: %w", err)
+		if d.Type().IsRegular() && !strings.HasSuffix(strings.TrimSuffix(path, filepath.Ext(path)), ".dec") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not walk %s: %w", dir, err)
+	}
+
+	app, err := newExploitApp()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	var errs error
+	decrypted := 0
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return multierror.Append(errs, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		img, err := img1.Parse(data)
+		if err != nil {
+			glog.V(1).Infof("%s: skipping, not an IMG1 image: %v", path, err)
+			continue
+		}
+		if !img.Encrypted() {
+			glog.V(1).Infof("%s: skipping, not encrypted", path)
+			continue
+		}
+		if img.Kind != app.desc.Kind {
+			glog.Infof("%s: skipping, image is for %s, but %s is connected", path, img.Kind, app.desc.Kind)
+			continue
+		}
+		out := decryptedPath(path)
+		if _, err := os.Stat(out); err == nil {
+			glog.Infof("%s: skipping, already decrypted to %s", path, out)
+			continue
 		}
 
-		glog.Infof("Done!")
+		glog.Infof("%s: decrypting to %s...", path, out)
+		wrapped, err := decryptImage(ctx, app, img, "")
+		if err == nil {
+			err = os.WriteFile(out, wrapped, 0600)
+		}
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		decrypted += 1
+	}
 
-		return nil
-	},
+	glog.Infof("Decrypted %d images.", decrypted)
+	return errs
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+	"github.com/freemyipod/wInd3x/pkg/sniff"
+)
+
+var dfuCmd = &cobra.Command{
+	Use:   "dfu",
+	Short: "DFU image utilities",
+}
+
+// parseDeviceKind parses a device kind as given on the command line, eg. n4g.
+func parseDeviceKind(s string) (devices.Kind, error) {
+	if k, ok := devices.Aliases[strings.ToLower(s)]; ok {
+		return k, nil
+	}
+	var names []string
+	seen := make(map[devices.Kind]bool)
+	for _, d := range devices.Descriptions {
+		if strings.ToLower(s) == string(d.Kind) {
+			return d.Kind, nil
+		}
+		if !seen[d.Kind] {
+			names = append(names, string(d.Kind))
+			seen[d.Kind] = true
+		}
+	}
+	var aliases []string
+	for alias := range devices.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	names = append(names, aliases...)
+	return "", fmt.Errorf("device kind must be one of: %s", strings.Join(names, ", "))
+}
+
+// dfuSuffix returns the DFU suffix for images for a given device.
+func dfuSuffix(kind devices.Kind) (dfu.Suffix, error) {
+	for _, d := range devices.Descriptions {
+		if d.Kind == kind {
+			return dfu.Suffix{
+				Device:  dfu.SuffixWildcard,
+				Product: uint16(d.DFUPID),
+				Vendor:  uint16(d.DFUVID),
+				DFU:     0x0100,
+			}, nil
+		}
+	}
+	return dfu.Suffix{}, fmt.Errorf("unknown device %s", kind)
+}
+
+// wrapPayload wraps a raw payload into an unsigned and unencrypted DFU image
+// for a device, with the entrypoint given as an offset within the payload.
+func wrapPayload(kind devices.Kind, entrypoint uint32, data []byte) ([]byte, error) {
+	var wrapped []byte
+	var err error
+	if kind == devices.S5L8900 {
+		if entrypoint != 0 {
+			return nil, fmt.Errorf("8900 images must start at their entrypoint")
+		}
+		wrapped, err = img1.NewUnsigned8900(data).Serialize()
+	} else {
+		wrapped, err = img1.NewUnsigned(kind, entrypoint, data).Serialize()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not make image: %w", err)
+	}
+	return wrapped, nil
+}
+
+var (
+	dfuWrapKind       string
+	dfuWrapEntrypoint string
+	dfuWrapSuffix     bool
+)
+
+var dfuWrapCmd = &cobra.Command{
+	Use:   "wrap [input] [output]",
+	Short: "Wrap a raw payload into a DFU image",
+	Long: `Wrap a flat binary into an unsigned and unencrypted DFU image for a given
+device, using the image header variant of its SoC, to use with haxdfu/run.
+
+The entrypoint can be given either as an offset within the payload, or as an
+absolute address if the load address of DFU images on the device is known.
+
+With --suffix, a standard DFU file suffix is appended, so that the image can be
+checked and sent by other DFU tooling.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, err := parseDeviceKind(dfuWrapKind)
+		if err != nil {
+			return err
+		}
+		variant, err := img1.VariantForKind(kind)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("could not read input: %w", err)
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("input is empty")
+		}
+		if f := sniff.Sniff(data); f != sniff.Raw {
+			return formatError(args[0], "a raw payload", f)
+		}
+
+		entrypoint, err := parseNumber(dfuWrapEntrypoint)
+		if err != nil {
+			return fmt.Errorf("invalid entrypoint")
+		}
+		if variant.LoadAddress != 0 && entrypoint >= variant.LoadAddress {
+			entrypoint -= variant.LoadAddress
+		}
+		if int(entrypoint) >= len(data) {
+			return fmt.Errorf("entrypoint 0x%x outside of payload (0x%x bytes)", entrypoint, len(data))
+		}
+
+		wrapped, err := wrapPayload(kind, entrypoint, data)
+		if err != nil {
+			return err
+		}
+		if dfuWrapSuffix {
+			suffix, err := dfuSuffix(kind)
+			if err != nil {
+				return err
+			}
+			wrapped = dfu.AppendSuffix(wrapped, suffix)
+		}
+		if err := os.WriteFile(args[1], wrapped, 0600); err != nil {
+			return fmt.Errorf("could not write image: %w", err)
+		}
+		if variant.LoadAddress != 0 {
+			glog.Infof("Wrapped 0x%x bytes for %s, loaded at 0x%08x, entrypoint 0x%08x.", len(data), kind, variant.LoadAddress, variant.LoadAddress+entrypoint)
+		} else {
+			glog.Infof("Wrapped 0x%x bytes for %s, entrypoint at offset 0x%x.", len(data), kind, entrypoint)
+		}
+		return nil
+	},
+}
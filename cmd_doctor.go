@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/golang/glog"
+	"github.com/google/gousb"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+var (
+	doctorFixPermissions bool
+	doctorUdevPath       string
+)
+
+// accessHint adds a hint about how to fix permissions to errors caused by
+// lacking access to a device.
+func accessHint(err error) error {
+	if !errors.Is(err, gousb.ErrorAccess) {
+		return err
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("%w (access to the device was denied)", err)
+	}
+	return fmt.Errorf("%w (access to the device was denied, run 'wInd3x doctor --fix-permissions' as root to install udev rules for it)", err)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose device access issues",
+	Long: `Check that libusb works and that all connected Apple devices in a known mode can
+be opened, reporting those which cannot be accessed.
+
+On Linux, accessing devices as a regular user requires udev rules. With
+--fix-permissions, rules for all known devices in DFU and recovery mode are
+written to --udev-rules (which usually requires root), or printed if they
+cannot be written.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if doctorFixPermissions {
+			return fixPermissions()
+		}
+
+		ctx, err := newContext()
+		if err != nil {
+			return fmt.Errorf("failed to initialize USB, is libusb installed? %w", err)
+		}
+		defer ctx.Close()
+		fmt.Println("libusb: ok")
+
+		var descs []*gousb.DeviceDesc
+		// Only enumerate, opening devices one by one below to report errors
+		// per device.
+		if _, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+			if devices.ModeOf(desc) != "" {
+				descs = append(descs, desc)
+			}
+			return false
+		}); err != nil {
+			return fmt.Errorf("could not enumerate devices: %w", err)
+		}
+		if len(descs) == 0 {
+			fmt.Println("No devices found. Check the cable, and that the device is in DFU mode.")
+			return nil
+		}
+
+		denied := 0
+		for _, desc := range descs {
+			name := fmt.Sprintf("%d.%d %s:%s (%s mode)", desc.Bus, desc.Address, desc.Vendor, desc.Product, devices.ModeOf(desc))
+			devs, err := ctx.OpenDevices(func(d *gousb.DeviceDesc) bool {
+				return d.Bus == desc.Bus && d.Address == desc.Address
+			})
+			for _, dev := range devs {
+				dev.Close()
+			}
+			switch {
+			case errors.Is(err, gousb.ErrorAccess):
+				fmt.Printf("%s: access denied\n", name)
+				denied++
+			case err != nil:
+				fmt.Printf("%s: %v\n", name, err)
+			case len(devs) == 0:
+				fmt.Printf("%s: disconnected\n", name)
+			default:
+				fmt.Printf("%s: ok\n", name)
+			}
+		}
+		if denied > 0 && runtime.GOOS == "linux" {
+			fmt.Println("Run 'wInd3x doctor --fix-permissions' as root to install udev rules, then reconnect the devices.")
+		}
+		return nil
+	},
+}
+
+// fixPermissions installs udev rules for all known devices.
+func fixPermissions() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("udev rules are only used on Linux")
+	}
+	rules := devices.UdevRules()
+	if err := os.WriteFile(doctorUdevPath, []byte(rules), 0644); err != nil {
+		glog.Errorf("Could not write %s: %v", doctorUdevPath, err)
+		fmt.Printf("Write the following rules to %s as root:\n\n%s\n", doctorUdevPath, rules)
+		fmt.Println("Then, run 'udevadm control --reload-rules && udevadm trigger' and reconnect the devices.")
+		return nil
+	}
+	glog.Infof("Wrote %s.", doctorUdevPath)
+	fmt.Println("Run 'udevadm control --reload-rules && udevadm trigger' and reconnect the devices.")
+	return nil
+}
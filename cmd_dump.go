@@ -17,7 +17,7 @@ var dumpCmd = &cobra.Command{
 	Long:  "Read memory from a connected device and write results to a file. Not very fast.",
 	Args:  cobra.ExactArgs(3),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		app, err := newApp()
+		app, err := newExploitApp()
 		if err != nil {
 			return err
 		}
@@ -40,6 +40,9 @@ var dumpCmd = &cobra.Command{
 
 		start := time.Now()
 		for i := uint32(0); i < size; i += 0x40 {
+			if err := cmd.Context().Err(); err != nil {
+				return err
+			}
 			o := offset + i
 			glog.Infof("Dumping %x...", o)
 			data, err := dumpmem.Trigger(app.usb, app.ep, o)
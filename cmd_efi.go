@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/efi"
+	"github.com/freemyipod/wInd3x/pkg/fwdb"
+	"github.com/freemyipod/wInd3x/pkg/image"
+	"github.com/freemyipod/wInd3x/pkg/sniff"
+)
+
+var efiCmd = &cobra.Command{
+	Use:   "efi",
+	Short: "EFI firmware volume utilities",
+}
+
+var (
+	efiStrict   bool
+	efiListJSON bool
+	efiMaxSize  int
+	efiPreserve bool
+	efiJobs     int
+	efiAlign    bool
+	efiLazy     bool
+)
+
+func readVolumeFile(path string) (*efi.Volume, error) {
+	f, err := efi.MapFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read volume: %w", err)
+	}
+	defer f.Close()
+	vol, err := efi.ReadVolume(f.Reader(), &efi.ParseOptions{
+		Strict:         efiStrict,
+		PreserveLayout: efiPreserve,
+		Parallelism:    efiJobs,
+		Lazy:           efiLazy,
+	})
+	if err != nil {
+		if err := wrongFileFormat(path, "a firmware volume", isFirmwareVolume); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not parse volume: %w", err)
+	}
+	for _, w := range vol.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	vol.RegisterNames()
+	return vol, nil
+}
+
+func isFirmwareVolume(f sniff.Format) bool {
+	return f == sniff.FirmwareVolume
+}
+
+// writeVolumeFile serializes vol straight into a file at path.
+func writeVolumeFile(path string, vol *efi.Volume) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("could not create volume: %w", err)
+	}
+	vol.MaxSize = efiMaxSize
+	vol.AlignData = efiAlign
+	w := bufio.NewWriter(f)
+	if err := vol.SerializeTo(w); err != nil {
+		f.Close()
+		return fmt.Errorf("could not serialize volume: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write volume: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write volume: %w", err)
+	}
+	return nil
+}
+
+var efiListCmd = &cobra.Command{
+	Use:   "list [volume]",
+	Short: "List contents of an EFI firmware volume",
+	Long:  "List files and sections within a raw EFI firmware volume as a tree, with their offsets and sizes, naming well-known GUIDs.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		if efiListJSON {
+			data, err := json.MarshalIndent(vol, "", "  ")
+			if err != nil {
+				return fmt.Errorf("could not marshal volume: %w", err)
+			}
+			fmt.Printf("%s\n", data)
+			return nil
+		}
+		vol.PrintTree(os.Stdout)
+		usage, err := vol.SpaceUsage()
+		if err != nil {
+			return fmt.Errorf("could not calculate space usage: %w", err)
+		}
+		fmt.Printf("Size: 0x%x bytes, free: 0x%x bytes\n", usage.Total, usage.Free)
+		return nil
+	},
+}
+
+var efiDiffCmd = &cobra.Command{
+	Use:   "diff [volume a] [volume b]",
+	Short: "Compare two EFI firmware volumes",
+	Long:  "Compare two raw EFI firmware volumes (eg. stock and patched), listing added, removed and changed files and the byte ranges that differ within their sections.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		b, err := readVolumeFile(args[1])
+		if err != nil {
+			return err
+		}
+		diff, err := efi.Diff(a, b)
+		if err != nil {
+			return fmt.Errorf("could not diff volumes: %w", err)
+		}
+		if diff.Empty() {
+			fmt.Printf("Volumes are the same.\n")
+			return nil
+		}
+		printVolumeDiff(diff, "")
+		return nil
+	},
+}
+
+// printVolumeDiff prints the differences between two volumes, with nested
+// volumes indented further.
+func printVolumeDiff(diff *efi.VolumeDiff, indent string) {
+	for _, f := range diff.Files {
+		fmt.Printf("%s%s %s (%s)\n", indent, f.Kind, f.GUID.Name(), f.Type)
+		if f.HeaderChanged {
+			fmt.Printf("%s  header changed\n", indent)
+		}
+		for _, s := range f.Sections {
+			path := make([]string, len(s.Path))
+			for i, p := range s.Path {
+				path[i] = fmt.Sprintf("%d", p)
+			}
+			fmt.Printf("%s  section %s (%s) %s", indent, strings.Join(path, "/"), s.Type, s.Kind)
+			for _, r := range s.Ranges {
+				fmt.Printf(" 0x%x-0x%x", r.Offset, r.Offset+r.Length)
+			}
+			fmt.Printf("\n")
+			if s.Volume != nil {
+				printVolumeDiff(s.Volume, indent+"    ")
+			}
+		}
+	}
+	if diff.CustomChanged {
+		fmt.Printf("%strailing data changed\n", indent)
+	}
+}
+
+var efiExtractCmd = &cobra.Command{
+	Use:   "extract [volume] [directory]",
+	Short: "Extract sections of an EFI firmware volume to a directory",
+	Long:  "Extract the data of all leaf sections (PE32, TE, raw, ...) of a raw EFI firmware volume into a directory tree mirroring its files and sections, alongside a manifest. These can then be modified and reassembled with 'efi rebuild'.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("could not read volume: %w", err)
+		}
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		if err := efi.Extract(vol, data, args[1]); err != nil {
+			return fmt.Errorf("could not extract volume: %w", err)
+		}
+		return nil
+	},
+}
+
+var efiRebuildCmd = &cobra.Command{
+	Use:   "rebuild [directory] [volume]",
+	Short: "Rebuild an EFI firmware volume from an extracted directory",
+	Long:  "Reassemble a raw EFI firmware volume from a directory created by 'efi extract', using the (possibly modified) section files within it.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := efi.Rebuild(args[0])
+		if err != nil {
+			return fmt.Errorf("could not rebuild volume: %w", err)
+		}
+		if err := os.WriteFile(args[1], data, 0600); err != nil {
+			return fmt.Errorf("could not write volume: %w", err)
+		}
+		return nil
+	},
+}
+
+// elementData returns the data of a file or section addressed by a path
+// expression: the inner data of leaf sections, or the serialized element
+// otherwise.
+func elementData(el *efi.Element) ([]byte, error) {
+	if el.Section == nil {
+		return el.File.Serialize()
+	}
+	if raw := el.Section.Raw(); raw != nil {
+		return raw, nil
+	}
+	return el.Section.Serialize()
+}
+
+var efiGetCmd = &cobra.Command{
+	Use:   "get [volume] [path] [output]",
+	Short: "Extract a single file or section from an EFI firmware volume",
+	Long:  "Extract a file or section addressed by a path expression (eg. 'name:DxeCore/section:pe32' or '<guid>/0/1') from a raw EFI firmware volume. Leaf sections are written without their header.",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		el, err := vol.Lookup(args[1])
+		if err != nil {
+			return fmt.Errorf("could not find %q: %w", args[1], err)
+		}
+		data, err := elementData(el)
+		if err != nil {
+			return fmt.Errorf("could not serialize %q: %w", args[1], err)
+		}
+		if err := os.WriteFile(args[2], data, 0600); err != nil {
+			return fmt.Errorf("could not write output: %w", err)
+		}
+		return nil
+	},
+}
+
+var efiReplaceCmd = &cobra.Command{
+	Use:   "replace [volume] [path] [data] [output]",
+	Short: "Replace the data of a single section in an EFI firmware volume",
+	Long:  "Replace the data of a leaf section (PE32, TE, raw, ...) addressed by a path expression (eg. 'name:DxeCore/section:pe32') in a raw EFI firmware volume, and write the resulting volume.",
+	Args:  cobra.ExactArgs(4),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		el, err := vol.Lookup(args[1])
+		if err != nil {
+			return fmt.Errorf("could not find %q: %w", args[1], err)
+		}
+		if el.Section == nil || el.Section.Raw() == nil {
+			return fmt.Errorf("%q is not a leaf section", args[1])
+		}
+		data, err := os.ReadFile(args[2])
+		if err != nil {
+			return fmt.Errorf("could not read data: %w", err)
+		}
+		el.Section.SetRaw(data)
+		return writeVolumeFile(args[3], vol)
+	},
+}
+
+var efiGrepString bool
+
+var efiGrepCmd = &cobra.Command{
+	Use:   "grep [volume] [pattern]",
+	Short: "Search for a byte pattern in an EFI firmware volume",
+	Long:  "Search for a hex byte pattern (or a string, with --string) within the (decompressed) sections and raw files of a raw EFI firmware volume. Matches are printed with a path usable with 'efi get' and 'efi replace'.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pattern := []byte(args[1])
+		if !efiGrepString {
+			var err error
+			pattern, err = hex.DecodeString(strings.ReplaceAll(args[1], " ", ""))
+			if err != nil {
+				return fmt.Errorf("invalid hex pattern: %w", err)
+			}
+		}
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		matches, err := vol.Search(pattern)
+		if err != nil {
+			return fmt.Errorf("could not search volume: %w", err)
+		}
+		for _, m := range matches {
+			path := make([]string, len(m.Path))
+			for i, p := range m.Path {
+				path[i] = fmt.Sprintf("%d", p)
+			}
+			kind := m.File.FileType.String()
+			if m.Section != nil {
+				kind = m.Section.Header().Type.String()
+			}
+			fmt.Printf("%s (%s, %s) +0x%x\n", strings.Join(path, "/"), m.File.GUID.Name(), kind, m.Offset)
+		}
+		return nil
+	},
+}
+
+var efiPatchSet string
+
+var efiPatchCmd = &cobra.Command{
+	Use:   "patch [volume] [patches] [output]",
+	Short: "Apply a patch set to an EFI firmware volume",
+	Long: `Apply a JSON list of patches ({file, section, offset, old, new}, with hex data)
+to a raw EFI firmware volume. Nothing is written unless all patches match the
+expected old data.
+
+With --set, no patches file is given. Instead, the firmware version of the
+volume is identified (see 'identify' and --firmware-db), and the patch set of
+that name for the version is applied.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if efiPatchSet != "" {
+			return cobra.ExactArgs(2)(cmd, args)
+		}
+		return cobra.ExactArgs(3)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		var patches []efi.Patch
+		if efiPatchSet != "" {
+			if patches, err = selectPatchSet(args[0], efiPatchSet); err != nil {
+				return err
+			}
+		} else {
+			data, err := os.ReadFile(args[1])
+			if err != nil {
+				return fmt.Errorf("could not read patches: %w", err)
+			}
+			if err := json.Unmarshal(data, &patches); err != nil {
+				return fmt.Errorf("could not parse patches: %w", err)
+			}
+		}
+		if err := vol.ApplyPatches(patches); err != nil {
+			return fmt.Errorf("could not apply patches: %w", err)
+		}
+		return writeVolumeFile(args[len(args)-1], vol)
+	},
+}
+
+// selectPatchSet returns the patch set of a given name for the firmware
+// version of a volume.
+func selectPatchSet(path, name string) ([]efi.Patch, error) {
+	db, err := loadFirmwareDB()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read volume: %w", err)
+	}
+	v, patches, err := db.PatchSet(data, name)
+	if errors.Is(err, fwdb.ErrUnknownVersion) && len(db.Versions) == 0 {
+		return nil, fmt.Errorf("could not select patch set: no firmware versions are known, load some with --firmware-db")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not select patch set: %w", err)
+	}
+	glog.Infof("Identified %s as %s, applying patch set %q.", path, v, name)
+	return patches, nil
+}
+
+var efiLogoCmd = &cobra.Command{
+	Use:   "logo",
+	Short: "Boot logo and picture utilities",
+	Long:  "Export and replace picture resources (eg. boot logos) stored as BMP or PNG within an EFI firmware volume.",
+}
+
+var efiLogoExportCmd = &cobra.Command{
+	Use:   "export [volume] [directory]",
+	Short: "Export all pictures as PNG files",
+	Long:  "Decode all pictures within a raw EFI firmware volume and write them as PNG files into a directory, named after their path.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		pictures, err := vol.Pictures()
+		if err != nil {
+			return fmt.Errorf("could not find pictures: %w", err)
+		}
+		if err := os.MkdirAll(args[1], 0755); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+		for _, p := range pictures {
+			parts := make([]string, len(p.Path))
+			for i, e := range p.Path {
+				parts[i] = fmt.Sprintf("%d", e)
+			}
+			path := strings.Join(parts, "/")
+			img, err := p.Decode()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s: could not decode %s picture: %v\n", path, p.Format, err)
+				continue
+			}
+			buf := bytes.NewBuffer(nil)
+			if err := png.Encode(buf, img); err != nil {
+				return fmt.Errorf("%s: could not encode PNG: %w", path, err)
+			}
+			name := filepath.Join(args[1], strings.Join(parts, ".")+".png")
+			if err := os.WriteFile(name, buf.Bytes(), 0600); err != nil {
+				return fmt.Errorf("could not write picture: %w", err)
+			}
+			b := img.Bounds()
+			fmt.Printf("%s (%s, %s, %dx%d) -> %s\n", path, p.File.GUID.Name(), p.Format, b.Dx(), b.Dy(), name)
+		}
+		return nil
+	},
+}
+
+var efiLogoImportCmd = &cobra.Command{
+	Use:   "import [volume] [path] [image] [output]",
+	Short: "Replace a picture with an image",
+	Long:  "Replace the picture at a path (as printed by 'efi logo export') with a PNG, re-encoding it in the format of the original picture.",
+	Args:  cobra.ExactArgs(4),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		el, err := vol.Lookup(args[1])
+		if err != nil {
+			return fmt.Errorf("could not find %q: %w", args[1], err)
+		}
+		pictures, err := vol.Pictures()
+		if err != nil {
+			return fmt.Errorf("could not find pictures: %w", err)
+		}
+		var picture *efi.Picture
+		for i, p := range pictures {
+			if p.File == el.File && p.Section == el.Section {
+				picture = &pictures[i]
+				break
+			}
+		}
+		if picture == nil {
+			return fmt.Errorf("%q is not a picture", args[1])
+		}
+		f, err := os.Open(args[2])
+		if err != nil {
+			return fmt.Errorf("could not open image: %w", err)
+		}
+		defer f.Close()
+		img, err := png.Decode(f)
+		if err != nil {
+			return fmt.Errorf("could not decode image: %w", err)
+		}
+		if err := picture.Replace(img); err != nil {
+			return fmt.Errorf("could not replace picture: %w", err)
+		}
+		return writeVolumeFile(args[3], vol)
+	},
+}
+
+var efiScanCmd = &cobra.Command{
+	Use:   "scan [image]",
+	Short: "Find EFI firmware volumes within an image",
+	Long:  "Find and parse all EFI firmware volumes within a larger image, eg. a full NOR dump, printing their offsets and sizes.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := efi.MapFile(args[0])
+		if err != nil {
+			return fmt.Errorf("could not read image: %w", err)
+		}
+		defer f.Close()
+		volumes, err := efi.ScanVolumes(f.Reader(), &efi.ParseOptions{
+			Strict:      efiStrict,
+			Parallelism: efiJobs,
+		})
+		if err != nil {
+			return fmt.Errorf("could not scan image: %w", err)
+		}
+		for _, sv := range volumes {
+			fmt.Printf("%08x %08x Volume %s, %d files\n", sv.Offset, sv.Size, sv.Volume.GUID.Name(), len(sv.Volume.Files))
+		}
+		return nil
+	},
+}
+
+var efiTrailerIMG1 string
+
+var efiTrailerCmd = &cobra.Command{
+	Use:   "trailer [volume]",
+	Short: "Show the signature and certificates after an EFI firmware volume",
+	Long:  "Parse the trailing signature and certificate chain after a raw EFI firmware volume. With --img1, the layout is taken from (and validated against) the header of the IMG1 image the volume was extracted from.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vol, err := readVolumeFile(args[0])
+		if err != nil {
+			return err
+		}
+		var t *efi.Trailer
+		if efiTrailerIMG1 != "" {
+			f, err := os.Open(efiTrailerIMG1)
+			if err != nil {
+				return fmt.Errorf("could not open IMG1: %w", err)
+			}
+			defer f.Close()
+			var hdr image.IMG1Header
+			if err := binary.Read(f, binary.LittleEndian, &hdr); err != nil {
+				return fmt.Errorf("could not read IMG1 header: %w", err)
+			}
+			t, err = vol.TrailerWithLayout(efi.TrailerLayout{
+				BodyLength:       hdr.BodyLength,
+				FooterCertOffset: hdr.FooterCertOffset,
+				FooterCertLength: hdr.FooterCertLength,
+			})
+			if err != nil {
+				return fmt.Errorf("trailer does not match IMG1 header: %w", err)
+			}
+		} else {
+			t, err = vol.Trailer()
+			if err != nil {
+				return fmt.Errorf("could not parse trailer: %w", err)
+			}
+		}
+		fmt.Printf("Padding: %d bytes\n", len(t.Padding))
+		fmt.Printf("Signature: %s\n", hex.EncodeToString(t.Signature))
+		certs, err := t.ParsedCertificates()
+		if err != nil {
+			return err
+		}
+		for i, c := range certs {
+			fmt.Printf("Certificate %d: subject %q, issuer %q\n", i, c.Subject.String(), c.Issuer.String())
+		}
+		if len(t.CertPadding) > 0 {
+			fmt.Printf("Certificate padding: %d bytes\n", len(t.CertPadding))
+		}
+		return nil
+	},
+}
+
+var efiVerifyCmd = &cobra.Command{
+	Use:   "verify [volume]",
+	Short: "Check the integrity of an EFI firmware volume",
+	Long:  "Check all volume header, file and CRC32 section checksums, blockmaps, file alignment and file state bits of a raw EFI firmware volume without modifying it, eg. before flashing it. Each check is printed with its result.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := efi.MapFile(args[0])
+		if err != nil {
+			return fmt.Errorf("could not read volume: %w", err)
+		}
+		defer f.Close()
+		report, err := efi.Verify(f.Reader(), &efi.ParseOptions{
+			Parallelism: efiJobs,
+		})
+		if err != nil {
+			return fmt.Errorf("could not parse volume: %w", err)
+		}
+		for _, c := range report.Checks {
+			result := "ok"
+			if !c.Passed() {
+				result = "FAIL"
+			}
+			fmt.Printf("%-4s %s\n", result, c.String())
+		}
+		if failed := len(report.Failed()); failed != 0 {
+			return fmt.Errorf("%d of %d checks failed", failed, len(report.Checks))
+		}
+		fmt.Printf("All %d checks passed\n", len(report.Checks))
+		return nil
+	},
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/exploit/encrypt"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+)
+
+var encryptRecovery string
+
+var encryptCmd = &cobra.Command{
+	Use:   "encrypt [input] [output]",
+	Short: "Encrypt DFU image",
+	Long: `Uses a connected device to encrypt a plaintext DFU image (eg. a patched image
+previously decrypted with 'decrypt') with the device's GID key.
+
+The result is not validly signed, as signing requires Apple's private key.
+It can be written back where an encrypted image is expected, but is only
+accepted by boot paths which do not verify signatures.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		img, err := readIMG1File(args[0])
+		if err != nil {
+			return err
+		}
+		if img.Encrypted() {
+			return fmt.Errorf("image is already encrypted")
+		}
+
+		app, err := newExploitApp()
+		if err != nil {
+			return err
+		}
+		defer app.close()
+
+		if app.desc.Kind != img.Kind {
+			return fmt.Errorf("image is for %s, but %s is connected", img.Kind, app.desc.Kind)
+		}
+
+		glog.Infof("Checking device encryption...")
+		if err := encrypt.Check(cmd.Context(), app.usb, app.ep); err != nil {
+			return fmt.Errorf("device encryption check failed: %w", err)
+		}
+
+		body := img.Body
+		if len(body)%16 != 0 {
+			body = append(append([]byte{}, body...), make([]byte, 16-len(body)%16)...)
+		}
+		glog.Infof("Encrypting 0x%x bytes...", len(body))
+
+		// Use a temporary file that we can use to continue encryption from
+		// after restarting the program.
+		var done []byte
+		var onChunk func([]byte) error
+		if encryptRecovery != "" {
+			done, err = os.ReadFile(encryptRecovery)
+			if err == nil {
+				glog.Infof("Using recovery buffer at %s...", encryptRecovery)
+			} else if os.IsNotExist(err) {
+				glog.Infof("Creating recovery buffer at %s...", encryptRecovery)
+			} else {
+				return fmt.Errorf("could not access recovery buffer: %w", err)
+			}
+			recovery, err := os.OpenFile(encryptRecovery, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return fmt.Errorf("could not open recovery buffer for append: %w", err)
+			}
+			defer recovery.Close()
+			onChunk = func(ciphertext []byte) error {
+				if _, err := recovery.Write(ciphertext); err != nil {
+					return fmt.Errorf("write to recovery failed: %w", err)
+				}
+				return nil
+			}
+		}
+
+		ciphertext, err := encrypt.Body(cmd.Context(), app.usb, app.ep, body, done, onChunk)
+		if err != nil {
+			return err
+		}
+
+		// Write image.
+		img.Body = ciphertext[:len(body)]
+		img.Header.Format = img1.FormatSignedEncrypted
+		wrapped, err := img.Serialize()
+		if err != nil {
+			return fmt.Errorf("could not make image: %w", err)
+		}
+		if err := os.WriteFile(args[1], wrapped, 0600); err != nil {
+			return fmt.Errorf("could not write image: %w", err)
+		}
+
+		glog.Infof("Done!")
+
+		return nil
+	},
+}
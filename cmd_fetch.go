@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/ipsw"
+)
+
+var (
+	fetchURL  string
+	fetchSHA1 string
+	fetchKind string
+	// fetchInsecure is set by --insecure-skip-verify, to extract archives
+	// without a known SHA-1.
+	fetchInsecure bool
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "Download and unpack a firmware archive",
+	Long: `Download an iPod firmware archive (IPSW) from Apple's servers, verify its
+SHA-1, and extract the WTF, Firmware.MSE and diagnostics images for a device
+generation into the local cache, for use with other commands.
+
+The device generation is taken from the connected device, unless --kind is
+given. The archive to download for it, and its SHA-1, are looked up in the
+firmware version database (see --firmware-db). Another archive can be given
+with --url and --sha1, eg. as listed in Apple's iTunes firmware catalog for the
+device. Archives are only extracted without a SHA-1 with
+--insecure-skip-verify. The paths of all extracted files are printed; the cache
+directory can be passed to 'decrypt' as a whole.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var kind devices.Kind
+		if fetchKind != "" {
+			var err error
+			if kind, err = parseDeviceKind(fetchKind); err != nil {
+				return err
+			}
+		} else {
+			app, err := newApp()
+			if err != nil {
+				return err
+			}
+			kind = app.desc.Kind
+			app.close()
+		}
+
+		url, sha1 := fetchURL, fetchSHA1
+		if url == "" {
+			db, err := loadFirmwareDB()
+			if err != nil {
+				return err
+			}
+			archive := db.Archive(kind)
+			if archive == nil {
+				return fmt.Errorf("no firmware archive known for %s, give one with --url and --sha1", kind)
+			}
+			glog.Infof("Using firmware archive %s.", archive)
+			url = archive.URL
+			if sha1 == "" {
+				sha1 = archive.SHA1[0]
+			}
+		}
+		if sha1 == "" && !fetchInsecure {
+			return fmt.Errorf("--sha1 must be given, so that the archive can be verified before it is extracted into the cache")
+		}
+		dir, err := ipsw.CacheDir(kind)
+		if err != nil {
+			return fmt.Errorf("could not determine cache directory: %w", err)
+		}
+
+		glog.Infof("Downloading %s...", url)
+		data, sum, err := ipsw.Download(url, sha1)
+		if err != nil {
+			return err
+		}
+		if sha1 == "" {
+			glog.Warningf("No SHA-1 given, extracting unverified archive with SHA-1 %s", sum)
+		}
+		files, err := ipsw.Extract(data, kind, dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			fmt.Println(f)
+		}
+		return os.WriteFile(filepath.Join(dir, "SOURCE"), []byte(fmt.Sprintf("%s %s\n", sum, url)), 0644)
+	},
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/fwdb"
+	"github.com/freemyipod/wInd3x/pkg/sniff"
+)
+
+// formatHints tell users what to do with a file of a given format.
+var formatHints = map[sniff.Format]string{
+	sniff.Raw:            "wrap raw payloads with 'dfu wrap' to run them",
+	sniff.FirmwareVolume: "use the 'efi' commands",
+	sniff.IMG1:           "use the 'img1' or 'wtf' commands, or 'run' it",
+	sniff.IMG1Encrypted:  "decrypt it with 'decrypt' or 'img1 decrypt' first",
+	sniff.IMG1Firmware:   "extract its firmware volume with 'img1 body' to use the 'efi' commands",
+	sniff.Image8900:      "'run' converts unencrypted 8900 images automatically",
+	sniff.IMG2:           "IMG2 images are only supported by the img2 package",
+	sniff.IMG3:           "IMG3 images are only supported by the img3 package",
+	sniff.MSE:            "use the 'mse' commands",
+}
+
+// wrongFormat returns an error for a file which could not be parsed as what
+// a command expected, pointing at what the file actually is, or nil if the
+// file is of the expected kind after all (and thus just malformed).
+func wrongFormat(path string, data []byte, want string, expected func(sniff.Format) bool) error {
+	f := sniff.Sniff(data)
+	if expected(f) {
+		return nil
+	}
+	return formatError(path, want, f)
+}
+
+// formatError returns an error for a file of format f given where want was
+// expected.
+func formatError(path, want string, f sniff.Format) error {
+	return fmt.Errorf("%s is not %s (detected: %s; %s)", path, want, f, formatHints[f])
+}
+
+// wrongFileFormat is wrongFormat for a file which has not been read yet.
+func wrongFileFormat(path string, want string, expected func(sniff.Format) bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return wrongFormat(path, data, want, expected)
+}
+
+var firmwareDBPaths []string
+
+// loadFirmwareDB returns the firmware version database, including any
+// versions loaded from files given with --firmware-db.
+func loadFirmwareDB() (*fwdb.DB, error) {
+	db := fwdb.Default()
+	for _, path := range firmwareDBPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open firmware database: %w", err)
+		}
+		err = db.Load(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return db, nil
+}
+
+var identifyCmd = &cobra.Command{
+	Use:   "identify [file...]",
+	Short: "Detect the format of files",
+	Long:  "Detect whether files are IMG1 images (eg. WTFs), firmware volumes, other containers or raw payloads, and show which commands handle them, as well as which known firmware version they are.",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := loadFirmwareDB()
+		if err != nil {
+			return err
+		}
+		for _, path := range args {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("could not read %s: %w", path, err)
+			}
+			f := sniff.Sniff(data)
+			fmt.Printf("%s: %s (%s)\n", path, f, formatHints[f])
+			if v := db.Identify(data); v != nil {
+				fmt.Printf("  version: %s\n", v)
+				if names := v.PatchSetNames(); len(names) > 0 {
+					fmt.Printf("  patch sets: %s (see 'efi patch --set')\n", strings.Join(names, ", "))
+				}
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/img1"
+	"github.com/freemyipod/wInd3x/pkg/sniff"
+)
+
+var img1Cmd = &cobra.Command{
+	Use:   "img1",
+	Short: "IMG1 (8900) image utilities",
+}
+
+func readIMG1File(path string) (*img1.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image: %w", err)
+	}
+	defer f.Close()
+	img, err := img1.Read(f)
+	if err != nil {
+		if err := wrongFileFormat(path, "an IMG1 image", sniff.Format.IsIMG1); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not parse image: %w", err)
+	}
+	return img, nil
+}
+
+var img1InfoCmd = &cobra.Command{
+	Use:   "info [image]",
+	Short: "Show the header of an IMG1 image",
+	Long:  "Parse an IMG1 (8900) image, eg. a DFU image or firmware from NOR, and show its header fields and layout.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		img, err := readIMG1File(args[0])
+		if err != nil {
+			return err
+		}
+		h := &img.Header
+		format := "signed"
+		if img.Encrypted() {
+			format = "signed, encrypted"
+		}
+		device := "unsupported"
+		if img.Kind != "" {
+			device = img.Kind.String()
+		}
+		fmt.Printf("SoC:            %s\n", img.Variant)
+		fmt.Printf("Device:         %s\n", device)
+		fmt.Printf("Format:         %d (%s)\n", h.Format, format)
+		fmt.Printf("Entrypoint:     0x%08x\n", h.Entrypoint)
+		fmt.Printf("Body:           0x%x bytes at 0x%x\n", h.BodyLength, img.Variant.BodyOffset)
+		fmt.Printf("Signature:      0x%x bytes\n", len(img.Signature))
+		fmt.Printf("Certificates:   0x%x bytes at body+0x%x\n", h.FooterCertLength, h.FooterCertOffset)
+		fmt.Printf("Data length:    0x%x\n", h.DataLength)
+		fmt.Printf("Security epoch: %d\n", h.SecurityEpoch)
+		return nil
+	},
+}
+
+var img1BodyCmd = &cobra.Command{
+	Use:   "body [image] [output]",
+	Short: "Extract the body of an unencrypted IMG1 image",
+	Long:  "Write the plaintext body of an unencrypted IMG1 image, eg. a firmware volume to use with the efi commands.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		img, err := readIMG1File(args[0])
+		if err != nil {
+			return err
+		}
+		body, err := img.Plaintext()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[1], body, 0600); err != nil {
+			return fmt.Errorf("could not write body: %w", err)
+		}
+		return nil
+	},
+}
+
+var img1VerifyRoots []string
+
+var img1VerifyCmd = &cobra.Command{
+	Use:   "verify [image]",
+	Short: "Verify the signature of an IMG1 image",
+	Long: `Verify the signature of an IMG1 image against its embedded certificate chain,
+and the chain against the certificates given with --root (eg. the Apple root
+certificate), to tell whether an image is stock-signed, signed by another chain
+or unsigned.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		img, err := readIMG1File(args[0])
+		if err != nil {
+			return err
+		}
+		var roots []*x509.Certificate
+		for _, path := range img1VerifyRoots {
+			root, err := readCertificateFile(path)
+			if err != nil {
+				return err
+			}
+			roots = append(roots, root)
+		}
+		res := img.Verify(roots)
+		for i, c := range res.Chain {
+			fmt.Printf("Certificate %d:  %s (issuer: %s)\n", i, c.Subject, c.Issuer)
+		}
+		fmt.Printf("Status:         %s\n", res.Status)
+		if res.Err != nil {
+			fmt.Printf("Reason:         %v\n", res.Err)
+		}
+		if res.Status == img1.StatusInvalid {
+			return fmt.Errorf("image signature invalid")
+		}
+		return nil
+	},
+}
+
+// readCertificateFile reads a PEM or DER encoded certificate.
+func readCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read certificate: %w", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse certificate %s: %w", path, err)
+	}
+	return cert, nil
+}
+
+var (
+	img1Key string
+	img1IV  string
+)
+
+// parseKeyIV parses the hex encoded key and IV given with --key and --iv.
+func parseKeyIV() (key, iv []byte, err error) {
+	if img1Key == "" {
+		return nil, nil, fmt.Errorf("--key must be given")
+	}
+	key, err = hex.DecodeString(img1Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if img1IV != "" {
+		iv, err = hex.DecodeString(img1IV)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid IV: %w", err)
+		}
+	}
+	return key, iv, nil
+}
+
+// cryptIMG1File reads an image from input, transforms it with fn given the
+// parsed key and IV, and writes the result to output.
+func cryptIMG1File(input, output string, fn func(img *img1.Image, key, iv []byte) error) error {
+	key, iv, err := parseKeyIV()
+	if err != nil {
+		return err
+	}
+	img, err := readIMG1File(input)
+	if err != nil {
+		return err
+	}
+	if err := fn(img, key, iv); err != nil {
+		return err
+	}
+	data, err := img.Serialize()
+	if err != nil {
+		return fmt.Errorf("could not build image: %w", err)
+	}
+	if err := os.WriteFile(output, data, 0600); err != nil {
+		return fmt.Errorf("could not write image: %w", err)
+	}
+	return nil
+}
+
+var img1DecryptCmd = &cobra.Command{
+	Use:   "decrypt [input] [output]",
+	Short: "Decrypt an IMG1 image with a known key",
+	Long:  "Decrypt the body of an IMG1 image on the host with a known AES key and IV, without a connected device. The resulting image is no longer validly signed.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cryptIMG1File(args[0], args[1], (*img1.Image).Decrypt)
+	},
+}
+
+var img1EncryptCmd = &cobra.Command{
+	Use:   "encrypt [input] [output]",
+	Short: "Encrypt an IMG1 image with a known key",
+	Long:  "Encrypt the body of an IMG1 image on the host with a known AES key and IV, eg. to convert a decrypted image back to its stock form.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cryptIMG1File(args[0], args[1], (*img1.Image).Encrypt)
+	},
+}
+
+var img1CertsCmd = &cobra.Command{
+	Use:   "certs [image] [output]",
+	Short: "Extract the certificate chain of an IMG1 image",
+	Long:  "Write the X.509 certificate chain embedded in a signed IMG1 image as PEM, to the given file or stdout.",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		img, err := readIMG1File(args[0])
+		if err != nil {
+			return err
+		}
+		data, err := img.ChainPEM()
+		if err != nil {
+			return fmt.Errorf("could not parse certificates: %w", err)
+		}
+		if data == nil {
+			return fmt.Errorf("image has no certificates")
+		}
+		if len(args) < 2 {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(args[1], data, 0644); err != nil {
+			return fmt.Errorf("could not write certificates: %w", err)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/exploit"
+)
+
+var listJSON bool
+
+// listedDevice is a device as shown by list.
+type listedDevice struct {
+	Bus     int    `json:"bus"`
+	Address int    `json:"address"`
+	ID      string `json:"id"`
+	Mode    string `json:"mode"`
+	Kind    string `json:"kind,omitempty"`
+	Serial  string `json:"serial,omitempty"`
+	// Exploitable is only set for devices in DFU mode.
+	Exploitable *bool `json:"exploitable,omitempty"`
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List connected devices",
+	Long: `List all connected Apple devices in DFU, WTF, recovery or disk mode, with their
+bus and address (as used by --bus and --address), serial number and, for
+supported devices in DFU mode, their generation and whether the wInd3x
+exploit can be run on them.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, err := newContext()
+		if err != nil {
+			return fmt.Errorf("failed to initialize USB: %w", err)
+		}
+		defer ctx.Close()
+		listed, err := devices.List(ctx)
+		if err != nil {
+			return fmt.Errorf("could not list devices: %w", err)
+		}
+
+		var res []listedDevice
+		for _, l := range listed {
+			d := listedDevice{
+				Bus:     l.Bus,
+				Address: l.Address,
+				ID:      fmt.Sprintf("%s:%s", l.Vendor, l.Product),
+				Mode:    string(l.Mode),
+				Serial:  l.Serial,
+			}
+			if l.Description != nil {
+				d.Kind = string(l.Description.Kind)
+				exploitable := exploit.ParametersForKind[l.Description.Kind] != nil
+				d.Exploitable = &exploitable
+			}
+			res = append(res, d)
+		}
+
+		if listJSON {
+			data, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				return fmt.Errorf("could not marshal devices: %w", err)
+			}
+			fmt.Printf("%s\n", data)
+			return nil
+		}
+		if len(res) == 0 {
+			fmt.Println("No devices found.")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "BUS\tADDRESS\tID\tMODE\tDEVICE\tSERIAL\tEXPLOIT\n")
+		for _, d := range res {
+			kind, exploitable := "-", "-"
+			if d.Kind != "" {
+				kind = devices.Kind(d.Kind).String()
+			}
+			if d.Exploitable != nil {
+				exploitable = "no"
+				if *d.Exploitable {
+					exploitable = "yes"
+				}
+			}
+			serial := d.Serial
+			if serial == "" {
+				serial = "-"
+			}
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", d.Bus, d.Address, d.ID, d.Mode, kind, serial, exploitable)
+		}
+		return w.Flush()
+	},
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/freemyipod/wInd3x/pkg/devices"
-	"github.com/freemyipod/wInd3x/pkg/image"
+	"github.com/freemyipod/wInd3x/pkg/img1"
 )
 
 var (
@@ -42,7 +42,7 @@ var makeDFUCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("invalid entrypoint")
 		}
-		wrapped, err := image.MakeUnsigned(kind, entrypoint, data)
+		wrapped, err := img1.NewUnsigned(kind, entrypoint, data).Serialize()
 		if err != nil {
 			return fmt.Errorf("could not make image: %w", err)
 		}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/mse"
+)
+
+var mseCmd = &cobra.Command{
+	Use:   "mse",
+	Short: "Firmware.MSE container utilities",
+	Long:  "Inspect, unpack and repack Firmware.MSE files from retail firmware updates, to modify the OS ('osos') and other images.",
+}
+
+func readMSEFile(path string) (*mse.Firmware, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read firmware: %w", err)
+	}
+	fw, err := mse.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse firmware: %w", err)
+	}
+	for _, err := range fw.Verify() {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return fw, nil
+}
+
+var mseListCmd = &cobra.Command{
+	Use:   "list [firmware]",
+	Short: "List the images within a Firmware.MSE file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fw, err := readMSEFile(args[0])
+		if err != nil {
+			return err
+		}
+		for _, i := range fw.Images {
+			e := &i.Entry
+			fmt.Printf("%s  %s  offset 0x%08x  length 0x%08x  load 0x%08x  entry +0x%x  checksum %08x\n", e.Name(), e.DeviceName(), e.Offset, e.Length, e.Address, e.EntryOffset, e.Checksum)
+		}
+		return nil
+	},
+}
+
+var mseUnpackCmd = &cobra.Command{
+	Use:   "unpack [firmware] [directory]",
+	Short: "Unpack all images from a Firmware.MSE file",
+	Long:  "Write every image within a Firmware.MSE file into a directory as <name>.bin, eg. osos.bin.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fw, err := readMSEFile(args[0])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(args[1], 0755); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+		for _, i := range fw.Images {
+			path := filepath.Join(args[1], i.Entry.Name()+".bin")
+			if err := os.WriteFile(path, i.Data, 0600); err != nil {
+				return fmt.Errorf("could not write image: %w", err)
+			}
+		}
+		return nil
+	},
+}
+
+var msePackCmd = &cobra.Command{
+	Use:   "pack [firmware] [directory] [output]",
+	Short: "Repack a Firmware.MSE file with modified images",
+	Long:  "Rebuild a Firmware.MSE file, replacing its images with the <name>.bin files found in a directory (eg. as written by 'mse unpack'). Images without a file are kept.",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fw, err := readMSEFile(args[0])
+		if err != nil {
+			return err
+		}
+		for _, i := range fw.Images {
+			data, err := os.ReadFile(filepath.Join(args[1], i.Entry.Name()+".bin"))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("could not read image: %w", err)
+			}
+			glog.Infof("Replacing %s (0x%x bytes)...", i.Entry.Name(), len(data))
+			i.Data = data
+		}
+		data, err := fw.Serialize()
+		if err != nil {
+			return fmt.Errorf("could not build firmware: %w", err)
+		}
+		if err := os.WriteFile(args[2], data, 0600); err != nil {
+			return fmt.Errorf("could not write firmware: %w", err)
+		}
+		return nil
+	},
+}
@@ -28,11 +28,15 @@ func nandReadPageOffset(a *app, bank, page, offset uint32) ([]byte, error) {
 		Listing: listing,
 	}
 
-	if err := dfu.Clean(usb); err != nil {
-		return nil, fmt.Errorf("clean failed: %w", err)
-	}
-
-	resBuf, err := exploit.RCE(usb, ep, read.Assemble(), nil)
+	var resBuf []byte
+	err := dfu.Retry.Do(fmt.Sprintf("NAND read of page 0x%x", page), func() error {
+		if err := dfu.Clean(usb); err != nil {
+			return fmt.Errorf("clean failed: %w", err)
+		}
+		var err error
+		resBuf, err = exploit.RCE(usb, ep, read.Assemble(), nil)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute read payload: %w", err)
 	}
@@ -45,7 +49,7 @@ var nandReadCmd = &cobra.Command{
 	Long:  "Read a 0x60000 'bank' (maybe?) of NAND. Slowly. Bank 0 contains the bootloader.",
 	Args:  cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		app, err := newApp()
+		app, err := newExploitApp()
 		if err != nil {
 			return err
 		}
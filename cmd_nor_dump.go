@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/nor"
+)
+
+func readNORDump(path string) (*nor.NOR, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read dump: %w", err)
+	}
+	n, err := nor.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse dump: %w", err)
+	}
+	for _, err := range n.Verify() {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return n, nil
+}
+
+var norListCmd = &cobra.Command{
+	Use:   "list [dump]",
+	Short: "List the regions of a NOR dump",
+	Long:  "List the bootloader, directory and images within a full NOR dump (eg. as read with 'nor read'), with their offsets, lengths, available space and checksums.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n, err := readNORDump(args[0])
+		if err != nil {
+			return err
+		}
+		for _, r := range n.Regions {
+			fmt.Printf("%-4s  0x%08x-0x%08x  length 0x%08x  capacity 0x%08x", r.Name, r.Offset, r.End(), r.Length, n.Capacity(r))
+			if r.Entry != nil {
+				fmt.Printf("  load 0x%08x  checksum %08x", r.Entry.Address, r.Entry.Checksum)
+			}
+			fmt.Printf("\n")
+		}
+		return nil
+	},
+}
+
+var norExtractCmd = &cobra.Command{
+	Use:   "extract [dump] [region] [output]",
+	Short: "Extract a region from a NOR dump",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n, err := readNORDump(args[0])
+		if err != nil {
+			return err
+		}
+		data, err := n.Read(args[1])
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[2], data, 0600); err != nil {
+			return fmt.Errorf("could not write region: %w", err)
+		}
+		return nil
+	},
+}
+
+var norReplaceCmd = &cobra.Command{
+	Use:   "replace [dump] [region] [input] [output]",
+	Short: "Replace a region within a NOR dump",
+	Long:  "Replace a region within a NOR dump, updating its directory entry. The new data must fit into the space available to the region, as shown by 'nor list'.",
+	Args:  cobra.ExactArgs(4),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n, err := readNORDump(args[0])
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(args[2])
+		if err != nil {
+			return fmt.Errorf("could not read input: %w", err)
+		}
+		if err := n.Replace(args[1], data); err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[3], n.Data, 0600); err != nil {
+			return fmt.Errorf("could not write dump: %w", err)
+		}
+		return nil
+	},
+}
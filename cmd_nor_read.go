@@ -48,11 +48,15 @@ func readNOR(app *app, w io.Writer, spino, offset, size uint32) error {
 			Address: ep.ExecAddr(),
 			Listing: listing,
 		}
-		if err := dfu.Clean(app.usb); err != nil {
-			return fmt.Errorf("clean failed: %w", err)
-		}
-
-		data, err := exploit.RCE(usb, ep, read.Assemble(), nil)
+		var data []byte
+		err := dfu.Retry.Do(fmt.Sprintf("NOR read of 0x%x", offset+i), func() error {
+			if err := dfu.Clean(app.usb); err != nil {
+				return fmt.Errorf("clean failed: %w", err)
+			}
+			var err error
+			data, err = exploit.RCE(usb, ep, read.Assemble(), nil)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("failed to execute read payload: %w", err)
 		}
@@ -69,16 +73,12 @@ var norReadCmd = &cobra.Command{
 	Long:  "Read N bytes from an address from given SPI peripheral.",
 	Args:  cobra.ExactArgs(4),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		app, err := newApp()
+		app, err := newExploitApp()
 		if err != nil {
 			return err
 		}
 		defer app.close()
 
-		if app.ep.NORInit == nil {
-			return fmt.Errorf("currently only implemented for N3G")
-		}
-
 		spino, err := parseNumber(args[0])
 		if err != nil {
 			return fmt.Errorf("invalid spi peripheral number")
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/efi"
+)
+
+var nvramCmd = &cobra.Command{
+	Use:   "nvram",
+	Short: "EFI variable store (NVRAM) utilities",
+	Long:  "List and modify EFI variables within VSS/NVRAM variable stores of a raw firmware image.",
+}
+
+var nvramStore int
+
+type nvramImage struct {
+	data    []byte
+	offsets []int
+}
+
+func readNVRAMImage(path string) (*nvramImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read image: %w", err)
+	}
+	offsets := efi.FindVariableStores(data)
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("no variable stores found")
+	}
+	return &nvramImage{data: data, offsets: offsets}, nil
+}
+
+// store returns the variable store selected with --store.
+func (n *nvramImage) store() (*efi.VariableStore, error) {
+	if nvramStore < 0 || nvramStore >= len(n.offsets) {
+		return nil, fmt.Errorf("store %d out of range, image has %d", nvramStore, len(n.offsets))
+	}
+	return efi.ParseVariableStore(n.data[n.offsets[nvramStore]:])
+}
+
+// write splices the serialized store back into the image and writes it to
+// path.
+func (n *nvramImage) write(path string, s *efi.VariableStore) error {
+	data, err := s.Serialize()
+	if err != nil {
+		return fmt.Errorf("could not serialize store: %w", err)
+	}
+	copy(n.data[n.offsets[nvramStore]:], data)
+	if err := os.WriteFile(path, n.data, 0600); err != nil {
+		return fmt.Errorf("could not write image: %w", err)
+	}
+	return nil
+}
+
+var nvramListCmd = &cobra.Command{
+	Use:   "list [image]",
+	Short: "List EFI variables",
+	Long:  "List all variable stores within a raw firmware image and the variables within them, including deleted ones.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		img, err := readNVRAMImage(args[0])
+		if err != nil {
+			return err
+		}
+		for i, offset := range img.offsets {
+			s, err := efi.ParseVariableStore(img.data[offset:])
+			if err != nil {
+				return fmt.Errorf("store %d: %w", i, err)
+			}
+			fmt.Printf("Store %d @%08x: %s, size 0x%x, %d variables\n", i, offset, s.Kind, s.Size, len(s.Variables))
+			for _, v := range s.Variables {
+				state := "live"
+				if !v.Live() {
+					state = fmt.Sprintf("state %02x", v.State)
+				}
+				fmt.Printf("  %s:%s (%s, %s, %d bytes)\n", v.GUID.Name(), v.Name, v.Attributes, state, len(v.Data))
+				if len(v.Data) <= 64 {
+					fmt.Printf("    %s\n", hex.EncodeToString(v.Data))
+				}
+			}
+		}
+		return nil
+	},
+}
+
+var nvramSetCmd = &cobra.Command{
+	Use:   "set [image] [guid] [name] [data] [output]",
+	Short: "Set an EFI variable",
+	Long:  "Set the data of an EFI variable to the contents of a file, adding it as a non-volatile boot and runtime variable if it does not exist yet.",
+	Args:  cobra.ExactArgs(5),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		img, err := readNVRAMImage(args[0])
+		if err != nil {
+			return err
+		}
+		s, err := img.store()
+		if err != nil {
+			return err
+		}
+		guid, err := efi.ParseGUID(args[1])
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(args[3])
+		if err != nil {
+			return fmt.Errorf("could not read data: %w", err)
+		}
+		if err := s.Set(guid, args[2], data); err != nil {
+			return fmt.Errorf("could not set variable: %w", err)
+		}
+		return img.write(args[4], s)
+	},
+}
+
+var nvramDeleteCmd = &cobra.Command{
+	Use:   "delete [image] [guid] [name] [output]",
+	Short: "Delete an EFI variable",
+	Args:  cobra.ExactArgs(4),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		img, err := readNVRAMImage(args[0])
+		if err != nil {
+			return err
+		}
+		s, err := img.store()
+		if err != nil {
+			return err
+		}
+		guid, err := efi.ParseGUID(args[1])
+		if err != nil {
+			return err
+		}
+		if err := s.Delete(guid, args[2]); err != nil {
+			return fmt.Errorf("could not delete variable: %w", err)
+		}
+		return img.write(args[3], s)
+	},
+}
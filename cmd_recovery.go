@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/google/gousb"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/recovery"
+	"github.com/freemyipod/wInd3x/pkg/transport"
+)
+
+var recoveryCmd = &cobra.Command{
+	Use:   "recovery",
+	Short: "Talk to a device in recovery mode",
+	Long: `Send commands and files to a device in recovery mode, ie. running a boot stage
+(eg. iBoot) which exposes a recovery command interface instead of DFU.`,
+}
+
+// openRecovery opens the device in recovery mode, waiting for it according
+// to --wait. The returned function closes it.
+func openRecovery() (transport.Bulk, func(), error) {
+	ctx, err := newContext()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize USB: %w", err)
+	}
+	var dev *gousb.Device
+	err = waitForDevice(func() (err error) {
+		dev, err = devices.OpenRecovery(ctx, deviceSelector)
+		return err
+	})
+	if err != nil {
+		ctx.Close()
+		return nil, nil, err
+	}
+	dev.ControlTimeout = dfu.ControlTimeout
+	usb, err := transport.OpenBulk(dev, recovery.Config, recovery.Interface, recovery.AltSetting, recovery.Endpoint)
+	if err != nil {
+		dev.Close()
+		ctx.Close()
+		return nil, nil, err
+	}
+	return usb, func() {
+		usb.Close()
+		ctx.Close()
+	}, nil
+}
+
+var recoveryCommandCmd = &cobra.Command{
+	Use:   "cmd [command...]",
+	Short: "Send a command to a device in recovery mode",
+	Long:  "Send a command (eg. 'setenv auto-boot false', 'saveenv' or 'go') to a device in recovery mode. Commands which reboot the device might report an error, as it disconnects.",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		usb, closeUSB, err := openRecovery()
+		if err != nil {
+			return err
+		}
+		defer closeUSB()
+		return recovery.Command(usb, strings.Join(args, " "))
+	},
+}
+
+var recoveryGetEnvCmd = &cobra.Command{
+	Use:   "getenv [name]",
+	Short: "Print an environment variable of a device in recovery mode",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		usb, closeUSB, err := openRecovery()
+		if err != nil {
+			return err
+		}
+		defer closeUSB()
+		value, err := recovery.GetEnv(usb, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var recoverySendCmd = &cobra.Command{
+	Use:   "send [file]",
+	Short: "Upload a file to a device in recovery mode",
+	Long:  "Upload a file (eg. an image) to a device in recovery mode, to then be used by commands, eg. booted with 'recovery cmd go'.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("could not read file: %w", err)
+		}
+		usb, closeUSB, err := openRecovery()
+		if err != nil {
+			return err
+		}
+		defer closeUSB()
+		glog.Infof("Uploading %s...", args[0])
+		if err := recovery.Send(cmd.Context(), usb, data, newProgressBar().update); err != nil {
+			return err
+		}
+		glog.Infof("Done.")
+		return nil
+	},
+}
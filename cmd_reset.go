@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/dfu"
+)
+
+var (
+	resetDetach  bool
+	resetTimeout time.Duration
+)
+
+var resetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Reset a device in DFU mode",
+	Long: `Reset a connected device in DFU mode with a USB port reset, and wait for it to
+re-enumerate in DFU mode. This gets a device out of a stuck DFU session (eg.
+after an interrupted transfer) without having to disconnect its battery.
+
+With --detach, DFU_DETACH is issued first, asking the device to leave DFU mode
+instead. In that case, the device is not expected to come back in DFU mode.
+
+Resetting is not supported with --remote, as the server would keep using the
+handle of the device from before it re-enumerated.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if remoteAddr != "" {
+			return fmt.Errorf("reset cannot be used with --remote")
+		}
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		if resetDetach {
+			if err := dfu.Detach(app.usb, time.Second); err != nil {
+				// The bootrom might not support detaching, continue with the
+				// reset anyway.
+				glog.Warningf("Detach failed: %v", err)
+			}
+		}
+		glog.Infof("Resetting %s...", app.desc.Kind)
+		if err := app.usb.Reset(); err != nil {
+			// The device disappearing during the reset is expected.
+			glog.V(1).Infof("Reset returned: %v", err)
+		}
+		app.close()
+		if resetDetach {
+			glog.Infof("Done.")
+			return nil
+		}
+
+		// Give the device time to disappear before looking for it again.
+		time.Sleep(time.Second)
+		waitTimeout = resetTimeout
+		app, err = newApp()
+		if err != nil {
+			return fmt.Errorf("device did not come back in DFU mode: %w", err)
+		}
+		defer app.close()
+		state, err := dfu.GetState(app.usb)
+		if err != nil {
+			return fmt.Errorf("could not get DFU state after reset: %w", err)
+		}
+		glog.Infof("%s back in DFU mode, state %s.", app.desc.Kind, state)
+		return nil
+	},
+}
@@ -1,43 +1,276 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/spf13/cobra"
 
+	"github.com/freemyipod/wInd3x/pkg/devices"
 	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/exploit"
 	"github.com/freemyipod/wInd3x/pkg/exploit/haxeddfu"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+	"github.com/freemyipod/wInd3x/pkg/sniff"
+	"github.com/freemyipod/wInd3x/pkg/transport"
+)
+
+var (
+	runWTF        string
+	runWTFTimeout time.Duration
+	runVerify     bool
+	runWrap       bool
 )
 
 var runCmd = &cobra.Command{
 	Use:   "run [dfu image path]",
 	Short: "Run a DFU image on a device",
-	Long:  "Run a DFU image (signed/encrypted or unsigned) on a connected device, starting haxed dfu mode first if necessary.",
-	Args:  cobra.ExactArgs(1),
+	Long: `Run a DFU image (signed/encrypted or unsigned) on a connected device, starting
+haxed dfu mode first if necessary. Unencrypted 8900 images are converted to
+unsigned IMG1 images first, unless an S5L8900 device (eg. the first iPhone) is
+connected. A DFU suffix, if present, is verified against the connected device
+and stripped. Files which are not recognized as DFU images are sent as they
+are, or with --wrap, wrapped like 'dfu wrap' does first (with the entrypoint at
+the start of the payload). With --all, the image is run on all connected devices at once.
+
+With --wtf, a WTF image is run first, and the image is then sent to the device
+once it has re-enumerated in WTF mode, eg. to run a firmware image. --wtf takes
+either a WTF image or a directory (eg. as populated by 'fetch'), in which the
+WTF for the connected device is looked up by its name.
+
+With --verify, the image is read back after it has been sent and compared to
+the original. This requires the device to support DFU_UPLOAD and to remain in
+DFU mode after manifestation, which is checked before sending the image. The
+bootroms of supported devices are not known to do so, but later boot stages
+might (eg. in WTF mode, see --wtf).`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		app, err := newApp()
+		path := args[0]
+		data, err := readDFUImage(path)
+		if err != nil {
+			return err
+		}
+		data.wrap = runWrap
+		if runWTF != "" {
+			if allDevices {
+				return fmt.Errorf("--wtf cannot be used with --all")
+			}
+			if remoteAddr != "" {
+				return fmt.Errorf("--wtf cannot be used with --remote")
+			}
+			app, err := newApp()
+			if err != nil {
+				return err
+			}
+			defer app.close()
+			return runViaWTF(cmd.Context(), app, path, data)
+		}
+
+		return forEachDevice(func(app *app) error {
+			if err := startHaxedDFU(cmd.Context(), app, data); err != nil {
+				return err
+			}
+			return sendImage(cmd.Context(), app, path, data, runVerify)
+		})
+	},
+}
+
+// dfuImage is a DFU image to be run, with the DFU suffix it had, if any.
+type dfuImage struct {
+	data   []byte
+	suffix *dfu.Suffix
+	format sniff.Format
+	// wrap is set if the image should be wrapped for the device, if it is a
+	// raw payload.
+	wrap bool
+}
+
+// readDFUImage reads a DFU image and strips its DFU suffix, if any.
+func readDFUImage(path string) (*dfuImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read image: %w", err)
+	}
+	suffix, stripped, err := dfu.ParseSuffix(data)
+	switch {
+	case err == nil:
+		data = stripped
+	case !errors.Is(err, dfu.ErrNoSuffix):
+		return nil, fmt.Errorf("Invalid DFU suffix: %w", err)
+	}
+	return &dfuImage{data: data, suffix: suffix, format: sniff.Sniff(data)}, nil
+}
+
+// startHaxedDFU verifies that an image can be run on a device, and starts
+// haxed DFU mode on it if possible.
+func startHaxedDFU(ctx context.Context, app *app, image *dfuImage) error {
+	if s := image.suffix; s != nil && !s.Matches(uint16(app.desc.DFUVID), uint16(app.desc.DFUPID)) {
+		return fmt.Errorf("DFU suffix is for %04x:%04x, but %s (%s:%s) is connected", s.Vendor, s.Product, app.desc.Kind, app.desc.DFUVID, app.desc.DFUPID)
+	}
+
+	err := haxeddfu.Trigger(ctx, app.usb, app.ep, false)
+	switch {
+	case errors.Is(err, exploit.ErrUnsupported):
+		app.infof("wInd3x exploit not available for %s, only signed images will run.", app.desc.Kind)
+	case err != nil:
+		return fmt.Errorf("Failed to run wInd3x exploit: %w", err)
+	}
+	return nil
+}
+
+// sendImage sends an image to a device, converting it first if needed, and
+// optionally verifies it afterwards.
+func sendImage(ctx context.Context, app *app, path string, image *dfuImage, verify bool) error {
+	if verify {
+		if err := dfu.CheckReadBack(app.usb); err != nil {
+			return fmt.Errorf("cannot verify image: %w", err)
+		}
+	}
+	app.infof("Uploading %s...", path)
+	data := image.data
+	switch image.format {
+	case sniff.IMG1, sniff.IMG1Encrypted, sniff.IMG1Firmware, sniff.Image8900:
+	case sniff.Raw:
+		if !image.wrap {
+			app.infof("%s is not a recognized DFU image, sending it as it is (use --wrap to wrap it first).", path)
+			break
+		}
+		wrapped, err := wrapPayload(app.desc.Kind, 0, data)
+		if err != nil {
+			return err
+		}
+		data = wrapped
+		app.infof("Wrapped raw payload into unsigned %s image.", app.desc.Kind)
+	default:
+		app.infof("%v, sending it as it is.", formatError(path, "a DFU image", image.format))
+	}
+	// S5L8900 devices take 8900 images as they are.
+	if app.desc.Kind == devices.S5L8900 && !img1.Is8900(data) {
+		app.infof("%s usually only runs 8900 images.", app.desc.Kind)
+	}
+	if img1.Is8900(data) && app.desc.Kind != devices.S5L8900 {
+		img, err := img1.Parse8900(data)
 		if err != nil {
+			return fmt.Errorf("Failed to parse 8900 image: %w", err)
+		}
+		converted, err := img.IMG1(app.desc.Kind)
+		if err != nil {
+			return fmt.Errorf("Failed to convert 8900 image: %w", err)
+		}
+		if data, err = converted.Serialize(); err != nil {
+			return fmt.Errorf("Failed to build image: %w", err)
+		}
+		app.infof("Converted 8900 image to unsigned %s IMG1 image.", app.desc.Kind)
+	}
+	if err := dfu.SendImageProgress(ctx, app.usb, data, app.desc.Kind.DFUVersion(), app.progress()); err != nil {
+		return fmt.Errorf("Failed to send image: %w", err)
+	}
+	app.infof("Image sent.")
+	if verify {
+		if err := dfu.Verify(ctx, app.usb, data); err != nil {
 			return err
 		}
-		defer app.close()
+		app.infof("Image verified.")
+	}
+	return nil
+}
 
-		if err := haxeddfu.Trigger(app.usb, app.ep, false); err != nil {
-			return fmt.Errorf("Failed to run wInd3x exploit: %w", err)
+// findWTF returns the path of the WTF for a device, given either the path of
+// a WTF image, or of a directory containing WTFs named after the DFU product
+// ID of the devices they are for (eg. WTF.x1225.release.dfu).
+func findWTF(path string, desc *devices.Description) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("could not access WTF: %w", err)
+	}
+	if !fi.IsDir() {
+		return path, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", fmt.Errorf("could not list %s: %w", path, err)
+	}
+	prefix := fmt.Sprintf("wtf.x%04x.", uint16(desc.DFUPID))
+	var found []string
+	for _, e := range entries {
+		name := strings.ToLower(e.Name())
+		if !e.IsDir() && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".dfu") {
+			found = append(found, e.Name())
 		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no WTF for %s (%s) in %s, see 'fetch'", desc.Kind, desc.DFUPID, path)
+	case 1:
+		return filepath.Join(path, found[0]), nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("multiple WTFs for %s in %s (%s), pass one explicitly", desc.Kind, path, strings.Join(found, ", "))
+}
 
-		path := args[0]
-		glog.Infof("Uploading %s...", path)
-		data, err := os.ReadFile(path)
+// runViaWTF runs the WTF given by --wtf on a device in bootrom DFU mode, waits
+// for the device to re-enumerate in WTF mode, and then sends it an image.
+func runViaWTF(ctx context.Context, app *app, path string, image *dfuImage) error {
+	wtfPath, err := findWTF(runWTF, app.desc)
+	if err != nil {
+		return err
+	}
+	wtf, err := readDFUImage(wtfPath)
+	if err != nil {
+		return err
+	}
+	if !img1.Is8900(wtf.data) {
+		img, err := img1.Parse(wtf.data)
 		if err != nil {
-			return fmt.Errorf("Failed to read image: %w", err)
+			return fmt.Errorf("could not parse WTF: %w", err)
 		}
-		if err := dfu.SendImage(app.usb, data, app.desc.Kind.DFUVersion()); err != nil {
-			return fmt.Errorf("Failed to send image: %w", err)
+		if img.Kind != "" && img.Kind != app.desc.Kind {
+			return fmt.Errorf("WTF is for %s, but %s is connected", img.Kind, app.desc.Kind)
 		}
-		glog.Infof("Image sent.")
+	}
 
-		return nil
-	},
+	if err := startHaxedDFU(ctx, app, wtf); err != nil {
+		return err
+	}
+	if err := sendImage(ctx, app, wtfPath, wtf, false); err != nil {
+		return err
+	}
+	app.usb.Close()
+
+	app.infof("Waiting up to %s for %s to re-enumerate in WTF mode...", runWTFTimeout, app.desc.Kind)
+	deadline := time.Now().Add(runWTFTimeout)
+	var usb transport.Device
+	for {
+		dev, err := devices.OpenWTF(app.ctx, deviceSelector)
+		if err == nil {
+			dev.ControlTimeout = dfu.ControlTimeout
+			usb = transport.GoUSB{Device: dev}
+			break
+		}
+		if !errors.Is(err, devices.ErrNotFound) {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("device did not re-enumerate in WTF mode: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	defer usb.Close()
+	app.infof("%s in WTF mode as %s.", app.desc.Kind, usb)
+
+	// WTF mode cannot be exploited, so the image is sent as it is.
+	wtfApp := *app
+	wtfApp.usb = usb
+	wtfApp.ep = nil
+	return sendImage(ctx, &wtfApp, path, image, runVerify)
 }
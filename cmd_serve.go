@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/gousb"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/exploit"
+	"github.com/freemyipod/wInd3x/pkg/transport/remote"
+)
+
+// remoteAddr is set by --remote, to use a device served by 'serve' on
+// another machine.
+var remoteAddr string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [address]",
+	Short: "Serve a device to wInd3x running on another machine",
+	Long: `Serve the connected device over TCP at an address (eg. :7777), so that wInd3x
+running elsewhere can use it with --remote, eg. when the device is plugged into
+a headless machine.
+
+The device is selected with --serial, --bus and --address given to serve, and
+is opened anew for every client, so that it can be reset and re-enumerate in
+between. It is waited for up to --wait (10s if not given). Clients are served
+one at a time. The protocol is
+neither authenticated nor encrypted, only serve devices on trusted networks.
+
+Devices exported with usbip do not need this, as they appear as local devices
+once attached.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if remoteAddr != "" {
+			return fmt.Errorf("cannot serve a remote device")
+		}
+		if waitTimeout == 0 {
+			// Clients might connect right after resetting the device.
+			waitTimeout = 10 * time.Second
+		}
+		l, err := net.Listen("tcp", args[0])
+		if err != nil {
+			return fmt.Errorf("could not listen: %w", err)
+		}
+		defer l.Close()
+		go func() {
+			<-cmd.Context().Done()
+			l.Close()
+		}()
+		glog.Infof("Serving on %s...", l.Addr())
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if cmd.Context().Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("accept: %w", err)
+			}
+			glog.Infof("Client %s connected.", conn.RemoteAddr())
+			if err := serveClient(conn); err != nil {
+				glog.Errorf("Client %s: %v", conn.RemoteAddr(), err)
+			} else {
+				glog.Infof("Client %s disconnected.", conn.RemoteAddr())
+			}
+			conn.Close()
+		}
+	},
+}
+
+// serveClient opens the device and serves it to a single client.
+func serveClient(conn net.Conn) error {
+	app, err := newApp()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+	defer app.usb.Close()
+	hello := remote.Hello{
+		Vendor:  uint16(app.desc.DFUVID),
+		Product: uint16(app.desc.DFUPID),
+		Kind:    string(app.desc.Kind),
+		Name:    app.usb.String(),
+	}
+	return remote.Serve(conn, app.usb, hello)
+}
+
+// newRemoteApp connects to the device served at --remote.
+func newRemoteApp() (*app, error) {
+	c, err := remote.Dial(remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", remoteAddr, err)
+	}
+	desc := &devices.Description{
+		DFUVID: gousb.ID(c.Hello.Vendor),
+		DFUPID: gousb.ID(c.Hello.Product),
+		Kind:   devices.Kind(c.Hello.Kind),
+	}
+	c.SetControlTimeout(dfu.ControlTimeout)
+	glog.Infof("Using %s at %s.", desc.Kind, c)
+	return &app{
+		usb:  c,
+		desc: desc,
+		ep:   exploit.ParametersForKind[desc.Kind],
+	}, nil
+}
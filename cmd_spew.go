@@ -276,7 +276,7 @@ var spewCmd = &cobra.Command{
 	Long:  "Displays SysCfg, GPIO, ... info from the connected device. Useful for reverse engineering and development.",
 	Args:  cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		app, err := newApp()
+		app, err := newExploitApp()
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/exploit/haxeddfu"
+)
+
+var (
+	uploadSize  string
+	uploadForce bool
+)
+
+var uploadCmd = &cobra.Command{
+	Use:   "upload [output]",
+	Short: "Read back data from a device over DFU",
+	Long: `Read back whatever a connected device in DFU mode (or a payload running on it)
+exposes through the DFU UPLOAD request, and write it to a file.
+
+Uploads are only requested from devices which report DFU_UPLOAD support, or
+which run haxed DFU, as the bootroms of supported devices do not, and the
+exploit abuses their handling of uploads. Use --force to upload anyway. At
+most 0x10000 blocks are read.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		size, err := parseNumber(uploadSize)
+		if err != nil {
+			return fmt.Errorf("invalid size")
+		}
+
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		defer app.close()
+
+		if err := dfu.CheckUpload(app.usb); err != nil {
+			if uploadForce {
+				glog.Warningf("%v, uploading anyway.", err)
+			} else if running, herr := haxeddfu.Running(app.usb); herr != nil {
+				return fmt.Errorf("%w, and could not check for haxed DFU: %v", err, herr)
+			} else if !running {
+				return fmt.Errorf("%w, and haxed DFU is not running (use --force to upload anyway)", err)
+			}
+		}
+
+		var progress dfu.Progress
+		if size != 0 {
+			progress = newProgressBar().update
+		}
+		data, err := dfu.ReceiveImage(cmd.Context(), app.usb, int(size), progress)
+		if err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+		glog.Infof("Received 0x%x bytes.", len(data))
+		if err := os.WriteFile(args[0], data, 0600); err != nil {
+			return fmt.Errorf("could not write output: %w", err)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/freemyipod/wInd3x/pkg/sniff"
+	"github.com/freemyipod/wInd3x/pkg/wtf"
+)
+
+var wtfCmd = &cobra.Command{
+	Use:   "wtf",
+	Short: "WTF (recovery loader) image utilities",
+	Long:  "Inspect, patch and rebuild decrypted WTF images, eg. as produced by 'decrypt'. Rebuilt images are unsigned, to be run with 'run'.",
+}
+
+func readWTFFile(path string) (*wtf.WTF, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read image: %w", err)
+	}
+	w, err := wtf.Parse(data)
+	if err != nil {
+		if err := wrongFormat(path, data, "a plaintext WTF image", func(f sniff.Format) bool { return f == sniff.IMG1 }); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not parse image: %w", err)
+	}
+	return w, nil
+}
+
+func writeWTFFile(path string, w *wtf.WTF) error {
+	data, err := w.Serialize()
+	if err != nil {
+		return fmt.Errorf("could not build image: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("could not write image: %w", err)
+	}
+	return nil
+}
+
+var wtfInfoCmd = &cobra.Command{
+	Use:   "info [image]",
+	Short: "Show the layout of a WTF image",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w, err := readWTFFile(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("SoC:            %s\n", w.Image.Variant)
+		fmt.Printf("Payload:        0x%x bytes\n", len(w.Payload))
+		if load, err := w.LoadAddress(); err == nil {
+			fmt.Printf("Load address:   0x%08x\n", load)
+			fmt.Printf("Entrypoint:     0x%08x\n", load+w.Image.Header.Entrypoint)
+		} else {
+			fmt.Printf("Entrypoint:     payload+0x%x\n", w.Image.Header.Entrypoint)
+		}
+		return nil
+	},
+}
+
+var wtfPayloadCmd = &cobra.Command{
+	Use:   "payload [image] [output]",
+	Short: "Extract the payload of a WTF image",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w, err := readWTFFile(args[0])
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[1], w.Payload, 0600); err != nil {
+			return fmt.Errorf("could not write payload: %w", err)
+		}
+		return nil
+	},
+}
+
+var wtfPatchCmd = &cobra.Command{
+	Use:   "patch [image] [patch] [output]",
+	Short: "Apply an IPS or BPS patch to the payload of a WTF image",
+	Long:  "Apply an IPS or BPS patch (eg. created with 'binpatch create' against an extracted payload) to the payload of a WTF image, and rebuild it.",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w, err := readWTFFile(args[0])
+		if err != nil {
+			return err
+		}
+		patch, err := os.ReadFile(args[1])
+		if err != nil {
+			return fmt.Errorf("could not read patch: %w", err)
+		}
+		if err := w.ApplyPatch(patch); err != nil {
+			return fmt.Errorf("could not apply patch: %w", err)
+		}
+		return writeWTFFile(args[2], w)
+	},
+}
+
+var wtfPokeCmd = &cobra.Command{
+	Use:   "poke [image] [address] [hex bytes] [output]",
+	Short: "Overwrite bytes of the payload of a WTF image",
+	Long:  "Overwrite bytes of the payload of a WTF image at a given load address (eg. 0x22000100), and rebuild it.",
+	Args:  cobra.ExactArgs(4),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w, err := readWTFFile(args[0])
+		if err != nil {
+			return err
+		}
+		addr, err := parseNumber(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid address")
+		}
+		data, err := hex.DecodeString(strings.ReplaceAll(args[2], " ", ""))
+		if err != nil {
+			return fmt.Errorf("invalid bytes: %w", err)
+		}
+		if err := w.Poke(addr, data); err != nil {
+			return err
+		}
+		return writeWTFFile(args[3], w)
+	},
+}
@@ -7,6 +7,6 @@ require (
 	github.com/google/gousb v1.1.1
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/spf13/cobra v1.3.0
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/spf13/pflag v1.0.5
 	github.com/tetratelabs/wazero v1.0.0-pre.1
 )
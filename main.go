@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
 
 	"github.com/google/gousb"
 	"github.com/hashicorp/go-multierror"
@@ -12,42 +21,206 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/dfu"
 	"github.com/freemyipod/wInd3x/pkg/exploit"
+	"github.com/freemyipod/wInd3x/pkg/transport"
+	"github.com/freemyipod/wInd3x/pkg/usblog"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "wInd3x",
-	Short: "wInd3x is an exploit tool for the iPod Nano 4G/5G",
+	Short: "wInd3x is an exploit tool for the iPod Nano 3G/4G/5G and Classic",
 	Long: `Allows to decrypt firmware files, generate DFU images and run unsigned DFU
-images on the Nano 4G/5G.
+images on the iPod Nano 3G/Classic, 4G and 5G. Nano 6G, 7G and S5L8900 devices
+(eg. the first iPhone) are detected too, but cannot be exploited, so only
+signed images run on them.
 
 Copyright 2022 q3k, user890104. With help from zizzy and d42.
 
 wInd3x comes with ABSOLUTELY NO WARRANTY. This is free software, and you are
 welcome to redistribute it under certain conditions; see COPYING file
 accompanying distribution for details.`,
-	SilenceUsage: true,
+	SilenceUsage:      true,
+	PersistentPreRunE: setup,
 }
 
 func main() {
 	makeDFUCmd.Flags().StringVarP(&makeDFUEntrypoint, "entrypoint", "e", "0x0", "Entrypoint offset for image (added to load address == 0x2200_0000)")
 	makeDFUCmd.Flags().StringVarP(&makeDFUDeviceKind, "kind", "k", "", "Device kind (one of 'n4g', 'n5g')")
 	decryptCmd.Flags().StringVarP(&decryptRecovery, "recovery", "r", "", "EXPERIMENTAL: Path to temporary file used for recovery when restarting the transfer")
+	encryptCmd.Flags().StringVarP(&encryptRecovery, "recovery", "r", "", "EXPERIMENTAL: Path to temporary file used for recovery when restarting the transfer")
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.PersistentFlags().StringVar(&usbLogPath, "usb-log", "", "Record all USB transfers to this file as JSON lines")
+	rootCmd.PersistentFlags().IntVar(&dfu.Retry.Attempts, "retries", dfu.Retry.Attempts, "Number of attempts for USB transfers which fail with transient errors")
+	rootCmd.PersistentFlags().DurationVar(&dfu.Retry.Backoff, "retry-backoff", dfu.Retry.Backoff, "Delay before retrying a failed USB transfer, doubled after every attempt")
+	rootCmd.PersistentFlags().IntVar(&exploit.TriggerRetryPolicy.Attempts, "trigger-retries", exploit.TriggerRetryPolicy.Attempts, "Number of attempts for exploit payloads (eg. decryption of a block) which fail to trigger")
+	rootCmd.PersistentFlags().DurationVar(&dfu.ControlTimeout, "control-timeout", dfu.ControlTimeout, "Timeout of USB control transfers, eg. while uploading images")
+	rootCmd.PersistentFlags().DurationVar(&exploit.TriggerTimeout, "exploit-timeout", exploit.TriggerTimeout, "Timeout of USB control transfers while running the exploit, which relies on some of them timing out")
+	rootCmd.PersistentFlags().IntVar(&dfu.TransferSize, "transfer-size", 0, "Size of DFU transfers in bytes (0 to use the size reported by the device)")
+	rootCmd.PersistentFlags().DurationVar(&waitTimeout, "wait", 0, "Wait up to this long (eg. 30s) for a device to be connected")
+	rootCmd.PersistentFlags().StringSliceVar(&deviceDBPaths, "device-db", nil, "Additional device identification database (JSON) to recognize devices with")
+	rootCmd.PersistentFlags().StringVar(&remoteAddr, "remote", "", "Use the device served by 'wInd3x serve' at this address (host:port) instead of a local one")
+	rootCmd.PersistentFlags().StringVar(&deviceSelector.Serial, "serial", "", "Serial number of the device to use, if multiple are connected")
+	rootCmd.PersistentFlags().IntVar(&deviceSelector.Bus, "bus", 0, "USB bus of the device to use, if multiple are connected")
+	rootCmd.PersistentFlags().IntVar(&deviceSelector.Address, "address", 0, "USB address of the device to use, if multiple are connected")
+	for _, c := range []*cobra.Command{haxDFUCmd, runCmd} {
+		c.Flags().BoolVar(&allDevices, "all", false, "Run on all connected devices (matching --serial, --bus and --address) concurrently")
+	}
+	rootCmd.PersistentFlags().StringSliceVar(&firmwareDBPaths, "firmware-db", nil, "Additional firmware version database (JSON) to identify images with")
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "Output devices as JSON")
+	rootCmd.AddCommand(listCmd)
+	doctorCmd.Flags().BoolVar(&doctorFixPermissions, "fix-permissions", false, "Install udev rules granting access to all known devices")
+	doctorCmd.Flags().StringVar(&doctorUdevPath, "udev-rules", "/etc/udev/rules.d/70-wInd3x.rules", "Path to write udev rules to")
+	rootCmd.AddCommand(doctorCmd)
+	haxDFUCmd.Flags().BoolVar(&haxDFUForce, "force", false, "Run the exploit even if the device is already running haxed DFU")
 	rootCmd.AddCommand(haxDFUCmd)
+	resetCmd.Flags().BoolVar(&resetDetach, "detach", false, "Issue DFU_DETACH before resetting, to leave DFU mode")
+	resetCmd.Flags().DurationVar(&resetTimeout, "timeout", 10*time.Second, "How long to wait for the device to re-enumerate")
+	rootCmd.AddCommand(resetCmd)
+	rootCmd.AddCommand(serveCmd)
+	runCmd.Flags().StringVar(&runWTF, "wtf", "", "WTF image, or directory of WTFs (eg. from 'fetch'), to run before sending the image in WTF mode")
+	runCmd.Flags().DurationVar(&runWTFTimeout, "wtf-timeout", 30*time.Second, "How long to wait for the device to re-enumerate in WTF mode")
+	runCmd.Flags().BoolVar(&runWrap, "wrap", false, "Wrap raw payloads into an unsigned DFU image for the device before sending them")
+	runCmd.Flags().BoolVar(&runVerify, "verify", false, "Read back the image after sending it and compare it, if the device supports this")
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(makeDFUCmd)
 	rootCmd.AddCommand(dumpCmd)
+	uploadCmd.Flags().StringVarP(&uploadSize, "size", "s", "0", "Maximum number of bytes to read (0 for everything the device sends)")
+	uploadCmd.Flags().BoolVar(&uploadForce, "force", false, "Upload even if the device does not report DFU_UPLOAD support and is not running haxed DFU")
+	rootCmd.AddCommand(uploadCmd)
+	recoveryCmd.AddCommand(recoveryCommandCmd)
+	recoveryCmd.AddCommand(recoveryGetEnvCmd)
+	recoveryCmd.AddCommand(recoverySendCmd)
+	rootCmd.AddCommand(recoveryCmd)
 	rootCmd.AddCommand(decryptCmd)
+	rootCmd.AddCommand(encryptCmd)
 	nandCmd.AddCommand(nandReadCmd)
 	rootCmd.AddCommand(nandCmd)
 	norCmd.AddCommand(norReadCmd)
+	norCmd.AddCommand(norListCmd)
+	norCmd.AddCommand(norExtractCmd)
+	norCmd.AddCommand(norReplaceCmd)
 	rootCmd.AddCommand(norCmd)
 	rootCmd.AddCommand(spewCmd)
+	rootCmd.AddCommand(identifyCmd)
+	fetchCmd.Flags().StringVar(&fetchURL, "url", "", "URL of the firmware archive (default: as known for the device)")
+	fetchCmd.Flags().StringVar(&fetchSHA1, "sha1", "", "Expected SHA-1 of the firmware archive, as hex")
+	fetchCmd.Flags().StringVarP(&fetchKind, "kind", "k", "", "Device kind to extract images for (default: connected device)")
+	fetchCmd.Flags().BoolVar(&fetchInsecure, "insecure-skip-verify", false, "Extract the archive even if no --sha1 is given to verify it with")
+	rootCmd.AddCommand(fetchCmd)
+	efiCmd.PersistentFlags().BoolVar(&efiStrict, "strict", false, "Fail on any EFI specification violation instead of warning")
+	efiListCmd.Flags().BoolVar(&efiListJSON, "json", false, "Output volume structure as JSON")
+	efiCmd.PersistentFlags().IntVar(&efiMaxSize, "max-size", 0, "Maximum size of written volumes including trailing data, eg. the flash partition size (0 for no limit)")
+	efiCmd.PersistentFlags().BoolVar(&efiPreserve, "preserve-layout", false, "Keep unmodified files byte-identical and at their original offsets when writing volumes")
+	efiCmd.PersistentFlags().IntVar(&efiJobs, "jobs", runtime.NumCPU(), "Number of files to parse in parallel")
+	efiCmd.PersistentFlags().BoolVar(&efiAlign, "align-data", false, "Align the data of all files to 16 bytes when writing volumes")
+	efiCmd.PersistentFlags().BoolVar(&efiLazy, "lazy", false, "Only decompress sections when accessed, eg. to quickly get a single file")
+	efiCmd.AddCommand(efiListCmd)
+	efiCmd.AddCommand(efiDiffCmd)
+	efiCmd.AddCommand(efiExtractCmd)
+	efiCmd.AddCommand(efiRebuildCmd)
+	efiCmd.AddCommand(efiGetCmd)
+	efiCmd.AddCommand(efiReplaceCmd)
+	efiGrepCmd.Flags().BoolVar(&efiGrepString, "string", false, "Treat pattern as a string instead of hex bytes")
+	efiCmd.AddCommand(efiGrepCmd)
+	efiPatchCmd.Flags().StringVar(&efiPatchSet, "set", "", "Apply the patch set of this name for the identified firmware version, instead of a patches file")
+	efiCmd.AddCommand(efiPatchCmd)
+	efiCmd.AddCommand(efiScanCmd)
+	efiTrailerCmd.Flags().StringVar(&efiTrailerIMG1, "img1", "", "IMG1 image the volume was extracted from, to validate the trailer layout against")
+	efiCmd.AddCommand(efiTrailerCmd)
+	efiCmd.AddCommand(efiVerifyCmd)
+	nvramCmd.PersistentFlags().IntVar(&nvramStore, "store", 0, "Index of variable store to modify, as shown by 'efi nvram list'")
+	nvramCmd.AddCommand(nvramListCmd)
+	nvramCmd.AddCommand(nvramSetCmd)
+	nvramCmd.AddCommand(nvramDeleteCmd)
+	efiCmd.AddCommand(nvramCmd)
+	efiLogoCmd.AddCommand(efiLogoExportCmd)
+	efiLogoCmd.AddCommand(efiLogoImportCmd)
+	efiCmd.AddCommand(efiLogoCmd)
+	rootCmd.AddCommand(efiCmd)
+	img1Cmd.AddCommand(img1InfoCmd)
+	img1Cmd.AddCommand(img1BodyCmd)
+	img1VerifyCmd.Flags().StringSliceVar(&img1VerifyRoots, "root", nil, "Trusted root certificate (PEM or DER), eg. the Apple root certificate")
+	img1Cmd.AddCommand(img1VerifyCmd)
+	img1Cmd.AddCommand(img1CertsCmd)
+	for _, c := range []*cobra.Command{img1DecryptCmd, img1EncryptCmd} {
+		c.Flags().StringVar(&img1Key, "key", "", "AES key as hex")
+		c.Flags().StringVar(&img1IV, "iv", "", "AES IV as hex (zero if not given)")
+		img1Cmd.AddCommand(c)
+	}
+	rootCmd.AddCommand(img1Cmd)
+	dfuWrapCmd.Flags().StringVarP(&dfuWrapKind, "kind", "k", "", "Device kind (one of 'n3g' or 'classic', 'n4g', 'n5g', 'n6g', 'n7g')")
+	dfuWrapCmd.Flags().StringVarP(&dfuWrapEntrypoint, "entrypoint", "e", "0x0", "Entrypoint, as offset within the payload or absolute address")
+	dfuWrapCmd.Flags().BoolVar(&dfuWrapSuffix, "suffix", false, "Append a DFU suffix for use with other DFU tooling")
+	dfuCmd.AddCommand(dfuWrapCmd)
+	rootCmd.AddCommand(dfuCmd)
+	wtfCmd.AddCommand(wtfInfoCmd)
+	wtfCmd.AddCommand(wtfPayloadCmd)
+	wtfCmd.AddCommand(wtfPatchCmd)
+	wtfCmd.AddCommand(wtfPokeCmd)
+	rootCmd.AddCommand(wtfCmd)
+	mseCmd.AddCommand(mseListCmd)
+	mseCmd.AddCommand(mseUnpackCmd)
+	mseCmd.AddCommand(msePackCmd)
+	rootCmd.AddCommand(mseCmd)
+	binpatchCreateCmd.Flags().StringVarP(&binpatchFormat, "format", "f", "bps", "Patch format (one of 'ips', 'bps')")
+	binpatchCmd.AddCommand(binpatchApplyCmd)
+	binpatchCmd.AddCommand(binpatchCreateCmd)
+	rootCmd.AddCommand(binpatchCmd)
 	if !flag.Parsed() {
 		flag.Parse()
 	}
-	rootCmd.Execute()
+	// Cancel the context of commands on the first interrupt, so that
+	// transfers can be stopped cleanly. Further interrupts kill the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	rootCmd.ExecuteContext(ctx)
+	stop()
+	stopUSBLog()
+}
+
+var (
+	// usbLogPath is set by --usb-log.
+	usbLogPath string
+	usbLogFile *os.File
+)
+
+// deviceDBPaths are set by --device-db.
+var deviceDBPaths []string
+
+// setup runs before all commands, applying global flags.
+func setup(cmd *cobra.Command, args []string) error {
+	for _, path := range deviceDBPaths {
+		if err := devices.LoadFile(path); err != nil {
+			return fmt.Errorf("could not load device database: %w", err)
+		}
+	}
+	return startUSBLog(cmd, args)
+}
+
+// startUSBLog starts recording USB transfers if --usb-log is given.
+func startUSBLog(cmd *cobra.Command, args []string) error {
+	if usbLogPath == "" {
+		return nil
+	}
+	f, err := os.Create(usbLogPath)
+	if err != nil {
+		return fmt.Errorf("could not create USB log: %w", err)
+	}
+	usbLogFile = f
+	usblog.Start(f)
+	return nil
+}
+
+func stopUSBLog() {
+	if usbLogFile == nil {
+		return
+	}
+	usblog.Stop()
+	usbLogFile.Close()
 }
 
 func init() {
@@ -79,43 +252,168 @@ func newContext() (*gousb.Context, error) {
 
 type app struct {
 	ctx  *gousb.Context
-	usb  *gousb.Device
+	usb  transport.Device
 	desc *devices.Description
 	ep   exploit.Parameters
+	// prefix of log messages, identifying the device in --all mode.
+	prefix string
+}
+
+// infof logs an informational message about the device.
+func (a *app) infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, a.prefix+fmt.Sprintf(format, args...))
+}
+
+// progress returns a progress callback for transfers to the device, which
+// shows a progress bar unless multiple devices are being handled.
+func (a *app) progress() dfu.Progress {
+	if a.prefix != "" {
+		return nil
+	}
+	return newProgressBar().update
 }
 
 func (a *app) close() {
+	if a.ctx == nil {
+		// Remote devices have no local USB context.
+		a.usb.Close()
+		return
+	}
 	a.ctx.Close()
 }
 
+// deviceSelector selects the device used by newApp, as given by --serial,
+// --bus and --address.
+var deviceSelector devices.Selector
+
 func newApp() (*app, error) {
+	if remoteAddr != "" {
+		return newRemoteApp()
+	}
 	ctx, err := newContext()
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize USB: %w", err)
 	}
 
-	var errs error
-	for _, deviceDesc := range devices.Descriptions {
-		usb, err := ctx.OpenDeviceWithVIDPID(deviceDesc.DFUVID, deviceDesc.DFUPID)
-		if err != nil {
-			errs = multierror.Append(errs, err)
+	var usb *gousb.Device
+	var desc *devices.Description
+	err = waitForDevice(func() (err error) {
+		usb, desc, err = devices.Open(ctx, deviceSelector)
+		return err
+	})
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+	usb.ControlTimeout = dfu.ControlTimeout
+	return &app{
+		ctx:  ctx,
+		usb:  transport.GoUSB{Device: usb},
+		desc: desc,
+		ep:   exploit.ParametersForKind[desc.Kind],
+	}, nil
+}
+
+// waitTimeout is set by --wait, to wait for a device to be connected instead
+// of failing immediately.
+var waitTimeout time.Duration
+
+// waitForDevice calls open until it stops returning devices.ErrNotFound, or
+// --wait times out.
+func waitForDevice(open func() error) error {
+	deadline := time.Now().Add(waitTimeout)
+	logged := false
+	for {
+		err := open()
+		if !errors.Is(err, devices.ErrNotFound) || !time.Now().Before(deadline) {
+			return accessHint(err)
+		}
+		if !logged {
+			glog.Infof("Waiting up to %s for a device in DFU mode to be connected...", waitTimeout)
+			logged = true
 		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
 
-		if usb == nil {
-			continue
+// newExploitApp is newApp, failing if the connected device cannot be
+// exploited.
+func newExploitApp() (*app, error) {
+	app, err := newApp()
+	if err != nil {
+		return nil, err
+	}
+	if app.ep == nil {
+		app.close()
+		return nil, fmt.Errorf("%s: %w", app.desc.Kind, exploit.ErrUnsupported)
+	}
+	return app, nil
+}
+
+// allDevices is set by --all, to run commands which support it on all matched
+// devices concurrently.
+var allDevices bool
+
+// forEachDevice runs fn on the device selected by deviceSelector or, with
+// --all, on all matched devices concurrently, with log messages prefixed by
+// the device's bus and address.
+func forEachDevice(fn func(a *app) error) error {
+	if allDevices && remoteAddr != "" {
+		return fmt.Errorf("--all cannot be used with --remote")
+	}
+	if !allDevices {
+		app, err := newApp()
+		if err != nil {
+			return err
 		}
+		defer app.close()
+		return fn(app)
+	}
 
-		return &app{
-			ctx:  ctx,
-			usb:  usb,
-			desc: &deviceDesc,
-			ep:   exploit.ParametersForKind[deviceDesc.Kind],
-		}, nil
+	ctx, err := newContext()
+	if err != nil {
+		return fmt.Errorf("failed to initialize USB: %w", err)
 	}
-	if errs == nil {
-		return nil, fmt.Errorf("no device found")
+	defer ctx.Close()
+	var usbs []devices.Device
+	err = waitForDevice(func() (err error) {
+		usbs, err = devices.OpenAll(ctx, deviceSelector)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	glog.Infof("Found %d devices.", len(usbs))
+
+	var mu sync.Mutex
+	var errs error
+	var wg sync.WaitGroup
+	for _, usb := range usbs {
+		desc := usb.Description
+		usb.ControlTimeout = dfu.ControlTimeout
+		a := &app{
+			ctx:    ctx,
+			usb:    transport.GoUSB{Device: usb.Device},
+			desc:   desc,
+			ep:     exploit.ParametersForKind[desc.Kind],
+			prefix: fmt.Sprintf("[%d.%d %s] ", usb.Desc.Bus, usb.Desc.Address, desc.Kind),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer a.usb.Close()
+			if err := fn(a); err != nil {
+				glog.Errorf("%s%v", a.prefix, err)
+				mu.Lock()
+				errs = multierror.Append(errs, fmt.Errorf("%s%w", a.prefix, err))
+				mu.Unlock()
+				return
+			}
+			a.infof("Done.")
+		}()
 	}
-	return nil, errs
+	wg.Wait()
+	return errs
 }
 
 func parseNumber(s string) (uint32, error) {
@@ -0,0 +1,47 @@
+package binpatch
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	src := bytes.Repeat([]byte("wInd3x firmware "), 64)
+	for _, tc := range []struct {
+		name string
+		dst  []byte
+	}{
+		{"same", src},
+		{"modified", append(append(append([]byte{}, src[:100]...), "PATCHED"...), src[107:]...)},
+		{"grown", append(append([]byte{}, src...), "tail"...)},
+		{"truncated", src[:500]},
+	} {
+		ips, err := CreateIPS(src, tc.dst)
+		if err != nil {
+			t.Fatalf("%s: CreateIPS: %v", tc.name, err)
+		}
+		res, err := ApplyIPS(src, ips)
+		if err != nil {
+			t.Fatalf("%s: ApplyIPS: %v", tc.name, err)
+		}
+		if !bytes.Equal(tc.dst, res) {
+			t.Errorf("%s: IPS result differs", tc.name)
+		}
+
+		bps := CreateBPS(src, tc.dst)
+		res, err = ApplyBPS(src, bps)
+		if err != nil {
+			t.Fatalf("%s: ApplyBPS: %v", tc.name, err)
+		}
+		if !bytes.Equal(tc.dst, res) {
+			t.Errorf("%s: BPS result differs", tc.name)
+		}
+	}
+}
+
+func TestApplyBPSChecksSource(t *testing.T) {
+	bps := CreateBPS([]byte("hello"), []byte("jello"))
+	if _, err := ApplyBPS([]byte("world"), bps); err == nil {
+		t.Errorf("ApplyBPS with wrong source succeeded")
+	}
+}
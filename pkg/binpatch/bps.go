@@ -0,0 +1,222 @@
+package binpatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+var bpsMagic = []byte("BPS1")
+
+const (
+	bpsSourceRead = 0
+	bpsTargetRead = 1
+	bpsSourceCopy = 2
+	bpsTargetCopy = 3
+)
+
+// IsBPS returns whether data looks like a BPS patch.
+func IsBPS(data []byte) bool {
+	return bytes.HasPrefix(data, bpsMagic)
+}
+
+type bpsReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bpsReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errors.New("unexpected end of patch")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *bpsReader) number() (uint64, error) {
+	var res uint64
+	shift := uint64(1)
+	for i := 0; ; i++ {
+		if i >= 10 {
+			return 0, errors.New("number too large")
+		}
+		x, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		res += uint64(x&0x7f) * shift
+		if x&0x80 != 0 {
+			return res, nil
+		}
+		shift <<= 7
+		res += shift
+	}
+}
+
+func (r *bpsReader) signed() (int64, error) {
+	n, err := r.number()
+	if err != nil {
+		return 0, err
+	}
+	v := int64(n >> 1)
+	if n&1 != 0 {
+		v = -v
+	}
+	return v, nil
+}
+
+func bpsNumber(buf *bytes.Buffer, n uint64) {
+	for {
+		x := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			buf.WriteByte(0x80 | x)
+			return
+		}
+		buf.WriteByte(x)
+		n--
+	}
+}
+
+// ApplyBPS applies a BPS patch to src and returns the result. The checksums of
+// the source, result and patch are verified.
+func ApplyBPS(src, patch []byte) ([]byte, error) {
+	if !IsBPS(patch) {
+		return nil, errors.New("not a BPS patch")
+	}
+	if len(patch) < len(bpsMagic)+12 {
+		return nil, errors.New("patch too short")
+	}
+	footer := patch[len(patch)-12:]
+	if want, got := binary.LittleEndian.Uint32(footer[8:]), crc32.ChecksumIEEE(patch[:len(patch)-4]); want != got {
+		return nil, fmt.Errorf("patch checksum mismatch: wanted %08x, got %08x", want, got)
+	}
+	if want, got := binary.LittleEndian.Uint32(footer[0:]), crc32.ChecksumIEEE(src); want != got {
+		return nil, fmt.Errorf("source checksum mismatch: wanted %08x, got %08x", want, got)
+	}
+
+	r := &bpsReader{data: patch[:len(patch)-12], pos: len(bpsMagic)}
+	srcSize, err := r.number()
+	if err != nil {
+		return nil, fmt.Errorf("reading source size: %w", err)
+	}
+	if srcSize != uint64(len(src)) {
+		return nil, fmt.Errorf("source is %d bytes, patch wants %d", len(src), srcSize)
+	}
+	dstSize, err := r.number()
+	if err != nil {
+		return nil, fmt.Errorf("reading target size: %w", err)
+	}
+	// Every action produces at least one byte of output from at least one
+	// byte of patch, or copies data already present in source or target.
+	if dstSize > uint64(len(src))+uint64(len(patch))*(1<<16) {
+		return nil, fmt.Errorf("target size %d implausible", dstSize)
+	}
+	metaSize, err := r.number()
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata size: %w", err)
+	}
+	if metaSize > uint64(len(r.data)-r.pos) {
+		return nil, errors.New("metadata size out of bounds")
+	}
+	r.pos += int(metaSize)
+
+	dst := make([]byte, 0, dstSize)
+	var srcRel, dstRel int64
+	for r.pos < len(r.data) {
+		n, err := r.number()
+		if err != nil {
+			return nil, fmt.Errorf("reading action: %w", err)
+		}
+		action := n & 3
+		length := int64(n>>2) + 1
+		if uint64(len(dst))+uint64(length) > dstSize {
+			return nil, errors.New("action overruns target size")
+		}
+		switch action {
+		case bpsSourceRead:
+			off := int64(len(dst))
+			if off+length > int64(len(src)) {
+				return nil, errors.New("source read out of bounds")
+			}
+			dst = append(dst, src[off:off+length]...)
+		case bpsTargetRead:
+			if int64(len(r.data)-r.pos) < length {
+				return nil, errors.New("target read out of bounds")
+			}
+			dst = append(dst, r.data[r.pos:r.pos+int(length)]...)
+			r.pos += int(length)
+		case bpsSourceCopy:
+			d, err := r.signed()
+			if err != nil {
+				return nil, fmt.Errorf("reading source offset: %w", err)
+			}
+			srcRel += d
+			if srcRel < 0 || srcRel+length > int64(len(src)) {
+				return nil, errors.New("source copy out of bounds")
+			}
+			dst = append(dst, src[srcRel:srcRel+length]...)
+			srcRel += length
+		case bpsTargetCopy:
+			d, err := r.signed()
+			if err != nil {
+				return nil, fmt.Errorf("reading target offset: %w", err)
+			}
+			dstRel += d
+			if dstRel < 0 || dstRel >= int64(len(dst)) {
+				return nil, errors.New("target copy out of bounds")
+			}
+			// Copies may overlap the data being written, so copy byte
+			// by byte.
+			for i := int64(0); i < length; i++ {
+				dst = append(dst, dst[dstRel])
+				dstRel++
+			}
+		}
+	}
+	if uint64(len(dst)) != dstSize {
+		return nil, fmt.Errorf("patch produced %d bytes, wanted %d", len(dst), dstSize)
+	}
+	if want, got := binary.LittleEndian.Uint32(footer[4:]), crc32.ChecksumIEEE(dst); want != got {
+		return nil, fmt.Errorf("target checksum mismatch: wanted %08x, got %08x", want, got)
+	}
+	return dst, nil
+}
+
+// CreateBPS creates a BPS patch which turns src into dst. The patch only uses
+// SourceRead and TargetRead actions, ie. it is optimized for in-place
+// modifications like firmware patches, not for data which moved around.
+func CreateBPS(src, dst []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(bpsMagic)
+	bpsNumber(buf, uint64(len(src)))
+	bpsNumber(buf, uint64(len(dst)))
+	bpsNumber(buf, 0)
+
+	same := func(i int) bool {
+		return i < len(src) && src[i] == dst[i]
+	}
+	for i := 0; i < len(dst); {
+		start := i
+		if same(i) {
+			for i < len(dst) && same(i) {
+				i++
+			}
+			bpsNumber(buf, uint64(i-start-1)<<2|bpsSourceRead)
+			continue
+		}
+		for i < len(dst) && !same(i) {
+			i++
+		}
+		bpsNumber(buf, uint64(i-start-1)<<2|bpsTargetRead)
+		buf.Write(dst[start:i])
+	}
+
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(src))
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(dst))
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
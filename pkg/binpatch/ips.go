@@ -0,0 +1,142 @@
+// package binpatch implements the IPS and BPS binary patch formats, as used by
+// the ROM hacking community, so that firmware patches can be distributed as
+// standard patch files.
+package binpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	ipsMagic  = []byte("PATCH")
+	ipsFooter = []byte("EOF")
+)
+
+const (
+	// ipsMaxOffset is the first offset that cannot be addressed by an IPS
+	// record.
+	ipsMaxOffset = 1 << 24
+	// ipsEOFOffset is the record offset which would be mistaken for the
+	// footer.
+	ipsEOFOffset = 0x454f46
+	ipsMaxRecord = 0xffff
+)
+
+// Apply applies an IPS or BPS patch to src, detecting its format.
+func Apply(src, patch []byte) ([]byte, error) {
+	switch {
+	case IsIPS(patch):
+		return ApplyIPS(src, patch)
+	case IsBPS(patch):
+		return ApplyBPS(src, patch)
+	}
+	return nil, errors.New("unknown patch format")
+}
+
+// IsIPS returns whether data looks like an IPS patch.
+func IsIPS(data []byte) bool {
+	return bytes.HasPrefix(data, ipsMagic)
+}
+
+// ApplyIPS applies an IPS patch to src and returns the result. The truncation
+// extension (a 24-bit size following the footer) is supported.
+func ApplyIPS(src, patch []byte) ([]byte, error) {
+	if !IsIPS(patch) {
+		return nil, errors.New("not an IPS patch")
+	}
+	res := append([]byte{}, src...)
+	write := func(offset int, data []byte) {
+		if end := offset + len(data); end > len(res) {
+			res = append(res, make([]byte, end-len(res))...)
+		}
+		copy(res[offset:], data)
+	}
+
+	p := patch[len(ipsMagic):]
+	for {
+		if len(p) < 3 {
+			return nil, errors.New("truncated patch: missing footer")
+		}
+		if bytes.Equal(p[:3], ipsFooter) {
+			p = p[3:]
+			break
+		}
+		if len(p) < 5 {
+			return nil, errors.New("truncated record header")
+		}
+		offset := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(p[3])<<8 | int(p[4])
+		p = p[5:]
+		if size != 0 {
+			if len(p) < size {
+				return nil, fmt.Errorf("record at 0x%x: truncated data", offset)
+			}
+			write(offset, p[:size])
+			p = p[size:]
+			continue
+		}
+		// RLE record.
+		if len(p) < 3 {
+			return nil, fmt.Errorf("record at 0x%x: truncated RLE record", offset)
+		}
+		count := int(p[0])<<8 | int(p[1])
+		write(offset, bytes.Repeat([]byte{p[2]}, count))
+		p = p[3:]
+	}
+	switch len(p) {
+	case 0:
+	case 3:
+		size := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		if size < len(res) {
+			res = res[:size]
+		}
+	default:
+		return nil, fmt.Errorf("%d bytes of trailing data after footer", len(p))
+	}
+	return res, nil
+}
+
+// CreateIPS creates an IPS patch which turns src into dst. Both must be
+// smaller than 16MiB, as that is the largest offset IPS can address. If dst is
+// shorter than src, the truncation extension is used.
+func CreateIPS(src, dst []byte) ([]byte, error) {
+	if len(src) > ipsMaxOffset || len(dst) > ipsMaxOffset {
+		return nil, errors.New("IPS patches cannot address data past 16MiB")
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.Write(ipsMagic)
+	record := func(offset int, data []byte) {
+		buf.Write([]byte{byte(offset >> 16), byte(offset >> 8), byte(offset)})
+		buf.Write([]byte{byte(len(data) >> 8), byte(len(data))})
+		buf.Write(data)
+	}
+
+	differs := func(i int) bool {
+		return i >= len(src) || src[i] != dst[i]
+	}
+	for i := 0; i < len(dst); {
+		if !differs(i) {
+			i++
+			continue
+		}
+		start := i
+		if start == ipsEOFOffset {
+			// Start one byte early so that the record isn't mistaken
+			// for the footer.
+			start--
+		}
+		end := i
+		for end < len(dst) && end-start < ipsMaxRecord && differs(end) {
+			end++
+		}
+		record(start, dst[start:end])
+		i = end
+	}
+	buf.Write(ipsFooter)
+	if len(dst) < len(src) {
+		buf.Write([]byte{byte(len(dst) >> 16), byte(len(dst) >> 8), byte(len(dst))})
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,231 @@
+// package bmp implements decoding and encoding of uncompressed Windows BMP
+// images, as used for boot logos and other pictures in EFI firmware.
+//
+// Unlike a general purpose codec, it keeps track of the pixel format an image
+// was decoded from, so that a replacement picture can be encoded back in the
+// same format as the original.
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	fileHeaderSize = 14
+	infoHeaderSize = 40
+)
+
+type fileHeader struct {
+	Magic      [2]byte
+	FileSize   uint32
+	Reserved1  uint16
+	Reserved2  uint16
+	DataOffset uint32
+}
+
+type infoHeader struct {
+	Size            uint32
+	Width           int32
+	Height          int32
+	Planes          uint16
+	BitsPerPixel    uint16
+	Compression     uint32
+	ImageSize       uint32
+	XPixelsPerMeter int32
+	YPixelsPerMeter int32
+	ColorsUsed      uint32
+	ColorsImportant uint32
+}
+
+// Format describes how the pixels of a BMP image are stored.
+type Format struct {
+	// BitsPerPixel is one of 1, 4, 8 (paletted), 24 or 32 (BGR/BGRX).
+	BitsPerPixel int
+	// Palette for paletted formats.
+	Palette color.Palette
+	// TopDown is set if rows are stored from the top of the image down,
+	// instead of the usual bottom-up.
+	TopDown bool
+}
+
+// IsBMP returns whether data looks like a BMP image.
+func IsBMP(data []byte) bool {
+	if len(data) < fileHeaderSize+infoHeaderSize || data[0] != 'B' || data[1] != 'M' {
+		return false
+	}
+	size := binary.LittleEndian.Uint32(data[fileHeaderSize:])
+	return size == 40 || size == 108 || size == 124
+}
+
+func rowSize(width, bpp int) int {
+	return ((width*bpp + 31) / 32) * 4
+}
+
+// Decode decodes a BMP image, returning it alongside the format it was stored
+// in.
+func Decode(data []byte) (image.Image, *Format, error) {
+	if !IsBMP(data) {
+		return nil, nil, fmt.Errorf("not a BMP image")
+	}
+	r := bytes.NewReader(data)
+	var fh fileHeader
+	var ih infoHeader
+	binary.Read(r, binary.LittleEndian, &fh)
+	binary.Read(r, binary.LittleEndian, &ih)
+	if ih.Compression != 0 {
+		return nil, nil, fmt.Errorf("unsupported compression %d", ih.Compression)
+	}
+	if ih.Planes != 1 {
+		return nil, nil, fmt.Errorf("unsupported plane count %d", ih.Planes)
+	}
+	f := &Format{
+		BitsPerPixel: int(ih.BitsPerPixel),
+	}
+	width := int(ih.Width)
+	height := int(ih.Height)
+	if height < 0 {
+		height = -height
+		f.TopDown = true
+	}
+	if width <= 0 || height == 0 || width > 1<<15 || height > 1<<15 {
+		return nil, nil, fmt.Errorf("invalid dimensions %dx%d", ih.Width, ih.Height)
+	}
+
+	switch f.BitsPerPixel {
+	case 1, 4, 8:
+		colors := int(ih.ColorsUsed)
+		if colors == 0 {
+			colors = 1 << f.BitsPerPixel
+		}
+		if colors > 1<<f.BitsPerPixel {
+			return nil, nil, fmt.Errorf("too many palette colors (%d)", colors)
+		}
+		start := fileHeaderSize + int(ih.Size)
+		if start+4*colors > len(data) {
+			return nil, nil, fmt.Errorf("palette truncated")
+		}
+		for i := 0; i < colors; i++ {
+			p := data[start+4*i:]
+			f.Palette = append(f.Palette, color.RGBA{R: p[2], G: p[1], B: p[0], A: 0xff})
+		}
+	case 24, 32:
+	default:
+		return nil, nil, fmt.Errorf("unsupported bit depth %d", f.BitsPerPixel)
+	}
+
+	stride := rowSize(width, f.BitsPerPixel)
+	if uint64(fh.DataOffset)+uint64(stride)*uint64(height) > uint64(len(data)) {
+		return nil, nil, fmt.Errorf("pixel data truncated")
+	}
+	pixels := data[fh.DataOffset:]
+
+	var res image.Image
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	paletted := image.NewPaletted(image.Rect(0, 0, width, height), f.Palette)
+	for y := 0; y < height; y++ {
+		row := pixels[y*stride:]
+		dy := height - 1 - y
+		if f.TopDown {
+			dy = y
+		}
+		for x := 0; x < width; x++ {
+			switch f.BitsPerPixel {
+			case 24, 32:
+				p := row[x*f.BitsPerPixel/8:]
+				rgba.SetRGBA(x, dy, color.RGBA{R: p[2], G: p[1], B: p[0], A: 0xff})
+			default:
+				bit := x * f.BitsPerPixel
+				v := row[bit/8] >> (8 - f.BitsPerPixel - bit%8) & (1<<f.BitsPerPixel - 1)
+				if int(v) >= len(f.Palette) {
+					return nil, nil, fmt.Errorf("pixel (%d, %d) out of palette range", x, dy)
+				}
+				paletted.SetColorIndex(x, dy, v)
+			}
+		}
+	}
+	if f.Palette != nil {
+		res = paletted
+	} else {
+		res = rgba
+	}
+	return res, f, nil
+}
+
+// Encode encodes img as a BMP image in the given format. For paletted formats,
+// pixels are mapped to the closest color in the palette.
+func Encode(img image.Image, f *Format) ([]byte, error) {
+	switch f.BitsPerPixel {
+	case 1, 4, 8:
+		if len(f.Palette) == 0 || len(f.Palette) > 1<<f.BitsPerPixel {
+			return nil, fmt.Errorf("invalid palette size %d for bit depth %d", len(f.Palette), f.BitsPerPixel)
+		}
+	case 24, 32:
+	default:
+		return nil, fmt.Errorf("unsupported bit depth %d", f.BitsPerPixel)
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	stride := rowSize(width, f.BitsPerPixel)
+	paletteSize := 4 * len(f.Palette)
+	dataOffset := fileHeaderSize + infoHeaderSize + paletteSize
+
+	ih := infoHeader{
+		Size:         infoHeaderSize,
+		Width:        int32(width),
+		Height:       int32(height),
+		Planes:       1,
+		BitsPerPixel: uint16(f.BitsPerPixel),
+		ImageSize:    uint32(stride * height),
+	}
+	if f.TopDown {
+		ih.Height = -ih.Height
+	}
+	if f.Palette != nil {
+		ih.ColorsUsed = uint32(len(f.Palette))
+	}
+	fh := fileHeader{
+		Magic:      [2]byte{'B', 'M'},
+		FileSize:   uint32(dataOffset + stride*height),
+		DataOffset: uint32(dataOffset),
+	}
+
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, fh)
+	binary.Write(buf, binary.LittleEndian, ih)
+	for _, c := range f.Palette {
+		r, g, b, _ := c.RGBA()
+		buf.Write([]byte{byte(b >> 8), byte(g >> 8), byte(r >> 8), 0})
+	}
+	row := make([]byte, stride)
+	for i := 0; i < height; i++ {
+		y := height - 1 - i
+		if f.TopDown {
+			y = i
+		}
+		for j := range row {
+			row[j] = 0
+		}
+		for x := 0; x < width; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			switch f.BitsPerPixel {
+			case 24, 32:
+				r, g, bl, _ := c.RGBA()
+				p := row[x*f.BitsPerPixel/8:]
+				p[0], p[1], p[2] = byte(bl>>8), byte(g>>8), byte(r>>8)
+			default:
+				v := byte(f.Palette.Index(c))
+				bit := x * f.BitsPerPixel
+				row[bit/8] |= v << (8 - f.BitsPerPixel - bit%8)
+			}
+		}
+		buf.Write(row)
+	}
+	return buf.Bytes(), nil
+}
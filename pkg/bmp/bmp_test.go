@@ -0,0 +1,62 @@
+package bmp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{0, 0, 0, 0xff},
+		color.RGBA{0xff, 0xff, 0xff, 0xff},
+	}
+	for _, f := range []*Format{
+		{BitsPerPixel: 24},
+		{BitsPerPixel: 32, TopDown: true},
+		{BitsPerPixel: 8, Palette: palette},
+		{BitsPerPixel: 1, Palette: palette},
+	} {
+		img := image.NewRGBA(image.Rect(0, 0, 13, 7))
+		for y := 0; y < 7; y++ {
+			for x := 0; x < 13; x++ {
+				if (x+y)%3 == 0 {
+					img.Set(x, y, color.White)
+				} else {
+					img.Set(x, y, color.Black)
+				}
+			}
+		}
+		data, err := Encode(img, f)
+		if err != nil {
+			t.Fatalf("%d bpp: Encode: %v", f.BitsPerPixel, err)
+		}
+		if !IsBMP(data) {
+			t.Fatalf("%d bpp: encoded image not detected as BMP", f.BitsPerPixel)
+		}
+		img2, f2, err := Decode(data)
+		if err != nil {
+			t.Fatalf("%d bpp: Decode: %v", f.BitsPerPixel, err)
+		}
+		if f2.BitsPerPixel != f.BitsPerPixel || f2.TopDown != f.TopDown || len(f2.Palette) != len(f.Palette) {
+			t.Errorf("%d bpp: decoded format %+v, wanted %+v", f.BitsPerPixel, f2, f)
+		}
+		for y := 0; y < 7; y++ {
+			for x := 0; x < 13; x++ {
+				r1, g1, b1, _ := img.At(x, y).RGBA()
+				r2, g2, b2, _ := img2.At(x, y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 {
+					t.Fatalf("%d bpp: pixel (%d, %d) differs", f.BitsPerPixel, x, y)
+				}
+			}
+		}
+		data2, err := Encode(img2, f2)
+		if err != nil {
+			t.Fatalf("%d bpp: Encode: %v", f.BitsPerPixel, err)
+		}
+		if !bytes.Equal(data, data2) {
+			t.Errorf("%d bpp: re-encoded image differs", f.BitsPerPixel)
+		}
+	}
+}
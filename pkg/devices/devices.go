@@ -9,23 +9,43 @@ import (
 type Kind string
 
 const (
+	// Nano3 is the Nano 3G, as well as the Classic (6G, 6.5G and 7G), which
+	// uses the same S5L8702 SoC and bootrom, and thus cannot be told apart in
+	// DFU mode.
 	Nano3 Kind = "n3g"
 	Nano4 Kind = "n4g"
 	Nano5 Kind = "n5g"
+	Nano6 Kind = "n6g"
+	Nano7 Kind = "n7g"
+	// S5L8900 devices, ie. the first iPhone, iPhone 3G and first iPod Touch,
+	// which share the DFU flow of the iPods but take 8900 images. They
+	// cannot be exploited, so only signed images can be run.
+	S5L8900 Kind = "s5l8900"
 )
 
 func (k Kind) String() string {
 	switch k {
 	case Nano3:
-		return "Nano 3G"
+		return "Nano 3G/Classic"
 	case Nano4:
 		return "Nano 4G"
 	case Nano5:
 		return "Nano 5G"
+	case Nano6:
+		return "Nano 6G"
+	case Nano7:
+		return "Nano 7G"
+	case S5L8900:
+		return "iPhone/iPod Touch (S5L8900)"
 	}
 	return "UNKNOWN"
 }
 
+// Aliases are other names accepted for kinds on the command line.
+var Aliases = map[string]Kind{
+	"classic": Nano3,
+}
+
 func (k Kind) SoCCode() string {
 	switch k {
 	case Nano3:
@@ -34,22 +54,39 @@ func (k Kind) SoCCode() string {
 		return "8720"
 	case Nano5:
 		return "8730"
+	case Nano6:
+		return "8723"
+	case Nano7:
+		return "8740"
+	case S5L8900:
+		return "8900"
 	}
 	return "INVL"
 }
 
 func (k Kind) DFUVersion() dfu.ProtoVersion {
-	if k == Nano3 {
+	switch k {
+	case Nano3:
 		return dfu.ProtoVersion1
+	case S5L8900:
+		return dfu.ProtoVersionSuffix
 	}
 	return dfu.ProtoVersion2
 }
 
+// Description of how a supported device in DFU mode is identified.
 type Description struct {
 	DFUVID, DFUPID gousb.ID
 	Kind           Kind
+	// Device, if not zero, is the bcdDevice the device must report.
+	Device gousb.BCD
+	// SerialPrefix, if not empty, is a prefix of the serial number the device
+	// must report, eg. to tell apart regional variants.
+	SerialPrefix string
 }
 
+// Descriptions of all known devices, in order of precedence. More entries
+// can be added with Register or LoadFile.
 var Descriptions = []Description{
 	{
 		DFUVID: 0x05ac,
@@ -66,4 +103,21 @@ var Descriptions = []Description{
 		DFUPID: 0x1231,
 		Kind:   Nano5,
 	},
+	{
+		DFUVID: 0x05ac,
+		DFUPID: 0x1232,
+		Kind:   Nano6,
+	},
+	// Units enumerating differently (eg. later revisions) can be added with a
+	// device database, see LoadFile.
+	{
+		DFUVID: 0x05ac,
+		DFUPID: 0x1234,
+		Kind:   Nano7,
+	},
+	{
+		DFUVID: 0x05ac,
+		DFUPID: 0x1222,
+		Kind:   S5L8900,
+	},
 }
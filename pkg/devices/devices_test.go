@@ -0,0 +1,132 @@
+package devices
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/gousb"
+)
+
+func TestSelector(t *testing.T) {
+	desc := &gousb.DeviceDesc{Bus: 1, Address: 5, Vendor: 0x05ac, Product: 0x1225}
+	for i, test := range []struct {
+		s    Selector
+		want bool
+	}{
+		{Selector{}, true},
+		{Selector{Bus: 1}, true},
+		{Selector{Bus: 2}, false},
+		{Selector{Bus: 1, Address: 5}, true},
+		{Selector{Address: 6}, false},
+	} {
+		if got := test.s.matches(desc); got != test.want {
+			t.Errorf("%d: wanted %v, got %v", i, test.want, got)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	defer func(d []Description) { Descriptions = d }(Descriptions)
+
+	path := filepath.Join(t.TempDir(), "devices.json")
+	data := `[{"kind": "n5g", "vid": "05ac", "pid": "1299", "bcd_device": "0x0200", "serial_prefix": "YM"}]`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+
+	nano4 := &gousb.DeviceDesc{Vendor: 0x05ac, Product: 0x1225}
+	custom := &gousb.DeviceDesc{Vendor: 0x05ac, Product: 0x1299, Device: 0x0200}
+	other := &gousb.DeviceDesc{Vendor: 0x05ac, Product: 0x1299, Device: 0x0100}
+	for i, test := range []struct {
+		desc   *gousb.DeviceDesc
+		serial string
+		want   Kind
+	}{
+		{nano4, "", Nano4},
+		{custom, "YM1234", Nano5},
+		{custom, "XX1234", ""},
+		{other, "YM1234", ""},
+	} {
+		var got Kind
+		if d := Lookup(test.desc, test.serial); d != nil {
+			got = d.Kind
+		}
+		if got != test.want {
+			t.Errorf("%d: wanted %q, got %q", i, test.want, got)
+		}
+	}
+	if !needSerial(custom) || needSerial(nano4) {
+		t.Errorf("needSerial returned wrong result")
+	}
+
+	if err := Register(Description{Kind: "n9g"}); err == nil {
+		t.Errorf("Register accepted unknown kind")
+	}
+}
+
+func TestHasDFUInterface(t *testing.T) {
+	withClass := func(class gousb.Class, subclass gousb.Class) *gousb.DeviceDesc {
+		return &gousb.DeviceDesc{
+			Configs: map[int]gousb.ConfigDesc{
+				1: {Interfaces: []gousb.InterfaceDesc{{
+					AltSettings: []gousb.InterfaceSetting{{Class: class, SubClass: subclass}},
+				}}},
+			},
+		}
+	}
+	if !hasDFUInterface(withClass(gousb.ClassApplication, 1)) {
+		t.Errorf("DFU interface not recognized")
+	}
+	if hasDFUInterface(withClass(gousb.ClassMassStorage, 6)) {
+		t.Errorf("mass storage interface recognized as DFU")
+	}
+	if hasDFUInterface(&gousb.DeviceDesc{}) {
+		t.Errorf("device without configurations recognized as DFU")
+	}
+}
+
+func TestModeOf(t *testing.T) {
+	withClass := func(pid gousb.ID, class, subclass gousb.Class) *gousb.DeviceDesc {
+		return &gousb.DeviceDesc{
+			Vendor:  0x05ac,
+			Product: pid,
+			Configs: map[int]gousb.ConfigDesc{
+				1: {Interfaces: []gousb.InterfaceDesc{{
+					AltSettings: []gousb.InterfaceSetting{{Class: class, SubClass: subclass}},
+				}}},
+			},
+		}
+	}
+	for i, test := range []struct {
+		desc *gousb.DeviceDesc
+		want Mode
+	}{
+		{withClass(0x1225, gousb.ClassApplication, 1), ModeDFU},
+		{withClass(0x1243, gousb.ClassApplication, 1), ModeWTF},
+		{withClass(0x1281, gousb.ClassVendorSpec, 0), ModeRecovery},
+		{withClass(0x1263, gousb.ClassMassStorage, 6), ModeDisk},
+		{withClass(0x1263, gousb.ClassHID, 0), ""},
+		{&gousb.DeviceDesc{Vendor: 0x1234, Product: 0x1225}, ""},
+	} {
+		if got := ModeOf(test.desc); got != test.want {
+			t.Errorf("%d: wanted %q, got %q", i, test.want, got)
+		}
+	}
+}
+
+func TestUdevRules(t *testing.T) {
+	rules := UdevRules()
+	for _, want := range []string{
+		`ATTR{idVendor}=="05ac", ATTR{idProduct}=="1225"`,
+		`ATTR{idVendor}=="05ac", ATTR{idProduct}=="1281"`,
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules do not contain %s:\n%s", want, rules)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package devices
+
+import (
+	"sort"
+
+	"github.com/google/gousb"
+)
+
+// Mode is the mode an Apple device is connected in.
+type Mode string
+
+const (
+	// ModeDFU is bootrom DFU mode, as recognized by Lookup.
+	ModeDFU Mode = "DFU"
+	// ModeWTF is the DFU mode of a device running a WTF, see OpenWTF.
+	ModeWTF Mode = "WTF"
+	// ModeRecovery is recovery mode, see OpenRecovery.
+	ModeRecovery Mode = "recovery"
+	// ModeDisk is disk mode, or a normally booted device exposing its
+	// storage.
+	ModeDisk Mode = "disk"
+)
+
+// hasInterfaceClass returns whether a device exposes an interface of a given
+// class.
+func hasInterfaceClass(desc *gousb.DeviceDesc, class gousb.Class) bool {
+	for _, cfg := range desc.Configs {
+		for _, intf := range cfg.Interfaces {
+			for _, alt := range intf.AltSettings {
+				if alt.Class == class {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ModeOf returns the mode of a device, not taking its serial number into
+// account, or an empty Mode if it is not an Apple device in a known mode.
+func ModeOf(desc *gousb.DeviceDesc) Mode {
+	if desc.Vendor != appleVID {
+		return ""
+	}
+	if candidate(desc) {
+		return ModeDFU
+	}
+	for _, pid := range RecoveryPIDs {
+		if desc.Product == pid {
+			return ModeRecovery
+		}
+	}
+	if hasDFUInterface(desc) {
+		return ModeWTF
+	}
+	if hasInterfaceClass(desc, gousb.ClassMassStorage) {
+		return ModeDisk
+	}
+	return ""
+}
+
+// Listed is a connected device, as returned by List.
+type Listed struct {
+	Bus, Address    int
+	Vendor, Product gousb.ID
+	Mode            Mode
+	// Serial number of the device, if it could be read.
+	Serial string
+	// Description of the device, if it is a supported device in DFU mode.
+	Description *Description
+}
+
+// List returns all connected Apple devices in a known mode, ordered by bus
+// and address. Devices which cannot be opened (eg. for lack of permissions)
+// are still listed, without a serial number.
+func List(ctx *gousb.Context) ([]Listed, error) {
+	var res []Listed
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		mode := ModeOf(desc)
+		if mode == "" {
+			return false
+		}
+		res = append(res, Listed{
+			Bus:     desc.Bus,
+			Address: desc.Address,
+			Vendor:  desc.Vendor,
+			Product: desc.Product,
+			Mode:    mode,
+		})
+		if mode == ModeDFU {
+			// Look the device up without the serial number, in case it
+			// cannot be read.
+			res[len(res)-1].Description = Lookup(desc, "")
+		}
+		return true
+	})
+	for _, dev := range devs {
+		for i := range res {
+			l := &res[i]
+			if l.Bus != dev.Desc.Bus || l.Address != dev.Desc.Address {
+				continue
+			}
+			l.Serial, _ = dev.SerialNumber()
+			if l.Mode == ModeDFU {
+				if d := Lookup(dev.Desc, l.Serial); d != nil {
+					l.Description = d
+				}
+			}
+		}
+		dev.Close()
+	}
+	if len(res) == 0 && err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Bus != res[j].Bus {
+			return res[i].Bus < res[j].Bus
+		}
+		return res[i].Address < res[j].Address
+	})
+	return res, nil
+}
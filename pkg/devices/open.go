@@ -0,0 +1,173 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/gousb"
+)
+
+// ErrNotFound is returned when no device matching a selector is connected.
+var ErrNotFound = errors.New("no device found")
+
+// Selector selects a device among all connected supported devices in DFU
+// mode, eg. when multiple iPods are connected. Zero values match any device.
+type Selector struct {
+	// Serial number of the device, as reported by its string descriptor.
+	Serial string
+	// Bus and Address of the device, as shown by eg. lsusb.
+	Bus     int
+	Address int
+}
+
+// matches returns whether the selector's bus and address match a device.
+func (s *Selector) matches(desc *gousb.DeviceDesc) bool {
+	if s.Bus != 0 && desc.Bus != s.Bus {
+		return false
+	}
+	if s.Address != 0 && desc.Address != s.Address {
+		return false
+	}
+	return true
+}
+
+// Device is an opened supported device in DFU mode.
+type Device struct {
+	*gousb.Device
+	Description *Description
+}
+
+// OpenAll opens all connected supported devices in DFU mode matched by the
+// selector, eg. to run an operation on several devices at once.
+func OpenAll(ctx *gousb.Context, s Selector) ([]Device, error) {
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return s.matches(desc) && candidate(desc)
+	})
+	if len(devs) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+
+	var matched []Device
+	for _, dev := range devs {
+		// Only read the serial number if needed, as some devices are slow
+		// to respond.
+		var serial string
+		if s.Serial != "" || needSerial(dev.Desc) {
+			serial, _ = dev.SerialNumber()
+		}
+		d := Lookup(dev.Desc, serial)
+		if d == nil || (s.Serial != "" && serial != s.Serial) {
+			dev.Close()
+			continue
+		}
+		matched = append(matched, Device{Device: dev, Description: d})
+	}
+	if len(matched) == 0 {
+		if s.Serial != "" {
+			return nil, fmt.Errorf("%w with serial %q", ErrNotFound, s.Serial)
+		}
+		return nil, ErrNotFound
+	}
+	return matched, nil
+}
+
+// Open opens the single connected supported device in DFU mode matched by the
+// selector. An error is returned if no device or more than one device is
+// matched, so that the selector can be refined.
+func Open(ctx *gousb.Context, s Selector) (*gousb.Device, *Description, error) {
+	devs, err := OpenAll(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(devs) == 1 {
+		return devs[0].Device, devs[0].Description, nil
+	}
+
+	var names []string
+	for _, dev := range devs {
+		name := fmt.Sprintf("bus %d address %d", dev.Desc.Bus, dev.Desc.Address)
+		if serial, err := dev.SerialNumber(); err == nil {
+			name += fmt.Sprintf(" serial %s", serial)
+		}
+		names = append(names, name)
+		dev.Close()
+	}
+	return nil, nil, fmt.Errorf("multiple devices found (%s), select one by serial, bus or address", strings.Join(names, "; "))
+}
+
+// appleVID is the USB vendor ID of all supported devices.
+const appleVID gousb.ID = 0x05ac
+
+// hasDFUInterface returns whether a device exposes a DFU interface, ie. an
+// application specific interface of subclass 1.
+func hasDFUInterface(desc *gousb.DeviceDesc) bool {
+	for _, cfg := range desc.Configs {
+		for _, intf := range cfg.Interfaces {
+			for _, alt := range intf.AltSettings {
+				if alt.Class == gousb.ClassApplication && alt.SubClass == 1 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// openOne opens the single connected device matched by match, describing
+// the mode the device is expected in as mode in errors.
+func openOne(ctx *gousb.Context, mode string, match func(desc *gousb.DeviceDesc) bool) (*gousb.Device, error) {
+	devs, err := ctx.OpenDevices(match)
+	switch {
+	case len(devs) == 1:
+		return devs[0], nil
+	case len(devs) == 0 && err != nil:
+		return nil, err
+	case len(devs) == 0:
+		return nil, ErrNotFound
+	}
+	for _, dev := range devs {
+		dev.Close()
+	}
+	return nil, fmt.Errorf("multiple devices in %s mode found, select one by bus", mode)
+}
+
+// OpenWTF opens the single connected Apple device in WTF mode, ie. in DFU
+// mode but not in bootrom DFU mode (as recognized by Lookup). This is how
+// devices re-enumerate after running a WTF image, ready to receive a firmware
+// image. Only the bus of the selector is used, as devices get a new address
+// and might report a different serial number when re-enumerating.
+func OpenWTF(ctx *gousb.Context, s Selector) (*gousb.Device, error) {
+	return openOne(ctx, "WTF", func(desc *gousb.DeviceDesc) bool {
+		if s.Bus != 0 && desc.Bus != s.Bus {
+			return false
+		}
+		return desc.Vendor == appleVID && !candidate(desc) && hasDFUInterface(desc)
+	})
+}
+
+// RecoveryPIDs are the USB product IDs of Apple devices in recovery mode, ie.
+// running a boot stage which exposes the recovery command interface.
+var RecoveryPIDs = []gousb.ID{0x1280, 0x1281, 0x1282, 0x1283}
+
+// OpenRecovery opens the single connected Apple device in recovery mode. As
+// with OpenWTF, only the bus of the selector is used.
+func OpenRecovery(ctx *gousb.Context, s Selector) (*gousb.Device, error) {
+	return openOne(ctx, "recovery", func(desc *gousb.DeviceDesc) bool {
+		if s.Bus != 0 && desc.Bus != s.Bus {
+			return false
+		}
+		if desc.Vendor != appleVID {
+			return false
+		}
+		for _, pid := range RecoveryPIDs {
+			if desc.Product == pid {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,126 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/gousb"
+)
+
+// Lookup returns the description of a device in DFU mode with a given USB
+// device descriptor and serial number, or nil if it is not supported.
+func Lookup(desc *gousb.DeviceDesc, serial string) *Description {
+	for i, d := range Descriptions {
+		if d.matchesUSB(desc) && strings.HasPrefix(serial, d.SerialPrefix) {
+			return &Descriptions[i]
+		}
+	}
+	return nil
+}
+
+// matchesUSB returns whether the description matches a device descriptor,
+// not taking the serial number into account.
+func (d *Description) matchesUSB(desc *gousb.DeviceDesc) bool {
+	if desc.Vendor != d.DFUVID || desc.Product != d.DFUPID {
+		return false
+	}
+	return d.Device == 0 || desc.Device == d.Device
+}
+
+// candidate returns whether a device might be supported, depending on its
+// serial number.
+func candidate(desc *gousb.DeviceDesc) bool {
+	for i := range Descriptions {
+		if Descriptions[i].matchesUSB(desc) {
+			return true
+		}
+	}
+	return false
+}
+
+// needSerial returns whether the serial number of a device is needed to
+// look it up.
+func needSerial(desc *gousb.DeviceDesc) bool {
+	for i := range Descriptions {
+		if Descriptions[i].SerialPrefix != "" && Descriptions[i].matchesUSB(desc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Register adds a description, taking precedence over all existing ones. Its
+// Kind must be one of the known kinds, as this determines how the device is
+// exploited.
+func Register(d Description) error {
+	switch d.Kind {
+	case Nano3, Nano4, Nano5, Nano6, Nano7, S5L8900:
+	default:
+		return fmt.Errorf("unknown device kind %q", d.Kind)
+	}
+	Descriptions = append([]Description{d}, Descriptions...)
+	return nil
+}
+
+// fileEntry is a description as stored in files read by LoadFile. IDs are
+// given as hex strings, as shown by eg. lsusb.
+type fileEntry struct {
+	Kind         Kind   `json:"kind"`
+	VID          string `json:"vid"`
+	PID          string `json:"pid"`
+	Device       string `json:"bcd_device,omitempty"`
+	SerialPrefix string `json:"serial_prefix,omitempty"`
+}
+
+func parseHex16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	return uint16(v), err
+}
+
+// LoadFile registers descriptions from a JSON file containing an array of
+// objects with the fields kind, vid, pid and optionally bcd_device and
+// serial_prefix, eg.:
+//
+//	[{"kind": "n5g", "vid": "05ac", "pid": "1231", "serial_prefix": "YM"}]
+//
+// Entries later in the file take precedence.
+func LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var entries []fileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	for i, e := range entries {
+		vid, err := parseHex16(e.VID)
+		if err != nil {
+			return fmt.Errorf("%s: entry %d: invalid vid %q", path, i, e.VID)
+		}
+		pid, err := parseHex16(e.PID)
+		if err != nil {
+			return fmt.Errorf("%s: entry %d: invalid pid %q", path, i, e.PID)
+		}
+		d := Description{
+			DFUVID:       gousb.ID(vid),
+			DFUPID:       gousb.ID(pid),
+			Kind:         e.Kind,
+			SerialPrefix: e.SerialPrefix,
+		}
+		if e.Device != "" {
+			bcd, err := parseHex16(e.Device)
+			if err != nil {
+				return fmt.Errorf("%s: entry %d: invalid bcd_device %q", path, i, e.Device)
+			}
+			d.Device = gousb.BCD(bcd)
+		}
+		if err := Register(d); err != nil {
+			return fmt.Errorf("%s: entry %d: %w", path, i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package devices
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/gousb"
+)
+
+// UdevRules returns udev rules granting the logged in user access to all
+// known devices in DFU and recovery mode, for use on Linux, eg. in
+// /etc/udev/rules.d/70-wInd3x.rules.
+func UdevRules() string {
+	type id struct {
+		vid, pid gousb.ID
+	}
+	seen := make(map[id]bool)
+	var ids []id
+	add := func(vid, pid gousb.ID) {
+		if !seen[id{vid, pid}] {
+			seen[id{vid, pid}] = true
+			ids = append(ids, id{vid, pid})
+		}
+	}
+	for _, d := range Descriptions {
+		add(d.DFUVID, d.DFUPID)
+	}
+	for _, pid := range RecoveryPIDs {
+		add(appleVID, pid)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].vid != ids[j].vid {
+			return ids[i].vid < ids[j].vid
+		}
+		return ids[i].pid < ids[j].pid
+	})
+
+	var b strings.Builder
+	b.WriteString("# Generated by wInd3x: access to iPods in DFU and recovery mode.\n")
+	for _, id := range ids {
+		fmt.Fprintf(&b, "SUBSYSTEM==\"usb\", ATTR{idVendor}==\"%s\", ATTR{idProduct}==\"%s\", MODE=\"0660\", TAG+=\"uaccess\"\n", id.vid, id.pid)
+	}
+	return b.String()
+}
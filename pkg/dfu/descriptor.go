@@ -0,0 +1,128 @@
+package dfu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/gousb"
+
+	"github.com/freemyipod/wInd3x/pkg/transport"
+)
+
+// DefaultTransferSize is the size of chunks transferred if the device does
+// not report its wTransferSize, and the size used by the bootroms of all
+// supported devices.
+const DefaultTransferSize = 0x400
+
+// TransferSize overrides the size of chunks transferred by SendImage and
+// ReceiveImage, eg. for devices which misreport their wTransferSize. If
+// zero, the wTransferSize of the device is used.
+var TransferSize = 0
+
+// ControlTimeout is the timeout of control transfers to devices, which should
+// be set on devices when opening them. Exploits temporarily use shorter
+// timeouts, after which they restore this one.
+var ControlTimeout = 5 * time.Second
+
+// descriptorTypeFunctional is the descriptor type of the DFU functional
+// descriptor.
+const descriptorTypeFunctional = 0x21
+
+// Attributes of the DFU functional descriptor.
+const (
+	AttrCanDnload             = 1 << 0
+	AttrCanUpload             = 1 << 1
+	AttrManifestationTolerant = 1 << 2
+	AttrWillDetach            = 1 << 3
+)
+
+// FunctionalDescriptor is the DFU functional descriptor of a device, which
+// describes its DFU capabilities.
+type FunctionalDescriptor struct {
+	Attributes    uint8
+	DetachTimeout time.Duration
+	// TransferSize is the maximum number of bytes the device accepts in a
+	// single DFU_DNLOAD or DFU_UPLOAD request.
+	TransferSize int
+	// Version is the BCD version of the DFU specification implemented by
+	// the device, if reported.
+	Version uint16
+}
+
+// GetFunctionalDescriptor retrieves the DFU functional descriptor of the
+// device from its first configuration descriptor, as DFU mode devices only
+// have a single configuration.
+func GetFunctionalDescriptor(usb transport.Device) (*FunctionalDescriptor, error) {
+	// GET_DESCRIPTOR of configuration 0.
+	buf := make([]byte, 0x100)
+	res, err := controlRetry(usb, 0x80, 0x06, uint16(gousb.DescriptorTypeConfig)<<8, 0, buf)
+	if err != nil {
+		return nil, fmt.Errorf("getting configuration descriptor: %w", err)
+	}
+	return parseFunctionalDescriptor(buf[:res])
+}
+
+// CanReadBack returns whether images downloaded to the device can be read
+// back, ie. whether it supports DFU_UPLOAD and remains in DFU mode after
+// manifestation.
+func (d *FunctionalDescriptor) CanReadBack() bool {
+	want := uint8(AttrCanUpload | AttrManifestationTolerant)
+	return d.Attributes&want == want
+}
+
+// parseFunctionalDescriptor finds and parses the DFU functional descriptor
+// within a configuration descriptor.
+func parseFunctionalDescriptor(config []byte) (*FunctionalDescriptor, error) {
+	for len(config) >= 2 {
+		length := int(config[0])
+		if length < 2 || length > len(config) {
+			return nil, fmt.Errorf("invalid descriptor length %d", length)
+		}
+		desc := config[:length]
+		config = config[length:]
+		if desc[1] != descriptorTypeFunctional {
+			continue
+		}
+		// DFU 1.0 descriptors lack bcdDFUVersion.
+		if length < 7 {
+			return nil, fmt.Errorf("functional descriptor too short (%d bytes)", length)
+		}
+		res := &FunctionalDescriptor{
+			Attributes:    desc[2],
+			DetachTimeout: time.Duration(binary.LittleEndian.Uint16(desc[3:])) * time.Millisecond,
+			TransferSize:  int(binary.LittleEndian.Uint16(desc[5:])),
+		}
+		if length >= 9 {
+			res.Version = binary.LittleEndian.Uint16(desc[7:])
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("no DFU functional descriptor")
+}
+
+// transferSize returns the size of chunks to transfer to and from the
+// device: TransferSize if set, otherwise the device's wTransferSize, falling
+// back to DefaultTransferSize.
+func transferSize(usb transport.Device) int {
+	if TransferSize != 0 {
+		return TransferSize
+	}
+	return deviceTransferSize(usb)
+}
+
+// deviceTransferSize returns the device's wTransferSize, falling back to
+// DefaultTransferSize.
+func deviceTransferSize(usb transport.Device) int {
+	desc, err := GetFunctionalDescriptor(usb)
+	if err != nil {
+		glog.V(1).Infof("Could not get DFU functional descriptor (%v), using transfer size 0x%x", err, DefaultTransferSize)
+		return DefaultTransferSize
+	}
+	if desc.TransferSize == 0 {
+		return DefaultTransferSize
+	}
+	glog.V(1).Infof("Using transfer size 0x%x", desc.TransferSize)
+	return desc.TransferSize
+}
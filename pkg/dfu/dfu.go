@@ -2,14 +2,16 @@ package dfu
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
-	"io"
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/google/gousb"
+
+	"github.com/freemyipod/wInd3x/pkg/transport"
+	"github.com/freemyipod/wInd3x/pkg/usblog"
 )
 
 type Request uint8
@@ -69,6 +71,8 @@ func (d State) String() string {
 		return "appDETACH"
 	case StateIdle:
 		return "dfuIDLE"
+	case StateDnloadSync:
+		return "dfuDNLOAD-SYNC"
 	case StateDnBusy:
 		return "dfuDNBUSY"
 	case StateDnloadIdle:
@@ -87,9 +91,9 @@ func (d State) String() string {
 	return "UNKNOWN"
 }
 
-func GetState(usb *gousb.Device) (State, error) {
+func GetState(usb transport.Device) (State, error) {
 	buf := make([]byte, 1)
-	res, err := usb.Control(0xa1, uint8(RequestGetState), 0, 0, buf)
+	res, err := controlRetry(usb, 0xa1, uint8(RequestGetState), 0, 0, buf)
 	if err != nil {
 		return StateError, fmt.Errorf("control: %w", err)
 	}
@@ -99,46 +103,309 @@ func GetState(usb *gousb.Device) (State, error) {
 	return State(uint8(buf[0])), nil
 }
 
+func (e Err) String() string {
+	switch e {
+	case ErrOk:
+		return "OK"
+	case ErrTarget:
+		return "errTARGET: file not targeted for this device"
+	case ErrFile:
+		return "errFILE: file failed vendor-specific verification"
+	case ErrWrite:
+		return "errWRITE: unable to write memory"
+	case ErrErase:
+		return "errERASE: memory erase failed"
+	case ErrCheckErased:
+		return "errCHECK_ERASED: memory erase check failed"
+	case ErrProg:
+		return "errPROG: program memory function failed"
+	case ErrVerify:
+		return "errVERIFY: programmed memory failed verification"
+	case ErrAddress:
+		return "errADDRESS: address out of range"
+	case ErrNotDone:
+		return "errNOTDONE: received zero-length download, but data is incomplete"
+	case ErrFirmware:
+		return "errFIRMWARE: firmware corrupt"
+	case ErrVendor:
+		return "errVENDOR: vendor-specific error"
+	case ErrUsbr:
+		return "errUSBR: unexpected USB reset"
+	case ErrPor:
+		return "errPOR: unexpected power on reset"
+	case ErrUnknown:
+		return "errUNKNOWN"
+	case ErrStalledPkt:
+		return "errSTALLEDPKT: unexpected request"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(e))
+}
+
 type Status struct {
 	Err     Err
 	State   State
 	Timeout time.Duration
+	// StringIndex is the index of a string descriptor describing the status,
+	// or zero if there is none.
+	StringIndex uint8
 }
 
-func GetStatus(usb *gousb.Device) (*Status, error) {
+func GetStatus(usb transport.Device) (*Status, error) {
 	buf := make([]byte, 6)
-	res, err := usb.Control(0xa1, uint8(RequestGetStatus), 0, 0, buf)
+	res, err := controlRetry(usb, 0xa1, uint8(RequestGetStatus), 0, 0, buf)
 	if err != nil {
 		return nil, fmt.Errorf("control: %w", err)
 	}
 	if res != 6 {
 		return nil, fmt.Errorf("status returned %d bytes", res)
 	}
+	return parseStatus(buf), nil
+}
 
-	timeoutMsec := (uint32(buf[2]) << 16) | (uint32(buf[1]) << 8) | uint32(buf[0])
+// parseStatus parses a DFU_GETSTATUS response: bStatus, a 24-bit
+// bwPollTimeout, bState and iString.
+func parseStatus(buf []byte) *Status {
+	timeoutMsec := (uint32(buf[3]) << 16) | (uint32(buf[2]) << 8) | uint32(buf[1])
 	return &Status{
-		Err:     Err(uint8(buf[0])),
-		State:   State(uint8(buf[4])),
-		Timeout: time.Duration(timeoutMsec) * time.Millisecond,
-	}, nil
+		Err:         Err(buf[0]),
+		State:       State(buf[4]),
+		Timeout:     time.Duration(timeoutMsec) * time.Millisecond,
+		StringIndex: buf[5],
+	}
+}
+
+// StatusError is returned when the device reports an error status or enters
+// dfuERROR.
+type StatusError struct {
+	Status
+	// Description is the status string reported by the device, if any.
+	Description string
+}
+
+func (e *StatusError) Error() string {
+	res := fmt.Sprintf("device in %s: %s", e.State, e.Err)
+	if e.Description != "" {
+		res += fmt.Sprintf(" (%q)", e.Description)
+	}
+	return res
+}
+
+// busy returns whether the device is expected to change state on its own
+// after a DFU_GETSTATUS request, ie. whether it should be polled again.
+func (s State) busy() bool {
+	return s == StateDnloadSync || s == StateDnBusy || s == StateManifestSync
+}
+
+// maxPolls bounds the number of DFU_GETSTATUS requests issued while waiting
+// for manifestation, so that a stuck device does not hang the transfer
+// forever.
+const maxPolls = 100
+
+// maxPollTimeout caps the bwPollTimeout waited for between DFU_GETSTATUS
+// requests, in case a device reports a bogus one.
+const maxPollTimeout = 5 * time.Second
+
+// pollWait waits for the bwPollTimeout of a status before the next
+// DFU_GETSTATUS request.
+func pollWait(ctx context.Context, st *Status) error {
+	timeout := st.Timeout
+	if timeout > maxPollTimeout {
+		timeout = maxPollTimeout
+	}
+	select {
+	case <-time.After(timeout):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// failed returns whether a status reports an error.
+func (s *Status) failed() bool {
+	return s.Err != ErrOk || s.State == StateError
+}
+
+// checkStatus issues DFU_GETSTATUS after a chunk has been sent, and returns a
+// *StatusError if the device reports an error. While the device reports
+// dfuDNBUSY, it is polled again after its bwPollTimeout. As with the bootroms
+// this was written against, other states are not checked.
+func checkStatus(ctx context.Context, usb transport.Device) error {
+	for i := 0; i < maxPolls; i++ {
+		st, err := GetStatus(usb)
+		if err != nil {
+			return err
+		}
+		if st.failed() {
+			return newStatusError(usb, st)
+		}
+		if st.State != StateDnBusy {
+			return nil
+		}
+		if err := pollWait(ctx, st); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("device still busy after %d status requests", maxPolls)
+}
+
+// waitManifest issues DFU_GETSTATUS requests after the zero-length download,
+// waiting for bwPollTimeout in between, until the device reaches dfuMANIFEST
+// (or dfuMANIFEST-WAIT-RESET). Any other state but dfuIDLE and dfuERROR is
+// polled through.
+func waitManifest(ctx context.Context, usb transport.Device) error {
+	for i := 0; i < maxPolls; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		st, err := GetStatus(usb)
+		if err != nil {
+			return fmt.Errorf("status failed: %w", err)
+		}
+		if st.failed() {
+			return newStatusError(usb, st)
+		}
+		switch st.State {
+		case StateManifest, StateManifestWaitReset:
+			glog.Infof("Got %s, image uploaded.", st.State)
+			return nil
+		case StateIdle:
+			return fmt.Errorf("unexpected idle, err: %s", st.Err)
+		}
+		glog.V(2).Infof("DFU state %s, polling again in %s...", st.State, st.Timeout)
+		if err := pollWait(ctx, st); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("did not reach manifest")
 }
 
-func ClearStatus(usb *gousb.Device) error {
-	_, err := usb.Control(0x21, uint8(RequestClrStatus), 0, 0, nil)
+// newStatusError builds a StatusError for a status, retrieving its string
+// descriptor from the device, and clears the error on the device so that it
+// returns to dfuIDLE.
+func newStatusError(usb transport.Device, st *Status) error {
+	res := &StatusError{Status: *st}
+	if st.StringIndex != 0 {
+		desc, err := usblog.StringDescriptor(usb, int(st.StringIndex))
+		if err != nil {
+			glog.Warningf("Could not get status string %d: %v", st.StringIndex, err)
+		}
+		res.Description = desc
+	}
+	if err := ClearStatus(usb); err != nil {
+		glog.Warningf("Could not clear error status: %v", err)
+	}
+	return res
+}
+
+func ClearStatus(usb transport.Device) error {
+	_, err := controlRetry(usb, 0x21, uint8(RequestClrStatus), 0, 0, nil)
+	if err != nil {
+		return fmt.Errorf("control: %w", err)
+	}
+	return nil
+}
+
+// Detach requests the device to leave DFU mode once it is reset, which it
+// should do within timeout. Devices which do not support detaching (eg. the
+// bootroms of supported devices) usually ignore or stall this request.
+func Detach(usb transport.Device, timeout time.Duration) error {
+	_, err := usblog.Control(usb, 0x21, uint8(RequestDetach), uint16(timeout.Milliseconds()), 0, nil)
+	if err != nil {
+		return fmt.Errorf("control: %w", err)
+	}
+	return nil
+}
+
+// Abort returns the device to dfuIDLE from dfuDNLOAD-IDLE or dfuUPLOAD-IDLE.
+func Abort(usb transport.Device) error {
+	_, err := usblog.Control(usb, 0x21, uint8(RequestAbort), 0, 0, nil)
 	if err != nil {
 		return fmt.Errorf("control: %w", err)
 	}
 	return nil
 }
 
-func SendChunk(usb *gousb.Device, c []byte, blockno uint16) error {
-	_, err := usb.Control(0x21, uint8(RequestDnload), blockno, 0, c)
+func SendChunk(usb transport.Device, c []byte, blockno uint16) error {
+	_, err := usblog.Control(usb, 0x21, uint8(RequestDnload), blockno, 0, c)
 	if err != nil {
 		return fmt.Errorf("control: %w", err)
 	}
 	return nil
 }
 
+// ReceiveChunk requests a chunk of up to length bytes with DFU_UPLOAD, and
+// returns the data sent by the device, which is shorter than length for the
+// last chunk.
+func ReceiveChunk(usb transport.Device, length int, blockno uint16) ([]byte, error) {
+	buf := make([]byte, length)
+	res, err := usblog.Control(usb, 0xa1, uint8(RequestUpload), blockno, 0, buf)
+	if err != nil {
+		return nil, fmt.Errorf("control: %w", err)
+	}
+	return buf[:res], nil
+}
+
+// maxUploadBlocks is the number of blocks ReceiveImage reads at most, as
+// block numbers are 16 bits wide.
+var maxUploadBlocks = 0x10000
+
+// ReceiveImage reads back an image from the device with DFU_UPLOAD, eg. memory
+// exposed by the device or by a payload, until the device sends a short chunk
+// or maxLength bytes (if not zero) have been read. At most 0x10000 blocks are
+// read, as their numbers would wrap around otherwise. The device is returned
+// to dfuIDLE afterwards, including when ctx is cancelled. progress (if not
+// nil) is called after every chunk, with a total of maxLength.
+//
+// Only devices supporting DFU_UPLOAD (see CheckUpload) or running a payload
+// which handles it should be sent uploads.
+func ReceiveImage(ctx context.Context, usb transport.Device, maxLength int, progress Progress) ([]byte, error) {
+	if err := Clean(usb); err != nil {
+		return nil, fmt.Errorf("clean: %w", err)
+	}
+
+	size := transferSize(usb)
+	res := bytes.NewBuffer(nil)
+	for blockno := 0; ; blockno++ {
+		length := size
+		if maxLength != 0 && maxLength-res.Len() < length {
+			length = maxLength - res.Len()
+		}
+		if length == 0 {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, abort(usb, err)
+		}
+		if blockno == maxUploadBlocks {
+			return nil, abort(usb, fmt.Errorf("device still sending data after %d blocks (0x%x bytes)", blockno, res.Len()))
+		}
+		chunk, err := ReceiveChunk(usb, length, uint16(blockno))
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d failed: %w", blockno, err)
+		}
+		res.Write(chunk)
+		if progress != nil {
+			progress(res.Len(), maxLength)
+		}
+		if len(chunk) < length {
+			break
+		}
+	}
+
+	// A short chunk returns the device to dfuIDLE. Otherwise, abort the
+	// upload.
+	state, err := GetState(usb)
+	if err != nil {
+		return nil, fmt.Errorf("GetState: %w", err)
+	}
+	if state == StateUploadIdle {
+		if err := Abort(usb); err != nil {
+			return nil, fmt.Errorf("abort: %w", err)
+		}
+	}
+	return res.Bytes(), nil
+}
+
 type ProtoVersion int
 
 const (
@@ -146,74 +413,137 @@ const (
 	ProtoVersion1 ProtoVersion = 1
 	// ProtoVersion2 is implemented by Nano4G+.
 	ProtoVersion2 ProtoVersion = 2
+	// ProtoVersionSuffix is implemented by S5L8900 devices (eg. the first
+	// iPhone), which expect images to end with a DFU suffix.
+	ProtoVersionSuffix ProtoVersion = 3
 )
 
-func SendImage(usb *gousb.Device, i []byte, version ProtoVersion) error {
+// Progress is called with the number of bytes sent so far and the total
+// number of bytes to send while transferring an image.
+type Progress func(sent, total int)
+
+// SendImage downloads an image to the device and makes it manifest, ie. run
+// it. If ctx is cancelled, the download is aborted between chunks, returning
+// the device to dfuIDLE.
+func SendImage(ctx context.Context, usb transport.Device, i []byte, version ProtoVersion) error {
+	return SendImageProgress(ctx, usb, i, version, nil)
+}
+
+// SendImageProgress is SendImage, calling progress (if not nil) after every
+// chunk sent.
+func SendImageProgress(ctx context.Context, usb transport.Device, i []byte, version ProtoVersion, progress Progress) error {
 	if err := Clean(usb); err != nil {
 		return fmt.Errorf("clean: %w", err)
 	}
 
-	if version == ProtoVersion1 {
-		crc := bytes.NewBuffer(nil)
-		binary.Write(crc, binary.LittleEndian, crc32.ChecksumIEEE(i))
-		for _, b := range crc.Bytes() {
-			i = append(i, b^0xff)
-		}
-	}
-
-	buf := bytes.NewBuffer(i)
-	blockno := uint16(0)
-	for {
-		chunk := make([]byte, 0x400)
-		_, err := buf.Read(chunk)
-		if err != nil {
-			if err == io.EOF {
-				break
+	data := imageData(i, version)
+	size := transferSize(usb)
+	start := time.Now()
+	chunks, err := sendChunks(ctx, usb, data, size, progress)
+	if err != nil && chunks == 0 && ctx.Err() == nil {
+		// Devices might reject chunks larger than their wTransferSize, in
+		// which case the first chunk already fails.
+		if fallback := deviceTransferSize(usb); fallback < size {
+			glog.Warningf("Transfer size 0x%x rejected (%v), retrying with 0x%x", size, err, fallback)
+			if err := Clean(usb); err != nil {
+				return fmt.Errorf("clean: %w", err)
 			}
-			return fmt.Errorf("read failed: %w", err)
-		}
-		if err := SendChunk(usb, chunk, blockno); err != nil {
-			return fmt.Errorf("chunk %d failed: %w", blockno, err)
+			size = fallback
+			chunks, err = sendChunks(ctx, usb, data, size, progress)
 		}
-		status, err := GetStatus(usb)
-		if err != nil {
-			return fmt.Errorf("chunk %d status failed: %w", blockno, err)
-		}
-		if want, got := ErrOk, status.Err; want != got {
-			return fmt.Errorf("chunk %d status expected %d, got %d", blockno, want, got)
-		}
-		blockno += 1
-
 	}
-	blockno += 1
+	if err != nil {
+		return err
+	}
+	if elapsed := time.Since(start); elapsed > 0 {
+		glog.V(1).Infof("Sent 0x%x bytes in 0x%x byte chunks in %s (%.1f KiB/s)", len(data), size, elapsed, float64(len(data))/1024/elapsed.Seconds())
+	}
+	blockno := uint16(chunks + 1)
+	if err := ctx.Err(); err != nil {
+		return abort(usb, err)
+	}
 
 	// Send zero-length download, completing image.
 	if err := SendChunk(usb, nil, blockno); err != nil {
 		return fmt.Errorf("zero length send failed: %w", err)
 	}
 
-	for i := 0; i < 100; i++ {
-		// Send status request, causing manifest.
-		st, err := GetStatus(usb)
-		if err != nil {
-			return fmt.Errorf("status failed: %w", err)
+	// Poll status, causing manifest.
+	return waitManifest(ctx, usb)
+}
+
+// imageData returns the data to download for an image, ie. the image with
+// its CRC or DFU suffix appended as required by the protocol version. The
+// image itself is never modified.
+func imageData(i []byte, version ProtoVersion) []byte {
+	switch version {
+	case ProtoVersion1:
+		res := make([]byte, len(i)+4)
+		copy(res, i)
+		binary.LittleEndian.PutUint32(res[len(i):], ^crc32.ChecksumIEEE(i))
+		return res
+	case ProtoVersionSuffix:
+		return AppendSuffix(append([]byte{}, i...), Suffix{
+			Device:  SuffixWildcard,
+			Product: SuffixWildcard,
+			Vendor:  0x05ac,
+			DFU:     0x0100,
+		})
+	}
+	return i
+}
+
+// sendChunks downloads data in chunks of size bytes, the last one padded
+// with zeroes, checking the device status after every chunk. The number of chunks
+// sent is returned.
+//
+// Status polling is not overlapped with anything: chunks are slices of the
+// padded data, so there is nothing left to prepare, and DFU does not allow the
+// next DFU_DNLOAD before the device has reported dfuDNLOAD-IDLE.
+func sendChunks(ctx context.Context, usb transport.Device, data []byte, size int, progress Progress) (int, error) {
+	total := len(data)
+	// Pad the data once, so that chunks are slices of it and no allocations
+	// happen per chunk.
+	padded := data
+	if rem := len(data) % size; rem != 0 {
+		padded = make([]byte, len(data)+size-rem)
+		copy(padded, data)
+	}
+
+	blockno := uint16(0)
+	for off := 0; off < len(padded); off += size {
+		chunk := padded[off : off+size]
+		if err := ctx.Err(); err != nil {
+			return int(blockno), abort(usb, err)
 		}
-		if st.State == StateIdle {
-			return fmt.Errorf("unexpected idle, err: %d", st.Err)
+		if err := SendChunk(usb, chunk, blockno); err != nil {
+			return int(blockno), fmt.Errorf("chunk %d failed: %w", blockno, err)
 		}
-		if st.State == StateDnBusy {
-			continue
+		if err := checkStatus(ctx, usb); err != nil {
+			if ctx.Err() != nil {
+				return int(blockno), abort(usb, ctx.Err())
+			}
+			return int(blockno), fmt.Errorf("chunk %d status failed: %w", blockno, err)
 		}
-		if st.State == StateManifest {
-			glog.Infof("Got dfuMANIFEST, image uploaded.")
-			return nil
+		blockno += 1
+		if progress != nil {
+			progress(min(off+size, total), total)
 		}
 	}
+	return int(blockno), nil
+}
 
-	return fmt.Errorf("did not reach manifest")
+// abort aborts an interrupted transfer, returning the device to dfuIDLE, and
+// returns err.
+func abort(usb transport.Device, err error) error {
+	glog.Infof("Transfer interrupted (%v), aborting...", err)
+	if aerr := Clean(usb); aerr != nil {
+		return fmt.Errorf("%w, and could not abort transfer: %v", err, aerr)
+	}
+	return err
 }
 
-func Clean(usb *gousb.Device) error {
+func Clean(usb transport.Device) error {
 	if err := ClearStatus(usb); err != nil {
 		return fmt.Errorf("ClrStatus: %w", err)
 	}
@@ -221,9 +551,24 @@ func Clean(usb *gousb.Device) error {
 	if err != nil {
 		return fmt.Errorf("GetState: %w", err)
 	}
+	if state == StateDnloadIdle || state == StateUploadIdle {
+		// Left over from an interrupted transfer.
+		if err := Abort(usb); err != nil {
+			return fmt.Errorf("Abort: %w", err)
+		}
+		if state, err = GetState(usb); err != nil {
+			return fmt.Errorf("GetState: %w", err)
+		}
+	}
 	if state != StateIdle {
 		return fmt.Errorf("unexpected DFU state %s", state)
 	}
 	return nil
+}
 
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
 }
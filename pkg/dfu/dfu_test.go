@@ -0,0 +1,405 @@
+package dfu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/gousb"
+
+	"github.com/freemyipod/wInd3x/pkg/transport/mock"
+)
+
+func TestParseStatus(t *testing.T) {
+	st := parseStatus([]byte{0x0a, 0x10, 0x27, 0x00, 0x0a, 0x03})
+	if want, got := ErrFirmware, st.Err; want != got {
+		t.Errorf("Err: wanted %s, got %s", want, got)
+	}
+	if want, got := StateError, st.State; want != got {
+		t.Errorf("State: wanted %s, got %s", want, got)
+	}
+	if want, got := 10*time.Second, st.Timeout; want != got {
+		t.Errorf("Timeout: wanted %s, got %s", want, got)
+	}
+	if want, got := uint8(3), st.StringIndex; want != got {
+		t.Errorf("StringIndex: wanted %d, got %d", want, got)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	err := &StatusError{
+		Status:      Status{Err: ErrVerify, State: StateError},
+		Description: "bad image",
+	}
+	if want, got := `device in dfuERROR: errVERIFY: programmed memory failed verification ("bad image")`, err.Error(); want != got {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
+
+func TestSuffix(t *testing.T) {
+	payload := []byte("hello, world")
+	s := Suffix{Device: SuffixWildcard, Product: 0x1225, Vendor: 0x05ac, DFU: 0x0100}
+	data := AppendSuffix(payload, s)
+	if want, got := len(payload)+SuffixLength, len(data); want != got {
+		t.Fatalf("length: wanted %d, got %d", want, got)
+	}
+
+	parsed, rest, err := ParseSuffix(data)
+	if err != nil {
+		t.Fatalf("ParseSuffix: %v", err)
+	}
+	if *parsed != s {
+		t.Errorf("wanted suffix %+v, got %+v", s, *parsed)
+	}
+	if string(rest) != string(payload) {
+		t.Errorf("wanted payload %q, got %q", payload, rest)
+	}
+	if !parsed.Matches(0x05ac, 0x1225) || parsed.Matches(0x05ac, 0x1231) {
+		t.Errorf("Matches returned wrong result")
+	}
+
+	data[0] ^= 1
+	if _, _, err := ParseSuffix(data); err == nil || err == ErrNoSuffix {
+		t.Errorf("expected CRC error, got %v", err)
+	}
+	if _, _, err := ParseSuffix(payload); err != ErrNoSuffix {
+		t.Errorf("expected ErrNoSuffix, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := RetryPolicy{Attempts: 3}
+
+	calls := 0
+	err := p.Do("test", func() error {
+		calls += 1
+		if calls < 3 {
+			return fmt.Errorf("control: %w", gousb.ErrorPipe)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("transient errors: got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = p.Do("test", func() error {
+		calls += 1
+		return fmt.Errorf("control: %w", gousb.ErrorPipe)
+	})
+	if !errors.Is(err, gousb.ErrorPipe) || calls != 3 {
+		t.Errorf("persistent errors: got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = p.Do("test", func() error {
+		calls += 1
+		return gousb.ErrorNoDevice
+	})
+	if err != gousb.ErrorNoDevice || calls != 1 {
+		t.Errorf("permanent errors: got %v after %d calls", err, calls)
+	}
+}
+
+func TestParseFunctionalDescriptor(t *testing.T) {
+	config := []byte{
+		// Configuration.
+		0x09, 0x02, 0x1b, 0x00, 0x01, 0x01, 0x00, 0x80, 0xfa,
+		// Interface.
+		0x09, 0x04, 0x00, 0x00, 0x00, 0xfe, 0x01, 0x02, 0x00,
+		// DFU functional.
+		0x09, 0x21, 0x01, 0xff, 0x00, 0x00, 0x08, 0x10, 0x01,
+	}
+	desc, err := parseFunctionalDescriptor(config)
+	if err != nil {
+		t.Fatalf("parseFunctionalDescriptor: %v", err)
+	}
+	if want, got := 0x800, desc.TransferSize; want != got {
+		t.Errorf("TransferSize: wanted 0x%x, got 0x%x", want, got)
+	}
+	if want, got := 255*time.Millisecond, desc.DetachTimeout; want != got {
+		t.Errorf("DetachTimeout: wanted %s, got %s", want, got)
+	}
+	if want, got := uint16(0x0110), desc.Version; want != got {
+		t.Errorf("Version: wanted %04x, got %04x", want, got)
+	}
+
+	if _, err := parseFunctionalDescriptor(config[:18]); err == nil {
+		t.Errorf("expected error without functional descriptor")
+	}
+}
+
+func TestSendImage(t *testing.T) {
+	d := mock.New()
+	image := bytes.Repeat([]byte{0x42}, 0x900)
+	if err := SendImage(context.Background(), d, image, ProtoVersion2); err != nil {
+		t.Fatalf("SendImage: %v", err)
+	}
+	// Chunks are padded to the transfer size.
+	if want, got := image, d.Received[:len(image)]; !bytes.Equal(want, got) {
+		t.Errorf("received image differs")
+	}
+	if want, got := uint8(StateManifest), d.State(); want != got {
+		t.Errorf("state: wanted %s, got %s", State(want), State(got))
+	}
+}
+
+func TestSendImageCancel(t *testing.T) {
+	d := mock.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	progress := func(sent, total int) {
+		cancel()
+	}
+	err := SendImageProgress(ctx, d, make([]byte, 0x1000), ProtoVersion2, progress)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("wanted cancellation, got %v", err)
+	}
+	if want, got := uint8(StateIdle), d.State(); want != got {
+		t.Errorf("state: wanted %s, got %s", State(want), State(got))
+	}
+}
+
+func TestReceiveImage(t *testing.T) {
+	d := mock.New()
+	d.Upload = bytes.Repeat([]byte{0x23}, 0x500)
+
+	data, err := ReceiveImage(context.Background(), d, 0, nil)
+	if err != nil {
+		t.Fatalf("ReceiveImage: %v", err)
+	}
+	if !bytes.Equal(data, d.Upload) {
+		t.Errorf("received 0x%x bytes, wanted 0x%x", len(data), len(d.Upload))
+	}
+
+	data, err = ReceiveImage(context.Background(), d, 0x400, nil)
+	if err != nil {
+		t.Fatalf("ReceiveImage: %v", err)
+	}
+	if want, got := 0x400, len(data); want != got {
+		t.Errorf("received 0x%x bytes, wanted 0x%x", got, want)
+	}
+	if want, got := uint8(StateIdle), d.State(); want != got {
+		t.Errorf("state: wanted %s, got %s", State(want), State(got))
+	}
+}
+
+func TestGetStateRetry(t *testing.T) {
+	d := mock.New()
+	failures := 2
+	d.Fail = func(request uint8) error {
+		if failures > 0 {
+			failures -= 1
+			return gousb.ErrorTimeout
+		}
+		return nil
+	}
+	defer func(r RetryPolicy) { Retry = r }(Retry)
+	Retry.Backoff = time.Millisecond
+
+	state, err := GetState(d)
+	if err != nil {
+		t.Fatalf("GetState: %v", err)
+	}
+	if want, got := StateIdle, state; want != got {
+		t.Errorf("wanted %s, got %s", want, got)
+	}
+}
+
+func TestSendImageSuffix(t *testing.T) {
+	d := mock.New()
+	image := bytes.Repeat([]byte{0x42}, 0x300)
+	if err := SendImage(context.Background(), d, image, ProtoVersionSuffix); err != nil {
+		t.Fatalf("SendImage: %v", err)
+	}
+	// Strip padding of the last chunk.
+	received := d.Received[:len(image)+SuffixLength]
+	s, rest, err := ParseSuffix(received)
+	if err != nil {
+		t.Fatalf("ParseSuffix: %v", err)
+	}
+	if !bytes.Equal(rest, image) {
+		t.Errorf("received image differs")
+	}
+	if !s.Matches(0x05ac, 0x1222) {
+		t.Errorf("suffix %+v does not match device", s)
+	}
+}
+
+// smallChunks is a mock device stalling downloads larger than the default
+// transfer size.
+type smallChunks struct {
+	*mock.Device
+}
+
+func (s smallChunks) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if rType == 0x21 && request == uint8(RequestDnload) && len(data) > DefaultTransferSize {
+		return 0, gousb.ErrorPipe
+	}
+	return s.Device.Control(rType, request, val, idx, data)
+}
+
+func TestSendImageTransferSizeFallback(t *testing.T) {
+	defer func(s int) { TransferSize = s }(TransferSize)
+	TransferSize = 0x1000
+
+	d := smallChunks{mock.New()}
+	image := bytes.Repeat([]byte{0x42}, 0x900)
+	if err := SendImage(context.Background(), d, image, ProtoVersion2); err != nil {
+		t.Fatalf("SendImage: %v", err)
+	}
+	if want, got := image, d.Received[:len(image)]; !bytes.Equal(want, got) {
+		t.Errorf("received image differs")
+	}
+	if want, got := 3*DefaultTransferSize, len(d.Received); want != got {
+		t.Errorf("wanted 0x%x bytes received, got 0x%x", want, got)
+	}
+}
+
+func TestImageData(t *testing.T) {
+	image := make([]byte, 4, 16)
+	copy(image, "abcd")
+	data := imageData(image, ProtoVersion1)
+	if want, got := 8, len(data); want != got {
+		t.Fatalf("wanted %d bytes, got %d", want, got)
+	}
+	// The CRC must not be written into the spare capacity of the image.
+	if extra := image[:8]; !bytes.Equal(extra[4:], make([]byte, 4)) {
+		t.Errorf("image modified: %x", extra)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	image := bytes.Repeat([]byte{0x42}, 0x900)
+
+	// Configuration descriptor with a functional descriptor allowing read
+	// back.
+	config := []byte{
+		0x09, 0x02, 0x1b, 0x00, 0x01, 0x01, 0x00, 0x80, 0xfa,
+		0x09, 0x04, 0x00, 0x00, 0x00, 0xfe, 0x01, 0x02, 0x00,
+		0x09, 0x21, 0x07, 0xff, 0x00, 0x00, 0x04, 0x10, 0x01,
+	}
+
+	d := mock.New()
+	d.Config = config
+	d.ManifestationTolerant = true
+	if err := SendImage(context.Background(), d, image, ProtoVersion2); err != nil {
+		t.Fatalf("SendImage: %v", err)
+	}
+	if err := Verify(context.Background(), d, image); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	d = mock.New()
+	d.Config = config
+	d.ManifestationTolerant = true
+	if err := SendImage(context.Background(), d, image[:0x400], ProtoVersion2); err != nil {
+		t.Fatalf("SendImage: %v", err)
+	}
+	if err := Verify(context.Background(), d, image); !errors.Is(err, ErrMismatch) {
+		t.Errorf("truncated image: wanted ErrMismatch, got %v", err)
+	}
+
+	d = mock.New()
+	if err := SendImage(context.Background(), d, image, ProtoVersion2); err != nil {
+		t.Fatalf("SendImage: %v", err)
+	}
+	if err := Verify(context.Background(), d, image); !errors.Is(err, ErrNoReadBack) {
+		t.Errorf("intolerant device: wanted ErrNoReadBack, got %v", err)
+	}
+}
+
+// manifestStatus is a mock device returning given statuses to DFU_GETSTATUS
+// requests during manifestation, before letting the mock device handle them.
+type manifestStatus struct {
+	*mock.Device
+	statuses [][]byte
+}
+
+func (m *manifestStatus) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if rType == 0xa1 && request == uint8(RequestGetStatus) && State(m.State()) == StateManifestSync && len(m.statuses) > 0 {
+		n := copy(data, m.statuses[0])
+		m.statuses = m.statuses[1:]
+		return n, nil
+	}
+	return m.Device.Control(rType, request, val, idx, data)
+}
+
+func TestSendImageManifestError(t *testing.T) {
+	d := &manifestStatus{
+		Device:   mock.New(),
+		statuses: [][]byte{{uint8(ErrFirmware), 0, 0, 0, uint8(StateError), 0}},
+	}
+	err := SendImage(context.Background(), d, make([]byte, 0x100), ProtoVersion2)
+	var serr *StatusError
+	if !errors.As(err, &serr) {
+		t.Fatalf("wanted StatusError, got %v", err)
+	}
+	if want, got := ErrFirmware, serr.Err; want != got {
+		t.Errorf("status: wanted %s, got %s", want, got)
+	}
+	last := d.Transfers[len(d.Transfers)-1]
+	if last.RequestType != 0x21 || last.Request != uint8(RequestClrStatus) {
+		t.Errorf("error status was not cleared, last request %+v", last)
+	}
+}
+
+func TestSendImagePollTimeout(t *testing.T) {
+	// dfuMANIFEST-SYNC with a bwPollTimeout of 20ms, three times.
+	busy := []byte{uint8(ErrOk), 20, 0, 0, uint8(StateManifestSync), 0}
+	d := &manifestStatus{
+		Device:   mock.New(),
+		statuses: [][]byte{busy, busy, busy},
+	}
+	start := time.Now()
+	if err := SendImage(context.Background(), d, make([]byte, 0x100), ProtoVersion2); err != nil {
+		t.Fatalf("SendImage: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("SendImage took %s, wanted at least three poll timeouts of 20ms", elapsed)
+	}
+	if want, got := uint8(StateManifest), d.State(); want != got {
+		t.Errorf("state: wanted %s, got %s", State(want), State(got))
+	}
+}
+
+func TestCheckUpload(t *testing.T) {
+	config := []byte{
+		0x09, 0x02, 0x1b, 0x00, 0x01, 0x01, 0x00, 0x80, 0xfa,
+		0x09, 0x04, 0x00, 0x00, 0x00, 0xfe, 0x01, 0x02, 0x00,
+		0x09, 0x21, 0x01, 0xff, 0x00, 0x00, 0x04, 0x10, 0x01,
+	}
+	d := mock.New()
+	if err := CheckUpload(d); !errors.Is(err, ErrNoUpload) {
+		t.Errorf("without descriptor: wanted ErrNoUpload, got %v", err)
+	}
+	d.Config = config
+	if err := CheckUpload(d); !errors.Is(err, ErrNoUpload) {
+		t.Errorf("download only: wanted ErrNoUpload, got %v", err)
+	}
+	config[20] |= AttrCanUpload
+	if err := CheckUpload(d); err != nil {
+		t.Errorf("CheckUpload: %v", err)
+	}
+}
+
+func TestReceiveImageBlockLimit(t *testing.T) {
+	defer func(n int) { maxUploadBlocks = n }(maxUploadBlocks)
+	maxUploadBlocks = 4
+
+	d := mock.New()
+	d.Upload = make([]byte, 3*DefaultTransferSize+0x100)
+	if _, err := ReceiveImage(context.Background(), d, 0, nil); err != nil {
+		t.Errorf("ReceiveImage of 4 blocks: %v", err)
+	}
+	// Without a short chunk, the device would send data forever.
+	d.Upload = make([]byte, 5*DefaultTransferSize)
+	if _, err := ReceiveImage(context.Background(), d, 0, nil); err == nil {
+		t.Errorf("ReceiveImage past block limit succeeded")
+	}
+	if want, got := uint8(StateIdle), d.State(); want != got {
+		t.Errorf("state: wanted %s, got %s", State(want), State(got))
+	}
+}
@@ -0,0 +1,128 @@
+package dfu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/gousb"
+
+	"github.com/freemyipod/wInd3x/pkg/transport"
+	"github.com/freemyipod/wInd3x/pkg/usblog"
+)
+
+// RetryPolicy configures how operations failing with transient USB errors are
+// retried, eg. control transfers to a freshly exploited bootrom which
+// occasionally stall or time out.
+type RetryPolicy struct {
+	// Attempts is the total number of attempts made. Values below 1 are
+	// treated as 1, ie. no retries.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled after every
+	// further attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable returns whether an error should be retried. If nil,
+	// Transient is used.
+	Retryable func(error) bool
+}
+
+// Retry is the policy used for all retried operations, and can be changed
+// by programs (eg. from flags) before any transfers are made.
+//
+// Only operations which can safely be repeated are retried: requests whose
+// repetition does not change the outcome (see controlRetry), and read-only
+// operations like NOR and NAND reads. Exploit payloads have their own policy,
+// exploit.TriggerRetryPolicy. Image downloads are not retried, as after a
+// failed DFU_DNLOAD the device cannot tell us whether the block was received.
+var Retry = RetryPolicy{
+	Attempts:   5,
+	Backoff:    50 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+}
+
+// Transient returns whether err is caused by a USB error which might not
+// happen again if the operation is retried.
+func Transient(err error) bool {
+	var uerr gousb.Error
+	if errors.As(err, &uerr) {
+		switch uerr {
+		case gousb.ErrorIO, gousb.ErrorTimeout, gousb.ErrorPipe, gousb.ErrorBusy, gousb.ErrorInterrupted, gousb.ErrorOverflow:
+			return true
+		}
+		return false
+	}
+	var terr gousb.TransferStatus
+	if errors.As(err, &terr) {
+		switch terr {
+		case gousb.TransferError, gousb.TransferTimedOut, gousb.TransferStall, gousb.TransferOverflow:
+			return true
+		}
+	}
+	return false
+}
+
+// Any can be used as RetryPolicy.Retryable to retry all errors, eg. when
+// running exploit payloads which sometimes fail to trigger.
+func Any(error) bool {
+	return true
+}
+
+// Do calls fn until it succeeds, fails with an error which is not retryable,
+// or all attempts are used up. The last error is returned.
+func (p RetryPolicy) Do(name string, fn func() error) error {
+	return p.DoContext(context.Background(), name, fn)
+}
+
+// DoContext is Do, stopping to retry once ctx is cancelled.
+func (p RetryPolicy) DoContext(ctx context.Context, name string, fn func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = Transient
+	}
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if !retryable(err) {
+			return err
+		}
+		if attempt >= p.Attempts {
+			if attempt > 1 {
+				return fmt.Errorf("%s failed after %d attempts: %w", name, attempt, err)
+			}
+			return err
+		}
+		glog.Warningf("%s failed (%v), retrying in %s...", name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+		if p.MaxBackoff != 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// controlRetry issues a control request which can safely be repeated,
+// retrying on transient errors according to Retry. This is the case for
+// requests which do not change device state (eg. DFU_GETSTATE and
+// GET_DESCRIPTOR), for DFU_CLRSTATUS, which is idempotent, and for
+// DFU_GETSTATUS: it does move the device out of dfuDNLOAD-SYNC and
+// dfuMANIFEST-SYNC, but if a response is lost after the transition, the
+// repeated request reports the state the device moved to, which callers
+// polling for that state expect anyway.
+func controlRetry(usb transport.Device, rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	var res int
+	err := Retry.Do(fmt.Sprintf("control request %d", request), func() (err error) {
+		res, err = usblog.Control(usb, rType, request, val, idx, data)
+		return err
+	})
+	return res, err
+}
@@ -0,0 +1,82 @@
+package dfu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// SuffixLength is the length of the standard DFU file suffix, as defined by
+// the DFU 1.1 specification.
+const SuffixLength = 16
+
+// SuffixWildcard can be used as the device, product or vendor ID of a suffix
+// to match any device.
+const SuffixWildcard = 0xffff
+
+// suffixSignature is 'DFU', stored reversed.
+var suffixSignature = []byte("UFD")
+
+// ErrNoSuffix is returned by ParseSuffix if data has no DFU suffix.
+var ErrNoSuffix = errors.New("no DFU suffix")
+
+// Suffix is the standard DFU file suffix, which allows other DFU tooling (eg.
+// dfu-util) to check that a file is meant for a given device.
+type Suffix struct {
+	Device  uint16
+	Product uint16
+	Vendor  uint16
+	// DFU is the BCD version of the DFU specification, usually 0x0100.
+	DFU uint16
+}
+
+// suffixCRC calculates the CRC of a DFU file, which is a CRC32 without the
+// final inversion.
+func suffixCRC(data []byte) uint32 {
+	return ^crc32.ChecksumIEEE(data)
+}
+
+// AppendSuffix returns data with a DFU suffix appended.
+func AppendSuffix(data []byte, s Suffix) []byte {
+	buf := bytes.NewBuffer(append([]byte{}, data...))
+	binary.Write(buf, binary.LittleEndian, []uint16{s.Device, s.Product, s.Vendor, s.DFU})
+	buf.Write(suffixSignature)
+	buf.WriteByte(SuffixLength)
+	binary.Write(buf, binary.LittleEndian, suffixCRC(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// ParseSuffix parses and verifies the DFU suffix of data, returning it along
+// with the data preceding it. ErrNoSuffix is returned if data has no suffix.
+func ParseSuffix(data []byte) (*Suffix, []byte, error) {
+	if len(data) < SuffixLength {
+		return nil, nil, ErrNoSuffix
+	}
+	raw := data[len(data)-SuffixLength:]
+	if !bytes.Equal(raw[8:11], suffixSignature) {
+		return nil, nil, ErrNoSuffix
+	}
+	// Vendor extensions may make the suffix longer, but are not used by any
+	// tooling we care about.
+	if length := int(raw[11]); length != SuffixLength {
+		return nil, nil, fmt.Errorf("unsupported DFU suffix length %d", length)
+	}
+	if want, got := suffixCRC(data[:len(data)-4]), binary.LittleEndian.Uint32(raw[12:]); want != got {
+		return nil, nil, fmt.Errorf("DFU suffix CRC mismatch: calculated %08x, stored %08x", want, got)
+	}
+	s := &Suffix{
+		Device:  binary.LittleEndian.Uint16(raw[0:]),
+		Product: binary.LittleEndian.Uint16(raw[2:]),
+		Vendor:  binary.LittleEndian.Uint16(raw[4:]),
+		DFU:     binary.LittleEndian.Uint16(raw[6:]),
+	}
+	return s, data[:len(data)-SuffixLength], nil
+}
+
+// Matches returns whether the suffix allows the file to be sent to a device
+// with the given vendor and product IDs.
+func (s *Suffix) Matches(vendor, product uint16) bool {
+	return (s.Vendor == SuffixWildcard || s.Vendor == vendor) && (s.Product == SuffixWildcard || s.Product == product)
+}
@@ -0,0 +1,99 @@
+package dfu
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/freemyipod/wInd3x/pkg/transport"
+)
+
+var (
+	// ErrNoReadBack is returned when verifying images sent to a device which
+	// cannot read them back.
+	ErrNoReadBack = errors.New("device cannot read back images")
+	// ErrMismatch is returned when an image read back differs from the one sent.
+	ErrMismatch = errors.New("verification failed")
+	// ErrNoUpload is returned by CheckUpload for devices which do not
+	// support DFU_UPLOAD.
+	ErrNoUpload = errors.New("device does not support DFU_UPLOAD")
+)
+
+// CheckUpload returns an error wrapping ErrNoUpload unless the device reports
+// DFU_UPLOAD support in its functional descriptor. Bootroms which do not must
+// not be sent uploads, as the exploit abuses exactly their handling of them.
+func CheckUpload(usb transport.Device) error {
+	desc, err := GetFunctionalDescriptor(usb)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoUpload, err)
+	}
+	if desc.Attributes&AttrCanUpload == 0 {
+		return fmt.Errorf("%w (DFU attributes %02x)", ErrNoUpload, desc.Attributes)
+	}
+	return nil
+}
+
+// CheckReadBack returns an error wrapping ErrNoReadBack if images sent to the
+// device cannot be verified with Verify, so that this can be checked before
+// sending them.
+func CheckReadBack(usb transport.Device) error {
+	desc, err := GetFunctionalDescriptor(usb)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoReadBack, err)
+	}
+	if !desc.CanReadBack() {
+		return fmt.Errorf("%w (DFU attributes %02x)", ErrNoReadBack, desc.Attributes)
+	}
+	return nil
+}
+
+// Verify reads back an image which was just sent to the device, once it has
+// returned to dfuIDLE after manifestation, and compares it to the image.
+func Verify(ctx context.Context, usb transport.Device, image []byte) error {
+	if err := CheckReadBack(usb); err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		st, err := GetStatus(usb)
+		if err != nil {
+			return fmt.Errorf("GetStatus: %w", err)
+		}
+		if st.State == StateIdle {
+			break
+		}
+		if st.State != StateManifest && !st.State.busy() {
+			return fmt.Errorf("%w: device in %s after manifestation", ErrNoReadBack, st.State)
+		}
+		if i == maxPolls {
+			return fmt.Errorf("device still manifesting after %d status requests", maxPolls)
+		}
+		if err := pollWait(ctx, st); err != nil {
+			return err
+		}
+	}
+
+	read, err := ReceiveImage(ctx, usb, len(image), nil)
+	if err != nil {
+		return fmt.Errorf("read back failed: %w", err)
+	}
+	if len(read) < len(image) {
+		return fmt.Errorf("%w: read back 0x%x of 0x%x bytes", ErrMismatch, len(read), len(image))
+	}
+	if want, got := sha256.Sum256(image), sha256.Sum256(read); want != got {
+		return fmt.Errorf("%w: SHA-256 %x, wanted %x (first difference at 0x%x)", ErrMismatch, got, want, firstDifference(image, read))
+	}
+	return nil
+}
+
+// firstDifference returns the offset of the first byte differing between a
+// and b.
+func firstDifference(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
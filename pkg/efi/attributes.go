@@ -0,0 +1,200 @@
+package efi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VolumeAttributes is the AttributeMask of a volume header
+// (EFI_FVB_ATTRIBUTES_2).
+type VolumeAttributes uint32
+
+const (
+	VolumeReadDisabledCap  VolumeAttributes = 0x00000001
+	VolumeReadEnabledCap   VolumeAttributes = 0x00000002
+	VolumeReadStatus       VolumeAttributes = 0x00000004
+	VolumeWriteDisabledCap VolumeAttributes = 0x00000008
+	VolumeWriteEnabledCap  VolumeAttributes = 0x00000010
+	VolumeWriteStatus      VolumeAttributes = 0x00000020
+	VolumeLockCap          VolumeAttributes = 0x00000040
+	VolumeLockStatus       VolumeAttributes = 0x00000080
+	VolumeStickyWrite      VolumeAttributes = 0x00000200
+	VolumeMemoryMapped     VolumeAttributes = 0x00000400
+	// VolumeErasePolarity is set if erased flash reads as 1 bits (0xff).
+	VolumeErasePolarity   VolumeAttributes = 0x00000800
+	VolumeReadLockCap     VolumeAttributes = 0x00001000
+	VolumeReadLockStatus  VolumeAttributes = 0x00002000
+	VolumeWriteLockCap    VolumeAttributes = 0x00004000
+	VolumeWriteLockStatus VolumeAttributes = 0x00008000
+	// VolumeAlignmentMask contains the log2 of the required alignment of the
+	// volume.
+	VolumeAlignmentMask VolumeAttributes = 0x001f0000
+	VolumeWeakAlignment VolumeAttributes = 0x80000000
+)
+
+var volumeAttributeNames = []struct {
+	flag VolumeAttributes
+	name string
+}{
+	{VolumeReadDisabledCap, "read-disabled-cap"},
+	{VolumeReadEnabledCap, "read-enabled-cap"},
+	{VolumeReadStatus, "read"},
+	{VolumeWriteDisabledCap, "write-disabled-cap"},
+	{VolumeWriteEnabledCap, "write-enabled-cap"},
+	{VolumeWriteStatus, "write"},
+	{VolumeLockCap, "lock-cap"},
+	{VolumeLockStatus, "locked"},
+	{VolumeStickyWrite, "sticky-write"},
+	{VolumeMemoryMapped, "memory-mapped"},
+	{VolumeErasePolarity, "erase-polarity"},
+	{VolumeReadLockCap, "read-lock-cap"},
+	{VolumeReadLockStatus, "read-locked"},
+	{VolumeWriteLockCap, "write-lock-cap"},
+	{VolumeWriteLockStatus, "write-locked"},
+	{VolumeWeakAlignment, "weak-alignment"},
+}
+
+// Has returns whether all of the given attribute bits are set.
+func (a VolumeAttributes) Has(flags VolumeAttributes) bool {
+	return a&flags == flags
+}
+
+// Readable returns whether the volume is marked as readable.
+func (a VolumeAttributes) Readable() bool {
+	return a.Has(VolumeReadStatus)
+}
+
+// Writable returns whether the volume is marked as writable.
+func (a VolumeAttributes) Writable() bool {
+	return a.Has(VolumeWriteStatus)
+}
+
+// ErasePolarity returns the value of an erased byte within the volume: 0xff
+// if VolumeErasePolarity is set, 0x00 otherwise.
+func (a VolumeAttributes) ErasePolarity() byte {
+	if a.Has(VolumeErasePolarity) {
+		return 0xff
+	}
+	return 0x00
+}
+
+// Alignment returns the required alignment of the volume in bytes.
+func (a VolumeAttributes) Alignment() uint64 {
+	return 1 << ((a & VolumeAlignmentMask) >> 16)
+}
+
+// SetAlignment sets the required alignment of the volume, which must be a
+// power of two.
+func (a *VolumeAttributes) SetAlignment(alignment uint64) error {
+	for i := uint64(0); i < 32; i++ {
+		if alignment == 1<<i {
+			*a = (*a &^ VolumeAlignmentMask) | VolumeAttributes(i<<16)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid volume alignment %d", alignment)
+}
+
+func (a VolumeAttributes) String() string {
+	var parts []string
+	for _, n := range volumeAttributeNames {
+		if a.Has(n.flag) {
+			parts = append(parts, n.name)
+		}
+	}
+	parts = append(parts, fmt.Sprintf("align=%d", a.Alignment()))
+	return strings.Join(parts, "|")
+}
+
+// FileAttributes are the attributes of a file header (EFI_FFS_FILE_ATTRIBUTES).
+type FileAttributes uint8
+
+const (
+	// FileAttributeLargeFile (FFS_ATTRIB_LARGE_FILE) is set on FFSv3 files
+	// whose size does not fit in the 24-bit Size field of the header. These
+	// files have an extended header with a 64-bit size instead.
+	FileAttributeLargeFile FileAttributes = 0x01
+	// FileAttributeDataAlignment2 (FFS_ATTRIB_DATA_ALIGNMENT_2) selects the
+	// second table of data alignments, from 128KiB to 16MiB.
+	FileAttributeDataAlignment2 FileAttributes = 0x02
+	// FileAttributeFixed (FFS_ATTRIB_FIXED) is set on files which must not be
+	// moved within their volume.
+	FileAttributeFixed FileAttributes = 0x04
+	// FileAttributeDataAlignmentMask (FFS_ATTRIB_DATA_ALIGNMENT) selects the
+	// required alignment of the file data.
+	FileAttributeDataAlignmentMask FileAttributes = 0x38
+	// FileAttributeChecksum (FFS_ATTRIB_CHECKSUM) is set on files whose
+	// ChecksumData is a checksum of the file data. Otherwise, ChecksumData
+	// is a fixed value.
+	FileAttributeChecksum FileAttributes = 0x40
+)
+
+var (
+	fileDataAlignments  = [8]uint64{1, 16, 128, 512, 1 << 10, 4 << 10, 32 << 10, 64 << 10}
+	fileDataAlignments2 = [8]uint64{128 << 10, 256 << 10, 512 << 10, 1 << 20, 2 << 20, 4 << 20, 8 << 20, 16 << 20}
+)
+
+// Has returns whether all of the given attribute bits are set.
+func (a FileAttributes) Has(flags FileAttributes) bool {
+	return a&flags == flags
+}
+
+// LargeFile returns whether FileAttributeLargeFile is set.
+func (a FileAttributes) LargeFile() bool {
+	return a.Has(FileAttributeLargeFile)
+}
+
+// Checksum returns whether FileAttributeChecksum is set.
+func (a FileAttributes) Checksum() bool {
+	return a.Has(FileAttributeChecksum)
+}
+
+// Fixed returns whether FileAttributeFixed is set.
+func (a FileAttributes) Fixed() bool {
+	return a.Has(FileAttributeFixed)
+}
+
+// DataAlignment returns the required alignment of the file data in bytes.
+func (a FileAttributes) DataAlignment() uint64 {
+	i := (a & FileAttributeDataAlignmentMask) >> 3
+	if a.Has(FileAttributeDataAlignment2) {
+		return fileDataAlignments2[i]
+	}
+	return fileDataAlignments[i]
+}
+
+// SetDataAlignment sets the required alignment of the file data. Only the
+// alignments defined by the PI specification are supported.
+func (a *FileAttributes) SetDataAlignment(alignment uint64) error {
+	clear := *a &^ (FileAttributeDataAlignmentMask | FileAttributeDataAlignment2)
+	for i, al := range fileDataAlignments {
+		if al == alignment {
+			*a = clear | FileAttributes(i<<3)
+			return nil
+		}
+	}
+	for i, al := range fileDataAlignments2 {
+		if al == alignment {
+			*a = clear | FileAttributes(i<<3) | FileAttributeDataAlignment2
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid file data alignment %d", alignment)
+}
+
+func (a FileAttributes) String() string {
+	var parts []string
+	if a.LargeFile() {
+		parts = append(parts, "large")
+	}
+	if a.Fixed() {
+		parts = append(parts, "fixed")
+	}
+	if a.Checksum() {
+		parts = append(parts, "checksum")
+	}
+	if al := a.DataAlignment(); al != 1 {
+		parts = append(parts, fmt.Sprintf("align=%d", al))
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,86 @@
+package efi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrOverBudget is wrapped by *BudgetError.
+var ErrOverBudget = errors.New("volume over budget")
+
+// FileGrowth describes how much a file grew compared to when it was read.
+type FileGrowth struct {
+	// Index of the file within the volume.
+	Index int
+	GUID  GUID
+	// OldSize is the size of the file as read, including alignment, or zero
+	// if the file was added.
+	OldSize int
+	// NewSize is the size of the file were it serialized now, including
+	// alignment.
+	NewSize int
+}
+
+// BudgetError is returned by Serialize when a volume would exceed its
+// MaxSize.
+type BudgetError struct {
+	MaxSize int
+	// Size the volume would have when serialized.
+	Size int
+	// Files which grew or were added, largest growth first.
+	Files []FileGrowth
+}
+
+func (e *BudgetError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: 0x%x bytes, maximum is 0x%x (0x%x bytes over)", ErrOverBudget, e.Size, e.MaxSize, e.Size-e.MaxSize)
+	for _, f := range e.Files {
+		if f.OldSize == 0 {
+			fmt.Fprintf(&sb, "\n  file %d (%s): added, 0x%x bytes", f.Index, f.GUID.Name(), f.NewSize)
+		} else {
+			fmt.Fprintf(&sb, "\n  file %d (%s): grew by 0x%x bytes, 0x%x -> 0x%x", f.Index, f.GUID.Name(), f.NewSize-f.OldSize, f.OldSize, f.NewSize)
+		}
+	}
+	return sb.String()
+}
+
+func (e *BudgetError) Unwrap() error {
+	return ErrOverBudget
+}
+
+// checkBudget returns a *BudgetError if the volume laid out as l would exceed
+// MaxSize.
+func (v *Volume) checkBudget(l *volumeLayout, padding *FirmwareFile) error {
+	size := l.totalSize + len(v.Custom)
+	if v.MaxSize == 0 || size <= v.MaxSize {
+		return nil
+	}
+	res := &BudgetError{
+		MaxSize: v.MaxSize,
+		Size:    size,
+	}
+	for i, f := range v.Files {
+		if f == padding || f.alignPad {
+			continue
+		}
+		oldSize := int(f.readSize)
+		if oldSize%8 != 0 {
+			oldSize += 8 - oldSize%8
+		}
+		if newSize := l.fileSizes[i]; newSize > oldSize {
+			res.Files = append(res.Files, FileGrowth{
+				Index:   i,
+				GUID:    f.GUID,
+				OldSize: oldSize,
+				NewSize: newSize,
+			})
+		}
+	}
+	sort.SliceStable(res.Files, func(i, j int) bool {
+		a, b := res.Files[i], res.Files[j]
+		return a.NewSize-a.OldSize > b.NewSize-b.OldSize
+	})
+	return res
+}
@@ -0,0 +1,175 @@
+package efi
+
+import (
+	"fmt"
+)
+
+var (
+	// SectionDefinitionCRC32 is the definition GUID of GUID-defined sections
+	// whose data is protected by a CRC32.
+	SectionDefinitionCRC32 = guidCRC32
+	// SectionDefinitionLZMA is the definition GUID of GUID-defined sections
+	// whose data is LZMA compressed.
+	SectionDefinitionLZMA = guidLZMA
+)
+
+// NewFile returns a file of a given type containing the given sections. The
+// file has a checksummed body and is in the 'data valid' state. Its size and
+// checksums are calculated when it is serialized.
+func NewFile(guid GUID, typ FileType, sections ...Section) *FirmwareFile {
+	return &FirmwareFile{
+		FirmwareFileHeader: FirmwareFileHeader{
+			GUID:       guid,
+			FileType:   typ,
+			Attributes: FileAttributeChecksum,
+			State:      0xf8,
+		},
+		Sections: sections,
+	}
+}
+
+// NewRawFile returns a FileTypeRaw file containing the given data.
+func NewRawFile(guid GUID, data []byte) *FirmwareFile {
+	f := NewFile(guid, FileTypeRaw)
+	f.Data = data
+	return f
+}
+
+// NewFreeformFile returns a FileTypeFreeform file containing the given
+// sections.
+func NewFreeformFile(guid GUID, sections ...Section) *FirmwareFile {
+	return NewFile(guid, FileTypeFreeform, sections...)
+}
+
+// NewDriverFile returns a FileTypeDriver file containing the given sections,
+// usually a PE32 or TE section, and optionally DEPEX, UI and version sections.
+func NewDriverFile(guid GUID, sections ...Section) *FirmwareFile {
+	return NewFile(guid, FileTypeDriver, sections...)
+}
+
+// NewLeafSection returns a section of a given type containing the given data.
+// Only PE32, TE, DEPEX, version, user interface and raw sections can be
+// created this way.
+func NewLeafSection(typ SectionType, data []byte) (Section, error) {
+	switch typ {
+	case SectionTypePE32, SectionTypeTE, SectionTypeDXEDEPEX, SectionTypeVersion, SectionTypeUserInterface, SectionTypeRaw:
+	default:
+		return nil, fmt.Errorf("%s is not a leaf section type", typ)
+	}
+	res := &leafSection{
+		commonSectionHeader: commonSectionHeader{Type: typ},
+	}
+	res.SetRaw(data)
+	return res, nil
+}
+
+// NewUserInterfaceSection returns a user interface section containing the
+// given name.
+func NewUserInterfaceSection(name string) (Section, error) {
+	res, _ := NewLeafSection(SectionTypeUserInterface, nil)
+	if err := SetSectionName(res, name); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// NewVersionSection returns a version section containing the given version.
+func NewVersionSection(v *Version) (Section, error) {
+	res, _ := NewLeafSection(SectionTypeVersion, nil)
+	if err := SetSectionVersion(res, v); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// NewDepexSection returns a DEPEX section containing the given dependency
+// expression.
+func NewDepexSection(d Depex) Section {
+	res, _ := NewLeafSection(SectionTypeDXEDEPEX, d.Serialize())
+	return res
+}
+
+// NewFreeformSection returns a freeform section with the given subtype GUID
+// containing the given data.
+func NewFreeformSection(subtype GUID, data []byte) Section {
+	res := &freeformSection{
+		subtype: subtype,
+	}
+	res.Type = SectionTypeFreeformSubtypeGUID
+	res.SetRaw(data)
+	return res
+}
+
+// NewCompressedSection returns a compression section encapsulating the given
+// sections using a given compression type (CompressionTypeNone or
+// CompressionTypeStandard, which uses Tiano compression).
+func NewCompressedSection(compressionType uint8, sections ...Section) (Section, error) {
+	switch compressionType {
+	case CompressionTypeNone, CompressionTypeStandard:
+	default:
+		return nil, fmt.Errorf("unsupported compression type %d", compressionType)
+	}
+	res := &compressionSection{
+		commonSectionHeader: commonSectionHeader{Type: SectionTypeCompression},
+		sub:                 sections,
+	}
+	res.extra.CompressionType = compressionType
+	return res, nil
+}
+
+// NewGUIDDefinedSection returns a GUID-defined section encapsulating the
+// given sections. Only CRC32 and LZMA definition GUIDs are supported, as the
+// section data must be generated on serialization.
+func NewGUIDDefinedSection(definition GUID, sections ...Section) (Section, error) {
+	res := &guidSection{
+		commonSectionHeader: commonSectionHeader{Type: SectionTypeGUIDDefined},
+		sub:                 sections,
+	}
+	res.extra.SectionDefinitionGUID = definition
+	switch definition {
+	case guidCRC32:
+		res.extra.Attributes = GUIDedSectionAuthStatusValid
+		res.custom = make([]byte, 4)
+	case guidLZMA:
+		res.extra.Attributes = GUIDedSectionProcessingRequired
+	default:
+		return nil, fmt.Errorf("unsupported section definition %s", definition.Name())
+	}
+	return res, nil
+}
+
+// NewFirmwareVolumeSection returns a firmware volume image section
+// containing the given volume.
+func NewFirmwareVolumeSection(v *Volume) Section {
+	return &volumeSection{
+		commonSectionHeader: commonSectionHeader{Type: SectionTypeFirmwareVolumeImage},
+		volume:              v,
+	}
+}
+
+// NewVolume returns an empty EFI_FIRMWARE_FILE_SYSTEM2 volume of the given
+// size in bytes (including its header), made up of 256 byte blocks. Files can
+// then be added with InsertFile, and the volume keeps its size when
+// serialized, with the remaining space taken up by a padding file.
+func NewVolume(size int) (*Volume, error) {
+	if size%defaultBlockSize != 0 {
+		return nil, fmt.Errorf("size 0x%x not a multiple of the %d byte block size", size, defaultBlockSize)
+	}
+	// Header, blockmap with terminator and an empty padding file.
+	if minimum := 0x38 + 0x10 + 0x18; size < minimum {
+		return nil, fmt.Errorf("size 0x%x too small, need at least 0x%x", size, minimum)
+	}
+	return &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			AttributeMask: VolumeReadEnabledCap | VolumeReadStatus |
+				VolumeWriteEnabledCap | VolumeWriteStatus |
+				VolumeMemoryMapped | VolumeErasePolarity,
+			Revision: 2,
+		},
+		blockmap: []blockmap{
+			{BlockCount: uint32(size / defaultBlockSize), BlockSize: defaultBlockSize},
+		},
+	}, nil
+}
@@ -0,0 +1,94 @@
+package efi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuilders(t *testing.T) {
+	pe32, err := NewLeafSection(SectionTypePE32, []byte("MZ not really"))
+	if err != nil {
+		t.Fatalf("NewLeafSection: %v", err)
+	}
+	ui, err := NewUserInterfaceSection("Hello")
+	if err != nil {
+		t.Fatalf("NewUserInterfaceSection: %v", err)
+	}
+	crc, err := NewGUIDDefinedSection(SectionDefinitionCRC32, pe32, ui)
+	if err != nil {
+		t.Fatalf("NewGUIDDefinedSection: %v", err)
+	}
+	compressed, err := NewCompressedSection(CompressionTypeStandard, crc)
+	if err != nil {
+		t.Fatalf("NewCompressedSection: %v", err)
+	}
+	depex := NewDepexSection(Depex{{Opcode: DepexTrue}})
+
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			NewDriverFile(GUID{0x01}, depex, compressed),
+			NewRawFile(GUID{0x02}, []byte("raw data")),
+			NewFreeformFile(GUID{0x03}, NewFreeformSection(GUID{0x04}, []byte("blob"))),
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if want, got := "Hello", v2.Files[0].UIName(); want != got {
+		t.Errorf("wanted driver name %q, got %q", want, got)
+	}
+	if want, got := []byte("raw data"), v2.Files[1].Data; !bytes.Equal(want, got) {
+		t.Errorf("wanted raw file data %q, got %q", want, got)
+	}
+	el, err := v2.Lookup("0/section:pe32")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if want, got := []byte("MZ not really"), el.Section.Raw(); !bytes.Equal(want, got) {
+		t.Errorf("wanted PE32 data %q, got %q", want, got)
+	}
+
+	if _, err := NewLeafSection(SectionTypeCompression, nil); err == nil {
+		t.Errorf("NewLeafSection(compression) succeeded, wanted error")
+	}
+}
+
+func TestNewVolume(t *testing.T) {
+	if _, err := NewVolume(0x123); err == nil {
+		t.Errorf("NewVolume with unaligned size succeeded")
+	}
+	v, err := NewVolume(0x1000)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	if err := v.InsertFile(0, NewRawFile(GUID{0x01}, []byte("payload"))); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	if err := v.InsertFile(1, NewRawFile(GUID{0x02}, make([]byte, 0x1000))); err == nil {
+		t.Errorf("InsertFile of oversized file succeeded")
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := 0x1000, len(data); want != got {
+		t.Fatalf("volume is 0x%x bytes, wanted 0x%x", got, want)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if _, err := v2.fileIndex(GUID{0x01}); err != nil {
+		t.Errorf("payload missing: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// package checksum implements the simple additive checksums used in EFI
+// firmware volume and file headers, and similar structures in other firmware
+// formats.
+//
+// A checksum field is set so that the sum of all 8-bit (or 16-bit little
+// endian) words of the structure, including the checksum itself, is zero.
+package checksum
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Sum8 returns the sum of all bytes in data, modulo 256.
+func Sum8(data []byte) uint8 {
+	var w Writer8
+	w.Write(data)
+	return w.Sum()
+}
+
+// Checksum8 returns the value necessary to make data sum to zero when
+// interpreted as an array of 8-bit integers.
+func Checksum8(data []byte) uint8 {
+	return -Sum8(data)
+}
+
+// Sum16 returns the sum of all little endian 16-bit words in data, modulo
+// 65536. It panics if data is not made up of whole words.
+func Sum16(data []byte) uint16 {
+	if len(data)%2 != 0 {
+		panic("cannot checksum non-16-bit-chunked data")
+	}
+	var sum uint16
+	for i := 0; i < len(data); i += 2 {
+		sum += binary.LittleEndian.Uint16(data[i:])
+	}
+	return sum
+}
+
+// Checksum16 returns the value necessary to make data sum to zero when
+// interpreted as an array of little endian 16-bit integers. It panics if data
+// is not made up of whole words.
+func Checksum16(data []byte) uint16 {
+	return -Sum16(data)
+}
+
+// Verify8 returns whether data, including its checksum byte, sums to zero.
+func Verify8(data []byte) bool {
+	return Sum8(data) == 0
+}
+
+// Verify16 returns whether data, including its checksum word, sums to zero.
+func Verify16(data []byte) bool {
+	return Sum16(data) == 0
+}
+
+// Fix8 sets the checksum byte at offset within data so that data sums to
+// zero.
+func Fix8(data []byte, offset int) error {
+	if offset < 0 || offset >= len(data) {
+		return fmt.Errorf("checksum offset %d out of range", offset)
+	}
+	data[offset] = 0
+	data[offset] = Checksum8(data)
+	return nil
+}
+
+// Fix16 sets the little endian checksum word at offset within data so that
+// data sums to zero.
+func Fix16(data []byte, offset int) error {
+	if len(data)%2 != 0 {
+		return fmt.Errorf("data length %d not a multiple of 2", len(data))
+	}
+	if offset < 0 || offset+2 > len(data) || offset%2 != 0 {
+		return fmt.Errorf("checksum offset %d out of range or unaligned", offset)
+	}
+	binary.LittleEndian.PutUint16(data[offset:], 0)
+	binary.LittleEndian.PutUint16(data[offset:], Checksum16(data))
+	return nil
+}
+
+// Writer8 is an io.Writer calculating the 8-bit sum of all data written to
+// it, so that a checksum can be computed while streaming data out.
+type Writer8 struct {
+	sum uint8
+}
+
+func (w *Writer8) Write(data []byte) (int, error) {
+	for _, b := range data {
+		w.sum += b
+	}
+	return len(data), nil
+}
+
+// Sum returns the sum of all data written so far.
+func (w *Writer8) Sum() uint8 {
+	return w.sum
+}
+
+// Checksum returns Checksum8 of all data written so far.
+func (w *Writer8) Checksum() uint8 {
+	return -w.sum
+}
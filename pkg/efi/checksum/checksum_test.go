@@ -0,0 +1,40 @@
+package checksum
+
+import (
+	"testing"
+)
+
+func TestChecksum8(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x00, 0xff}
+	if err := Fix8(data, 2); err != nil {
+		t.Fatalf("Fix8: %v", err)
+	}
+	if want, got := uint8(0xfe), data[2]; want != got {
+		t.Errorf("checksum byte is %02x, wanted %02x", got, want)
+	}
+	if !Verify8(data) {
+		t.Errorf("fixed data does not verify")
+	}
+	var w Writer8
+	w.Write(data[:2])
+	w.Write(data[3:])
+	if want, got := Checksum8([]byte{0x01, 0x02, 0xff}), w.Checksum(); want != got {
+		t.Errorf("streamed checksum %02x, wanted %02x", got, want)
+	}
+}
+
+func TestChecksum16(t *testing.T) {
+	data := []byte{0x34, 0x12, 0xaa, 0xaa, 0xff, 0xff}
+	if err := Fix16(data, 2); err != nil {
+		t.Fatalf("Fix16: %v", err)
+	}
+	if want, got := uint16(0xedcd), uint16(data[2])|uint16(data[3])<<8; want != got {
+		t.Errorf("checksum word is %04x, wanted %04x", got, want)
+	}
+	if !Verify16(data) {
+		t.Errorf("fixed data does not verify")
+	}
+	if err := Fix16(data, 1); err == nil {
+		t.Errorf("Fix16 at unaligned offset succeeded")
+	}
+}
@@ -2,6 +2,9 @@
 // calling out into edk2 Tiano{Dec,C}ompres functions compiled into
 // WebAssembly.
 //
+// The EFI 1.1 variant of the algorithm is not part of the WebAssembly build,
+// and is instead implemented in pure Go (see efi.go).
+//
 // We don't use cgo or c2go because I don't trust that code.
 //
 // See build.sh on how to regenerate edk2.wasm.
@@ -89,7 +92,8 @@ func (e *edk2) readu32(ctx context.Context, ptr uint32) uint32 {
 }
 
 var (
-	edk2 *edk2
+	// instance is the lazily loaded edk2 module singleton.
+	instance *edk2
 )
 
 func edk2Error(code int32) error {
@@ -109,8 +113,8 @@ func edk2Error(code int32) error {
 
 func getedk2() *edk2 {
 	// Already guarded by 'mu'.
-	if edk2 != nil {
-		return edk2
+	if instance != nil {
+		return instance
 	}
 
 	ctx := context.Background()
@@ -140,7 +144,7 @@ func getedk2() *edk2 {
 		decompressF: mod.ExportedFunction("TianoDecompress"),
 	}
 
-	edk2 = e
+	instance = e
 	return e
 }
 
@@ -149,6 +153,9 @@ func Decompress(in []byte) ([]byte, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
+	if len(in) < 8 {
+		return nil, fmt.Errorf("too short for header")
+	}
 	var dstSize uint32
 	if err := binary.Read(bytes.NewBuffer(in[4:8]), binary.LittleEndian, &dstSize); err != nil {
 		return nil, err
@@ -170,7 +177,7 @@ func Decompress(in []byte) ([]byte, error) {
 
 	// Prepare `scratch` in wasm.
 	scratchPtr := e.malloc(ctx, 13393)
-	defer e.free(ctx, outPtr)
+	defer e.free(ctx, scratchPtr)
 
 	results, err := e.decompressF.Call(ctx, uint64(inPtr), uint64(len(in)), uint64(outPtr), uint64(dstSize), uint64(scratchPtr), 13393)
 	if err != nil {
@@ -205,25 +212,39 @@ func Compress(in []byte) ([]byte, error) {
 	defer e.free(ctx, inPtr)
 	e.write(ctx, inPtr, in)
 
-	// Prepare `out` in wasm.
-	outPtr := e.malloc(ctx, len(in))
-	defer e.free(ctx, outPtr)
-
 	// Prepare `outSize` in wasm.
 	outSizePtr := e.malloc(ctx, 4)
 	defer e.free(ctx, outSizePtr)
-	e.writeu32(ctx, outSizePtr, uint32(len(in)))
 
-	results, err := e.compressF.Call(ctx, uint64(inPtr), uint64(len(in)), uint64(outPtr), uint64(outSizePtr))
-	if err != nil {
-		return nil, fmt.Errorf("wasm TianoCompress() failed: %w", err)
+	// Start off with an output buffer the size of the input. If that's too
+	// small (eg. for incompressible data), TianoCompress will tell us how much
+	// it actually needs, and we try again.
+	outSize := len(in)
+	for {
+		// Prepare `out` in wasm.
+		outPtr := e.malloc(ctx, outSize)
+		e.writeu32(ctx, outSizePtr, uint32(outSize))
+
+		results, err := e.compressF.Call(ctx, uint64(inPtr), uint64(len(in)), uint64(outPtr), uint64(outSizePtr))
+		if err != nil {
+			e.free(ctx, outPtr)
+			return nil, fmt.Errorf("wasm TianoCompress() failed: %w", err)
+		}
+
+		res := int32(results[0])
+		outSizeU32 := e.readu32(ctx, outSizePtr)
+		if res == 5 && int(outSizeU32) > outSize {
+			e.free(ctx, outPtr)
+			outSize = int(outSizeU32)
+			continue
+		}
+		if res != 0 {
+			e.free(ctx, outPtr)
+			return nil, edk2Error(res)
+		}
+
+		data := e.read(ctx, outPtr, int(outSizeU32))
+		e.free(ctx, outPtr)
+		return data, nil
 	}
-
-	res := int32(results[0])
-	if res != 0 {
-		return nil, edk2Error(res)
-	}
-
-	outSizeU32 := e.readu32(ctx, outSizePtr)
-	return e.read(ctx, outPtr, int(outSizeU32)), nil
 }
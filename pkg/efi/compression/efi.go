@@ -0,0 +1,713 @@
+package compression
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// This file implements the EFI 1.1 compression algorithm in pure Go. It's the
+// same LZ77+Huffman scheme as Tiano compression, differing only in the bit
+// width used to encode the position code table (4 bits for EFI 1.1, 5 bits
+// for Tiano) and the window size used by the compressor.
+//
+// The decoder is a straightforward reimplementation of the UEFI
+// specification (Appendix H). The encoder does not attempt to produce the
+// same output as the EDK2 EfiCompress implementation, but its output will
+// decompress on any compliant implementation.
+
+const (
+	efiMaxMatch  = 256
+	efiThreshold = 3
+	efiCodeBit   = 16
+	// efiNC is the size of the char/length alphabet.
+	efiNC   = 0xff + efiMaxMatch + 2 - efiThreshold
+	efiCBit = 9
+	// efiNT is the size of the alphabet used to encode the char/length
+	// Huffman code lengths.
+	efiNT   = efiCodeBit + 3
+	efiTBit = 5
+	// efiMaxBlock is the maximum amount of char/length codes in a block.
+	efiMaxBlock = 0xffff
+
+	pbitEFI   = 4
+	pbitTiano = 5
+
+	wndbitEFI = 13
+)
+
+// DecompressEFI decompresses data using the EFI 1.1 compression algorithm.
+func DecompressEFI(in []byte) ([]byte, error) {
+	return efiDecompress(in, pbitEFI)
+}
+
+// CompressEFI compresses data using the EFI 1.1 compression algorithm.
+func CompressEFI(in []byte) ([]byte, error) {
+	return efiCompress(in, pbitEFI, wndbitEFI)
+}
+
+type bitReader struct {
+	in  []byte
+	pos int
+	// buf contains up to 64 bits of yet unconsumed data, left aligned.
+	buf   uint64
+	nbits int
+}
+
+func (b *bitReader) fill() {
+	for b.nbits <= 56 {
+		var v byte
+		if b.pos < len(b.in) {
+			v = b.in[b.pos]
+		}
+		// Reading past the end yields zeroes, as per the reference
+		// implementation.
+		b.pos++
+		b.buf |= uint64(v) << uint(56-b.nbits)
+		b.nbits += 8
+	}
+}
+
+func (b *bitReader) peek(n int) uint32 {
+	if n == 0 {
+		return 0
+	}
+	b.fill()
+	return uint32(b.buf >> uint(64-n))
+}
+
+func (b *bitReader) skip(n int) {
+	b.fill()
+	b.buf <<= uint(n)
+	b.nbits -= n
+}
+
+func (b *bitReader) get(n int) uint32 {
+	v := b.peek(n)
+	b.skip(n)
+	return v
+}
+
+// huffmanTable is a canonical Huffman decoder built from code lengths.
+type huffmanTable struct {
+	// single is set if the table always decodes to a single symbol without
+	// consuming any bits.
+	single bool
+	symbol uint16
+
+	// count[l] is the amount of codes with length l.
+	count [17]uint16
+	// symbols sorted by code length, then symbol value.
+	symbols []uint16
+}
+
+var errBadTable = errors.New("bad huffman table")
+
+func newHuffmanTable(lengths []uint8) (*huffmanTable, error) {
+	t := &huffmanTable{}
+	for _, l := range lengths {
+		if l > 16 {
+			return nil, errBadTable
+		}
+		t.count[l]++
+	}
+	// Check code is complete, as per MakeTable.
+	total := 0
+	for l := 1; l <= 16; l++ {
+		total += int(t.count[l]) << uint(16-l)
+	}
+	if total != 1<<16 {
+		return nil, errBadTable
+	}
+	for l := 1; l <= 16; l++ {
+		for s, sl := range lengths {
+			if int(sl) == l {
+				t.symbols = append(t.symbols, uint16(s))
+			}
+		}
+	}
+	return t, nil
+}
+
+func newSingleHuffmanTable(symbol uint16) *huffmanTable {
+	return &huffmanTable{
+		single: true,
+		symbol: symbol,
+	}
+}
+
+func (t *huffmanTable) decode(b *bitReader) uint16 {
+	if t.single {
+		return t.symbol
+	}
+	code := 0
+	first := 0
+	index := 0
+	for l := 1; l <= 16; l++ {
+		code |= int(b.get(1))
+		count := int(t.count[l])
+		if code-first < count {
+			return t.symbols[index+code-first]
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	// Unreachable for complete codes.
+	return 0
+}
+
+// readPTLen reads a code length table for the 'T' (code length) or 'P'
+// (position) alphabets.
+func readPTLen(b *bitReader, nn, nbit, special int) (*huffmanTable, error) {
+	number := int(b.get(nbit))
+	if number == 0 {
+		symbol := b.get(nbit)
+		if int(symbol) >= nn {
+			return nil, errBadTable
+		}
+		return newSingleHuffmanTable(uint16(symbol)), nil
+	}
+	if number > nn {
+		return nil, errBadTable
+	}
+	lengths := make([]uint8, nn)
+	index := 0
+	for index < number {
+		l := int(b.peek(3))
+		if l == 7 {
+			b.skip(3)
+			for b.get(1) == 1 {
+				l++
+				if l > 16 {
+					return nil, errBadTable
+				}
+			}
+		} else {
+			b.skip(3)
+		}
+		lengths[index] = uint8(l)
+		index++
+		if index == special {
+			zeroes := int(b.get(2))
+			for ; zeroes > 0 && index < nn; zeroes-- {
+				lengths[index] = 0
+				index++
+			}
+		}
+	}
+	return newHuffmanTable(lengths)
+}
+
+// readCLen reads the code length table for the char/length alphabet.
+func readCLen(b *bitReader, pt *huffmanTable) (*huffmanTable, error) {
+	number := int(b.get(efiCBit))
+	if number == 0 {
+		symbol := b.get(efiCBit)
+		if int(symbol) >= efiNC {
+			return nil, errBadTable
+		}
+		return newSingleHuffmanTable(uint16(symbol)), nil
+	}
+	if number > efiNC {
+		return nil, errBadTable
+	}
+	lengths := make([]uint8, efiNC)
+	index := 0
+	for index < number {
+		c := int(pt.decode(b))
+		if c <= 2 {
+			switch c {
+			case 0:
+				c = 1
+			case 1:
+				c = int(b.get(4)) + 3
+			case 2:
+				c = int(b.get(efiCBit)) + 20
+			}
+			for ; c > 0 && index < efiNC; c-- {
+				lengths[index] = 0
+				index++
+			}
+		} else {
+			lengths[index] = uint8(c - 2)
+			index++
+		}
+	}
+	return newHuffmanTable(lengths)
+}
+
+func efiDecompress(in []byte, pbit int) ([]byte, error) {
+	if len(in) < 8 {
+		return nil, fmt.Errorf("too short for header")
+	}
+	compSize := binary.LittleEndian.Uint32(in[0:4])
+	origSize := binary.LittleEndian.Uint32(in[4:8])
+	if int(compSize) > len(in)-8 {
+		return nil, fmt.Errorf("compressed size %d larger than input", compSize)
+	}
+
+	b := &bitReader{
+		in: in[8 : 8+compSize],
+	}
+	np := (1 << uint(pbit)) - 1
+	out := make([]byte, 0, origSize)
+
+	var c, p *huffmanTable
+	blockSize := 0
+	for len(out) < int(origSize) {
+		if blockSize == 0 {
+			blockSize = int(b.get(16))
+			t, err := readPTLen(b, efiNT, efiTBit, 3)
+			if err != nil {
+				return nil, fmt.Errorf("reading T table: %w", err)
+			}
+			c, err = readCLen(b, t)
+			if err != nil {
+				return nil, fmt.Errorf("reading C table: %w", err)
+			}
+			p, err = readPTLen(b, np, pbit, -1)
+			if err != nil {
+				return nil, fmt.Errorf("reading P table: %w", err)
+			}
+		}
+		blockSize--
+		if b.pos > len(b.in)+8 {
+			return nil, fmt.Errorf("read past end of input")
+		}
+
+		sym := int(c.decode(b))
+		if sym < 256 {
+			out = append(out, byte(sym))
+			continue
+		}
+		length := sym - (0x100 - efiThreshold)
+		pos := int(p.decode(b))
+		if pos > 1 {
+			pos = (1 << uint(pos-1)) + int(b.get(pos-1))
+		}
+		start := len(out) - pos - 1
+		if start < 0 {
+			return nil, fmt.Errorf("match position out of range")
+		}
+		for i := 0; i < length && len(out) < int(origSize); i++ {
+			out = append(out, out[start+i])
+		}
+	}
+	return out, nil
+}
+
+type bitWriter struct {
+	buf   bytes.Buffer
+	acc   uint64
+	nbits int
+}
+
+func (w *bitWriter) put(n int, v uint32) {
+	if n == 0 {
+		return
+	}
+	w.acc = (w.acc << uint(n)) | uint64(v&((1<<uint(n))-1))
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf.WriteByte(byte(w.acc >> uint(w.nbits-8)))
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) flush() []byte {
+	if w.nbits > 0 {
+		w.buf.WriteByte(byte(w.acc << uint(8-w.nbits)))
+		w.nbits = 0
+	}
+	return w.buf.Bytes()
+}
+
+type huffmanNode struct {
+	weight int
+	// symbol is the leaf symbol, or -1 for internal nodes.
+	symbol      int
+	left, right *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths builds length-limited (to 16 bits) Huffman code lengths for
+// the given symbol frequencies. At least two symbols must have a non-zero
+// frequency.
+func huffmanLengths(freq []int) []uint8 {
+	h := &huffmanHeap{}
+	var used []int
+	for s, f := range freq {
+		if f > 0 {
+			heap.Push(h, &huffmanNode{weight: f, symbol: s})
+			used = append(used, s)
+		}
+	}
+	for h.Len() > 1 {
+		a := heap.Pop(h).(*huffmanNode)
+		b := heap.Pop(h).(*huffmanNode)
+		heap.Push(h, &huffmanNode{weight: a.weight + b.weight, symbol: -1, left: a, right: b})
+	}
+
+	var count [64]int
+	var walk func(n *huffmanNode, depth int)
+	walk = func(n *huffmanNode, depth int) {
+		if n.symbol >= 0 {
+			count[depth]++
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(heap.Pop(h).(*huffmanNode), 0)
+
+	// Limit lengths to 16 bits, keeping the code complete. This is the same
+	// approach as EDK2's MakeLen.
+	for l := 17; l < len(count); l++ {
+		count[16] += count[l]
+		count[l] = 0
+	}
+	cum := 0
+	for l := 1; l <= 16; l++ {
+		cum += count[l] << uint(16-l)
+	}
+	for ; cum != 1<<16; cum-- {
+		count[16]--
+		for l := 15; l > 0; l-- {
+			if count[l] != 0 {
+				count[l]--
+				count[l+1] += 2
+				break
+			}
+		}
+	}
+
+	// Assign shortest lengths to most frequent symbols.
+	sort.SliceStable(used, func(i, j int) bool {
+		return freq[used[i]] > freq[used[j]]
+	})
+	lengths := make([]uint8, len(freq))
+	ix := 0
+	for l := 1; l <= 16; l++ {
+		for i := 0; i < count[l]; i++ {
+			lengths[used[ix]] = uint8(l)
+			ix++
+		}
+	}
+	return lengths
+}
+
+// huffmanCodes returns canonical codes for the given lengths, matching the
+// assignment done by the decoder.
+func huffmanCodes(lengths []uint8) []uint32 {
+	var count [17]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [18]uint32
+	code := uint32(0)
+	for l := 1; l <= 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for s, l := range lengths {
+		if l != 0 {
+			codes[s] = next[l]
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// huffmanCode is either a single-symbol code (which takes no bits to
+// encode), or a set of lengths with canonical codes.
+type huffmanCode struct {
+	single  bool
+	symbol  int
+	lengths []uint8
+	codes   []uint32
+}
+
+func newHuffmanCode(freq []int) *huffmanCode {
+	used := 0
+	symbol := 0
+	for s, f := range freq {
+		if f > 0 {
+			used++
+			symbol = s
+		}
+	}
+	if used < 2 {
+		return &huffmanCode{
+			single:  true,
+			symbol:  symbol,
+			lengths: make([]uint8, len(freq)),
+		}
+	}
+	lengths := huffmanLengths(freq)
+	return &huffmanCode{
+		lengths: lengths,
+		codes:   huffmanCodes(lengths),
+	}
+}
+
+func (h *huffmanCode) put(w *bitWriter, sym int) {
+	if h.single {
+		return
+	}
+	w.put(int(h.lengths[sym]), h.codes[sym])
+}
+
+// used returns the amount of lengths that need to be emitted, ie. the index
+// of the last non-zero length plus one.
+func (h *huffmanCode) used() int {
+	n := len(h.lengths)
+	for n > 0 && h.lengths[n-1] == 0 {
+		n--
+	}
+	return n
+}
+
+// writePTLen is the inverse of readPTLen.
+func writePTLen(w *bitWriter, h *huffmanCode, nbit, special int) {
+	if h.single {
+		w.put(nbit, 0)
+		w.put(nbit, uint32(h.symbol))
+		return
+	}
+	number := h.used()
+	w.put(nbit, uint32(number))
+	index := 0
+	for index < number {
+		l := int(h.lengths[index])
+		if l < 7 {
+			w.put(3, uint32(l))
+		} else {
+			// 7 and above: 111, then (l-7) ones, then a zero.
+			w.put(3, 7)
+			for i := 7; i < l; i++ {
+				w.put(1, 1)
+			}
+			w.put(1, 0)
+		}
+		index++
+		if index == special {
+			zeroes := 0
+			for zeroes < 3 && index+zeroes < number && h.lengths[index+zeroes] == 0 {
+				zeroes++
+			}
+			w.put(2, uint32(zeroes))
+			index += zeroes
+		}
+	}
+}
+
+// cLenSymbols converts char/length code lengths into T alphabet symbols and
+// their extra bits.
+type tSymbol struct {
+	symbol    int
+	extraBits int
+	extra     uint32
+}
+
+func cLenSymbols(h *huffmanCode) []tSymbol {
+	var res []tSymbol
+	number := h.used()
+	for i := 0; i < number; {
+		l := h.lengths[i]
+		if l != 0 {
+			res = append(res, tSymbol{symbol: int(l) + 2})
+			i++
+			continue
+		}
+		run := 0
+		for i+run < number && h.lengths[i+run] == 0 {
+			run++
+		}
+		i += run
+		for run > 0 {
+			switch {
+			case run <= 2:
+				res = append(res, tSymbol{symbol: 0})
+				run--
+			case run <= 18:
+				res = append(res, tSymbol{symbol: 1, extraBits: 4, extra: uint32(run - 3)})
+				run = 0
+			case run == 19:
+				res = append(res, tSymbol{symbol: 0})
+				run--
+			default:
+				n := run
+				if n > 20+(1<<efiCBit)-1 {
+					n = 20 + (1 << efiCBit) - 1
+				}
+				res = append(res, tSymbol{symbol: 2, extraBits: efiCBit, extra: uint32(n - 20)})
+				run -= n
+			}
+		}
+	}
+	return res
+}
+
+// lzSymbol is a literal (length == 0) or a match.
+type lzSymbol struct {
+	literal byte
+	length  int
+	pos     int
+}
+
+func positionCode(pos int) int {
+	c := 0
+	for p := pos; p != 0; p >>= 1 {
+		c++
+	}
+	return c
+}
+
+func writeBlock(w *bitWriter, syms []lzSymbol, pbit int) {
+	np := (1 << uint(pbit)) - 1
+	cFreq := make([]int, efiNC)
+	pFreq := make([]int, np)
+	for _, s := range syms {
+		if s.length == 0 {
+			cFreq[s.literal]++
+		} else {
+			cFreq[s.length+(0x100-efiThreshold)]++
+			pFreq[positionCode(s.pos)]++
+		}
+	}
+	c := newHuffmanCode(cFreq)
+	p := newHuffmanCode(pFreq)
+
+	w.put(16, uint32(len(syms)))
+	var tsyms []tSymbol
+	tFreq := make([]int, efiNT)
+	if !c.single {
+		tsyms = cLenSymbols(c)
+		for _, ts := range tsyms {
+			tFreq[ts.symbol]++
+		}
+	}
+	t := newHuffmanCode(tFreq)
+	writePTLen(w, t, efiTBit, 3)
+	if c.single {
+		w.put(efiCBit, 0)
+		w.put(efiCBit, uint32(c.symbol))
+	} else {
+		w.put(efiCBit, uint32(c.used()))
+		for _, ts := range tsyms {
+			t.put(w, ts.symbol)
+			w.put(ts.extraBits, ts.extra)
+		}
+	}
+	writePTLen(w, p, pbit, -1)
+
+	for _, s := range syms {
+		if s.length == 0 {
+			c.put(w, int(s.literal))
+			continue
+		}
+		c.put(w, s.length+(0x100-efiThreshold))
+		pc := positionCode(s.pos)
+		p.put(w, pc)
+		if pc > 1 {
+			w.put(pc-1, uint32(s.pos-(1<<uint(pc-1))))
+		}
+	}
+}
+
+func efiCompress(in []byte, pbit, wndbit int) ([]byte, error) {
+	const hashBits = 15
+	window := 1 << uint(wndbit)
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(in))
+	hash := func(pos int) uint32 {
+		v := uint32(in[pos]) | uint32(in[pos+1])<<8 | uint32(in[pos+2])<<16
+		return (v * 2654435761) >> (32 - hashBits)
+	}
+	insert := func(pos int) {
+		if pos+efiThreshold > len(in) {
+			return
+		}
+		h := hash(pos)
+		prev[pos] = head[h]
+		head[h] = int32(pos)
+	}
+
+	w := &bitWriter{}
+	var block []lzSymbol
+	for pos := 0; pos < len(in); {
+		bestLen, bestPos := 0, 0
+		if pos+efiThreshold <= len(in) {
+			cand := head[hash(pos)]
+			for chain := 0; cand >= 0 && chain < 64; chain++ {
+				dist := pos - int(cand)
+				if dist > window {
+					break
+				}
+				max := len(in) - pos
+				if max > efiMaxMatch {
+					max = efiMaxMatch
+				}
+				l := 0
+				for l < max && in[int(cand)+l] == in[pos+l] {
+					l++
+				}
+				if l > bestLen {
+					bestLen, bestPos = l, dist-1
+				}
+				cand = prev[cand]
+			}
+		}
+		if bestLen >= efiThreshold {
+			block = append(block, lzSymbol{length: bestLen, pos: bestPos})
+			for i := 0; i < bestLen; i++ {
+				insert(pos + i)
+			}
+			pos += bestLen
+		} else {
+			block = append(block, lzSymbol{literal: in[pos]})
+			insert(pos)
+			pos++
+		}
+		if len(block) == efiMaxBlock {
+			writeBlock(w, block, pbit)
+			block = nil
+		}
+	}
+	if len(block) > 0 {
+		writeBlock(w, block, pbit)
+	}
+	data := w.flush()
+
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	binary.Write(buf, binary.LittleEndian, uint32(len(in)))
+	buf.Write(data)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,71 @@
+package compression
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func testInputs() [][]byte {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 20000)
+	r.Read(random)
+	// Text-like data with a small alphabet, to exercise longer codes.
+	skewed := make([]byte, 70000)
+	for i := range skewed {
+		skewed[i] = "aaaaaaaabbbbccd\x00"[r.Intn(16)]
+	}
+	return [][]byte{
+		[]byte("a"),
+		[]byte("According to all known laws of aviation, there is no way an EFI implementation should be able to fly. It's wings are too small to get its fat little body off the ground. The implementation, of course, flies anyway, because computers don't care what humans think is impossible."),
+		bytes.Repeat([]byte{0}, 100000),
+		random,
+		skewed,
+	}
+}
+
+func TestEFILoopback(t *testing.T) {
+	for i, input := range testInputs() {
+		compressed, err := CompressEFI(input)
+		if err != nil {
+			t.Fatalf("%d: CompressEFI() failed: %v", i, err)
+		}
+		uncompressed, err := DecompressEFI(compressed)
+		if err != nil {
+			t.Fatalf("%d: DecompressEFI() failed: %v", i, err)
+		}
+		if !bytes.Equal(input, uncompressed) {
+			t.Fatalf("%d: did not decompress to same data", i)
+		}
+	}
+}
+
+// TestEFIAgainstEDK2 checks the pure Go codec against the EDK2 Tiano codec,
+// which only differs in the position table bit width.
+func TestEFIAgainstEDK2(t *testing.T) {
+	for i, input := range testInputs() {
+		compressed, err := Compress(input)
+		if err != nil {
+			t.Fatalf("%d: Compress() failed: %v", i, err)
+		}
+		uncompressed, err := efiDecompress(compressed, pbitTiano)
+		if err != nil {
+			t.Fatalf("%d: efiDecompress() failed: %v", i, err)
+		}
+		if !bytes.Equal(input, uncompressed) {
+			t.Fatalf("%d: did not decompress EDK2 data to same data", i)
+		}
+
+		compressed, err = efiCompress(input, pbitTiano, 19)
+		if err != nil {
+			t.Fatalf("%d: efiCompress() failed: %v", i, err)
+		}
+		uncompressed, err = Decompress(compressed)
+		if err != nil {
+			t.Fatalf("%d: Decompress() failed: %v", i, err)
+		}
+		if !bytes.Equal(input, uncompressed) {
+			t.Fatalf("%d: EDK2 did not decompress to same data", i)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package efi
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DepexOpcode is an opcode of a dependency expression, as found in DEPEX
+// sections.
+type DepexOpcode uint8
+
+const (
+	DepexBefore DepexOpcode = 0x00
+	DepexAfter  DepexOpcode = 0x01
+	DepexPush   DepexOpcode = 0x02
+	DepexAnd    DepexOpcode = 0x03
+	DepexOr     DepexOpcode = 0x04
+	DepexNot    DepexOpcode = 0x05
+	DepexTrue   DepexOpcode = 0x06
+	DepexFalse  DepexOpcode = 0x07
+	DepexEnd    DepexOpcode = 0x08
+	DepexSOR    DepexOpcode = 0x09
+)
+
+func (o DepexOpcode) String() string {
+	switch o {
+	case DepexBefore:
+		return "BEFORE"
+	case DepexAfter:
+		return "AFTER"
+	case DepexPush:
+		return "PUSH"
+	case DepexAnd:
+		return "AND"
+	case DepexOr:
+		return "OR"
+	case DepexNot:
+		return "NOT"
+	case DepexTrue:
+		return "TRUE"
+	case DepexFalse:
+		return "FALSE"
+	case DepexEnd:
+		return "END"
+	case DepexSOR:
+		return "SOR"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", o)
+	}
+}
+
+// hasGUID returns whether the opcode is followed by a GUID operand.
+func (o DepexOpcode) hasGUID() bool {
+	return o == DepexBefore || o == DepexAfter || o == DepexPush
+}
+
+// DepexInstruction is a single instruction of a dependency expression. GUID
+// is only used by BEFORE, AFTER and PUSH.
+type DepexInstruction struct {
+	Opcode DepexOpcode
+	GUID   GUID
+}
+
+func (i DepexInstruction) String() string {
+	if i.Opcode.hasGUID() {
+		return fmt.Sprintf("%s %s", i.Opcode, i.GUID.Name())
+	}
+	return i.Opcode.String()
+}
+
+// Depex is a dependency expression, which controls the dispatch order of
+// drivers.
+type Depex []DepexInstruction
+
+func (d Depex) String() string {
+	parts := make([]string, len(d))
+	for i, insn := range d {
+		parts[i] = insn.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ParseDepex decodes the contents of a DEPEX section. The expression must be
+// terminated by END, and END must be the last instruction.
+func ParseDepex(data []byte) (Depex, error) {
+	var res Depex
+	for i := 0; i < len(data); {
+		insn := DepexInstruction{
+			Opcode: DepexOpcode(data[i]),
+		}
+		if insn.Opcode > DepexSOR {
+			return nil, fmt.Errorf("at 0x%x: unknown opcode 0x%02x", i, data[i])
+		}
+		i++
+		if insn.Opcode.hasGUID() {
+			if len(data)-i < 16 {
+				return nil, fmt.Errorf("at 0x%x: truncated %s", i-1, insn.Opcode)
+			}
+			copy(insn.GUID[:], data[i:i+16])
+			i += 16
+		}
+		res = append(res, insn)
+		if insn.Opcode == DepexEnd {
+			if i != len(data) {
+				return nil, fmt.Errorf("at 0x%x: data after END", i)
+			}
+			return res, nil
+		}
+	}
+	return nil, fmt.Errorf("missing END")
+}
+
+// Serialize encodes the dependency expression into the contents of a DEPEX
+// section. END is appended if not already present.
+func (d Depex) Serialize() []byte {
+	buf := bytes.NewBuffer(nil)
+	for _, insn := range d {
+		buf.WriteByte(byte(insn.Opcode))
+		if insn.Opcode.hasGUID() {
+			buf.Write(insn.GUID[:])
+		}
+	}
+	if len(d) == 0 || d[len(d)-1].Opcode != DepexEnd {
+		buf.WriteByte(byte(DepexEnd))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,43 @@
+package efi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDepex(t *testing.T) {
+	a := GUID{0x01}
+	b := GUID{0x02}
+	data := []byte{0x02}
+	data = append(data, a[:]...)
+	data = append(data, 0x02)
+	data = append(data, b[:]...)
+	data = append(data, 0x03, 0x08)
+
+	d, err := ParseDepex(data)
+	if err != nil {
+		t.Fatalf("ParseDepex: %v", err)
+	}
+	want := Depex{
+		{Opcode: DepexPush, GUID: a},
+		{Opcode: DepexPush, GUID: b},
+		{Opcode: DepexAnd},
+		{Opcode: DepexEnd},
+	}
+	if !reflect.DeepEqual(want, d) {
+		t.Errorf("parsed %v, wanted %v", d, want)
+	}
+	if got := d.Serialize(); !bytes.Equal(data, got) {
+		t.Errorf("serialized %x, wanted %x", got, data)
+	}
+	if got := (Depex{{Opcode: DepexTrue}}).Serialize(); !bytes.Equal([]byte{0x06, 0x08}, got) {
+		t.Errorf("serialized %x, wanted END to be appended", got)
+	}
+
+	for _, bad := range [][]byte{{0x02, 0x00}, {0x06}, {0x08, 0x06}, {0x42, 0x08}} {
+		if _, err := ParseDepex(bad); err == nil {
+			t.Errorf("ParseDepex(%x) succeeded, wanted error", bad)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package efi
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DiffKind describes how an element differs between two volumes.
+type DiffKind int
+
+const (
+	// DiffAdded elements are only present in the second volume.
+	DiffAdded DiffKind = iota
+	// DiffRemoved elements are only present in the first volume.
+	DiffRemoved
+	// DiffChanged elements are present in both volumes, but differ.
+	DiffChanged
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", int(k))
+	}
+}
+
+// ByteRange is a range of bytes within some data.
+type ByteRange struct {
+	Offset int
+	Length int
+}
+
+// VolumeDiff is the structured difference between two volumes, as returned by
+// Diff.
+type VolumeDiff struct {
+	// Files which differ between the two volumes.
+	Files []FileDiff
+	// CustomChanged is set if the trailing data of the volumes differs.
+	CustomChanged bool
+}
+
+// Empty returns whether the two volumes were found to be the same.
+func (d *VolumeDiff) Empty() bool {
+	return len(d.Files) == 0 && !d.CustomChanged
+}
+
+// FileDiff describes a file which differs between two volumes.
+type FileDiff struct {
+	GUID GUID
+	Kind DiffKind
+	// Type of the file, as present in the second volume if available.
+	Type FileType
+	// HeaderChanged is set for changed files whose type, attributes or
+	// state differ.
+	HeaderChanged bool
+	// Sections which differ within changed files.
+	Sections []SectionDiff
+}
+
+// SectionDiff describes a section which differs between two versions of a
+// file.
+type SectionDiff struct {
+	// Path of the section within the file, as indices into Sections and
+	// then Sub.
+	Path []int
+	Kind DiffKind
+	Type SectionType
+	// Ranges of bytes within the section data which differ, for changed
+	// leaf sections (ie. ones with Raw data).
+	Ranges []ByteRange
+	// Volume is the difference between changed nested volumes.
+	Volume *VolumeDiff
+}
+
+// Diff compares two volumes and returns the differences between them. Files
+// are matched by GUID (and order of occurence, if multiple files share a
+// GUID), and sections by their path within files.
+func Diff(a, b *Volume) (*VolumeDiff, error) {
+	res := &VolumeDiff{
+		CustomChanged: !bytes.Equal(a.Custom, b.Custom),
+	}
+
+	type key struct {
+		guid GUID
+		nth  int
+	}
+	keys := func(v *Volume) []key {
+		seen := make(map[GUID]int)
+		var res []key
+		for _, f := range v.Files {
+			res = append(res, key{f.GUID, seen[f.GUID]})
+			seen[f.GUID]++
+		}
+		return res
+	}
+	aKeys := keys(a)
+	bKeys := keys(b)
+	bByKey := make(map[key]*FirmwareFile)
+	for i, k := range bKeys {
+		bByKey[k] = b.Files[i]
+	}
+	aByKey := make(map[key]bool)
+
+	for i, k := range aKeys {
+		aByKey[k] = true
+		af := a.Files[i]
+		bf, ok := bByKey[k]
+		if !ok {
+			res.Files = append(res.Files, FileDiff{
+				GUID: af.GUID,
+				Kind: DiffRemoved,
+				Type: af.FileType,
+			})
+			continue
+		}
+		fd, err := diffFiles(af, bf)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", af.GUID, err)
+		}
+		if fd != nil {
+			res.Files = append(res.Files, *fd)
+		}
+	}
+	for i, k := range bKeys {
+		if aByKey[k] {
+			continue
+		}
+		res.Files = append(res.Files, FileDiff{
+			GUID: b.Files[i].GUID,
+			Kind: DiffAdded,
+			Type: b.Files[i].FileType,
+		})
+	}
+	return res, nil
+}
+
+// diffFiles compares two versions of a file, returning nil if they are the
+// same.
+func diffFiles(a, b *FirmwareFile) (*FileDiff, error) {
+	res := &FileDiff{
+		GUID:          b.GUID,
+		Kind:          DiffChanged,
+		Type:          b.FileType,
+		HeaderChanged: a.FileType != b.FileType || a.Attributes != b.Attributes || a.State != b.State,
+	}
+	if a.FileType == FileTypePadding && b.FileType == FileTypePadding {
+		// Padding files are expected to change size, but only differences
+		// in contents are interesting.
+		if !bytes.Equal(a.padContents, b.padContents) {
+			res.Sections = append(res.Sections, SectionDiff{
+				Kind:   DiffChanged,
+				Ranges: diffBytes(a.padContents, b.padContents),
+			})
+		}
+	} else if a.FileType == FileTypeRaw && b.FileType == FileTypeRaw {
+		if !bytes.Equal(a.Data, b.Data) {
+			res.Sections = append(res.Sections, SectionDiff{
+				Kind:   DiffChanged,
+				Ranges: diffBytes(a.Data, b.Data),
+			})
+		}
+	} else {
+		var err error
+		res.Sections, err = diffSections(nil, a.Sections, b.Sections)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !res.HeaderChanged && len(res.Sections) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+func diffSections(path []int, a, b []Section) ([]SectionDiff, error) {
+	var res []SectionDiff
+	for i := 0; i < len(a) || i < len(b); i++ {
+		p := appendPath(path, i)
+		switch {
+		case i >= len(b):
+			res = append(res, SectionDiff{Path: p, Kind: DiffRemoved, Type: a[i].Header().Type})
+			continue
+		case i >= len(a):
+			res = append(res, SectionDiff{Path: p, Kind: DiffAdded, Type: b[i].Header().Type})
+			continue
+		}
+		as, bs := a[i], b[i]
+		if as.Header().Type != bs.Header().Type {
+			res = append(res, SectionDiff{Path: p, Kind: DiffChanged, Type: bs.Header().Type})
+			continue
+		}
+
+		if avs, ok := as.(FirmwareVolumeSection); ok {
+			// Nested volumes are compared by their files, as serializing
+			// them would modify them.
+			bvs, ok := bs.(FirmwareVolumeSection)
+			if !ok {
+				return nil, fmt.Errorf("section %v: mismatched volume sections", p)
+			}
+			vd, err := Diff(avs.Volume(), bvs.Volume())
+			if err != nil {
+				return nil, fmt.Errorf("section %v: %w", p, err)
+			}
+			if !vd.Empty() {
+				res = append(res, SectionDiff{Path: p, Kind: DiffChanged, Type: bs.Header().Type, Volume: vd})
+			}
+			continue
+		}
+
+		ad, err := sectionData(as)
+		if err != nil {
+			return nil, err
+		}
+		bd, err := sectionData(bs)
+		if err != nil {
+			return nil, err
+		}
+		if ad != nil || bd != nil {
+			if !bytes.Equal(ad, bd) {
+				res = append(res, SectionDiff{
+					Path:   p,
+					Kind:   DiffChanged,
+					Type:   bs.Header().Type,
+					Ranges: diffBytes(ad, bd),
+				})
+			}
+			continue
+		}
+
+		sub, err := diffSections(p, as.Sub(), bs.Sub())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sub...)
+	}
+	return res, nil
+}
+
+// sectionData returns the data of a section that should be compared directly,
+// or nil if the section's subsections should be compared instead.
+func sectionData(s Section) ([]byte, error) {
+	if len(s.Sub()) != 0 {
+		return nil, nil
+	}
+	if raw := s.Raw(); raw != nil {
+		return raw, nil
+	}
+	return s.Serialize()
+}
+
+// diffBytes returns the ranges of bytes which differ between a and b. If their
+// lengths differ, the tail of the longer one is returned as a single range.
+func diffBytes(a, b []byte) []ByteRange {
+	var res []ByteRange
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	start := -1
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start != -1 {
+			res = append(res, ByteRange{Offset: start, Length: i - start})
+			start = -1
+		}
+	}
+	if start != -1 {
+		res = append(res, ByteRange{Offset: start, Length: n - start})
+	}
+	if len(a) != len(b) {
+		longest := len(a)
+		if len(b) > longest {
+			longest = len(b)
+		}
+		res = append(res, ByteRange{Offset: n, Length: longest - n})
+	}
+	return res
+}
@@ -0,0 +1,126 @@
+package efi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	mkVolume := func(payload string, extra bool) *Volume {
+		v := &Volume{
+			Files: []*FirmwareFile{
+				{
+					FirmwareFileHeader: FirmwareFileHeader{GUID: guidLZMA, FileType: FileTypeDriver},
+					Sections: []Section{
+						&leafSection{
+							commonSectionHeader: commonSectionHeader{Type: SectionTypeRaw},
+							data:                []byte(payload),
+						},
+					},
+				},
+			},
+		}
+		if extra {
+			v.Files = append(v.Files, &FirmwareFile{
+				FirmwareFileHeader: FirmwareFileHeader{GUID: guidCRC32, FileType: FileTypeApplication},
+			})
+		}
+		return v
+	}
+
+	diff, err := Diff(mkVolume("hello, world", true), mkVolume("hellO, World!", false))
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	want := []FileDiff{
+		{
+			GUID: guidLZMA,
+			Kind: DiffChanged,
+			Type: FileTypeDriver,
+			Sections: []SectionDiff{
+				{
+					Path: []int{0},
+					Kind: DiffChanged,
+					Type: SectionTypeRaw,
+					Ranges: []ByteRange{
+						{Offset: 4, Length: 1},
+						{Offset: 7, Length: 1},
+						{Offset: 12, Length: 1},
+					},
+				},
+			},
+		},
+		{
+			GUID: guidCRC32,
+			Kind: DiffRemoved,
+			Type: FileTypeApplication,
+		},
+	}
+	if !reflect.DeepEqual(want, diff.Files) {
+		t.Errorf("got diff %+v, wanted %+v", diff.Files, want)
+	}
+}
+
+func TestDiffNested(t *testing.T) {
+	mkVolume := func(payload string) (*Volume, *Volume) {
+		inner, err := NewVolume(0x400)
+		if err != nil {
+			t.Fatalf("NewVolume: %v", err)
+		}
+		if err := inner.InsertFile(0, NewRawFile(GUID{0x01}, []byte(payload))); err != nil {
+			t.Fatalf("InsertFile: %v", err)
+		}
+		outer := &Volume{
+			Files: []*FirmwareFile{
+				NewFreeformFile(GUID{0x02}, NewFirmwareVolumeSection(inner)),
+			},
+		}
+		return outer, inner
+	}
+	a, aInner := mkVolume("hello")
+	b, bInner := mkVolume("hellO")
+	aFiles, bFiles := len(aInner.Files), len(bInner.Files)
+	aHeader, bHeader := aInner.FirmwareVolumeHeader, bInner.FirmwareVolumeHeader
+
+	diff, err := Diff(a, b)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	want := []FileDiff{
+		{
+			GUID: GUID{0x02},
+			Kind: DiffChanged,
+			Type: FileTypeFreeform,
+			Sections: []SectionDiff{
+				{
+					Path: []int{0},
+					Kind: DiffChanged,
+					Type: SectionTypeFirmwareVolumeImage,
+					Volume: &VolumeDiff{
+						Files: []FileDiff{
+							{
+								GUID: GUID{0x01},
+								Kind: DiffChanged,
+								Type: FileTypeRaw,
+								Sections: []SectionDiff{
+									{Kind: DiffChanged, Ranges: []ByteRange{{Offset: 4, Length: 1}}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(want, diff.Files) {
+		t.Errorf("got diff %+v, wanted %+v", diff.Files, want)
+	}
+
+	// Diffing must not modify the volumes, eg. by serializing them.
+	if len(aInner.Files) != aFiles || len(bInner.Files) != bFiles {
+		t.Errorf("Diff changed the files of nested volumes")
+	}
+	if aInner.FirmwareVolumeHeader != aHeader || bInner.FirmwareVolumeHeader != bHeader {
+		t.Errorf("Diff changed the headers of nested volumes")
+	}
+}
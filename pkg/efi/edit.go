@@ -0,0 +1,98 @@
+package efi
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrFileNotFound is returned when a file with a given GUID is not
+	// present in a volume.
+	ErrFileNotFound = errors.New("file not found")
+	// ErrVolumeFull is returned when a modification would not fit in a
+	// volume without growing it beyond the size it was read with.
+	ErrVolumeFull = errors.New("volume full")
+)
+
+// fileIndex returns the index of the first file with a given GUID.
+func (v *Volume) fileIndex(guid GUID) (int, error) {
+	for i, f := range v.Files {
+		if f.GUID == guid {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrFileNotFound, guid)
+}
+
+// checkFits returns an error wrapping ErrVolumeFull if the volume cannot be
+// serialized without growing beyond the size it was read with. Volumes which
+// have not been read from an image can always grow.
+func (v *Volume) checkFits() error {
+	if len(v.blockmap) == 0 {
+		return nil
+	}
+	usage, err := v.SpaceUsage()
+	if err != nil {
+		return err
+	}
+	if have := int(blockmapSize(v.blockmap)); usage.Total > have {
+		return fmt.Errorf("%w: needs 0x%x bytes, has 0x%x", ErrVolumeFull, usage.Total, have)
+	}
+	return nil
+}
+
+// ReplaceFile replaces the first file with the given GUID by file. The
+// padding file of the volume is adjusted on Serialize so that the size of the
+// volume stays the same. If the new file does not fit, an error wrapping
+// ErrVolumeFull is returned and the volume is left unmodified.
+func (v *Volume) ReplaceFile(guid GUID, file *FirmwareFile) error {
+	i, err := v.fileIndex(guid)
+	if err != nil {
+		return err
+	}
+	old := v.Files[i]
+	v.Files[i] = file
+	if err := v.checkFits(); err != nil {
+		v.Files[i] = old
+		return err
+	}
+	return nil
+}
+
+// InsertFile inserts file into the volume at the given index into Files. The
+// padding file of the volume shrinks on Serialize to make room for it, and all
+// files stay 8-byte aligned. If the new file does not fit, an error wrapping
+// ErrVolumeFull is returned and the volume is left unmodified.
+func (v *Volume) InsertFile(index int, file *FirmwareFile) error {
+	if index < 0 || index > len(v.Files) {
+		return fmt.Errorf("index %d out of range [0, %d]", index, len(v.Files))
+	}
+	if index == 0 && len(v.Files) > 0 && v.Files[0].extHeader {
+		return errors.New("cannot insert before extended header")
+	}
+	files := v.Files
+	v.Files = make([]*FirmwareFile, 0, len(files)+1)
+	v.Files = append(v.Files, files[:index]...)
+	v.Files = append(v.Files, file)
+	v.Files = append(v.Files, files[index:]...)
+	if err := v.checkFits(); err != nil {
+		v.Files = files
+		return err
+	}
+	return nil
+}
+
+// DeleteFile removes the first file with the given GUID from the volume. The
+// space it occupied is reclaimed into the padding file on Serialize, so that
+// the size of the volume stays the same.
+func (v *Volume) DeleteFile(guid GUID) error {
+	i, err := v.fileIndex(guid)
+	if err != nil {
+		return err
+	}
+	if v.Files[i].extHeader {
+		return errors.New("cannot delete extended header")
+	}
+	v.Files = append(v.Files[:i:i], v.Files[i+1:]...)
+	return nil
+}
@@ -0,0 +1,156 @@
+package efi
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testEditVolume returns a volume with a single raw file of the given size,
+// as read from a serialized image.
+func testEditVolume(t *testing.T, size int) *Volume {
+	t.Helper()
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			testRawFile(GUID{0x01}, size),
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v, err = ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	return v
+}
+
+func testRawFile(guid GUID, size int) *FirmwareFile {
+	return &FirmwareFile{
+		FirmwareFileHeader: FirmwareFileHeader{
+			GUID:       guid,
+			FileType:   FileTypeDriver,
+			Attributes: FileAttributeChecksum,
+			State:      0xf8,
+		},
+		Sections: []Section{
+			&leafSection{
+				commonSectionHeader: commonSectionHeader{Type: SectionTypeRaw},
+				data:                bytes.Repeat([]byte{0x42}, size),
+			},
+		},
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	v := testEditVolume(t, 0x100)
+	usage, err := v.SpaceUsage()
+	if err != nil {
+		t.Fatalf("SpaceUsage: %v", err)
+	}
+
+	if err := v.ReplaceFile(GUID{0x02}, testRawFile(GUID{0x02}, 0x10)); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("replacing missing file: wanted ErrFileNotFound, got %v", err)
+	}
+	if err := v.ReplaceFile(GUID{0x01}, testRawFile(GUID{0x01}, usage.Total)); !errors.Is(err, ErrVolumeFull) {
+		t.Errorf("replacing with huge file: wanted ErrVolumeFull, got %v", err)
+	}
+	if err := v.ReplaceFile(GUID{0x01}, testRawFile(GUID{0x03}, 0x80)); err != nil {
+		t.Fatalf("ReplaceFile: %v", err)
+	}
+
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := usage.Total, len(data); want != got {
+		t.Errorf("wanted volume of %d bytes, got %d", want, got)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if want, got := (GUID{0x03}), v2.Files[0].GUID; want != got {
+		t.Errorf("wanted first file %s, got %s", want, got)
+	}
+}
+
+func TestInsertFile(t *testing.T) {
+	v := testEditVolume(t, 0x100)
+	usage, err := v.SpaceUsage()
+	if err != nil {
+		t.Fatalf("SpaceUsage: %v", err)
+	}
+
+	if err := v.InsertFile(0, testRawFile(GUID{0x02}, usage.Free)); !errors.Is(err, ErrVolumeFull) {
+		t.Errorf("inserting huge file: wanted ErrVolumeFull, got %v", err)
+	}
+	if want, got := 2, len(v.Files); want != got {
+		t.Fatalf("wanted %d files after failed insert, got %d", want, got)
+	}
+	if err := v.InsertFile(1, testRawFile(GUID{0x02}, 0x21)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := usage.Total, len(data); want != got {
+		t.Errorf("wanted volume of %d bytes, got %d", want, got)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if want, got := 3, len(v2.Files); want != got {
+		t.Fatalf("wanted %d files, got %d", want, got)
+	}
+	for i, f := range v2.Files {
+		if f.ReadOffset%8 != 0 {
+			t.Errorf("file %d at unaligned offset %x", i, f.ReadOffset)
+		}
+	}
+	if want, got := (GUID{0x02}), v2.Files[1].GUID; want != got {
+		t.Errorf("wanted second file %s, got %s", want, got)
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	v := testEditVolume(t, 0x100)
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	if err := v.DeleteFile(GUID{0x02}); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("deleting missing file: wanted ErrFileNotFound, got %v", err)
+	}
+	if err := v.DeleteFile(GUID{0x01}); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+
+	data2, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := len(data), len(data2); want != got {
+		t.Errorf("wanted volume of %d bytes, got %d", want, got)
+	}
+	v2, err := ReadVolume(NewNestedReader(data2), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if want, got := 1, len(v2.Files); want != got {
+		t.Fatalf("wanted %d files, got %d", want, got)
+	}
+	if want, got := FileTypePadding, v2.Files[0].FileType; want != got {
+		t.Errorf("wanted remaining file to be %s, got %s", want, got)
+	}
+}
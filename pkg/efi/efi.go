@@ -3,27 +3,28 @@
 //
 // This library is similar in functionality and scope to UEFITool or
 // uefi-firmware-parser. However, some differences remain:
-// 1. This implements the small subset of EFI FV as used by Apple devices, and
-//    is only tested against them. This is in contrast to UEFITool and
-//    uefi-firmware-parser which attempt to parse all possible images out
-//    there.
-// 2. This implementation is in pure Go, with Tiano compression routines
-//    implemented via WebAssembly (emscripten-compiled C from EDK2). This is in
-//    contrast to uefi-firmware-parser and UEFITool which link against a binary
-//    build of the functionality from EDK2.
-// 3. This implementation focuses on bit-perfect reconstruction of images. A
-//    back-to-back Read-to-Serialize of any image should result in exactly the
-//    same data outputted.
-//
+//  1. This implements the small subset of EFI FV as used by Apple devices, and
+//     is only tested against them. This is in contrast to UEFITool and
+//     uefi-firmware-parser which attempt to parse all possible images out
+//     there.
+//  2. This implementation is in pure Go, with Tiano compression routines
+//     implemented via WebAssembly (emscripten-compiled C from EDK2). This is in
+//     contrast to uefi-firmware-parser and UEFITool which link against a binary
+//     build of the functionality from EDK2. LZMA compressed sections are
+//     handled by a small pure Go LZMA implementation.
+//  3. This implementation focuses on bit-perfect reconstruction of images. A
+//     back-to-back Read-to-Serialize of any image should result in exactly the
+//     same data outputted.
 package efi
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"strings"
+
+	"github.com/freemyipod/wInd3x/pkg/efi/guids"
 )
 
 // GUID type compatible with EFI.
@@ -38,24 +39,35 @@ func (g GUID) String() string {
 	return fmt.Sprintf("%s-%s-%s-%s-%s", hex.EncodeToString(a), hex.EncodeToString(b), hex.EncodeToString(c), hex.EncodeToString(d), hex.EncodeToString(e))
 }
 
-func MustParseGUID(s string) GUID {
-	if len(s) != 36 {
-		panic("wrong guid length")
+// Name returns a human readable name for this GUID if it is well-known, or
+// its text representation otherwise.
+func (g GUID) Name() string {
+	s := g.String()
+	if name := guids.Name(s); name != "" {
+		return name
 	}
-	parts := strings.Split(s, "-")
-	if len(parts) != 5 {
-		panic("invalid format")
+	return s
+}
+
+// ParseGUID parses a GUID in its canonical text form, eg.
+// "8c8ce578-8a3d-4f1c-9935-896185c32dd3". Upper case digits and surrounding
+// braces are accepted.
+func ParseGUID(s string) (GUID, error) {
+	str := strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	parts := strings.Split(str, "-")
+	if len(str) != 36 || len(parts) != 5 {
+		return GUID{}, fmt.Errorf("invalid GUID %q", s)
 	}
 
 	lengths := []int{8, 4, 4, 4, 12}
 	vs := make([][]byte, 5)
 	for i, l := range lengths {
 		if len(parts[i]) != l {
-			panic("invalid format")
+			return GUID{}, fmt.Errorf("invalid GUID %q", s)
 		}
 		v, err := hex.DecodeString(parts[i])
 		if err != nil {
-			panic("inavlid format")
+			return GUID{}, fmt.Errorf("invalid GUID %q", s)
 		}
 		vs[i] = v
 	}
@@ -72,34 +84,86 @@ func MustParseGUID(s string) GUID {
 		c[1], c[0],
 		d[0], d[1],
 		e[0], e[1], e[2], e[3], e[4], e[5],
+	}, nil
+}
+
+// MustGUID is like ParseGUID, but panics if s is not a valid GUID. It's meant
+// for well-known GUIDs defined in code.
+func MustGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
 	}
+	return g
+}
+
+// MustParseGUID is an alias of MustGUID.
+func MustParseGUID(s string) GUID {
+	return MustGUID(s)
+}
+
+// MarshalText implements encoding.TextMarshaler, encoding a GUID in its
+// canonical text form.
+func (g GUID) MarshalText() ([]byte, error) {
+	return []byte(g.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting anything
+// ParseGUID does.
+func (g *GUID) UnmarshalText(text []byte) error {
+	res, err := ParseGUID(string(text))
+	if err != nil {
+		return err
+	}
+	*g = res
+	return nil
 }
 
 // NestedReader is a io.Reader which implements carving out a subelement of
 // itself into another io.Reader. It also allows keeping track of the position
 // of a reader within the original backing data.
+//
+// The backing data is either a byte slice (NewNestedReader), or an io.ReaderAt
+// (NewNestedReaderAt) for images which should not be loaded into memory as a
+// whole. Carving out subelements never copies data.
 type NestedReader struct {
 	parent *NestedReader
 	data   []byte
-	pos    int
-	start  int
+	// ra and size are used instead of data for io.ReaderAt backed readers.
+	// Then, base is the offset of this reader within ra.
+	ra    io.ReaderAt
+	base  int64
+	size  int
+	pos   int
+	start int
 }
 
 func (r *NestedReader) Read(out []byte) (int, error) {
-	left := len(r.data) - r.pos
+	left := r.Len()
 	if left <= 0 {
 		return 0, io.EOF
 	}
 	if len(out) < left {
 		left = len(out)
 	}
+	if r.ra != nil {
+		n, err := r.ra.ReadAt(out[:left], r.base+int64(r.pos))
+		r.pos += n
+		if n == left {
+			return n, nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
 	copy(out, r.data[r.pos:r.pos+left])
 	r.pos += left
 	return left, nil
 }
 
 func (r *NestedReader) Advance(count int) {
-	left := len(r.data) - r.pos
+	left := r.Len()
 	if left < count {
 		count = left
 	}
@@ -111,6 +175,18 @@ func (r *NestedReader) TellGlobal() int {
 }
 
 func (r *NestedReader) Sub(start, length int) *NestedReader {
+	if r.ra != nil {
+		if start < 0 || length < 0 || r.pos+start+length > r.size {
+			panic(fmt.Sprintf("sub range [%d:%d] out of bounds with length %d", r.pos+start, r.pos+start+length, r.size))
+		}
+		return &NestedReader{
+			parent: r,
+			ra:     r.ra,
+			base:   r.base + int64(r.pos+start),
+			size:   length,
+			start:  r.start + r.pos + start,
+		}
+	}
 	return &NestedReader{
 		parent: r,
 		data:   r.data[r.pos+start : r.pos+start+length],
@@ -120,6 +196,9 @@ func (r *NestedReader) Sub(start, length int) *NestedReader {
 }
 
 func (r *NestedReader) Len() int {
+	if r.ra != nil {
+		return r.size - r.pos
+	}
 	return len(r.data) - r.pos
 }
 
@@ -132,6 +211,15 @@ func NewNestedReader(underlying []byte) *NestedReader {
 	}
 }
 
+// NewNestedReaderAt returns a NestedReader backed by size bytes of an
+// io.ReaderAt, eg. an os.File. Data is only read from it as needed.
+func NewNestedReaderAt(underlying io.ReaderAt, size int64) *NestedReader {
+	return &NestedReader{
+		ra:   underlying,
+		size: int(size),
+	}
+}
+
 // Uint24 as per EFI.
 type Uint24 [3]uint8
 
@@ -146,31 +234,19 @@ func (s Uint24) Uint32() uint32 {
 	return (uint32(s[2]) << 16) | (uint32(s[1]) << 8) | uint32(s[0])
 }
 
-// checksum16 is the 16-bit checksum as used in some EFI headers. It calculates
-// the value necessary to make the given data sum to 0 when interpreted as an
-// array of 16-bit integers.
-func checksum16(data []byte) uint16 {
-	if len(data)%2 != 0 {
-		panic("cannot checksum non-16-bit-chunked data")
-	}
-	checkNums := make([]uint16, len(data)/2)
-	binary.Read(bytes.NewBuffer(data), binary.LittleEndian, checkNums)
-	sum := uint16(0)
-	for _, n := range checkNums {
-		sum += n
-	}
-	return (sum ^ 0xffff) + 1
-}
-
-// checksum8 is the 16-bit checksum as used in some EFI headers. It calculates
-// the value necessary to make the given data sum to 0 when interpreted as an
-// array of 8-bit integers.
-func checksum8(data []byte) uint8 {
-	checkNums := make([]uint8, len(data))
-	binary.Read(bytes.NewBuffer(data), binary.LittleEndian, checkNums)
-	sum := uint8(0)
-	for _, n := range checkNums {
-		sum += n
+// writeFill writes count bytes of value b to w, without allocating all of
+// them at once.
+func writeFill(w io.Writer, b byte, count int) error {
+	chunk := bytes.Repeat([]byte{b}, 4096)
+	for count > 0 {
+		n := count
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		if _, err := w.Write(chunk[:n]); err != nil {
+			return err
+		}
+		count -= n
 	}
-	return (sum ^ 0xff) + 1
+	return nil
 }
@@ -0,0 +1,57 @@
+package efi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseGUID(t *testing.T) {
+	want := guidFFS2
+	for _, s := range []string{
+		"8c8ce578-8a3d-4f1c-9935-896185c32dd3",
+		"8C8CE578-8A3D-4F1C-9935-896185C32DD3",
+		"{8c8ce578-8a3d-4f1c-9935-896185c32dd3}",
+	} {
+		got, err := ParseGUID(s)
+		if err != nil {
+			t.Errorf("ParseGUID(%q): %v", s, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseGUID(%q): got %s, wanted %s", s, got, want)
+		}
+	}
+	for _, s := range []string{
+		"",
+		"8c8ce578-8a3d-4f1c-9935-896185c32dd",
+		"8c8ce5788-a3d-4f1c-9935-896185c32dd3",
+		"8c8ce578-8a3d-4f1c-9935-896185c32ddx",
+	} {
+		if _, err := ParseGUID(s); err == nil {
+			t.Errorf("ParseGUID(%q) succeeded", s)
+		}
+	}
+}
+
+func TestGUIDJSON(t *testing.T) {
+	type manifest struct {
+		GUID GUID `json:"guid"`
+	}
+	data, err := json.Marshal(manifest{GUID: guidFFS2})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if want, got := `{"guid":"8c8ce578-8a3d-4f1c-9935-896185c32dd3"}`, string(data); want != got {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if m.GUID != guidFFS2 {
+		t.Errorf("unmarshaled %s, wanted %s", m.GUID, guidFFS2)
+	}
+	if err := json.Unmarshal([]byte(`{"guid":"nope"}`), &m); err == nil {
+		t.Errorf("unmarshaling invalid GUID succeeded")
+	}
+}
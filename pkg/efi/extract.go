@@ -0,0 +1,212 @@
+package efi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	manifestName      = "manifest.json"
+	manifestImageName = "volume.bin"
+)
+
+// Manifest describes a volume extracted to a directory by Extract.
+type Manifest struct {
+	// Image is the name of the file within the directory which contains the
+	// original volume image. It is used as a base when rebuilding.
+	Image string `json:"image"`
+	// Sections are all extracted leaf sections.
+	Sections []ManifestSection `json:"sections"`
+}
+
+// ManifestSection is a leaf section extracted to a file.
+type ManifestSection struct {
+	// Path of the section, as passed to a WalkFunc by Volume.Walk.
+	Path []int `json:"path"`
+	// GUID of the file containing the section.
+	GUID string `json:"guid"`
+	// Name of the file containing the section, if well-known.
+	Name string `json:"name,omitempty"`
+	// Type of the section.
+	Type string `json:"type"`
+	// Data is the path of the file within the directory which contains the
+	// section's data, with slashes as separators.
+	Data string `json:"data"`
+}
+
+// localPath returns path (given with slashes as separators) as a path
+// relative to and within a directory, or an error if it would escape it, like
+// filepath.IsLocal (which is not available in all supported Go versions).
+func localPath(path string) (string, error) {
+	p := filepath.FromSlash(path)
+	if p == "" || filepath.IsAbs(p) || filepath.VolumeName(p) != "" {
+		return "", fmt.Errorf("path %q is not relative", path)
+	}
+	clean := filepath.Clean(p)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside of the directory", path)
+	}
+	return clean, nil
+}
+
+// safeName returns s with path separators replaced, so that it can be used as
+// a single path component.
+func safeName(s string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+}
+
+// pathString returns a stable, sortable text representation of a walk path.
+func pathString(path []int) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		parts[i] = fmt.Sprintf("%03d", p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Extract writes the data of all leaf sections (ie. ones with Raw data) of a
+// volume into separate files in dir, alongside the original image and a
+// manifest. The files can then be modified and reassembled into an image with
+// Rebuild.
+//
+// The files are laid out as a directory tree mirroring the volume: every file
+// and every section with subsections (including nested volumes) is a
+// directory named after its index and its name, GUID or type, and leaf
+// sections are files within them, eg. 003_DxeCore/000_compression/001_pe32.bin.
+//
+// The given image must be the data from which the volume was read.
+func Extract(v *Volume, image []byte, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+	manifest := Manifest{
+		Image: manifestImageName,
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifest.Image), image, 0644); err != nil {
+		return fmt.Errorf("could not write image: %w", err)
+	}
+
+	// Directories of files and sections with subsections by their path.
+	dirs := make(map[string]string)
+	err := v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		parent := dirs[pathString(path[:len(path)-1])]
+		index := path[len(path)-1]
+		name := guidName(file.GUID)
+		if section == nil {
+			component := file.GUID.String()
+			if name != "" {
+				component = name
+			}
+			dirs[pathString(path)] = filepath.Join(parent, fmt.Sprintf("%03d_%s", index, safeName(component)))
+			return nil
+		}
+		raw := section.Raw()
+		if raw == nil {
+			dirs[pathString(path)] = filepath.Join(parent, fmt.Sprintf("%03d_%s", index, section.Header().Type))
+			return nil
+		}
+		fname := filepath.Join(parent, fmt.Sprintf("%03d_%s.bin", index, section.Header().Type))
+		if err := os.MkdirAll(filepath.Join(dir, parent), 0755); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fname), raw, 0644); err != nil {
+			return fmt.Errorf("could not write section %s: %w", pathString(path), err)
+		}
+		manifest.Sections = append(manifest.Sections, ManifestSection{
+			Path: path,
+			GUID: file.GUID.String(),
+			Name: name,
+			Type: section.Header().Type.String(),
+			Data: filepath.ToSlash(fname),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestName), data, 0644); err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+	return nil
+}
+
+// Rebuild reassembles a volume image from a directory created by Extract,
+// replacing the data of all leaf sections with the contents of their
+// extracted files. All files named by the manifest must be within dir.
+func Rebuild(dir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("could not parse manifest: %w", err)
+	}
+	imagePath, err := localPath(manifest.Image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image in manifest: %w", err)
+	}
+	byPath := make(map[string]*ManifestSection)
+	dataPaths := make(map[string]string)
+	for i, s := range manifest.Sections {
+		p, err := localPath(s.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data of section %s in manifest: %w", pathString(s.Path), err)
+		}
+		byPath[pathString(s.Path)] = &manifest.Sections[i]
+		dataPaths[pathString(s.Path)] = p
+	}
+
+	image, err := os.ReadFile(filepath.Join(dir, imagePath))
+	if err != nil {
+		return nil, fmt.Errorf("could not read image: %w", err)
+	}
+	v, err := ReadVolume(NewNestedReader(image), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse image: %w", err)
+	}
+
+	found := 0
+	err = v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		if section == nil {
+			return nil
+		}
+		ms, ok := byPath[pathString(path)]
+		if !ok {
+			return nil
+		}
+		if ms.Type != section.Header().Type.String() {
+			return fmt.Errorf("section %s: manifest says type %s, image has %s", pathString(path), ms.Type, section.Header().Type)
+		}
+		found++
+		data, err := os.ReadFile(filepath.Join(dir, dataPaths[pathString(path)]))
+		if err != nil {
+			return fmt.Errorf("could not read section %s: %w", pathString(path), err)
+		}
+		if !bytes.Equal(data, section.Raw()) {
+			section.SetRaw(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found != len(manifest.Sections) {
+		return nil, fmt.Errorf("manifest has %d sections, only %d found in image", len(manifest.Sections), found)
+	}
+
+	res, err := v.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("could not serialize volume: %w", err)
+	}
+	return res, nil
+}
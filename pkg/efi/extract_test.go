@@ -0,0 +1,98 @@
+package efi
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractRebuild(t *testing.T) {
+	pe32, err := NewLeafSection(SectionTypePE32, []byte("MZ not really"))
+	if err != nil {
+		t.Fatalf("NewLeafSection: %v", err)
+	}
+	compressed, err := NewCompressedSection(CompressionTypeNone, pe32)
+	if err != nil {
+		t.Fatalf("NewCompressedSection: %v", err)
+	}
+	v, err := NewVolume(0x800)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	if err := v.InsertFile(0, NewDriverFile(GUID{0x01}, compressed)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	image, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v, err = ReadVolume(NewNestedReader(image), nil)
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := Extract(v, image, dir); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	pe32Path := filepath.Join(dir, "000_"+GUID{0x01}.String(), "000_compression", "000_pe32.bin")
+	data, err := os.ReadFile(pe32Path)
+	if err != nil {
+		t.Fatalf("reading extracted section: %v", err)
+	}
+	if want := []byte("MZ not really"); !bytes.Equal(want, data) {
+		t.Errorf("extracted section is %q, wanted %q", data, want)
+	}
+
+	// Unmodified, the image is rebuilt as it was.
+	rebuilt, err := Rebuild(dir)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	if !bytes.Equal(image, rebuilt) {
+		t.Errorf("unmodified rebuild differs from image")
+	}
+
+	if err := os.WriteFile(pe32Path, []byte("MZ modified"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	rebuilt, err = Rebuild(dir)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	v, err = ReadVolume(NewNestedReader(rebuilt), nil)
+	if err != nil {
+		t.Fatalf("ReadVolume of rebuilt image: %v", err)
+	}
+	el, err := v.Lookup("0/section:pe32")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if want, got := []byte("MZ modified"), el.Section.Raw(); !bytes.Equal(want, got) {
+		t.Errorf("rebuilt section is %q, wanted %q", got, want)
+	}
+}
+
+func TestRebuildOutsidePaths(t *testing.T) {
+	for _, manifest := range []Manifest{
+		{Image: "../volume.bin"},
+		{Image: "/etc/passwd"},
+		{Image: "volume.bin", Sections: []ManifestSection{{Path: []int{0, 0}, Data: "a/../../secret"}}},
+		{Image: "volume.bin", Sections: []ManifestSection{{Path: []int{0, 0}, Data: "/etc/passwd"}}},
+	} {
+		dir := t.TempDir()
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, manifestName), data, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := Rebuild(dir); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("Rebuild with manifest %+v: wanted invalid path error, got %v", manifest, err)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 
+	"github.com/freemyipod/wInd3x/pkg/efi/checksum"
 	"github.com/golang/glog"
 )
 
@@ -13,28 +15,50 @@ type FirmwareFileHeader struct {
 	GUID GUID
 	// ChecksumHeader is recalculated when Serialize is called.
 	ChecksumHeader uint8
-	// ChecksumData is recalculated when Serialize is called.
+	// ChecksumData is recalculated when Serialize is called. If the file does
+	// not have FileAttributeChecksum set, it is set to the fixed value
+	// required by the volume's file system.
 	ChecksumData uint8
 	FileType     FileType
-	Attributes   uint8
+	Attributes   FileAttributes
 	// Size is recalculated when Serialize is called.
 	Size  Uint24
 	State uint8
 }
 
+// fixedChecksum returns the ChecksumData value of files without
+// FileAttributeChecksum within a volume using the given file system. This was
+// 0x5a in the Framework specification (FFSv1), and is 0xaa since PI (FFSv2).
+func fixedChecksum(fs GUID) uint8 {
+	if fs == guidFFS1 {
+		return 0x5a
+	}
+	return 0xaa
+}
+
 type FileType uint8
 
 const (
+	// FileTypeRaw files contain raw data instead of sections.
+	FileTypeRaw          FileType = 1
+	FileTypeFreeform     FileType = 2
 	FileTypeSecurityCore FileType = 3
 	FileTypePEICore      FileType = 4
 	FileTypeDXECore      FileType = 5
 	FileTypeDriver       FileType = 7
 	FileTypeApplication  FileType = 9
-	FileTypePadding      FileType = 240
+	// FileTypeFirmwareVolumeImage files contain a nested Volume within a
+	// SectionTypeFirmwareVolumeImage section.
+	FileTypeFirmwareVolumeImage FileType = 11
+	FileTypePadding             FileType = 240
 )
 
 func (f FileType) String() string {
 	switch f {
+	case FileTypeRaw:
+		return "raw"
+	case FileTypeFreeform:
+		return "freeform"
 	case FileTypeSecurityCore:
 		return "security core"
 	case FileTypePEICore:
@@ -45,6 +69,8 @@ func (f FileType) String() string {
 		return "driver"
 	case FileTypeApplication:
 		return "application"
+	case FileTypeFirmwareVolumeImage:
+		return "firmware volume image"
 	case FileTypePadding:
 		return "padding"
 	default:
@@ -52,84 +78,361 @@ func (f FileType) String() string {
 	}
 }
 
+// EFI_FILE_STATE bits, as returned by FirmwareFile.StateBits.
+const (
+	FileStateHeaderConstruction uint8 = 0x01
+	FileStateHeaderValid        uint8 = 0x02
+	FileStateDataValid          uint8 = 0x04
+	FileStateMarkedForUpdate    uint8 = 0x08
+	FileStateDeleted            uint8 = 0x10
+	FileStateHeaderInvalid      uint8 = 0x20
+)
+
 // FirmwareFile represents an EFI Firmware File within a Firmware Volume.
 type FirmwareFile struct {
 	FirmwareFileHeader
 	Sections []Section
+	// Data is the contents of FileTypeRaw files, which do not contain
+	// sections.
+	Data []byte
+	// ExtendedSize is the size of the file if it is a large file (ie. has
+	// FileAttributeLargeFile set). It is recalculated when Serialize is
+	// called.
+	ExtendedSize uint64
 	// ReadOffset is the offset within the volume at which the file has been
 	// encountered.
 	ReadOffset int
+
+	// original is the data of the file as read, including any alignment
+	// padding following it, if parsed with ParseOptions.PreserveLayout.
+	original []byte
+	// readSize is the total size of the file as read, or zero if it has not
+	// been read from an image.
+	readSize uint64
+	// extHeader is set if this is the padding file containing the volume's
+	// extended header.
+	extHeader bool
+	// alignPad is set if this is a padding file which only serves to align
+	// the data of the following file. Such files are regenerated as needed
+	// on Serialize.
+	alignPad bool
+	// fileSystem is the file system GUID of the volume containing this file,
+	// which determines the fixed value of ChecksumData. It is set when the
+	// file is read, and when the containing volume is serialized.
+	fileSystem GUID
+	// zeroErased is set if the file is within a volume without
+	// VolumeErasePolarity, ie. where erased bytes are 0x00. Its State bits are
+	// then stored as is, instead of inverted. It is set when the file is read,
+	// and when the containing volume is serialized.
+	zeroErased bool
+	// padContents is the data emitted at the beginning of a padding file, eg.
+	// a volume extended header. The rest of the padding file is erased.
+	padContents []byte
+}
+
+// large returns whether this file uses an FFSv3 extended header.
+func (f *FirmwareFile) large() bool {
+	return f.Attributes.LargeFile()
+}
+
+// headerSize returns the size of this file's header, including the extended
+// size if applicable.
+func (f *FirmwareFile) headerSize() uint64 {
+	if f.large() {
+		return 0x20
+	}
+	return 0x18
+}
+
+// TotalSize returns the size of this file, including its header, as last read
+// or serialized.
+func (f *FirmwareFile) TotalSize() uint64 {
+	if f.large() {
+		return f.ExtendedSize
+	}
+	return uint64(f.Size.Uint32())
+}
+
+// erasedByte returns the value of an erased byte within the volume containing
+// this file.
+func (f *FirmwareFile) erasedByte() byte {
+	if f.zeroErased {
+		return 0x00
+	}
+	return 0xff
+}
+
+// setErasePolarity sets the value of an erased byte within the volume
+// containing this file, converting its State bits if needed. State bits are
+// set by programming erased bits, so they are inverted in volumes erased to
+// 0xff.
+func (f *FirmwareFile) setErasePolarity(erase byte) {
+	zeroErased := erase == 0x00
+	if zeroErased != f.zeroErased {
+		f.State = ^f.State
+		f.zeroErased = zeroErased
+	}
+}
+
+// StateBits returns the FileState* bits set in the State of this file,
+// regardless of the erase polarity of the volume containing it.
+func (f *FirmwareFile) StateBits() uint8 {
+	if f.zeroErased {
+		return f.State
+	}
+	return ^f.State
+}
+
+// erased returns whether data consists only of erased bytes.
+func erased(data []byte, erase byte) bool {
+	for _, b := range data {
+		if b != erase {
+			return false
+		}
+	}
+	return true
+}
+
+// setTotalSize sets the size of this file, including its header. This is only
+// useful for padding files, whose contents are generated from their size.
+func (f *FirmwareFile) setTotalSize(size uint64) {
+	if size > 0xffffff {
+		f.Attributes |= FileAttributeLargeFile
+	}
+	if f.large() {
+		f.Size = ToUint24(0)
+		f.ExtendedSize = size
+	} else {
+		f.Size = ToUint24(uint32(size))
+	}
+}
+
+// computeChecksums returns the ChecksumHeader and ChecksumData values for this
+// file given the checksum of its data and the fixed checksum value of the volume
+// it's in.
+func (f *FirmwareFile) computeChecksums(dataChecksum, fixed uint8) (uint8, uint8) {
+	// The header checksum is calculated with ChecksumData and State set to
+	// zero.
+	header := f.FirmwareFileHeader
+	header.ChecksumHeader = 0
+	header.ChecksumData = 0
+	header.State = 0
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, header)
+	if f.large() {
+		binary.Write(buf, binary.LittleEndian, f.ExtendedSize)
+	}
+	checksumHeader := checksum.Checksum8(buf.Bytes())
+	if !f.Attributes.Checksum() {
+		return checksumHeader, fixed
+	}
+	return checksumHeader, dataChecksum
 }
 
 func (f *FirmwareFile) Serialize() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := f.SerializeTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SerializeTo writes the serialized file to w. Padding files are streamed out,
+// so that large ones do not have to be kept in memory.
+func (f *FirmwareFile) SerializeTo(w io.Writer) error {
+	if f.FileType == FileTypePadding {
+		return f.serializePaddingTo(w)
+	}
 	var data []byte
 	var err error
-	if f.FileType == FileTypePadding {
-		data = bytes.Repeat([]byte{0xff}, int(f.Size.Uint32()-0x18))
+	if f.FileType == FileTypeRaw {
+		data = f.Data
 	} else {
 		data, err = concatSections(f.Sections)
 		if err != nil {
-			return nil, fmt.Errorf("could not serialize sections: %w", err)
+			return fmt.Errorf("could not serialize sections: %w", err)
 		}
 	}
 
-	f.Size = ToUint24(uint32(len(data)) + 0x18)
+	// Files which do not fit in a 24-bit size get converted to large files.
+	// Files which already are large files stay that way, even if they would
+	// fit, so that they serialize back the same way.
+	if uint64(len(data))+0x18 > 0xffffff {
+		f.Attributes |= FileAttributeLargeFile
+	}
+	if f.large() {
+		f.Size = ToUint24(0)
+		f.ExtendedSize = uint64(len(data)) + 0x20
+	} else {
+		f.Size = ToUint24(uint32(len(data)) + 0x18)
+	}
+
+	f.ChecksumHeader, f.ChecksumData = f.computeChecksums(checksum.Checksum8(data), fixedChecksum(f.fileSystem))
+	if err := f.writeHeader(w); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
 
-	f.ChecksumHeader = 0
-	f.ChecksumData = 0
-	state := f.State
-	f.State = 0
+// serializePaddingTo writes a padding file of its current total size to w.
+// Its data is written twice, first to calculate the checksum, then to w.
+func (f *FirmwareFile) serializePaddingTo(w io.Writer) error {
+	if f.TotalSize() < f.headerSize() {
+		return fmt.Errorf("padding file size 0x%x smaller than its header", f.TotalSize())
+	}
+	size := int(f.TotalSize() - f.headerSize())
+	contents := f.padContents
+	if len(contents) > size {
+		contents = contents[:size]
+	}
+	writeData := func(w io.Writer) error {
+		if _, err := w.Write(contents); err != nil {
+			return err
+		}
+		return writeFill(w, f.erasedByte(), size-len(contents))
+	}
+	var sum checksum.Writer8
+	writeData(&sum)
+	f.ChecksumHeader, f.ChecksumData = f.computeChecksums(sum.Checksum(), fixedChecksum(f.fileSystem))
+	if err := f.writeHeader(w); err != nil {
+		return err
+	}
+	return writeData(w)
+}
 
-	checkBuf := bytes.NewBuffer(nil)
-	binary.Write(checkBuf, binary.LittleEndian, f.FirmwareFileHeader)
+// preservedData returns the file as read if data, the file as serialized now,
+// only differs from it in checksums, ie. the file has not been modified.
+// Otherwise, data is returned.
+func (f *FirmwareFile) preservedData(data []byte) []byte {
+	size := int(f.readSize)
+	if f.original == nil || len(data) != size || len(f.original) < size {
+		return data
+	}
+	// Checksums are at 0x10 and 0x11.
+	if !bytes.Equal(data[:0x10], f.original[:0x10]) || !bytes.Equal(data[0x12:], f.original[0x12:size]) {
+		return data
+	}
+	f.ChecksumHeader = f.original[0x10]
+	f.ChecksumData = f.original[0x11]
+	return f.original
+}
 
-	f.ChecksumHeader = checksum8(checkBuf.Bytes())
-	if (f.Attributes & 0x40) != 0 {
-		f.ChecksumData = checksum8(data)
-	} else {
-		f.ChecksumData = 0x5a
+func (f *FirmwareFile) writeHeader(w io.Writer) error {
+	if err := binary.Write(w, binary.LittleEndian, f.FirmwareFileHeader); err != nil {
+		return err
 	}
-	f.State = state
+	if f.large() {
+		if err := binary.Write(w, binary.LittleEndian, f.ExtendedSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	if err := binary.Write(buf, binary.LittleEndian, f.FirmwareFileHeader); err != nil {
+func (p *parser) readFile(r *NestedReader) (*FirmwareFile, error) {
+	file, dataSub, err := p.readFileHeader(r)
+	if err != nil {
 		return nil, err
 	}
-	if _, err := buf.Write(data); err != nil {
-		panic(err)
+	if dataSub != nil {
+		if err := p.readFileSections(file, dataSub); err != nil {
+			return nil, err
+		}
 	}
-
-	return buf.Bytes(), nil
+	return file, nil
 }
 
-func readFile(r *NestedReader) (*FirmwareFile, error) {
+// readFileHeader reads a file, except for its sections. If the file contains
+// sections, a reader for them is returned, and they must then be parsed with
+// readFileSections.
+func (p *parser) readFileHeader(r *NestedReader) (*FirmwareFile, *NestedReader, error) {
 	start := r.TellGlobal()
+	whole := r.Sub(0, r.Len())
 	var header FirmwareFileHeader
 	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	glog.V(1).Infof("File header @%08x: %+v", start, header)
-	size := header.Size.Uint32()
-	dataSub := r.Sub(0, int(size-0x18))
-	r.Advance(int(size - 0x18))
+	file := &FirmwareFile{
+		FirmwareFileHeader: header,
+		ReadOffset:         start,
+		fileSystem:         p.fileSystem,
+		zeroErased:         p.erase == 0x00,
+	}
+	size := uint64(header.Size.Uint32())
+	if file.large() {
+		if err := binary.Read(r, binary.LittleEndian, &file.ExtendedSize); err != nil {
+			return nil, nil, fmt.Errorf("reading extended size: %w", err)
+		}
+		size = file.ExtendedSize
+	}
+	file.readSize = size
+	headerSize := file.headerSize()
+	if size < headerSize {
+		return nil, nil, fmt.Errorf("file size too small (%d bytes)", size)
+	}
+	if size-headerSize > uint64(r.Len()) {
+		return nil, nil, fmt.Errorf("file size too large (%d bytes)", size)
+	}
+	dataSub := r.Sub(0, int(size-headerSize))
+	r.Advance(int(size - headerSize))
 
-	alignment := (size - 0x18) % 8
+	alignment := (size - headerSize) % 8
 	if alignment != 0 {
-		r.Advance(int(8 - alignment))
+		pad := make([]byte, 8-alignment)
+		n, _ := r.Read(pad)
+		if !erased(pad[:n], p.erase) {
+			if err := p.warnf(r.TellGlobal()-n, "file alignment padding is not erased: %x", pad[:n]); err != nil {
+				return nil, nil, err
+			}
+		}
 	}
-	var sections []Section
-	if header.FileType != FileTypePadding {
-		var err error
-		sections, err = readSections(dataSub)
+	if p.opts.PreserveLayout {
+		original, err := io.ReadAll(whole.Sub(0, r.TellGlobal()-start))
 		if err != nil {
-			return nil, err
+			return nil, nil, fmt.Errorf("reading file: %w", err)
 		}
+		file.original = original
 	}
-	// TODO: checksum
-	return &FirmwareFile{
-		FirmwareFileHeader: header,
-		Sections:           sections,
-		ReadOffset:         start,
-	}, nil
+	data, err := io.ReadAll(dataSub.Sub(0, dataSub.Len()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading data: %w", err)
+	}
+	checksumHeader, checksumData := file.computeChecksums(checksum.Checksum8(data), fixedChecksum(p.fileSystem))
+	if checksumHeader != header.ChecksumHeader {
+		if err := p.warnf(start, "file %s: header checksum is %02x, should be %02x", header.GUID, header.ChecksumHeader, checksumHeader); err != nil {
+			return nil, nil, err
+		}
+	}
+	if checksumData != header.ChecksumData {
+		if err := p.warnf(start, "file %s: data checksum is %02x, should be %02x", header.GUID, header.ChecksumData, checksumData); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch header.FileType {
+	case FileTypeRaw:
+		file.Data = data
+	case FileTypePadding:
+		// Keep non-erased contents of padding files, so that they serialize
+		// back the same way.
+		if !erased(data, p.erase) {
+			file.padContents = data
+		}
+	default:
+		return file, dataSub, nil
+	}
+	return file, nil, nil
+}
+
+// readFileSections parses the sections of a file read by readFileHeader.
+func (p *parser) readFileSections(file *FirmwareFile, r *NestedReader) error {
+	sections, err := p.readSections(r)
+	if err != nil {
+		return err
+	}
+	file.Sections = sections
+	return nil
 }
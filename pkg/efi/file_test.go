@@ -0,0 +1,100 @@
+package efi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLargeFile(t *testing.T) {
+	file := &FirmwareFile{
+		FirmwareFileHeader: FirmwareFileHeader{
+			FileType:   FileTypeDriver,
+			Attributes: FileAttributeLargeFile,
+			State:      0xf8,
+		},
+		Sections: []Section{
+			&leafSection{
+				commonSectionHeader: commonSectionHeader{Type: SectionTypeRaw},
+				data:                []byte("hello, world"),
+			},
+		},
+	}
+	data, err := file.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := uint64(0x20+4+12), file.ExtendedSize; want != got {
+		t.Errorf("wanted extended size %d, got %d", want, got)
+	}
+	if want, got := uint32(0), file.Size.Uint32(); want != got {
+		t.Errorf("wanted size %d, got %d", want, got)
+	}
+
+	file2, err := newParser(nil).readFile(NewNestedReader(data))
+	if err != nil {
+		t.Fatalf("readFile: %v", err)
+	}
+	if want, got := "hello, world", string(file2.Sections[0].Raw()); want != got {
+		t.Errorf("wanted raw data %q, got %q", want, got)
+	}
+	data2, err := file2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized file differs from original")
+	}
+}
+
+func TestFileChecksums(t *testing.T) {
+	for _, attributes := range []FileAttributes{0, FileAttributeChecksum} {
+		file := &FirmwareFile{
+			FirmwareFileHeader: FirmwareFileHeader{
+				FileType:   FileTypeDriver,
+				Attributes: attributes,
+				State:      0xf8,
+			},
+			Sections: []Section{
+				&leafSection{
+					commonSectionHeader: commonSectionHeader{Type: SectionTypeRaw},
+					data:                []byte("hello, world"),
+				},
+			},
+		}
+		if _, err := file.Serialize(); err != nil {
+			t.Fatalf("Serialize: %v", err)
+		}
+		// Modify file after first serialization, checksums should follow.
+		file.Sections[0].SetRaw([]byte("goodbye, world"))
+		data, err := file.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize: %v", err)
+		}
+		if attributes == 0 && file.ChecksumData != 0xaa {
+			t.Errorf("wanted fixed data checksum 0xaa, got %02x", file.ChecksumData)
+		}
+
+		p := newParser(&ParseOptions{Strict: true})
+		if _, err := p.readFile(NewNestedReader(data)); err != nil {
+			t.Errorf("attributes %02x: readFile: %v", attributes, err)
+		}
+	}
+}
+
+func TestFileAttributes(t *testing.T) {
+	a := FileAttributeChecksum
+	for _, al := range []uint64{1, 16, 4 << 10, 128 << 10, 16 << 20} {
+		if err := a.SetDataAlignment(al); err != nil {
+			t.Fatalf("SetDataAlignment(%d): %v", al, err)
+		}
+		if want, got := al, a.DataAlignment(); want != got {
+			t.Errorf("wanted alignment %d, got %d", want, got)
+		}
+		if !a.Checksum() {
+			t.Errorf("SetDataAlignment(%d) cleared checksum attribute", al)
+		}
+	}
+	if err := a.SetDataAlignment(8); err == nil {
+		t.Errorf("SetDataAlignment(8) succeeded, wanted error")
+	}
+}
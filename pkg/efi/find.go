@@ -0,0 +1,83 @@
+package efi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errStopWalk stops a Walk once a find helper found what it's looking for.
+var errStopWalk = errors.New("stop walk")
+
+// findFile returns the first file within the volume or any nested volume
+// matching a predicate.
+func (v *Volume) findFile(match func(*FirmwareFile) bool) *FirmwareFile {
+	var res *FirmwareFile
+	v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		if section == nil && match(file) {
+			res = file
+			return errStopWalk
+		}
+		return nil
+	})
+	return res
+}
+
+// FileByGUID returns the first file with the given GUID within the volume or
+// any volume nested within it. If there is none, an error wrapping
+// ErrFileNotFound is returned.
+func (v *Volume) FileByGUID(guid GUID) (*FirmwareFile, error) {
+	if f := v.findFile(func(f *FirmwareFile) bool { return f.GUID == guid }); f != nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrFileNotFound, guid)
+}
+
+// FileByName returns the first file whose user interface section contains the
+// given name, within the volume or any volume nested within it. If there is
+// none, an error wrapping ErrFileNotFound is returned.
+func (v *Volume) FileByName(name string) (*FirmwareFile, error) {
+	if f := v.findFile(func(f *FirmwareFile) bool { return f.UIName() == name }); f != nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("%w: no file named %q", ErrFileNotFound, name)
+}
+
+// Section returns the first section of the given type within the file,
+// searching depth-first through encapsulation sections but not nested
+// volumes. If there is none, an error wrapping ErrSectionNotFound is returned.
+func (f *FirmwareFile) Section(typ SectionType) (Section, error) {
+	_, s := findSectionFunc(nil, f.Sections, func(s Section) bool {
+		return s.Header().Type == typ
+	})
+	if s == nil {
+		return nil, fmt.Errorf("%w: no %s section in file %s", ErrSectionNotFound, typ, f.GUID)
+	}
+	return s, nil
+}
+
+// SectionsOfType returns all sections of the given type within the file, in
+// depth-first order, not descending into nested volumes.
+func (f *FirmwareFile) SectionsOfType(typ SectionType) []Section {
+	var res []Section
+	findSectionFunc(nil, f.Sections, func(s Section) bool {
+		if s.Header().Type == typ {
+			res = append(res, s)
+		}
+		return false
+	})
+	return res
+}
+
+// Executable returns the data of the PE32 or TE section of the file, eg. the
+// code of a driver. If there is none, an error wrapping ErrSectionNotFound is
+// returned.
+func (f *FirmwareFile) Executable() ([]byte, error) {
+	_, s := findSectionFunc(nil, f.Sections, func(s Section) bool {
+		t := s.Header().Type
+		return t == SectionTypePE32 || t == SectionTypeTE
+	})
+	if s == nil {
+		return nil, fmt.Errorf("%w: no executable section in file %s", ErrSectionNotFound, f.GUID)
+	}
+	return s.Raw(), nil
+}
@@ -0,0 +1,68 @@
+// package guids contains a database of well-known EFI GUIDs, mapping them to
+// human readable names.
+//
+// GUIDs are keyed by their canonical text form (as returned by
+// efi.GUID.String), so that this package can be used from the efi package
+// itself.
+//
+// Only GUIDs defined by the EFI/PI specifications and EDK2 are built in.
+// Apple-specific modules carry their names in user interface sections, which
+// efi.Volume.RegisterNames adds at runtime with Register. Others can be
+// registered as they get reverse engineered.
+package guids
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	mu sync.RWMutex
+
+	names = map[string]string{
+		// Firmware file systems.
+		"7a9354d9-0468-444a-81ce-0bf617d890df": "FirmwareFileSystem",
+		"8c8ce578-8a3d-4f1c-9935-896185c32dd3": "FirmwareFileSystem2",
+		"5473c07a-3dcb-4dca-bd6f-1e9689e7349a": "FirmwareFileSystem3",
+
+		// Special files.
+		"1ba0062e-c779-4582-8566-336ae8f78f09": "VolumeTopFile",
+
+		// Variable stores and vendors.
+		"fff12b8d-7696-4c8b-a985-2747075b4f50": "EfiSystemNvData",
+		"ddcf3616-3275-4164-98b6-fe85707ffe7d": "EfiVariable",
+		"aaf32c78-947b-439a-a180-2e144ec37792": "EfiAuthenticatedVariable",
+		"8be4df61-93ca-11d2-aa0d-00e098032b8c": "EfiGlobalVariable",
+
+		// GUID-defined section encapsulations.
+		"fc1bcdb0-7d31-49aa-936a-a4600d9dd083": "Crc32GuidedSectionExtraction",
+		"ee4e5898-3914-4259-9d6e-dc7bd79403cf": "LzmaCustomDecompress",
+		"a31280ad-481e-41b6-95e8-127f4c984779": "TianoCustomDecompress",
+
+		// EDK2 core modules.
+		"52c05b14-0b98-496c-bc3b-04b50211d680": "PeiCore",
+		"d6a2cb7f-6a18-4e2f-b43b-9920a733700a": "DxeCore",
+		"fc5c7020-1a48-4198-9be2-ead5abc8cf2f": "BdsDxe",
+		"b601f8c4-43b7-4784-95b1-f4226cb40cee": "RuntimeDxe",
+		"cbd2e4d5-7068-4ff5-b462-9822b4ad8d60": "VariableRuntimeDxe",
+		"f099d67f-71ae-4c36-b2a3-dceb0eb2b7d8": "WatchdogTimer",
+		"ad608272-d07f-4964-801e-7bd3b7888652": "MonotonicCounterRuntimeDxe",
+		"7bb28b99-61bb-11d5-9a5d-0090273fc14d": "Logo",
+	}
+)
+
+// Name returns the human readable name of a GUID given in its canonical text
+// form, or an empty string if the GUID is not known.
+func Name(guid string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return names[strings.ToLower(guid)]
+}
+
+// Register a name for a GUID given in its canonical text form, overriding any
+// previously known name.
+func Register(guid, name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	names[strings.ToLower(guid)] = name
+}
@@ -0,0 +1,30 @@
+package guids
+
+import "testing"
+
+func TestName(t *testing.T) {
+	for _, test := range []struct {
+		guid string
+		want string
+	}{
+		{"d6a2cb7f-6a18-4e2f-b43b-9920a733700a", "DxeCore"},
+		{"D6A2CB7F-6A18-4E2F-B43B-9920A733700A", "DxeCore"},
+		{"00000000-0000-0000-0000-000000000000", ""},
+	} {
+		if got := Name(test.guid); got != test.want {
+			t.Errorf("Name(%q): wanted %q, got %q", test.guid, test.want, got)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const guid = "0f7e1c2a-5b3d-4e6f-8a9b-0c1d2e3f4a5b"
+	Register("0F7E1C2A-5B3D-4E6F-8A9B-0C1D2E3F4A5B", "AppleTest")
+	if want, got := "AppleTest", Name(guid); want != got {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+	Register(guid, "AppleTest2")
+	if want, got := "AppleTest2", Name(guid); want != got {
+		t.Errorf("after override: wanted %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,218 @@
+package efi
+
+import (
+	"encoding/json"
+)
+
+// jsonVolume is the JSON representation of a Volume, as emitted by
+// Volume.MarshalJSON.
+type jsonVolume struct {
+	GUID            string         `json:"guid"`
+	Name            string         `json:"name,omitempty"`
+	Length          uint64         `json:"length"`
+	AttributeMask   uint32         `json:"attribute_mask"`
+	AttributeNames  string         `json:"attribute_names"`
+	HeaderLength    uint16         `json:"header_length"`
+	Checksum        uint16         `json:"checksum"`
+	ExtHeaderOffset uint16         `json:"ext_header_offset"`
+	Revision        uint8          `json:"revision"`
+	Blockmap        []jsonBlockmap `json:"blockmap"`
+	ExtHeader       *jsonExtHeader `json:"ext_header,omitempty"`
+	Quirks          string         `json:"quirks,omitempty"`
+	Files           []jsonFile     `json:"files"`
+	TrailingSize    int            `json:"trailing_size"`
+	Warnings        []string       `json:"warnings,omitempty"`
+}
+
+type jsonBlockmap struct {
+	BlockCount uint32 `json:"block_count"`
+	BlockSize  uint32 `json:"block_size"`
+}
+
+type jsonExtHeader struct {
+	Name    string         `json:"name"`
+	Entries []jsonExtEntry `json:"entries,omitempty"`
+}
+
+type jsonExtEntry struct {
+	Type uint16 `json:"type"`
+	Size int    `json:"size"`
+}
+
+type jsonFile struct {
+	GUID           string        `json:"guid"`
+	Name           string        `json:"name,omitempty"`
+	UIName         string        `json:"ui_name,omitempty"`
+	Type           string        `json:"type"`
+	TypeID         uint8         `json:"type_id"`
+	Attributes     uint8         `json:"attributes"`
+	AttributeNames string        `json:"attribute_names,omitempty"`
+	State          uint8         `json:"state"`
+	Size           uint64        `json:"size"`
+	Offset         int           `json:"offset"`
+	Sections       []jsonSection `json:"sections,omitempty"`
+}
+
+type jsonSection struct {
+	Type            string        `json:"type"`
+	TypeID          uint8         `json:"type_id"`
+	Size            uint32        `json:"size"`
+	DataSize        int           `json:"data_size,omitempty"`
+	CompressionType *uint8        `json:"compression_type,omitempty"`
+	DefinitionGUID  string        `json:"definition_guid,omitempty"`
+	DefinitionName  string        `json:"definition_name,omitempty"`
+	Attributes      *uint16       `json:"attributes,omitempty"`
+	SubtypeGUID     string        `json:"subtype_guid,omitempty"`
+	SubtypeName     string        `json:"subtype_name,omitempty"`
+	Sections        []jsonSection `json:"sections,omitempty"`
+	Volume          *jsonVolume   `json:"volume,omitempty"`
+	Image           *jsonImage    `json:"image,omitempty"`
+	Depex           []string      `json:"depex,omitempty"`
+	Name            *string       `json:"name,omitempty"`
+	Version         *jsonVersion  `json:"version,omitempty"`
+}
+
+type jsonVersion struct {
+	BuildNumber uint16 `json:"build_number"`
+	Version     string `json:"version"`
+}
+
+type jsonImage struct {
+	TE         bool   `json:"te"`
+	Machine    uint16 `json:"machine"`
+	EntryPoint uint32 `json:"entry_point"`
+	ImageBase  uint64 `json:"image_base"`
+}
+
+// guidName returns the well-known name of a GUID, or an empty string.
+func guidName(g GUID) string {
+	if name := g.Name(); name != g.String() {
+		return name
+	}
+	return ""
+}
+
+// MarshalJSON emits the structure of the volume (header fields, blockmap,
+// files and recursively nested sections) as JSON. Section payloads are not
+// included. This is a one-way representation: volumes cannot be unmarshaled
+// from it.
+func (v *Volume) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.toJSON())
+}
+
+func (v *Volume) toJSON() *jsonVolume {
+	res := &jsonVolume{
+		GUID:            v.GUID.String(),
+		Name:            guidName(v.GUID),
+		Length:          v.Length,
+		AttributeMask:   uint32(v.AttributeMask),
+		AttributeNames:  v.AttributeMask.String(),
+		HeaderLength:    v.HeaderLength,
+		Checksum:        v.Checksum,
+		ExtHeaderOffset: v.ExtHeaderOffset,
+		Revision:        v.Revision,
+		Blockmap:        []jsonBlockmap{},
+		Files:           []jsonFile{},
+		TrailingSize:    len(v.Custom),
+	}
+	for _, entry := range v.blockmap {
+		res.Blockmap = append(res.Blockmap, jsonBlockmap{
+			BlockCount: entry.BlockCount,
+			BlockSize:  entry.BlockSize,
+		})
+	}
+	if v.ExtHeader != nil {
+		res.ExtHeader = &jsonExtHeader{
+			Name: v.ExtHeader.Name.String(),
+		}
+		for _, entry := range v.ExtHeader.Entries {
+			res.ExtHeader.Entries = append(res.ExtHeader.Entries, jsonExtEntry{
+				Type: entry.Type,
+				Size: len(entry.Data),
+			})
+		}
+	}
+	if v.Quirks != nil {
+		res.Quirks = v.Quirks.Name()
+	}
+	for _, f := range v.Files {
+		file := jsonFile{
+			GUID:           f.GUID.String(),
+			Name:           guidName(f.GUID),
+			UIName:         f.UIName(),
+			Type:           f.FileType.String(),
+			TypeID:         uint8(f.FileType),
+			Attributes:     uint8(f.Attributes),
+			AttributeNames: f.Attributes.String(),
+			State:          f.State,
+			Size:           f.TotalSize(),
+			Offset:         f.ReadOffset,
+		}
+		for _, s := range f.Sections {
+			file.Sections = append(file.Sections, sectionToJSON(s))
+		}
+		res.Files = append(res.Files, file)
+	}
+	for _, w := range v.Warnings {
+		res.Warnings = append(res.Warnings, w.String())
+	}
+	return res
+}
+
+func sectionToJSON(s Section) jsonSection {
+	header := s.Header()
+	res := jsonSection{
+		Type:     header.Type.String(),
+		TypeID:   uint8(header.Type),
+		Size:     header.Size.Uint32(),
+		DataSize: len(s.Raw()),
+	}
+	switch s := s.(type) {
+	case *compressionSection:
+		compressionType := s.extra.CompressionType
+		res.CompressionType = &compressionType
+	case GUIDDefinedSection:
+		attributes := s.Attributes()
+		res.DefinitionGUID = s.DefinitionGUID().String()
+		res.DefinitionName = guidName(s.DefinitionGUID())
+		res.Attributes = &attributes
+	case FreeformSection:
+		res.SubtypeGUID = s.SubtypeGUID().String()
+		res.SubtypeName = guidName(s.SubtypeGUID())
+	case FirmwareVolumeSection:
+		res.Volume = s.Volume().toJSON()
+	}
+	if header.Type == SectionTypePE32 || header.Type == SectionTypeTE {
+		if image, err := SectionImage(s); err == nil {
+			res.Image = &jsonImage{
+				TE:         image.TE,
+				Machine:    image.Machine,
+				EntryPoint: image.EntryPoint,
+				ImageBase:  image.ImageBase,
+			}
+		}
+	}
+	switch header.Type {
+	case SectionTypeDXEDEPEX:
+		if depex, err := ParseDepex(s.Raw()); err == nil {
+			for _, insn := range depex {
+				res.Depex = append(res.Depex, insn.String())
+			}
+		}
+	case SectionTypeUserInterface:
+		if name, err := SectionName(s); err == nil {
+			res.Name = &name
+		}
+	case SectionTypeVersion:
+		if version, err := SectionVersion(s); err == nil {
+			res.Version = &jsonVersion{
+				BuildNumber: version.BuildNumber,
+				Version:     version.Version,
+			}
+		}
+	}
+	for _, sub := range s.Sub() {
+		res.Sections = append(res.Sections, sectionToJSON(sub))
+	}
+	return res
+}
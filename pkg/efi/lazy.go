@@ -0,0 +1,74 @@
+package efi
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// lazySections defers parsing the sections nested within a compressed section
+// until they are first accessed, if the volume was parsed with
+// ParseOptions.Lazy.
+type lazySections struct {
+	// p is a parser forked off when the section was read.
+	p *parser
+	// load parses the sections using p, or is nil if they have already been
+	// parsed.
+	load func(p *parser) error
+	// err is the error encountered when parsing the sections, if any.
+	err error
+}
+
+// pending returns whether the sections have not been parsed yet.
+func (l *lazySections) pending() bool {
+	return l.load != nil
+}
+
+// deferLoad arranges for load to be called with a fork of p once the sections
+// are first accessed.
+func (l *lazySections) deferLoad(p *parser, load func(p *parser) error) {
+	l.p = p.fork()
+	l.load = load
+}
+
+// ensure parses the sections if they have not been parsed yet. Any error is
+// recorded and logged, as Section.Sub cannot return it.
+func (l *lazySections) ensure() {
+	if l.load == nil {
+		return
+	}
+	load := l.load
+	l.load = nil
+	err := load(l.p)
+	for _, w := range l.p.warnings {
+		glog.Warningf("Warning in deferred section: %s", w)
+	}
+	l.p = nil
+	if err != nil {
+		glog.Errorf("Parsing deferred sections failed: %v", err)
+		l.err = err
+	}
+}
+
+// lazyLoader is implemented by sections which may defer parsing their
+// subsections.
+type lazyLoader interface {
+	// loadSections parses the subsections if needed, returning any error
+	// encountered doing so.
+	loadSections() error
+}
+
+// Load parses all sections whose parsing has been deferred by
+// ParseOptions.Lazy, including ones within nested volumes, and returns the
+// first error encountered doing so. Sections which fail to parse have no
+// subsections, but still serialize back to the data they were read from.
+func (v *Volume) Load() error {
+	return v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		if l, ok := section.(lazyLoader); ok {
+			if err := l.loadSections(); err != nil {
+				return fmt.Errorf("section %v: %w", path, err)
+			}
+		}
+		return nil
+	})
+}
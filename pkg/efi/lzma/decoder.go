@@ -0,0 +1,265 @@
+package lzma
+
+import (
+	"fmt"
+)
+
+// rangeDecoder is the arithmetic decoder underlying LZMA.
+type rangeDecoder struct {
+	in    []byte
+	pos   int
+	rng   uint32
+	code  uint32
+	trunc bool
+}
+
+func newRangeDecoder(in []byte) (*rangeDecoder, error) {
+	if len(in) < 5 {
+		return nil, fmt.Errorf("stream too short")
+	}
+	if in[0] != 0 {
+		return nil, fmt.Errorf("invalid stream start")
+	}
+	r := &rangeDecoder{
+		in:  in,
+		pos: 5,
+		rng: 0xffffffff,
+	}
+	for _, b := range in[1:5] {
+		r.code = (r.code << 8) | uint32(b)
+	}
+	if r.code == r.rng {
+		return nil, fmt.Errorf("invalid stream start")
+	}
+	return r, nil
+}
+
+func (r *rangeDecoder) next() byte {
+	if r.pos >= len(r.in) {
+		r.trunc = true
+		return 0
+	}
+	b := r.in[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *rangeDecoder) normalize() {
+	if r.rng < 1<<24 {
+		r.rng <<= 8
+		r.code = (r.code << 8) | uint32(r.next())
+	}
+}
+
+func (r *rangeDecoder) bit(p *uint16) uint32 {
+	bound := (r.rng >> 11) * uint32(*p)
+	var res uint32
+	if r.code < bound {
+		*p += ((1 << 11) - *p) >> 5
+		r.rng = bound
+	} else {
+		*p -= *p >> 5
+		r.code -= bound
+		r.rng -= bound
+		res = 1
+	}
+	r.normalize()
+	return res
+}
+
+func (r *rangeDecoder) direct(numBits int) uint32 {
+	var res uint32
+	for ; numBits > 0; numBits-- {
+		r.rng >>= 1
+		bit := uint32(0)
+		if r.code >= r.rng {
+			r.code -= r.rng
+			bit = 1
+		}
+		res = (res << 1) | bit
+		r.normalize()
+	}
+	return res
+}
+
+func (r *rangeDecoder) tree(probs []uint16, numBits int) uint32 {
+	m := uint32(1)
+	for i := 0; i < numBits; i++ {
+		m = (m << 1) + r.bit(&probs[m])
+	}
+	return m - (1 << uint(numBits))
+}
+
+func (r *rangeDecoder) reverseTree(probs []uint16, numBits int) uint32 {
+	m := uint32(1)
+	var sym uint32
+	for i := 0; i < numBits; i++ {
+		bit := r.bit(&probs[m])
+		m = (m << 1) + bit
+		sym |= bit << uint(i)
+	}
+	return sym
+}
+
+func (r *rangeDecoder) length(l *lenModel, posState int) int {
+	if r.bit(&l.choice) == 0 {
+		return int(r.tree(l.low[posState][:], 3))
+	}
+	if r.bit(&l.choice2) == 0 {
+		return 8 + int(r.tree(l.mid[posState][:], 3))
+	}
+	return 16 + int(r.tree(l.high[:], 8))
+}
+
+type decoder struct {
+	*model
+	rc  *rangeDecoder
+	out []byte
+}
+
+func newDecoder(in []byte, lc, lp, pb int) (*decoder, error) {
+	rc, err := newRangeDecoder(in)
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{
+		model: newModel(lc, lp, pb),
+		rc:    rc,
+	}, nil
+}
+
+func (d *decoder) literal(state int, rep0 uint32) {
+	var prev byte
+	if len(d.out) > 0 {
+		prev = d.out[len(d.out)-1]
+	}
+	probs := d.literalProbs(len(d.out), prev)
+	sym := uint32(1)
+	if state >= 7 {
+		match := uint32(d.out[len(d.out)-int(rep0)-1])
+		for sym < 0x100 {
+			matchBit := (match >> 7) & 1
+			match <<= 1
+			bit := d.rc.bit(&probs[((1+matchBit)<<8)+sym])
+			sym = (sym << 1) | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for sym < 0x100 {
+		sym = (sym << 1) | d.rc.bit(&probs[sym])
+	}
+	d.out = append(d.out, byte(sym))
+}
+
+func (d *decoder) distance(l int) uint32 {
+	slot := d.rc.tree(d.posSlot[lenToPosState(l)][:], 6)
+	if slot < startPosModelIndex {
+		return slot
+	}
+	numDirect := int(slot>>1) - 1
+	dist := (2 | (slot & 1)) << uint(numDirect)
+	if slot < endPosModelIndex {
+		dist += d.rc.reverseTree(d.posSpec[dist-slot:], numDirect)
+	} else {
+		dist += d.rc.direct(numDirect-numAlignBits) << numAlignBits
+		dist += d.rc.reverseTree(d.align[:], numAlignBits)
+	}
+	return dist
+}
+
+// decode the stream. If size is unknownSize, the stream must be terminated
+// with an end marker.
+func (d *decoder) decode(size, limit uint64) ([]byte, error) {
+	if size != unknownSize {
+		d.out = make([]byte, 0, int(size))
+	}
+
+	var rep0, rep1, rep2, rep3 uint32
+	state := 0
+	pbMask := (1 << uint(d.pb)) - 1
+
+	for {
+		if size != unknownSize && uint64(len(d.out)) >= size {
+			break
+		}
+		if d.rc.trunc {
+			return nil, fmt.Errorf("truncated stream")
+		}
+		if uint64(len(d.out)) > limit {
+			return nil, fmt.Errorf("uncompressed data exceeds limit of %d bytes", limit)
+		}
+		posState := len(d.out) & pbMask
+
+		if d.rc.bit(&d.isMatch[(state<<numPosBitsMax)+posState]) == 0 {
+			d.literal(state, rep0)
+			state = stateUpdateLiteral(state)
+			continue
+		}
+
+		var l int
+		if d.rc.bit(&d.isRep[state]) != 0 {
+			if len(d.out) == 0 {
+				return nil, fmt.Errorf("rep match at stream start")
+			}
+			if d.rc.bit(&d.isRepG0[state]) == 0 {
+				if d.rc.bit(&d.isRep0Long[(state<<numPosBitsMax)+posState]) == 0 {
+					if int(rep0) >= len(d.out) {
+						return nil, fmt.Errorf("match distance %d out of range", rep0)
+					}
+					state = stateUpdateShortRep(state)
+					d.out = append(d.out, d.out[len(d.out)-int(rep0)-1])
+					continue
+				}
+			} else {
+				var dist uint32
+				if d.rc.bit(&d.isRepG1[state]) == 0 {
+					dist = rep1
+				} else {
+					if d.rc.bit(&d.isRepG2[state]) == 0 {
+						dist = rep2
+					} else {
+						dist = rep3
+						rep3 = rep2
+					}
+					rep2 = rep1
+				}
+				rep1 = rep0
+				rep0 = dist
+			}
+			l = d.rc.length(&d.repLen, posState)
+			state = stateUpdateRep(state)
+		} else {
+			rep3 = rep2
+			rep2 = rep1
+			rep1 = rep0
+			l = d.rc.length(&d.lenCoder, posState)
+			state = stateUpdateMatch(state)
+			rep0 = d.distance(l)
+			if rep0 == 0xffffffff {
+				// End marker.
+				if size != unknownSize && uint64(len(d.out)) != size {
+					return nil, fmt.Errorf("unexpected end marker")
+				}
+				break
+			}
+		}
+		if int(rep0) >= len(d.out) {
+			return nil, fmt.Errorf("match distance %d out of range", rep0)
+		}
+
+		l += matchMinLen
+		if size != unknownSize && uint64(len(d.out)+l) > size {
+			return nil, fmt.Errorf("match overruns uncompressed size")
+		}
+		start := len(d.out) - int(rep0) - 1
+		for i := 0; i < l; i++ {
+			d.out = append(d.out, d.out[start+i])
+		}
+	}
+	if d.rc.trunc {
+		return nil, fmt.Errorf("truncated stream")
+	}
+	return d.out, nil
+}
@@ -0,0 +1,278 @@
+package lzma
+
+import (
+	"io"
+)
+
+// rangeEncoder is the arithmetic encoder underlying LZMA.
+type rangeEncoder struct {
+	w         io.ByteWriter
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int
+}
+
+func newRangeEncoder(w io.ByteWriter) *rangeEncoder {
+	return &rangeEncoder{
+		w:         w,
+		rng:       0xffffffff,
+		cacheSize: 1,
+	}
+}
+
+func (r *rangeEncoder) shiftLow() {
+	if uint32(r.low) < 0xff000000 || (r.low>>32) != 0 {
+		temp := r.cache
+		for {
+			r.w.WriteByte(temp + byte(r.low>>32))
+			temp = 0xff
+			r.cacheSize--
+			if r.cacheSize == 0 {
+				break
+			}
+		}
+		r.cache = byte(uint32(r.low) >> 24)
+	}
+	r.cacheSize++
+	r.low = uint64(uint32(r.low) << 8)
+}
+
+func (r *rangeEncoder) bit(p *uint16, bit uint32) {
+	bound := (r.rng >> 11) * uint32(*p)
+	if bit == 0 {
+		r.rng = bound
+		*p += ((1 << 11) - *p) >> 5
+	} else {
+		r.low += uint64(bound)
+		r.rng -= bound
+		*p -= *p >> 5
+	}
+	for r.rng < 1<<24 {
+		r.rng <<= 8
+		r.shiftLow()
+	}
+}
+
+func (r *rangeEncoder) direct(value uint32, numBits int) {
+	for numBits--; numBits >= 0; numBits-- {
+		r.rng >>= 1
+		if (value>>uint(numBits))&1 != 0 {
+			r.low += uint64(r.rng)
+		}
+		for r.rng < 1<<24 {
+			r.rng <<= 8
+			r.shiftLow()
+		}
+	}
+}
+
+func (r *rangeEncoder) flush() {
+	for i := 0; i < 5; i++ {
+		r.shiftLow()
+	}
+}
+
+func (r *rangeEncoder) tree(probs []uint16, numBits int, sym uint32) {
+	m := uint32(1)
+	for i := numBits - 1; i >= 0; i-- {
+		bit := (sym >> uint(i)) & 1
+		r.bit(&probs[m], bit)
+		m = (m << 1) | bit
+	}
+}
+
+func (r *rangeEncoder) reverseTree(probs []uint16, numBits int, sym uint32) {
+	m := uint32(1)
+	for i := 0; i < numBits; i++ {
+		bit := sym & 1
+		sym >>= 1
+		r.bit(&probs[m], bit)
+		m = (m << 1) | bit
+	}
+}
+
+func (r *rangeEncoder) length(l *lenModel, length, posState int) {
+	switch {
+	case length < 8:
+		r.bit(&l.choice, 0)
+		r.tree(l.low[posState][:], 3, uint32(length))
+	case length < 16:
+		r.bit(&l.choice, 1)
+		r.bit(&l.choice2, 0)
+		r.tree(l.mid[posState][:], 3, uint32(length-8))
+	default:
+		r.bit(&l.choice, 1)
+		r.bit(&l.choice2, 1)
+		r.tree(l.high[:], 8, uint32(length-16))
+	}
+}
+
+const (
+	hashBits     = 16
+	maxChainLen  = 64
+	niceMatchLen = 128
+)
+
+type encoder struct {
+	*model
+	rc       *rangeEncoder
+	dictSize int
+}
+
+func newEncoder(w io.ByteWriter, lc, lp, pb, dictSize int) *encoder {
+	return &encoder{
+		model:    newModel(lc, lp, pb),
+		rc:       newRangeEncoder(w),
+		dictSize: dictSize,
+	}
+}
+
+func hash3(b []byte) uint32 {
+	v := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+	return (v * 2654435761) >> (32 - hashBits)
+}
+
+func matchLen(in []byte, a, b int) int {
+	max := len(in) - b
+	if max > matchMaxLen {
+		max = matchMaxLen
+	}
+	n := 0
+	for n < max && in[a+n] == in[b+n] {
+		n++
+	}
+	return n
+}
+
+// encode all of in, greedily picking the longest match available at every
+// position.
+func (e *encoder) encode(in []byte) {
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(in))
+	insert := func(pos int) {
+		if pos+3 > len(in) {
+			return
+		}
+		h := hash3(in[pos:])
+		prev[pos] = head[h]
+		head[h] = int32(pos)
+	}
+
+	var reps [4]uint32
+	state := 0
+	pbMask := (1 << uint(e.pb)) - 1
+
+	pos := 0
+	for pos < len(in) {
+		posState := pos & pbMask
+
+		// Find best match via hash chain.
+		bestLen, bestDist := 0, 0
+		if pos+3 <= len(in) {
+			cand := head[hash3(in[pos:])]
+			for chain := 0; cand >= 0 && chain < maxChainLen; chain++ {
+				dist := pos - int(cand)
+				if dist > e.dictSize {
+					break
+				}
+				if l := matchLen(in, int(cand), pos); l > bestLen {
+					bestLen, bestDist = l, dist
+					if l >= niceMatchLen {
+						break
+					}
+				}
+				cand = prev[cand]
+			}
+		}
+
+		// Prefer repeating the last distance if it's about as good, as it's
+		// cheaper to encode.
+		repLen := 0
+		if pos > int(reps[0]) {
+			repLen = matchLen(in, pos-int(reps[0])-1, pos)
+		}
+
+		switch {
+		case repLen >= matchMinLen && repLen+1 >= bestLen:
+			e.rc.bit(&e.isMatch[(state<<numPosBitsMax)+posState], 1)
+			e.rc.bit(&e.isRep[state], 1)
+			e.rc.bit(&e.isRepG0[state], 0)
+			e.rc.bit(&e.isRep0Long[(state<<numPosBitsMax)+posState], 1)
+			e.rc.length(&e.repLen, repLen-matchMinLen, posState)
+			state = stateUpdateRep(state)
+			for i := 0; i < repLen; i++ {
+				insert(pos + i)
+			}
+			pos += repLen
+		case bestLen >= 3:
+			dist := uint32(bestDist - 1)
+			e.rc.bit(&e.isMatch[(state<<numPosBitsMax)+posState], 1)
+			e.rc.bit(&e.isRep[state], 0)
+			e.rc.length(&e.lenCoder, bestLen-matchMinLen, posState)
+			e.distance(dist, bestLen-matchMinLen)
+			reps[3], reps[2], reps[1], reps[0] = reps[2], reps[1], reps[0], dist
+			state = stateUpdateMatch(state)
+			for i := 0; i < bestLen; i++ {
+				insert(pos + i)
+			}
+			pos += bestLen
+		default:
+			e.rc.bit(&e.isMatch[(state<<numPosBitsMax)+posState], 0)
+			e.literal(in, pos, state, reps[0])
+			state = stateUpdateLiteral(state)
+			insert(pos)
+			pos++
+		}
+	}
+	e.rc.flush()
+}
+
+func (e *encoder) literal(in []byte, pos, state int, rep0 uint32) {
+	var prevByte byte
+	if pos > 0 {
+		prevByte = in[pos-1]
+	}
+	probs := e.literalProbs(pos, prevByte)
+	sym := uint32(in[pos]) | 0x100
+	if state >= 7 {
+		match := uint32(in[pos-int(rep0)-1])
+		offs := uint32(0x100)
+		for i := 7; i >= 0; i-- {
+			bit := (sym >> uint(i)) & 1
+			matchBit := (match >> uint(i)) & 1
+			ctx := sym >> uint(i+1)
+			e.rc.bit(&probs[offs+(matchBit<<8)+ctx], bit)
+			if matchBit != bit {
+				// Fall back to plain literal coding for the rest.
+				for i--; i >= 0; i-- {
+					bit := (sym >> uint(i)) & 1
+					e.rc.bit(&probs[sym>>uint(i+1)], bit)
+				}
+				return
+			}
+		}
+		return
+	}
+	e.rc.tree(probs, 8, uint32(in[pos]))
+}
+
+func (e *encoder) distance(dist uint32, l int) {
+	slot := posSlot(dist)
+	e.rc.tree(e.posSlot[lenToPosState(l)][:], 6, slot)
+	if slot < startPosModelIndex {
+		return
+	}
+	footerBits := int(slot>>1) - 1
+	base := (2 | (slot & 1)) << uint(footerBits)
+	reduced := dist - base
+	if slot < endPosModelIndex {
+		e.rc.reverseTree(e.posSpec[base-slot:], footerBits, reduced)
+		return
+	}
+	e.rc.direct(reduced>>numAlignBits, footerBits-numAlignBits)
+	e.rc.reverseTree(e.align[:], numAlignBits, reduced&((1<<numAlignBits)-1))
+}
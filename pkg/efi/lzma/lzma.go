@@ -0,0 +1,236 @@
+// package lzma implements the LZMA 'alone' format as used by EDK2's
+// LzmaCustomDecompress GUID-defined sections.
+//
+// This is a small, pure Go implementation. The decoder supports all valid
+// LZMA streams. The encoder is a simple greedy hash-chain matcher: it does not
+// attempt to produce the same output as the LZMA SDK encoder used by EDK2, but
+// its output is a valid stream that any LZMA decoder (including the one in the
+// bootloaders) will accept.
+package lzma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// headerSize is the size of the LZMA 'alone' header: one properties byte,
+	// a 32-bit dictionary size and a 64-bit uncompressed size.
+	headerSize = 13
+
+	// unknownSize is the uncompressed size value signaling that the stream is
+	// terminated by an end marker.
+	unknownSize = 0xffffffffffffffff
+
+	// Default properties used by EDK2's LzmaCompress.
+	defaultLC       = 3
+	defaultLP       = 0
+	defaultPB       = 2
+	defaultDictSize = 1 << 22
+)
+
+// header of an LZMA 'alone' stream.
+type header struct {
+	Properties       uint8
+	DictionarySize   uint32
+	UncompressedSize uint64
+}
+
+func (h *header) props() (lc, lp, pb int, err error) {
+	d := int(h.Properties)
+	if d >= 9*5*5 {
+		return 0, 0, 0, fmt.Errorf("invalid properties byte %02x", h.Properties)
+	}
+	lc = d % 9
+	d /= 9
+	lp = d % 5
+	pb = d / 5
+	return
+}
+
+// Decompress an LZMA 'alone' stream.
+func Decompress(in []byte) ([]byte, error) {
+	return DecompressLimit(in, unknownSize)
+}
+
+// DecompressLimit decompresses an LZMA 'alone' stream, failing if it would
+// decompress to more than limit bytes.
+func DecompressLimit(in []byte, limit uint64) ([]byte, error) {
+	if len(in) < headerSize {
+		return nil, fmt.Errorf("too short for header")
+	}
+	var h header
+	if err := binary.Read(bytes.NewReader(in[:headerSize]), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if h.UncompressedSize != unknownSize && h.UncompressedSize > limit {
+		return nil, fmt.Errorf("uncompressed size %d exceeds limit of %d bytes", h.UncompressedSize, limit)
+	}
+	lc, lp, pb, err := h.props()
+	if err != nil {
+		return nil, err
+	}
+	d, err := newDecoder(in[headerSize:], lc, lp, pb)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(h.UncompressedSize, limit)
+}
+
+// Compress data into an LZMA 'alone' stream, using the same properties as
+// EDK2's LzmaCompress.
+func Compress(in []byte) ([]byte, error) {
+	h := header{
+		Properties:       uint8((defaultPB*5+defaultLP)*9 + defaultLC),
+		DictionarySize:   defaultDictSize,
+		UncompressedSize: uint64(len(in)),
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.LittleEndian, h); err != nil {
+		return nil, err
+	}
+	e := newEncoder(buf, defaultLC, defaultLP, defaultPB, defaultDictSize)
+	e.encode(in)
+	return buf.Bytes(), nil
+}
+
+const (
+	numStates          = 12
+	numPosBitsMax      = 4
+	numLenToPosStates  = 4
+	numAlignBits       = 4
+	startPosModelIndex = 4
+	endPosModelIndex   = 14
+	numFullDistances   = 1 << (endPosModelIndex >> 1)
+	matchMinLen        = 2
+	matchMaxLen        = 273
+
+	probInitValue = 1 << 10
+)
+
+func initProbs(p []uint16) {
+	for i := range p {
+		p[i] = probInitValue
+	}
+}
+
+func stateUpdateLiteral(s int) int {
+	switch {
+	case s < 4:
+		return 0
+	case s < 10:
+		return s - 3
+	default:
+		return s - 6
+	}
+}
+
+func stateUpdateMatch(s int) int {
+	if s < 7 {
+		return 7
+	}
+	return 10
+}
+
+func stateUpdateRep(s int) int {
+	if s < 7 {
+		return 8
+	}
+	return 11
+}
+
+func stateUpdateShortRep(s int) int {
+	if s < 7 {
+		return 9
+	}
+	return 11
+}
+
+// model contains all adaptive probabilities of an LZMA coder. It's shared by
+// the encoder and decoder, which must update it in lockstep.
+type model struct {
+	lc, lp, pb int
+
+	literal    []uint16
+	isMatch    [numStates << numPosBitsMax]uint16
+	isRep      [numStates]uint16
+	isRepG0    [numStates]uint16
+	isRepG1    [numStates]uint16
+	isRepG2    [numStates]uint16
+	isRep0Long [numStates << numPosBitsMax]uint16
+
+	posSlot  [numLenToPosStates][1 << 6]uint16
+	posSpec  [1 + numFullDistances - endPosModelIndex]uint16
+	align    [1 << numAlignBits]uint16
+	lenCoder lenModel
+	repLen   lenModel
+}
+
+func newModel(lc, lp, pb int) *model {
+	m := &model{
+		lc:      lc,
+		lp:      lp,
+		pb:      pb,
+		literal: make([]uint16, 0x300<<uint(lc+lp)),
+	}
+	initProbs(m.literal)
+	initProbs(m.isMatch[:])
+	initProbs(m.isRep[:])
+	initProbs(m.isRepG0[:])
+	initProbs(m.isRepG1[:])
+	initProbs(m.isRepG2[:])
+	initProbs(m.isRep0Long[:])
+	for i := range m.posSlot {
+		initProbs(m.posSlot[i][:])
+	}
+	initProbs(m.posSpec[:])
+	initProbs(m.align[:])
+	m.lenCoder.init()
+	m.repLen.init()
+	return m
+}
+
+// literalProbs returns the literal coder probabilities for a given position
+// and previous byte.
+func (m *model) literalProbs(pos int, prev byte) []uint16 {
+	lit := ((pos & ((1 << uint(m.lp)) - 1)) << uint(m.lc)) + int(prev>>uint(8-m.lc))
+	return m.literal[0x300*lit : 0x300*(lit+1)]
+}
+
+type lenModel struct {
+	choice  uint16
+	choice2 uint16
+	low     [1 << numPosBitsMax][1 << 3]uint16
+	mid     [1 << numPosBitsMax][1 << 3]uint16
+	high    [1 << 8]uint16
+}
+
+func (l *lenModel) init() {
+	l.choice = probInitValue
+	l.choice2 = probInitValue
+	for i := range l.low {
+		initProbs(l.low[i][:])
+		initProbs(l.mid[i][:])
+	}
+	initProbs(l.high[:])
+}
+
+// posSlot returns the distance slot for a given (zero-based) distance.
+func posSlot(dist uint32) uint32 {
+	if dist < startPosModelIndex {
+		return dist
+	}
+	n := uint32(31)
+	for (dist>>n)&1 == 0 {
+		n--
+	}
+	return 2*n + ((dist >> (n - 1)) & 1)
+}
+
+func lenToPosState(l int) int {
+	if l > numLenToPosStates-1 {
+		return numLenToPosStates - 1
+	}
+	return l
+}
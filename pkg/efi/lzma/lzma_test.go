@@ -0,0 +1,45 @@
+package lzma
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestLoopback(t *testing.T) {
+	for _, input := range [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("According to all known laws of aviation, there is no way an EFI implementation should be able to fly. It's wings are too small to get its fat little body off the ground. The implementation, of course, flies anyway, because computers don't care what humans think is impossible."),
+		bytes.Repeat([]byte("wInd3x"), 10000),
+	} {
+		compressed, err := Compress(input)
+		if err != nil {
+			t.Fatalf("Compress() failed: %v", err)
+		}
+
+		uncompressed, err := Decompress(compressed)
+		if err != nil {
+			t.Fatalf("Decompress() failed: %v", err)
+		}
+
+		if !bytes.Equal(input, uncompressed) {
+			t.Fatalf("did not decompress to same data: %q", string(uncompressed))
+		}
+	}
+}
+
+// TestDecompressReference decompresses a stream generated by the reference
+// LZMA SDK encoder (using an unknown size and an end marker).
+func TestDecompressReference(t *testing.T) {
+	compressed, _ := hex.DecodeString("5d00008000ffffffffffffffff002098ecde7232afd60d9819312c529c2f0464bc8c4970bcf82612cf9358c5a28a080c31596e973a86cf509367433b7c8c8ba9b75396437cd293420a039fd75580debf5471aabbf2779737838b10ee89d65826f5b4dfff67d58000")
+	want := bytes.Repeat([]byte("According to all known laws of aviation, there is no way a bee should be able to fly. "), 3)
+
+	got, err := Decompress(compressed)
+	if err != nil {
+		t.Fatalf("Decompress() failed: %v", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Fatalf("decompressed to %q, wanted %q", string(got), string(want))
+	}
+}
@@ -0,0 +1,52 @@
+package efi
+
+import (
+	"fmt"
+	"io"
+)
+
+// MappedFile is a read-only view of a file, memory mapped where the platform
+// supports it. It can back a NestedReader via NewNestedReaderAt without loading
+// the whole file into memory.
+type MappedFile struct {
+	data  []byte
+	unmap func() error
+}
+
+// ReadAt implements io.ReaderAt.
+func (m *MappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if m.data == nil {
+		return 0, fmt.Errorf("file closed")
+	}
+	if off < 0 || off > int64(len(m.data)) {
+		return 0, fmt.Errorf("offset %d out of range", off)
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Len returns the size of the mapped file.
+func (m *MappedFile) Len() int {
+	return len(m.data)
+}
+
+// Reader returns a NestedReader over the entire file.
+func (m *MappedFile) Reader() *NestedReader {
+	return NewNestedReaderAt(m, int64(len(m.data)))
+}
+
+// Close unmaps the file. Any NestedReader created from it must not be used
+// afterwards.
+func (m *MappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	m.data = nil
+	if m.unmap == nil {
+		return nil
+	}
+	return m.unmap()
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!freebsd,!linux,!netbsd,!openbsd
+
+package efi
+
+import (
+	"os"
+)
+
+// MapFile reads the file at path into memory, as memory mapping is not
+// supported on this platform.
+func MapFile(path string) (*MappedFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MappedFile{data: data}, nil
+}
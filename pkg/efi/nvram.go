@@ -0,0 +1,446 @@
+package efi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrVariableNotFound is returned when a variable with a given name and
+	// GUID is not present in a variable store.
+	ErrVariableNotFound = errors.New("variable not found")
+	// ErrVariableStoreFull is returned when the variables of a store do not
+	// fit within its size.
+	ErrVariableStoreFull = errors.New("variable store full")
+)
+
+var (
+	// guidVariableStore is gEfiVariableGuid, the signature of a variable
+	// store with plain variable headers.
+	guidVariableStore = MustParseGUID("ddcf3616-3275-4164-98b6-fe85707ffe7d")
+	// guidAuthVariableStore is gEfiAuthenticatedVariableGuid, the signature
+	// of a variable store with authenticated variable headers.
+	guidAuthVariableStore = MustParseGUID("aaf32c78-947b-439a-a180-2e144ec37792")
+)
+
+// VariableStoreKind is the flavour of a variable store header.
+type VariableStoreKind int
+
+const (
+	// VariableStoreVSS is a store with a 4-byte '$VSS' signature, as used by
+	// older and Apple firmware.
+	VariableStoreVSS VariableStoreKind = iota
+	// VariableStoreGUID is a store signed with gEfiVariableGuid.
+	VariableStoreGUID
+	// VariableStoreAuthenticated is a store signed with
+	// gEfiAuthenticatedVariableGuid, with authenticated variable headers.
+	VariableStoreAuthenticated
+)
+
+func (k VariableStoreKind) String() string {
+	switch k {
+	case VariableStoreVSS:
+		return "$VSS"
+	case VariableStoreGUID:
+		return "EfiVariable"
+	case VariableStoreAuthenticated:
+		return "EfiAuthenticatedVariable"
+	}
+	return fmt.Sprintf("VariableStoreKind(%d)", int(k))
+}
+
+func (k VariableStoreKind) headerSize() int {
+	if k == VariableStoreVSS {
+		return 0x10
+	}
+	return 0x1c
+}
+
+func (k VariableStoreKind) variableHeaderSize() int {
+	if k == VariableStoreAuthenticated {
+		return 0x3c
+	}
+	return 0x20
+}
+
+// VariableAttributes are the EFI_VARIABLE_* attributes of a variable.
+type VariableAttributes uint32
+
+const (
+	VariableNonVolatile                       VariableAttributes = 0x01
+	VariableBootserviceAccess                 VariableAttributes = 0x02
+	VariableRuntimeAccess                     VariableAttributes = 0x04
+	VariableHardwareErrorRecord               VariableAttributes = 0x08
+	VariableAuthenticatedWriteAccess          VariableAttributes = 0x10
+	VariableTimeBasedAuthenticatedWriteAccess VariableAttributes = 0x20
+	VariableAppendWrite                       VariableAttributes = 0x40
+)
+
+var variableAttributeNames = []struct {
+	a    VariableAttributes
+	name string
+}{
+	{VariableNonVolatile, "NV"},
+	{VariableBootserviceAccess, "BS"},
+	{VariableRuntimeAccess, "RT"},
+	{VariableHardwareErrorRecord, "HR"},
+	{VariableAuthenticatedWriteAccess, "AW"},
+	{VariableTimeBasedAuthenticatedWriteAccess, "AT"},
+	{VariableAppendWrite, "AP"},
+}
+
+func (a VariableAttributes) String() string {
+	var parts []string
+	for _, n := range variableAttributeNames {
+		if a&n.a != 0 {
+			parts = append(parts, n.name)
+			a &^= n.a
+		}
+	}
+	if a != 0 {
+		parts = append(parts, fmt.Sprintf("0x%x", uint32(a)))
+	}
+	return strings.Join(parts, "|")
+}
+
+// Variable states. States are written by clearing bits, so a variable being
+// deleted has its state ANDed with VariableStateInDeletedTransition and then
+// VariableStateDeleted.
+const (
+	VariableStateHeaderValidOnly     uint8 = 0x7f
+	VariableStateDeleted             uint8 = 0xfd
+	VariableStateInDeletedTransition uint8 = 0xfe
+	VariableStateAdded               uint8 = 0x3f
+)
+
+const variableStartID = 0x55aa
+
+// Variable is a single EFI variable within a VariableStore.
+type Variable struct {
+	State      uint8
+	Attributes VariableAttributes
+	GUID       GUID
+	Name       string
+	Data       []byte
+
+	// MonotonicCount, Timestamp and PubKeyIndex are only present in
+	// authenticated variable stores.
+	MonotonicCount uint64
+	Timestamp      [16]byte
+	PubKeyIndex    uint32
+
+	// ReadOffset is the offset of the variable header within the store, as
+	// read.
+	ReadOffset int
+
+	reserved uint8
+	// rawName is the name as read, kept to serialize back names with
+	// trailing data after their NUL terminator.
+	rawName []byte
+}
+
+// Live returns whether the variable is the current value of its name, ie. it
+// has been fully added and not deleted.
+func (v *Variable) Live() bool {
+	return v.State == VariableStateAdded || v.State == VariableStateAdded&VariableStateInDeletedTransition
+}
+
+// serializedName returns the UCS-2 name of the variable as stored, or nil if
+// it cannot be encoded.
+func (v *Variable) serializedName() []byte {
+	if v.rawName != nil {
+		if name, err := decodeUCS2(v.rawName); err == nil && name == v.Name {
+			return v.rawName
+		}
+	}
+	name, err := encodeUCS2(v.Name)
+	if err != nil {
+		return nil
+	}
+	return name
+}
+
+// VariableStore is a VSS/NVRAM region of EFI variables, as found in the
+// non-volatile data volume of a firmware image.
+type VariableStore struct {
+	Kind VariableStoreKind
+	// Size of the store including its header, as declared in the header.
+	// Serialize pads the store to this size.
+	Size   uint32
+	Format uint8
+	State  uint8
+	// Variables in the order they are stored, including deleted ones.
+	Variables []*Variable
+
+	reserved [6]byte
+	// tail is the data following the last variable as read, and tailOffset
+	// its offset. It is kept if the variables still end at the same offset.
+	tail       []byte
+	tailOffset int
+}
+
+// IsVariableStore returns whether data starts with a variable store header.
+func IsVariableStore(data []byte) bool {
+	_, ok := variableStoreKind(data)
+	return ok
+}
+
+func variableStoreKind(data []byte) (VariableStoreKind, bool) {
+	if len(data) >= 4 && string(data[:4]) == "$VSS" {
+		return VariableStoreVSS, true
+	}
+	if len(data) >= 16 {
+		var g GUID
+		copy(g[:], data)
+		switch g {
+		case guidVariableStore:
+			return VariableStoreGUID, true
+		case guidAuthVariableStore:
+			return VariableStoreAuthenticated, true
+		}
+	}
+	return 0, false
+}
+
+// ParseVariableStore parses a variable store at the start of data. Parsing
+// stops at the first slot not containing a variable header, which is where new
+// variables would be written.
+func ParseVariableStore(data []byte) (*VariableStore, error) {
+	kind, ok := variableStoreKind(data)
+	if !ok {
+		return nil, fmt.Errorf("no variable store signature")
+	}
+	hsize := kind.headerSize()
+	if len(data) < hsize {
+		return nil, fmt.Errorf("variable store header truncated")
+	}
+	s := &VariableStore{
+		Kind: kind,
+	}
+	h := data[hsize-0xc:]
+	s.Size = binary.LittleEndian.Uint32(h[0:])
+	s.Format = h[4]
+	s.State = h[5]
+	copy(s.reserved[:], h[6:12])
+	if int(s.Size) < hsize || int(s.Size) > len(data) {
+		return nil, fmt.Errorf("variable store size 0x%x out of range (0x%x available)", s.Size, len(data))
+	}
+
+	vhsize := kind.variableHeaderSize()
+	store := data[:s.Size]
+	offset := hsize
+	for offset+vhsize <= len(store) {
+		vh := store[offset : offset+vhsize]
+		if binary.LittleEndian.Uint16(vh) != variableStartID {
+			break
+		}
+		v := &Variable{
+			State:      vh[2],
+			reserved:   vh[3],
+			Attributes: VariableAttributes(binary.LittleEndian.Uint32(vh[4:])),
+			ReadOffset: offset,
+		}
+		rest := vh[8:]
+		if kind == VariableStoreAuthenticated {
+			v.MonotonicCount = binary.LittleEndian.Uint64(rest)
+			copy(v.Timestamp[:], rest[8:24])
+			v.PubKeyIndex = binary.LittleEndian.Uint32(rest[24:])
+			rest = rest[28:]
+		}
+		nameSize := binary.LittleEndian.Uint32(rest[0:])
+		dataSize := binary.LittleEndian.Uint32(rest[4:])
+		copy(v.GUID[:], rest[8:24])
+		if v.State == VariableStateHeaderValidOnly {
+			// Write was interrupted after the header, sizes are not
+			// trustworthy.
+			return nil, fmt.Errorf("variable at 0x%x: only header is valid", offset)
+		}
+		end := uint64(offset) + uint64(vhsize) + uint64(nameSize) + uint64(dataSize)
+		if end > uint64(len(store)) {
+			return nil, fmt.Errorf("variable at 0x%x: size out of range", offset)
+		}
+		nameStart := offset + vhsize
+		name, err := decodeUCS2(store[nameStart : nameStart+int(nameSize)])
+		if err != nil {
+			return nil, fmt.Errorf("variable at 0x%x: invalid name: %w", offset, err)
+		}
+		v.Name = name
+		v.rawName = append([]byte{}, store[nameStart:nameStart+int(nameSize)]...)
+		dataStart := nameStart + int(nameSize)
+		v.Data = append([]byte{}, store[dataStart:dataStart+int(dataSize)]...)
+		s.Variables = append(s.Variables, v)
+
+		offset = int(end)
+		if offset%4 != 0 {
+			offset += 4 - offset%4
+		}
+	}
+	if offset < len(store) {
+		s.tail = append([]byte{}, store[offset:]...)
+		s.tailOffset = offset
+	}
+	return s, nil
+}
+
+// Lookup returns the live variable with the given name and GUID.
+func (s *VariableStore) Lookup(guid GUID, name string) (*Variable, error) {
+	for _, v := range s.Variables {
+		if v.Live() && v.GUID == guid && v.Name == name {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s:%s", ErrVariableNotFound, guid, name)
+}
+
+// Set sets the data of the live variable with the given name and GUID, or adds
+// it as a new non-volatile variable accessible at boot and runtime if it does
+// not exist yet. If the store would overflow, an error wrapping
+// ErrVariableStoreFull is returned and the store is left unmodified.
+func (s *VariableStore) Set(guid GUID, name string, data []byte) error {
+	if _, err := encodeUCS2(name); err != nil {
+		return fmt.Errorf("invalid name: %w", err)
+	}
+	v, err := s.Lookup(guid, name)
+	if err != nil {
+		v = &Variable{
+			State:      VariableStateAdded,
+			Attributes: VariableNonVolatile | VariableBootserviceAccess | VariableRuntimeAccess,
+			GUID:       guid,
+			Name:       name,
+			Data:       data,
+		}
+		s.Variables = append(s.Variables, v)
+		if err := s.checkFits(); err != nil {
+			s.Variables = s.Variables[:len(s.Variables)-1]
+			return err
+		}
+		return nil
+	}
+	old := v.Data
+	v.Data = data
+	if err := s.checkFits(); err != nil {
+		v.Data = old
+		return err
+	}
+	return nil
+}
+
+// Delete removes the live variable with the given name and GUID.
+func (s *VariableStore) Delete(guid GUID, name string) error {
+	for i, v := range s.Variables {
+		if v.Live() && v.GUID == guid && v.Name == name {
+			s.Variables = append(s.Variables[:i:i], s.Variables[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s:%s", ErrVariableNotFound, guid, name)
+}
+
+// Compact removes all variables which are not live, reclaiming their space.
+func (s *VariableStore) Compact() {
+	var live []*Variable
+	for _, v := range s.Variables {
+		if v.Live() {
+			live = append(live, v)
+		}
+	}
+	s.Variables = live
+}
+
+// usedSize returns how many bytes of the store are used by its header and
+// variables.
+func (s *VariableStore) usedSize() int {
+	size := s.Kind.headerSize()
+	for _, v := range s.Variables {
+		size += s.variableSize(v)
+	}
+	return size
+}
+
+func (s *VariableStore) variableSize(v *Variable) int {
+	size := s.Kind.variableHeaderSize() + len(v.serializedName()) + len(v.Data)
+	if size%4 != 0 {
+		size += 4 - size%4
+	}
+	return size
+}
+
+func (s *VariableStore) checkFits() error {
+	if used := s.usedSize(); used > int(s.Size) {
+		return fmt.Errorf("%w: needs 0x%x bytes, has 0x%x", ErrVariableStoreFull, used, s.Size)
+	}
+	return nil
+}
+
+// Serialize returns the variable store, padded with erased (0xff) bytes to its
+// declared size.
+func (s *VariableStore) Serialize() ([]byte, error) {
+	if err := s.checkFits(); err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	switch s.Kind {
+	case VariableStoreVSS:
+		buf.WriteString("$VSS")
+	case VariableStoreGUID:
+		buf.Write(guidVariableStore[:])
+	case VariableStoreAuthenticated:
+		buf.Write(guidAuthVariableStore[:])
+	default:
+		return nil, fmt.Errorf("unknown variable store kind %s", s.Kind)
+	}
+	binary.Write(buf, binary.LittleEndian, s.Size)
+	buf.WriteByte(s.Format)
+	buf.WriteByte(s.State)
+	buf.Write(s.reserved[:])
+
+	for _, v := range s.Variables {
+		name := v.serializedName()
+		if name == nil {
+			return nil, fmt.Errorf("variable %q: name cannot be represented in UCS-2", v.Name)
+		}
+		start := buf.Len()
+		binary.Write(buf, binary.LittleEndian, uint16(variableStartID))
+		buf.WriteByte(v.State)
+		buf.WriteByte(v.reserved)
+		binary.Write(buf, binary.LittleEndian, uint32(v.Attributes))
+		if s.Kind == VariableStoreAuthenticated {
+			binary.Write(buf, binary.LittleEndian, v.MonotonicCount)
+			buf.Write(v.Timestamp[:])
+			binary.Write(buf, binary.LittleEndian, v.PubKeyIndex)
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(len(name)))
+		binary.Write(buf, binary.LittleEndian, uint32(len(v.Data)))
+		buf.Write(v.GUID[:])
+		buf.Write(name)
+		buf.Write(v.Data)
+		if size := buf.Len() - start; size%4 != 0 {
+			buf.Write(bytes.Repeat([]byte{0xff}, 4-size%4))
+		}
+	}
+	if s.tail != nil && buf.Len() == s.tailOffset {
+		buf.Write(s.tail)
+	} else {
+		buf.Write(bytes.Repeat([]byte{0xff}, int(s.Size)-buf.Len()))
+	}
+	return buf.Bytes(), nil
+}
+
+// FindVariableStores returns the offsets of all variable stores within data,
+// eg. a full firmware image.
+func FindVariableStores(data []byte) []int {
+	var res []int
+	for i := 0; i+16 <= len(data); i += 4 {
+		if !IsVariableStore(data[i:]) {
+			continue
+		}
+		if _, err := ParseVariableStore(data[i:]); err != nil {
+			continue
+		}
+		res = append(res, i)
+	}
+	return res
+}
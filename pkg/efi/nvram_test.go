@@ -0,0 +1,70 @@
+package efi
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVariableStore(t *testing.T) {
+	global := MustParseGUID("8be4df61-93ca-11d2-aa0d-00e098032b8c")
+	s := &VariableStore{
+		Kind:   VariableStoreVSS,
+		Size:   0x100,
+		Format: 0x5a,
+		State:  0xfe,
+	}
+	if err := s.Set(global, "BootOrder", []byte{0x00, 0x00}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := s.Set(global, "Timeout", []byte{0x05, 0x00}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	data, err := s.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if len(data) != 0x100 {
+		t.Fatalf("store is 0x%x bytes, wanted 0x100", len(data))
+	}
+	if !IsVariableStore(data) {
+		t.Fatalf("serialized store not detected")
+	}
+
+	s2, err := ParseVariableStore(data)
+	if err != nil {
+		t.Fatalf("ParseVariableStore: %v", err)
+	}
+	if want, got := 2, len(s2.Variables); want != got {
+		t.Fatalf("wanted %d variables, got %d", want, got)
+	}
+	v, err := s2.Lookup(global, "Timeout")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if !bytes.Equal(v.Data, []byte{0x05, 0x00}) || !v.Live() {
+		t.Errorf("unexpected variable %+v", v)
+	}
+	data2, err := s2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized store differs from original")
+	}
+
+	if err := s2.Set(global, "Timeout", make([]byte, 0x100)); !errors.Is(err, ErrVariableStoreFull) {
+		t.Errorf("Set of oversized variable: got %v, wanted ErrVariableStoreFull", err)
+	}
+	if err := s2.Delete(global, "BootOrder"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s2.Lookup(global, "BootOrder"); !errors.Is(err, ErrVariableNotFound) {
+		t.Errorf("Lookup of deleted variable: got %v, wanted ErrVariableNotFound", err)
+	}
+
+	image := append(bytes.Repeat([]byte{0xff}, 0x40), data...)
+	if want, got := []int{0x40}, FindVariableStores(image); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("FindVariableStores: wanted %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,203 @@
+package efi
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ParseOptions configure how ReadVolume parses a volume.
+type ParseOptions struct {
+	// Strict makes parsing fail on any violation of the EFI specification.
+	// Otherwise, violations which do not prevent parsing are recorded as
+	// Warnings in the resulting Volume.
+	Strict bool
+	// Quirks to parse the top-level volume with. If nil, they are
+	// automatically selected from KnownQuirks.
+	Quirks Quirks
+	// MaxDepth is the maximum nesting depth of encapsulation sections and
+	// volumes. Zero means defaultMaxDepth.
+	MaxDepth int
+	// MaxDecompressedSize is the maximum size in bytes of data that any
+	// single compressed section may decompress to. Zero means
+	// defaultMaxDecompressedSize.
+	MaxDecompressedSize uint64
+	// PreserveLayout records the exact bytes and offsets of all files as
+	// read, so that files which have not been modified serialize back to
+	// exactly the same bytes at the same offsets, as long as the files
+	// before them did not grow. This includes non-erased alignment padding
+	// and invalid checksums.
+	PreserveLayout bool
+	// Parallelism is the number of files of the top-level volume whose
+	// sections are parsed concurrently. Zero or one parses all files
+	// sequentially. Either way, files and warnings end up in the same order.
+	Parallelism int
+	// Lazy defers decompressing and parsing the contents of compressed
+	// sections until they are first accessed through Section.Sub. This speeds
+	// up working with a few files within a large volume. As Sub cannot return
+	// errors, problems found when parsing deferred sections are only logged,
+	// and not recorded as Warnings; use Volume.Load to get them.
+	Lazy bool
+}
+
+const (
+	defaultMaxDepth            = 16
+	defaultMaxDecompressedSize = 256 << 20
+)
+
+// Warning is a non-fatal problem encountered when parsing a volume in lenient
+// (non-strict) mode.
+type Warning struct {
+	// Offset within the parsed data at which the problem was encountered.
+	// For data within compressed sections, this is the offset of the
+	// compressed section.
+	Offset  int
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("@%08x: %s", w.Offset, w.Message)
+}
+
+// parser keeps track of state while parsing a volume and everything nested
+// within it.
+type parser struct {
+	opts     ParseOptions
+	warnings []Warning
+	// offset is the offset of the outermost compressed/guided section being
+	// parsed, if any. Data within such sections is read from a new
+	// NestedReader, and thus offsets within it are meaningless to the user.
+	offset *int
+	// fileSystem is the file system GUID of the volume being parsed.
+	fileSystem GUID
+	// erase is the value of an erased byte within the volume being parsed.
+	erase byte
+	// depth is the current nesting depth of encapsulation sections.
+	depth int
+}
+
+func newParser(opts *ParseOptions) *parser {
+	p := &parser{}
+	if opts != nil {
+		p.opts = *opts
+	}
+	return p
+}
+
+// fork returns a parser in the same state as p, but recording its own
+// warnings, so that it can be used concurrently with p.
+func (p *parser) fork() *parser {
+	return &parser{
+		opts:       p.opts,
+		offset:     p.offset,
+		fileSystem: p.fileSystem,
+		erase:      p.erase,
+		depth:      p.depth,
+	}
+}
+
+// pendingFile is a file read by readFileHeader whose sections are yet to be
+// parsed by its own forked parser.
+type pendingFile struct {
+	p     *parser
+	index int
+	file  *FirmwareFile
+	data  *NestedReader
+}
+
+// readPendingFiles parses the sections of files using opts.Parallelism
+// workers. The warnings of all files are then returned in file order.
+func (p *parser) readPendingFiles(pending []pendingFile) ([]Warning, error) {
+	errs := make([]error, len(pending))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < p.opts.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				f := pending[j]
+				if f.data != nil {
+					errs[j] = f.p.readFileSections(f.file, f.data)
+				}
+			}
+		}()
+	}
+	for j := range pending {
+		work <- j
+	}
+	close(work)
+	wg.Wait()
+
+	var warnings []Warning
+	for j, f := range pending {
+		if errs[j] != nil {
+			return nil, fmt.Errorf("reading file %d failed: %v", f.index, errs[j])
+		}
+		warnings = append(warnings, f.p.warnings...)
+	}
+	return warnings, nil
+}
+
+// enter marks that data decompressed from a section at the given offset is
+// about to be parsed. The returned function must be called when done.
+func (p *parser) enter(offset int) func() {
+	if p.offset != nil {
+		return func() {}
+	}
+	p.offset = &offset
+	return func() {
+		p.offset = nil
+	}
+}
+
+// warnf records a spec violation found at a given reader offset. In strict
+// mode, it is returned as an error instead, which the caller must then return.
+func (p *parser) warnf(offset int, format string, args ...interface{}) error {
+	if p.offset != nil {
+		offset = *p.offset
+	}
+	msg := fmt.Sprintf(format, args...)
+	if p.opts.Strict {
+		return fmt.Errorf("@%08x: %s", offset, msg)
+	}
+	p.warnings = append(p.warnings, Warning{
+		Offset:  offset,
+		Message: msg,
+	})
+	return nil
+}
+
+// descend marks that an encapsulation section is about to be parsed, failing
+// if that would exceed the maximum nesting depth. The returned function must
+// be called when done.
+func (p *parser) descend() (func(), error) {
+	max := p.opts.MaxDepth
+	if max == 0 {
+		max = defaultMaxDepth
+	}
+	if p.depth >= max {
+		return nil, fmt.Errorf("sections nested deeper than %d levels", max)
+	}
+	p.depth++
+	return func() {
+		p.depth--
+	}, nil
+}
+
+// maxDecompressedSize returns the maximum size of data that any single
+// compressed section may decompress to.
+func (p *parser) maxDecompressedSize() uint64 {
+	if p.opts.MaxDecompressedSize == 0 {
+		return defaultMaxDecompressedSize
+	}
+	return p.opts.MaxDecompressedSize
+}
+
+// checkDecompressedSize fails if a compressed section claims to decompress
+// to more than the maximum allowed size.
+func (p *parser) checkDecompressedSize(size uint64) error {
+	if max := p.maxDecompressedSize(); size > max {
+		return fmt.Errorf("decompressed size %d exceeds maximum of %d bytes", size, max)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package efi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/efi/lzma"
+)
+
+// TestReadVolumeMalformed makes sure that truncated and corrupted volumes
+// result in errors or warnings, not panics.
+func TestReadVolumeMalformed(t *testing.T) {
+	raw, _ := NewLeafSection(SectionTypeRaw, []byte("hello, world"))
+	crc, err := NewGUIDDefinedSection(SectionDefinitionCRC32, raw)
+	if err != nil {
+		t.Fatalf("NewGUIDDefinedSection: %v", err)
+	}
+	lzma, err := NewGUIDDefinedSection(SectionDefinitionLZMA, crc)
+	if err != nil {
+		t.Fatalf("NewGUIDDefinedSection: %v", err)
+	}
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			NewDriverFile(GUID{0x01}, lzma),
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	parse := func(desc string, data []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("%s: panic: %v", desc, r)
+			}
+		}()
+		ReadVolume(NewNestedReader(data), nil)
+	}
+	for i := 0; i < len(data); i++ {
+		parse("truncated", data[:i])
+	}
+	for i := 0; i < len(data); i++ {
+		for _, b := range []byte{0x00, 0x01, 0x80, 0xff} {
+			corrupted := append([]byte{}, data...)
+			corrupted[i] ^= b
+			parse("corrupted", corrupted)
+		}
+	}
+}
+
+func TestReadSectionDepthLimit(t *testing.T) {
+	var section Section
+	section, _ = NewLeafSection(SectionTypeRaw, []byte("deep"))
+	for i := 0; i < 20; i++ {
+		var err error
+		section, err = NewCompressedSection(CompressionTypeNone, section)
+		if err != nil {
+			t.Fatalf("NewCompressedSection: %v", err)
+		}
+	}
+	data, err := section.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if _, err := newParser(nil).readSection(NewNestedReader(data)); err == nil {
+		t.Errorf("readSection succeeded, wanted depth limit error")
+	}
+	if _, err := newParser(&ParseOptions{MaxDepth: 32}).readSection(NewNestedReader(data)); err != nil {
+		t.Errorf("readSection with MaxDepth 32: %v", err)
+	}
+}
+
+func TestReadVolumeParallel(t *testing.T) {
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+	}
+	for i := 0; i < 8; i++ {
+		raw, _ := NewLeafSection(SectionTypeRaw, bytes.Repeat([]byte{byte(i)}, 0x100*i))
+		lzma, err := NewGUIDDefinedSection(SectionDefinitionLZMA, raw)
+		if err != nil {
+			t.Fatalf("NewGUIDDefinedSection: %v", err)
+		}
+		v.Files = append(v.Files, NewFreeformFile(GUID{byte(i + 1)}, lzma))
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	// Corrupt the data checksums of some files to get warnings.
+	read, err := ReadVolume(NewNestedReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	for _, i := range []int{2, 5} {
+		data[read.Files[i].ReadOffset+0x11] ^= 0xff
+	}
+
+	sequential, err := ReadVolume(NewNestedReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	parallel, err := ReadVolume(NewNestedReader(data), &ParseOptions{Parallelism: 4})
+	if err != nil {
+		t.Fatalf("ReadVolume (parallel): %v", err)
+	}
+	if len(sequential.Warnings) != 2 {
+		t.Errorf("got %d warnings, wanted 2", len(sequential.Warnings))
+	}
+	if !reflect.DeepEqual(sequential.Warnings, parallel.Warnings) {
+		t.Errorf("parallel warnings %v differ from sequential %v", parallel.Warnings, sequential.Warnings)
+	}
+	for i, f := range parallel.Files {
+		if want, got := sequential.Files[i].GUID, f.GUID; want != got {
+			t.Errorf("file %d is %s, wanted %s", i, got, want)
+		}
+	}
+	want, _ := sequential.Serialize()
+	got, err := parallel.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("parallel parse serializes differently")
+	}
+}
+
+func TestReadVolumeLazy(t *testing.T) {
+	raw, _ := NewLeafSection(SectionTypeRaw, []byte("hello, world"))
+	good, _ := NewGUIDDefinedSection(SectionDefinitionLZMA, raw)
+	// An LZMA section whose contents are not valid sections.
+	garbage, err := lzma.Compress([]byte("garbage!"))
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	// Pretend it's unmodified, so that its compressed data is used as is.
+	clean := &leafSection{commonSectionHeader: commonSectionHeader{Type: SectionTypeRaw}}
+	bad := &guidSection{
+		commonSectionHeader: commonSectionHeader{Type: SectionTypeGUIDDefined},
+		custom:              []byte{},
+		cache:               compressionCache{uncompressed: []byte("garbage!"), compressed: garbage},
+		sub:                 []Section{clean},
+		readSub:             []Section{clean},
+	}
+	bad.extra.SectionDefinitionGUID = guidLZMA
+	bad.extra.Attributes = GUIDedSectionProcessingRequired
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			NewFreeformFile(GUID{0x01}, good),
+			NewFreeformFile(GUID{0x02}, bad),
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if _, err := ReadVolume(NewNestedReader(data), nil); err == nil {
+		t.Fatalf("ReadVolume of invalid section succeeded")
+	}
+
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Lazy: true})
+	if err != nil {
+		t.Fatalf("ReadVolume (lazy): %v", err)
+	}
+	lazy := v2.Files[0].Sections[0].(*guidSection)
+	if !lazy.lazy.pending() {
+		t.Errorf("section parsed eagerly")
+	}
+	data2, err := v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+	if !lazy.lazy.pending() {
+		t.Errorf("section parsed by Serialize")
+	}
+	if sub := lazy.Sub(); len(sub) != 1 || string(sub[0].Raw()) != "hello, world" {
+		t.Errorf("deferred section parsed wrongly: %v", sub)
+	}
+	if err := v2.Load(); err == nil {
+		t.Errorf("Load of invalid section succeeded")
+	}
+	if data2, err = v2.Serialize(); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original after failed Load")
+	}
+}
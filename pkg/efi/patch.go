@@ -0,0 +1,110 @@
+package efi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPatchMismatch is returned when the data to be patched does not match the
+// data expected by a patch.
+var ErrPatchMismatch = errors.New("data does not match")
+
+// HexBytes is a byte slice which is represented as a hex string in text
+// formats like JSON. Whitespace is ignored when unmarshaling.
+type HexBytes []byte
+
+func (h HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+func (h *HexBytes) UnmarshalText(text []byte) error {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(string(text)), ""))
+	if err != nil {
+		return err
+	}
+	*h = data
+	return nil
+}
+
+// Patch replaces bytes within the data of a leaf section or raw file, if they
+// match expected data.
+type Patch struct {
+	// File selects the file to patch, as the first component of a path
+	// expression (see Volume.Lookup): a GUID, index or name:<name>.
+	File string `json:"file"`
+	// Section selects the section to patch within the file, as the
+	// remaining components of a path expression, eg. "section:pe32". If
+	// empty, the data of a raw file is patched.
+	Section string `json:"section,omitempty"`
+	// Offset within the section's (or raw file's) data.
+	Offset int `json:"offset"`
+	// Old is the data expected at Offset.
+	Old HexBytes `json:"old"`
+	// New is the data to put at Offset. It must be the same length as Old.
+	New HexBytes `json:"new"`
+}
+
+func (p *Patch) path() string {
+	if p.Section == "" {
+		return p.File
+	}
+	return p.File + "/" + p.Section
+}
+
+// ApplyPatches applies patches to the volume. All patches are verified before
+// any of them are applied, so if an error is returned the volume is left
+// unmodified. Patches are applied in order, so a patch may expect data
+// written by a previous one.
+func (v *Volume) ApplyPatches(patches []Patch) error {
+	type target struct {
+		el   *Element
+		data []byte
+	}
+	var targets []*target
+	byPath := make(map[string]*target)
+	for i, p := range patches {
+		el, err := v.Lookup(p.path())
+		if err != nil {
+			return fmt.Errorf("patch %d: %w", i, err)
+		}
+		key := pathString(el.Path)
+		t, ok := byPath[key]
+		if !ok {
+			t = &target{el: el}
+			switch {
+			case el.Section != nil:
+				t.data = el.Section.Raw()
+			case el.File.FileType == FileTypeRaw:
+				t.data = append([]byte{}, el.File.Data...)
+			}
+			if t.data == nil {
+				return fmt.Errorf("patch %d: %s has no data to patch", i, p.path())
+			}
+			byPath[key] = t
+			targets = append(targets, t)
+		}
+
+		if len(p.Old) != len(p.New) {
+			return fmt.Errorf("patch %d: old and new data differ in length (%d vs. %d)", i, len(p.Old), len(p.New))
+		}
+		if p.Offset < 0 || p.Offset+len(p.Old) > len(t.data) {
+			return fmt.Errorf("patch %d: range 0x%x-0x%x out of bounds (size 0x%x)", i, p.Offset, p.Offset+len(p.Old), len(t.data))
+		}
+		if got := t.data[p.Offset : p.Offset+len(p.Old)]; !bytes.Equal(got, p.Old) {
+			return fmt.Errorf("patch %d: %w at 0x%x: wanted %x, got %x", i, ErrPatchMismatch, p.Offset, []byte(p.Old), got)
+		}
+		copy(t.data[p.Offset:], p.New)
+	}
+
+	for _, t := range targets {
+		if t.el.Section != nil {
+			t.el.Section.SetRaw(t.data)
+		} else {
+			t.el.File.Data = t.data
+		}
+	}
+	return nil
+}
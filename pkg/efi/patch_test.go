@@ -0,0 +1,45 @@
+package efi
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestApplyPatches(t *testing.T) {
+	pe32, _ := NewLeafSection(SectionTypePE32, []byte("check signature"))
+	v := &Volume{
+		Files: []*FirmwareFile{
+			NewDriverFile(GUID{0x01}, pe32),
+			NewRawFile(GUID{0x02}, []byte("raw")),
+		},
+	}
+
+	var patches []Patch
+	err := json.Unmarshal([]byte(`[
+		{"file": "00000001-0000-0000-0000-000000000000", "section": "section:pe32", "offset": 0, "old": "636865636b", "new": "736b697020"},
+		{"file": "1", "offset": 1, "old": "61", "new": "41"}
+	]`), &patches)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	bad := append([]Patch{}, patches...)
+	bad = append(bad, Patch{File: "0", Section: "0", Offset: 0, Old: HexBytes("check"), New: HexBytes("CHECK")})
+	if err := v.ApplyPatches(bad); !errors.Is(err, ErrPatchMismatch) {
+		t.Fatalf("ApplyPatches with mismatch: wanted ErrPatchMismatch, got %v", err)
+	}
+	if want, got := "check signature", string(pe32.Raw()); want != got {
+		t.Errorf("failed patching modified data: wanted %q, got %q", want, got)
+	}
+
+	if err := v.ApplyPatches(patches); err != nil {
+		t.Fatalf("ApplyPatches: %v", err)
+	}
+	if want, got := "skip  signature", string(pe32.Raw()); want != got {
+		t.Errorf("wanted section data %q, got %q", want, got)
+	}
+	if want, got := "rAw", string(v.Files[1].Data); want != got {
+		t.Errorf("wanted raw file data %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,170 @@
+package efi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrSectionNotFound is returned when a path expression does not match any
+// section.
+var ErrSectionNotFound = errors.New("section not found")
+
+// Element is a file or section within a volume, as returned by Volume.Lookup.
+type Element struct {
+	// Path of the element, as passed to a WalkFunc by Volume.Walk.
+	Path []int
+	// File is the file containing the element, or the element itself if
+	// Section is nil.
+	File *FirmwareFile
+	// Section is the addressed section, or nil if the path addresses a file.
+	Section Section
+}
+
+// Lookup resolves a path expression to a file or section within the volume.
+//
+// A path expression is a list of components separated by slashes. The first
+// component selects a file within the volume, and is one of:
+//
+//   - a file index, eg. "3",
+//   - a file GUID, eg. "d6a2cb7f-6a18-4e2f-b43b-9920a733700a",
+//   - a well-known file name or the name in a file's user interface
+//     section, eg. "name:DxeCore".
+//
+// Subsequent components select sections, and are one of:
+//
+//   - a section index within the parent's sections, eg. "0",
+//   - a section type, eg. "section:pe32", which selects the first section of
+//     that type at any depth below the parent (but not within nested
+//     volumes),
+//   - a freeform section subtype GUID or well-known name, eg.
+//     "subtype:<guid>", which selects the first freeform section with that
+//     subtype at any depth below the parent.
+//
+// A component following a firmware volume image section selects a file
+// within that nested volume again. For example, "name:DxeCore/section:pe32"
+// addresses the code of the DXE core, wherever it's nested in compressed or
+// GUID-defined sections.
+func (v *Volume) Lookup(expr string) (*Element, error) {
+	expr = strings.Trim(expr, "/")
+	if expr == "" {
+		return nil, errors.New("empty path")
+	}
+	res := &Element{}
+	vol := v
+	var sections []Section
+	for _, part := range strings.Split(expr, "/") {
+		if vol != nil {
+			i, err := vol.lookupFile(part)
+			if err != nil {
+				return nil, err
+			}
+			res.Path = appendPath(res.Path, i)
+			res.File = vol.Files[i]
+			res.Section = nil
+			sections = res.File.Sections
+			vol = nil
+			continue
+		}
+
+		path, section, err := lookupSection(sections, part)
+		if err != nil {
+			return nil, err
+		}
+		res.Path = append(append([]int{}, res.Path...), path...)
+		res.Section = section
+		if vs, ok := section.(FirmwareVolumeSection); ok {
+			vol = vs.Volume()
+			sections = nil
+		} else {
+			sections = section.Sub()
+		}
+	}
+	return res, nil
+}
+
+// lookupFile returns the index of the file selected by a path component.
+func (v *Volume) lookupFile(part string) (int, error) {
+	if i, err := strconv.Atoi(part); err == nil {
+		if i < 0 || i >= len(v.Files) {
+			return 0, fmt.Errorf("%w: index %d out of range", ErrFileNotFound, i)
+		}
+		return i, nil
+	}
+	if name := strings.TrimPrefix(part, "name:"); name != part {
+		for i, f := range v.Files {
+			if n := guidName(f.GUID); n != "" && strings.EqualFold(n, name) {
+				return i, nil
+			}
+			if n := f.UIName(); n != "" && strings.EqualFold(n, name) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%w: no file named %q", ErrFileNotFound, name)
+	}
+	for i, f := range v.Files {
+		if strings.EqualFold(f.GUID.String(), part) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrFileNotFound, part)
+}
+
+// lookupSection returns the section selected by a path component, and its
+// path relative to sections.
+func lookupSection(sections []Section, part string) ([]int, Section, error) {
+	if i, err := strconv.Atoi(part); err == nil {
+		if i < 0 || i >= len(sections) {
+			return nil, nil, fmt.Errorf("%w: index %d out of range", ErrSectionNotFound, i)
+		}
+		return []int{i}, sections[i], nil
+	}
+	if typ := strings.TrimPrefix(part, "section:"); typ != part {
+		if path, section := findSection(nil, sections, typ); section != nil {
+			return path, section, nil
+		}
+		return nil, nil, fmt.Errorf("%w: no %s section", ErrSectionNotFound, typ)
+	}
+	if subtype := strings.TrimPrefix(part, "subtype:"); subtype != part {
+		path, section := findSectionFunc(nil, sections, func(s Section) bool {
+			fs, ok := s.(FreeformSection)
+			if !ok {
+				return false
+			}
+			g := fs.SubtypeGUID()
+			return strings.EqualFold(g.String(), subtype) || strings.EqualFold(guidName(g), subtype)
+		})
+		if section != nil {
+			return path, section, nil
+		}
+		return nil, nil, fmt.Errorf("%w: no freeform section with subtype %s", ErrSectionNotFound, subtype)
+	}
+	return nil, nil, fmt.Errorf("invalid section selector %q", part)
+}
+
+// findSection performs a depth-first search for a section of a given type,
+// not descending into nested volumes.
+func findSection(prefix []int, sections []Section, typ string) ([]int, Section) {
+	return findSectionFunc(prefix, sections, func(s Section) bool {
+		return strings.EqualFold(s.Header().Type.String(), typ)
+	})
+}
+
+// findSectionFunc performs a depth-first search for a section matching a
+// predicate, not descending into nested volumes.
+func findSectionFunc(prefix []int, sections []Section, match func(Section) bool) ([]int, Section) {
+	for i, s := range sections {
+		path := appendPath(prefix, i)
+		if match(s) {
+			return path, s
+		}
+		if _, ok := s.(FirmwareVolumeSection); ok {
+			continue
+		}
+		if p, found := findSectionFunc(path, s.Sub(), match); found != nil {
+			return p, found
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,171 @@
+package efi
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Image is the parsed header of a PE32/PE32+ or TE executable image, as found
+// in PE32 and TE sections.
+type Image struct {
+	// TE is set if this is a Terse Executable image.
+	TE      bool
+	Machine uint16
+	// EntryPoint is the address of the entry point, relative to ImageBase.
+	EntryPoint uint32
+	ImageBase  uint64
+	Sections   []ImageSection
+}
+
+// ImageSection is an entry in the section table of an Image.
+type ImageSection struct {
+	Name string
+	// VirtualAddress of the section, relative to ImageBase.
+	VirtualAddress uint32
+	VirtualSize    uint32
+	// Offset of the section's data within the image data. For TE images this
+	// is already adjusted for the stripped header.
+	Offset uint32
+	Size   uint32
+}
+
+// teHeader is EFI_TE_IMAGE_HEADER.
+type teHeader struct {
+	Signature           [2]byte
+	Machine             uint16
+	NumberOfSections    uint8
+	Subsystem           uint8
+	StrippedSize        uint16
+	AddressOfEntryPoint uint32
+	BaseOfCode          uint32
+	ImageBase           uint64
+	DataDirectory       [2]struct {
+		VirtualAddress uint32
+		Size           uint32
+	}
+}
+
+const teHeaderSize = 0x28
+
+// SectionImage parses the executable image header within a PE32 or TE
+// section.
+func SectionImage(s Section) (*Image, error) {
+	switch s.Header().Type {
+	case SectionTypePE32:
+		return ParsePE32(s.Raw())
+	case SectionTypeTE:
+		return ParseTE(s.Raw())
+	default:
+		return nil, fmt.Errorf("%s section is not an executable image", s.Header().Type)
+	}
+}
+
+// ParsePE32 parses the header of a PE32/PE32+ image.
+func ParsePE32(data []byte) (*Image, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PE image: %w", err)
+	}
+	res := &Image{
+		Machine: f.Machine,
+	}
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		res.EntryPoint = oh.AddressOfEntryPoint
+		res.ImageBase = uint64(oh.ImageBase)
+	case *pe.OptionalHeader64:
+		res.EntryPoint = oh.AddressOfEntryPoint
+		res.ImageBase = oh.ImageBase
+	default:
+		return nil, fmt.Errorf("invalid PE image: no optional header")
+	}
+	for _, s := range f.Sections {
+		res.Sections = append(res.Sections, ImageSection{
+			Name:           s.Name,
+			VirtualAddress: s.VirtualAddress,
+			VirtualSize:    s.VirtualSize,
+			Offset:         s.Offset,
+			Size:           s.Size,
+		})
+	}
+	return res, nil
+}
+
+// ParseTE parses the header of a Terse Executable image.
+func ParseTE(data []byte) (*Image, error) {
+	r := bytes.NewReader(data)
+	var h teHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("invalid TE image: %w", err)
+	}
+	if h.Signature != [2]byte{'V', 'Z'} {
+		return nil, fmt.Errorf("invalid TE image: bad signature %q", h.Signature[:])
+	}
+	if int(h.StrippedSize) < teHeaderSize {
+		return nil, fmt.Errorf("invalid TE image: stripped size 0x%x too small", h.StrippedSize)
+	}
+	// Offsets within a TE image are relative to the original PE image, from
+	// which StrippedSize bytes were replaced by the TE header.
+	adjust := uint32(h.StrippedSize) - teHeaderSize
+	res := &Image{
+		TE:         true,
+		Machine:    h.Machine,
+		EntryPoint: h.AddressOfEntryPoint,
+		ImageBase:  h.ImageBase,
+	}
+	for i := 0; i < int(h.NumberOfSections); i++ {
+		var sh pe.SectionHeader32
+		if err := binary.Read(r, binary.LittleEndian, &sh); err != nil {
+			return nil, fmt.Errorf("invalid TE image: section %d: %w", i, err)
+		}
+		s := ImageSection{
+			Name:           strings.TrimRight(string(sh.Name[:]), "\x00"),
+			VirtualAddress: sh.VirtualAddress,
+			VirtualSize:    sh.VirtualSize,
+			Size:           sh.SizeOfRawData,
+		}
+		if sh.PointerToRawData != 0 {
+			if sh.PointerToRawData < adjust {
+				return nil, fmt.Errorf("invalid TE image: section %d: data at 0x%x within stripped header", i, sh.PointerToRawData)
+			}
+			s.Offset = sh.PointerToRawData - adjust
+		}
+		res.Sections = append(res.Sections, s)
+	}
+	return res, nil
+}
+
+// OffsetToVA translates an offset within the image data to a virtual address,
+// taking ImageBase into account.
+func (i *Image) OffsetToVA(offset uint32) (uint64, error) {
+	for _, s := range i.Sections {
+		if s.Size == 0 || offset < s.Offset || offset >= s.Offset+s.Size {
+			continue
+		}
+		return i.ImageBase + uint64(s.VirtualAddress+(offset-s.Offset)), nil
+	}
+	return 0, fmt.Errorf("offset 0x%x not within any image section", offset)
+}
+
+// VAToOffset translates a virtual address to an offset within the image data,
+// taking ImageBase into account.
+func (i *Image) VAToOffset(va uint64) (uint32, error) {
+	if va < i.ImageBase {
+		return 0, fmt.Errorf("address 0x%x below image base 0x%x", va, i.ImageBase)
+	}
+	rva := va - i.ImageBase
+	for _, s := range i.Sections {
+		size := s.Size
+		if s.VirtualSize != 0 && s.VirtualSize < size {
+			size = s.VirtualSize
+		}
+		if rva < uint64(s.VirtualAddress) || rva >= uint64(s.VirtualAddress)+uint64(size) {
+			continue
+		}
+		return s.Offset + uint32(rva-uint64(s.VirtualAddress)), nil
+	}
+	return 0, fmt.Errorf("address 0x%x not backed by image data", va)
+}
@@ -0,0 +1,59 @@
+package efi
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseTE(t *testing.T) {
+	// A TE image stripped of 0x100 bytes of PE header, with one .text section
+	// at RVA 0x1000 whose data was at 0x200 in the original PE image.
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, teHeader{
+		Signature:           [2]byte{'V', 'Z'},
+		Machine:             pe.IMAGE_FILE_MACHINE_ARM,
+		NumberOfSections:    1,
+		StrippedSize:        0x100,
+		AddressOfEntryPoint: 0x1010,
+		ImageBase:           0x8000_0000,
+	})
+	sh := pe.SectionHeader32{
+		VirtualAddress:   0x1000,
+		VirtualSize:      0x20,
+		SizeOfRawData:    0x20,
+		PointerToRawData: 0x200,
+	}
+	copy(sh.Name[:], ".text")
+	binary.Write(buf, binary.LittleEndian, sh)
+
+	image, err := ParseTE(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseTE: %v", err)
+	}
+	if want, got := uint16(pe.IMAGE_FILE_MACHINE_ARM), image.Machine; want != got {
+		t.Errorf("wanted machine %x, got %x", want, got)
+	}
+	if want, got := ".text", image.Sections[0].Name; want != got {
+		t.Errorf("wanted section %q, got %q", want, got)
+	}
+	// 0x200 in the PE image is 0x200 - 0x100 + 0x28 in the TE image.
+	if want, got := uint32(0x128), image.Sections[0].Offset; want != got {
+		t.Errorf("wanted section offset %x, got %x", want, got)
+	}
+	va, err := image.OffsetToVA(0x138)
+	if err != nil {
+		t.Fatalf("OffsetToVA: %v", err)
+	}
+	if want, got := uint64(0x8000_1010), va; want != got {
+		t.Errorf("wanted VA %x, got %x", want, got)
+	}
+	offset, err := image.VAToOffset(va)
+	if err != nil {
+		t.Fatalf("VAToOffset: %v", err)
+	}
+	if want, got := uint32(0x138), offset; want != got {
+		t.Errorf("wanted offset %x, got %x", want, got)
+	}
+}
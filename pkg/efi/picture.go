@@ -0,0 +1,122 @@
+package efi
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+
+	"github.com/freemyipod/wInd3x/pkg/bmp"
+)
+
+// PictureFormat is the encoding of a picture resource within a volume.
+type PictureFormat string
+
+const (
+	PictureBMP PictureFormat = "bmp"
+	PicturePNG PictureFormat = "png"
+)
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// Picture is a picture resource (eg. a boot logo) found by Volume.Pictures.
+type Picture struct {
+	// Path of the section (or raw file) containing the picture, as passed to
+	// a WalkFunc by Volume.Walk.
+	Path []int
+	// File containing the picture.
+	File *FirmwareFile
+	// Section containing the picture, or nil if the picture is the data of a
+	// raw file.
+	Section Section
+	Format  PictureFormat
+}
+
+func pictureFormat(data []byte) (PictureFormat, bool) {
+	switch {
+	case bmp.IsBMP(data):
+		return PictureBMP, true
+	case bytes.HasPrefix(data, pngSignature):
+		return PicturePNG, true
+	}
+	return "", false
+}
+
+// Pictures returns all picture resources within the leaf sections and raw
+// files of the volume, including nested volumes. Pictures are identified by
+// their content, and can be stored as BMP or PNG.
+func (v *Volume) Pictures() ([]Picture, error) {
+	var res []Picture
+	err := v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		var data []byte
+		switch {
+		case section == nil && file.FileType == FileTypeRaw:
+			data = file.Data
+		case section != nil:
+			data = section.Raw()
+		}
+		if format, ok := pictureFormat(data); ok {
+			res = append(res, Picture{
+				Path:    path,
+				File:    file,
+				Section: section,
+				Format:  format,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (p *Picture) data() []byte {
+	if p.Section == nil {
+		return p.File.Data
+	}
+	return p.Section.Raw()
+}
+
+// Decode decodes the picture.
+func (p *Picture) Decode() (image.Image, error) {
+	switch p.Format {
+	case PictureBMP:
+		img, _, err := bmp.Decode(p.data())
+		return img, err
+	case PicturePNG:
+		return png.Decode(bytes.NewReader(p.data()))
+	}
+	return nil, fmt.Errorf("unknown picture format %q", p.Format)
+}
+
+// Replace re-encodes img in the format of the picture (including the pixel
+// format of BMP pictures) and replaces the picture's data with it.
+func (p *Picture) Replace(img image.Image) error {
+	var data []byte
+	switch p.Format {
+	case PictureBMP:
+		_, format, err := bmp.Decode(p.data())
+		if err != nil {
+			return fmt.Errorf("could not decode original picture: %w", err)
+		}
+		data, err = bmp.Encode(img, format)
+		if err != nil {
+			return err
+		}
+	case PicturePNG:
+		buf := bytes.NewBuffer(nil)
+		if err := png.Encode(buf, img); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	default:
+		return fmt.Errorf("unknown picture format %q", p.Format)
+	}
+	if p.Section == nil {
+		p.File.Data = data
+	} else {
+		p.Section.SetRaw(data)
+	}
+	return nil
+}
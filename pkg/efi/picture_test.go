@@ -0,0 +1,54 @@
+package efi
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/bmp"
+)
+
+func TestPictures(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	logo, err := bmp.Encode(img, &bmp.Format{BitsPerPixel: 24})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	raw, err := NewLeafSection(SectionTypeRaw, logo)
+	if err != nil {
+		t.Fatalf("NewLeafSection: %v", err)
+	}
+	v := &Volume{
+		Files: []*FirmwareFile{
+			testRawFile(GUID{0x01}, 0x10),
+			NewFreeformFile(GUID{0x02}, raw),
+		},
+	}
+	pictures, err := v.Pictures()
+	if err != nil {
+		t.Fatalf("Pictures: %v", err)
+	}
+	if len(pictures) != 1 || pictures[0].Format != PictureBMP || pictures[0].Section != raw {
+		t.Fatalf("unexpected pictures %+v", pictures)
+	}
+
+	white := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 2; x++ {
+			white.Set(x, y, color.White)
+		}
+	}
+	if err := pictures[0].Replace(white); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	got, err := pictures[0].Decode()
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if b := got.Bounds(); b.Dx() != 2 || b.Dy() != 3 {
+		t.Errorf("replaced picture is %dx%d, wanted 2x3", b.Dx(), b.Dy())
+	}
+	if r, g, b, _ := got.At(1, 2).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Errorf("replaced picture pixel is not white")
+	}
+}
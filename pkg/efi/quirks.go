@@ -0,0 +1,89 @@
+package efi
+
+// Quirks describe how the layout of a firmware volume deviates from the EFI
+// specification, eg. due to bugs in the firmware build tooling used by a
+// given vendor or device.
+type Quirks interface {
+	// Name of this set of quirks, for diagnostics.
+	Name() string
+	// Applies returns whether this set of quirks should be used to parse a
+	// volume with the given header, whose blockmap describes blockmapSize
+	// bytes.
+	Applies(header *FirmwareVolumeHeader, blockmapSize uint32) bool
+	// DataSize returns the size of the file data area of a volume with the
+	// given header, whose blockmap describes blockmapSize bytes.
+	DataSize(header *FirmwareVolumeHeader, blockmapSize uint32) uint32
+	// Length returns the value of the Length header field for a volume with
+	// the given header length and file data size.
+	Length(headerLength uint16, dataSize uint64) uint64
+}
+
+var (
+	// QuirksNone is used for volumes laid out as per the EFI specification,
+	// eg. ones built by EDK2 and nested within Apple firmware.
+	QuirksNone Quirks = specQuirks{}
+	// QuirksApple is used for top-level volumes in Apple iPod firmware. In
+	// these, the Length field of the header does not include the header
+	// itself.
+	QuirksApple Quirks = appleQuirks{}
+
+	// KnownQuirks is the list of quirks automatically selected by ReadVolume,
+	// in order of precedence. The first entry which Applies to a volume is
+	// used. QuirksNone always applies, and thus should be last. Callers can
+	// prepend their own quirks to support other devices.
+	KnownQuirks = []Quirks{
+		QuirksApple,
+		QuirksNone,
+	}
+)
+
+// selectQuirks returns the first of KnownQuirks which applies to a volume.
+func selectQuirks(header *FirmwareVolumeHeader, blockmapSize uint32) Quirks {
+	for _, q := range KnownQuirks {
+		if q.Applies(header, blockmapSize) {
+			return q
+		}
+	}
+	return QuirksNone
+}
+
+type specQuirks struct{}
+
+func (specQuirks) Name() string {
+	return "none"
+}
+
+func (specQuirks) Applies(header *FirmwareVolumeHeader, blockmapSize uint32) bool {
+	return true
+}
+
+func (specQuirks) DataSize(header *FirmwareVolumeHeader, blockmapSize uint32) uint32 {
+	// The blockmap describes the entire volume, including its header.
+	return blockmapSize - uint32(header.HeaderLength)
+}
+
+func (specQuirks) Length(headerLength uint16, dataSize uint64) uint64 {
+	return uint64(headerLength) + dataSize
+}
+
+type appleQuirks struct{}
+
+func (appleQuirks) Name() string {
+	return "apple"
+}
+
+func (appleQuirks) Applies(header *FirmwareVolumeHeader, blockmapSize uint32) bool {
+	if header.GUID != guidFFS1 {
+		return false
+	}
+	return header.Length != uint64(blockmapSize)
+}
+
+func (appleQuirks) DataSize(header *FirmwareVolumeHeader, blockmapSize uint32) uint32 {
+	// Same as the spec: the blockmap does cover the header.
+	return blockmapSize - uint32(header.HeaderLength)
+}
+
+func (appleQuirks) Length(headerLength uint16, dataSize uint64) uint64 {
+	return dataSize
+}
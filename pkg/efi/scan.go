@@ -0,0 +1,136 @@
+package efi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// ScannedVolume is a firmware volume found within a larger image by
+// ScanVolumes.
+type ScannedVolume struct {
+	// Offset of the volume within the scanned reader.
+	Offset int
+	// Size of the volume as read, including its header.
+	Size   int
+	Volume *Volume
+}
+
+// volumeSignatureOffset is the offset of the _FVH signature within a volume
+// header.
+const volumeSignatureOffset = 0x28
+
+// volumeSize returns the size of the volume at the start of r, as described
+// by its header's blockmap.
+func volumeSize(r *NestedReader) (int, error) {
+	var header FirmwareVolumeHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return 0, fmt.Errorf("reading volume header failed: %w", err)
+	}
+	if err := header.check(); err != nil {
+		return 0, fmt.Errorf("volume header invalid: %w", err)
+	}
+	var total uint64
+	for i := 0; i < int(header.HeaderLength-0x38)/8; i++ {
+		var entry blockmap
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			return 0, fmt.Errorf("reading blockmap failed: %w", err)
+		}
+		total += uint64(entry.BlockCount) * uint64(entry.BlockSize)
+	}
+	if total > 0xffffffff || total < uint64(header.HeaderLength) {
+		return 0, fmt.Errorf("blockmap size (%d bytes) invalid", total)
+	}
+	return int(total), nil
+}
+
+// ScanVolumes finds and parses all firmware volumes within r, eg. a full NOR
+// dump, by looking for volume header signatures. Volumes nested within other
+// volumes are not returned separately. Candidates which fail to parse are
+// skipped, as they are likely just data which happens to contain a signature.
+func ScanVolumes(r *NestedReader, opts *ParseOptions) ([]*ScannedVolume, error) {
+	const chunkSize = 1 << 20
+	sig := []byte("_FVH")
+	var res []*ScannedVolume
+	// Only volumes starting at 8 byte aligned offsets are considered, as
+	// required for the files within them to be aligned.
+	end := 0
+	buf := make([]byte, chunkSize+len(sig))
+	for pos := 0; pos < r.Len(); pos += chunkSize {
+		n := chunkSize + len(sig)
+		if pos+n > r.Len() {
+			n = r.Len() - pos
+		}
+		if _, err := io.ReadFull(r.Sub(pos, n), buf[:n]); err != nil {
+			return nil, fmt.Errorf("reading at 0x%x failed: %w", pos, err)
+		}
+		for i := 0; i < n; {
+			j := bytes.Index(buf[i:n], sig)
+			if j == -1 {
+				break
+			}
+			i += j
+			offset := pos + i - volumeSignatureOffset
+			i++
+			if i > chunkSize || offset < end || offset%8 != 0 {
+				continue
+			}
+			v, size, err := scanVolume(r, offset, opts)
+			if err != nil {
+				glog.V(1).Infof("No volume at 0x%x: %v", offset, err)
+				continue
+			}
+			res = append(res, &ScannedVolume{
+				Offset: offset,
+				Size:   size,
+				Volume: v,
+			})
+			end = offset + size
+		}
+	}
+	return res, nil
+}
+
+func scanVolume(r *NestedReader, offset int, opts *ParseOptions) (*Volume, int, error) {
+	size, err := volumeSize(r.Sub(offset, r.Len()-offset))
+	if err != nil {
+		return nil, 0, err
+	}
+	if size > r.Len()-offset {
+		return nil, 0, fmt.Errorf("volume size 0x%x extends past end of image", size)
+	}
+	v, err := ReadVolume(r.Sub(offset, size), opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, size, nil
+}
+
+// SpliceVolumes returns a copy of image with the given scanned volumes
+// serialized back in place. Volumes must keep the size they were scanned
+// with.
+func SpliceVolumes(image []byte, volumes []*ScannedVolume) ([]byte, error) {
+	res := append([]byte{}, image...)
+	sorted := append([]*ScannedVolume{}, volumes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+	end := 0
+	for _, sv := range sorted {
+		if sv.Offset < end || sv.Offset+sv.Size > len(image) {
+			return nil, fmt.Errorf("volume at 0x%x: out of bounds or overlapping", sv.Offset)
+		}
+		end = sv.Offset + sv.Size
+		data, err := sv.Volume.Serialize()
+		if err != nil {
+			return nil, fmt.Errorf("volume at 0x%x: %w", sv.Offset, err)
+		}
+		if len(data) != sv.Size {
+			return nil, fmt.Errorf("volume at 0x%x: size changed from 0x%x to 0x%x bytes", sv.Offset, sv.Size, len(data))
+		}
+		copy(res[sv.Offset:], data)
+	}
+	return res, nil
+}
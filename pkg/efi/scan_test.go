@@ -0,0 +1,63 @@
+package efi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScanVolumes(t *testing.T) {
+	var volumes [][]byte
+	for i := 0; i < 2; i++ {
+		v, err := NewVolume(0x400)
+		if err != nil {
+			t.Fatalf("NewVolume: %v", err)
+		}
+		if err := v.InsertFile(0, NewRawFile(GUID{byte(i + 1)}, []byte("payload"))); err != nil {
+			t.Fatalf("InsertFile: %v", err)
+		}
+		data, err := v.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize: %v", err)
+		}
+		volumes = append(volumes, data)
+	}
+	junk := bytes.Repeat([]byte("_FVH"), 0x40)
+	image := append(append(append(append([]byte{}, junk...), volumes[0]...), junk...), volumes[1]...)
+
+	scanned, err := ScanVolumes(NewNestedReader(image), nil)
+	if err != nil {
+		t.Fatalf("ScanVolumes: %v", err)
+	}
+	if want, got := 2, len(scanned); want != got {
+		t.Fatalf("wanted %d volumes, got %d", want, got)
+	}
+	if want, got := len(junk), scanned[0].Offset; want != got {
+		t.Errorf("first volume at 0x%x, wanted 0x%x", got, want)
+	}
+	if want, got := 2*len(junk)+0x400, scanned[1].Offset; want != got {
+		t.Errorf("second volume at 0x%x, wanted 0x%x", got, want)
+	}
+
+	res, err := SpliceVolumes(image, scanned)
+	if err != nil {
+		t.Fatalf("SpliceVolumes: %v", err)
+	}
+	if !bytes.Equal(image, res) {
+		t.Errorf("spliced unmodified image differs from original")
+	}
+
+	v := scanned[1].Volume
+	if err := v.ReplaceFile(GUID{0x02}, NewRawFile(GUID{0x03}, []byte("replaced"))); err != nil {
+		t.Fatalf("ReplaceFile: %v", err)
+	}
+	res, err = SpliceVolumes(image, scanned)
+	if err != nil {
+		t.Fatalf("SpliceVolumes: %v", err)
+	}
+	if !bytes.Equal(image[:scanned[1].Offset], res[:scanned[1].Offset]) {
+		t.Errorf("splicing modified data outside of volume")
+	}
+	if !bytes.Contains(res, []byte("replaced")) {
+		t.Errorf("modified volume not spliced into image")
+	}
+}
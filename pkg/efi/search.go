@@ -0,0 +1,57 @@
+package efi
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Match is an occurence of a pattern found by Volume.Search.
+type Match struct {
+	// Path of the section (or raw file) containing the match, as passed to a
+	// WalkFunc by Volume.Walk.
+	Path []int
+	// File containing the match.
+	File *FirmwareFile
+	// Section containing the match, or nil if the match is within the data
+	// of a raw file.
+	Section Section
+	// Offset of the match within the section's (or raw file's) data.
+	Offset int
+}
+
+// Search finds all occurences of pattern within the data of leaf sections
+// (ie. ones with Raw data, after decompression) and raw files of the volume,
+// including nested volumes.
+func (v *Volume) Search(pattern []byte) ([]Match, error) {
+	if len(pattern) == 0 {
+		return nil, errors.New("empty pattern")
+	}
+	var res []Match
+	err := v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		var data []byte
+		switch {
+		case section == nil && file.FileType == FileTypeRaw:
+			data = file.Data
+		case section != nil:
+			data = section.Raw()
+		}
+		for offset := 0; ; {
+			i := bytes.Index(data[offset:], pattern)
+			if i == -1 {
+				break
+			}
+			res = append(res, Match{
+				Path:    path,
+				File:    file,
+				Section: section,
+				Offset:  offset + i,
+			})
+			offset += i + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 
 	"github.com/freemyipod/wInd3x/pkg/efi/compression"
+	"github.com/freemyipod/wInd3x/pkg/efi/lzma"
 	"github.com/golang/glog"
 )
 
@@ -20,7 +21,17 @@ const (
 	SectionTypePE32        SectionType = 16
 	SectionTypeTE          SectionType = 18
 	SectionTypeDXEDEPEX    SectionType = 19
-	SectionTypeRaw         SectionType = 25
+	// SectionTypeVersion sections contain a build number and UCS-2 version
+	// string of a file.
+	SectionTypeVersion SectionType = 20
+	// SectionTypeUserInterface sections contain the UCS-2 name of a file.
+	SectionTypeUserInterface SectionType = 21
+	// SectionTypeFirmwareVolumeImage sections contain a nested Volume.
+	SectionTypeFirmwareVolumeImage SectionType = 23
+	// SectionTypeFreeformSubtypeGUID sections contain data identified by a
+	// subtype GUID.
+	SectionTypeFreeformSubtypeGUID SectionType = 24
+	SectionTypeRaw                 SectionType = 25
 )
 
 func (s SectionType) String() string {
@@ -35,6 +46,14 @@ func (s SectionType) String() string {
 		return "te"
 	case SectionTypeDXEDEPEX:
 		return "depex"
+	case SectionTypeVersion:
+		return "version"
+	case SectionTypeUserInterface:
+		return "ui"
+	case SectionTypeFirmwareVolumeImage:
+		return "fv"
+	case SectionTypeFreeformSubtypeGUID:
+		return "freeform"
 	case SectionTypeRaw:
 		return "raw"
 	default:
@@ -53,26 +72,31 @@ type Section interface {
 	Serialize() ([]byte, error)
 
 	// Raw returns the inner data within this section, if this section is a
-	// PE32/TE/DXE/Raw section.
+	// PE32/TE/DXE/Version/UI/Freeform/Raw section.
 	Raw() []byte
 	// SetRaw overrides the inner data within this section, if this section is
-	// a PE32/TE/DXE/Raw section.
+	// a PE32/TE/DXE/Version/UI/Freeform/Raw section.
 	SetRaw([]byte)
 }
 
-func readSections(r *NestedReader) ([]Section, error) {
+func (p *parser) readSections(r *NestedReader) ([]Section, error) {
 	var res []Section
 	for r.Len() != 0 {
 		p1 := r.TellGlobal()
-		section, err := readSection(r)
+		section, err := p.readSection(r)
 		if err != nil {
 			return nil, fmt.Errorf("section %d: %w", len(res), err)
 		}
 		p2 := r.TellGlobal()
 		read := p2 - p1
 		if read%4 != 0 && r.Len() != 0 {
-			align := 4 - (read % 4)
-			r.Advance(align)
+			pad := make([]byte, 4-(read%4))
+			n, _ := r.Read(pad)
+			if !bytes.Equal(pad[:n], make([]byte, n)) {
+				if err := p.warnf(p2, "section alignment padding is not zero: %x", pad[:n]); err != nil {
+					return nil, err
+				}
+			}
 		}
 		res = append(res, section)
 	}
@@ -95,19 +119,49 @@ func (c *commonSectionHeader) Raw() []byte {
 func (c *commonSectionHeader) SetRaw([]byte) {
 }
 
+const (
+	// CompressionTypeNone is EFI_NOT_COMPRESSED.
+	CompressionTypeNone uint8 = 0
+	// CompressionTypeStandard is EFI_STANDARD_COMPRESSION. The EFI spec
+	// defines this to be EFI 1.1 compression, but EDK2-based firmware uses
+	// Tiano compression instead.
+	CompressionTypeStandard uint8 = 1
+)
+
 type compressionSection struct {
 	commonSectionHeader
 	extra struct {
 		UncompressedLength uint32
 		CompressionType    uint8
 	}
-	sub []Section
+	// efi11 is set if this is a CompressionTypeStandard section compressed
+	// using EFI 1.1 compression instead of Tiano compression.
+	efi11 bool
+	cache compressionCache
+	sub   []Section
+	// readSub are the sections nested within this section as read, used to
+	// determine whether it has been modified.
+	readSub []Section
+	lazy    lazySections
 }
 
 func (c *compressionSection) Sub() []Section {
+	c.lazy.ensure()
 	return c.sub
 }
 
+func (c *compressionSection) modified() bool {
+	if c.lazy.pending() {
+		return false
+	}
+	return subModified(c.sub, c.readSub)
+}
+
+func (c *compressionSection) loadSections() error {
+	c.lazy.ensure()
+	return c.lazy.err
+}
+
 func concatSections(sub []Section) ([]byte, error) {
 	var res []byte
 	if len(sub) == 0 {
@@ -126,16 +180,85 @@ func concatSections(sub []Section) ([]byte, error) {
 	return res, nil
 }
 
+// modifiable is implemented by sections which track whether they have been
+// modified since they were read.
+type modifiable interface {
+	modified() bool
+}
+
+// sectionModified returns whether a section, or any section nested within it,
+// has been modified since it was read. Sections not read from an image are
+// always considered modified.
+func sectionModified(s Section) bool {
+	if m, ok := s.(modifiable); ok {
+		return m.modified()
+	}
+	return true
+}
+
+// subModified returns whether the sections nested within an encapsulation
+// section have been replaced or modified since they were read as readSub.
+func subModified(sub, readSub []Section) bool {
+	if len(sub) != len(readSub) {
+		return true
+	}
+	for i, s := range sub {
+		if s != readSub[i] || sectionModified(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressionCache remembers the last compressed form of some data. This
+// ensures that serializing unmodified sections yields the exact bytes they
+// were read from, even if our compressors don't produce the same output as the
+// ones used to build the image. It also keeps repeated Serialize calls cheap.
+type compressionCache struct {
+	uncompressed []byte
+	compressed   []byte
+}
+
+func (c *compressionCache) compress(data []byte, compress func([]byte) ([]byte, error)) ([]byte, error) {
+	if c.compressed != nil && bytes.Equal(c.uncompressed, data) {
+		return c.compressed, nil
+	}
+	compressed, err := compress(data)
+	if err != nil {
+		return nil, err
+	}
+	c.uncompressed = data
+	c.compressed = compressed
+	return compressed, nil
+}
+
 func (c *compressionSection) Serialize() ([]byte, error) {
+	if c.cache.compressed != nil && !c.modified() {
+		// Nothing changed within this section, skip serializing and
+		// compressing its contents only to end up with the same data.
+		return c.serialize(c.cache.compressed)
+	}
 	uncompressed, err := concatSections(c.sub)
 	if err != nil {
 		return nil, err
 	}
 	c.extra.UncompressedLength = uint32(len(uncompressed))
-	compressed, err := compression.Compress(uncompressed)
+	var compressed []byte
+	switch {
+	case c.extra.CompressionType == CompressionTypeNone:
+		compressed = uncompressed
+	case c.efi11:
+		compressed, err = c.cache.compress(uncompressed, compression.CompressEFI)
+	default:
+		compressed, err = c.cache.compress(uncompressed, compression.Compress)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("compression failed: %w", err)
 	}
+	return c.serialize(compressed)
+}
+
+func (c *compressionSection) serialize(compressed []byte) ([]byte, error) {
 	c.commonSectionHeader.Size = ToUint24(uint32(4 + 5 + len(compressed)))
 
 	buf := bytes.NewBuffer(nil)
@@ -151,6 +274,36 @@ func (c *compressionSection) Serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+var (
+	// guidCRC32 is EFI_CRC32_GUIDED_SECTION_EXTRACTION_GUID, for sections
+	// whose data is protected by a CRC32 stored in the custom header data.
+	guidCRC32 = MustParseGUID("fc1bcdb0-7d31-49aa-936a-a4600d9dd083")
+	// guidLZMA is EDK2's LZMA_CUSTOM_DECOMPRESS_GUID, for sections whose data
+	// is LZMA compressed.
+	guidLZMA = MustParseGUID("ee4e5898-3914-4259-9d6e-dc7bd79403cf")
+)
+
+const (
+	// GUIDedSectionProcessingRequired is set in GUID-defined section
+	// attributes if the section data must be processed (eg. decompressed) to
+	// get to the encapsulated sections.
+	GUIDedSectionProcessingRequired uint16 = 0x01
+	// GUIDedSectionAuthStatusValid is set in GUID-defined section attributes
+	// if the section contains authentication data (eg. a CRC32).
+	GUIDedSectionAuthStatusValid uint16 = 0x02
+)
+
+// GUIDDefinedSection is implemented by EFI_SECTION_GUID_DEFINED sections.
+// Their encapsulated sections are available through Sub.
+type GUIDDefinedSection interface {
+	Section
+	// DefinitionGUID returns the GUID which defines how the data of this
+	// section is encapsulated (eg. CRC32, LZMA).
+	DefinitionGUID() GUID
+	// Attributes returns the GUIDedSection* attributes of this section.
+	Attributes() uint16
+}
+
 type guidSection struct {
 	commonSectionHeader
 	extra struct {
@@ -159,20 +312,49 @@ type guidSection struct {
 		Attributes            uint16
 	}
 	custom []byte
+	cache  compressionCache
 	sub    []Section
+	// readSub are the sections nested within this section as read, used to
+	// determine whether it has been modified.
+	readSub []Section
+	lazy    lazySections
 }
 
 func (c *guidSection) Sub() []Section {
+	c.lazy.ensure()
 	return c.sub
 }
 
+func (c *guidSection) modified() bool {
+	if c.lazy.pending() {
+		return false
+	}
+	return subModified(c.sub, c.readSub)
+}
+
+func (c *guidSection) loadSections() error {
+	c.lazy.ensure()
+	return c.lazy.err
+}
+
+func (c *guidSection) DefinitionGUID() GUID {
+	return c.extra.SectionDefinitionGUID
+}
+
+func (c *guidSection) Attributes() uint16 {
+	return c.extra.Attributes
+}
+
 func (c *guidSection) Serialize() ([]byte, error) {
+	if c.extra.SectionDefinitionGUID == guidLZMA && c.cache.compressed != nil && !c.modified() {
+		return c.serialize(c.cache.compressed)
+	}
 	data, err := concatSections(c.sub)
 	if err != nil {
 		return nil, err
 	}
-	c.commonSectionHeader.Size = ToUint24(uint32(4 + 20 + len(c.custom) + len(data)))
-	if c.extra.SectionDefinitionGUID.String() == "fc1bcdb0-7d31-49aa-936a-a4600d9dd083" {
+	switch c.extra.SectionDefinitionGUID {
+	case guidCRC32:
 		// Rebuild CRC32 checksum.
 		h := crc32.NewIEEE()
 		h.Write(data)
@@ -180,7 +362,18 @@ func (c *guidSection) Serialize() ([]byte, error) {
 		buf := bytes.NewBuffer(nil)
 		binary.Write(buf, binary.LittleEndian, h.Sum32())
 		c.custom = buf.Bytes()
+	case guidLZMA:
+		data, err = c.cache.compress(data, lzma.Compress)
+		if err != nil {
+			return nil, fmt.Errorf("lzma compression failed: %w", err)
+		}
 	}
+	return c.serialize(data)
+}
+
+func (c *guidSection) serialize(data []byte) ([]byte, error) {
+	c.commonSectionHeader.Size = ToUint24(uint32(4 + 20 + len(c.custom) + len(data)))
+	c.extra.DataOffset = uint16(4 + 20 + len(c.custom))
 
 	buf := bytes.NewBuffer(nil)
 	if err := binary.Write(buf, binary.LittleEndian, c.commonSectionHeader); err != nil {
@@ -189,7 +382,6 @@ func (c *guidSection) Serialize() ([]byte, error) {
 	if err := binary.Write(buf, binary.LittleEndian, c.extra); err != nil {
 		return nil, err
 	}
-	//pad := make([]byte, c.extra.DataOffset-24)
 	if err := binary.Write(buf, binary.LittleEndian, c.custom); err != nil {
 		return nil, err
 	}
@@ -199,15 +391,64 @@ func (c *guidSection) Serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// FirmwareVolumeSection is implemented by SectionTypeFirmwareVolumeImage
+// sections.
+type FirmwareVolumeSection interface {
+	Section
+	// Volume returns the nested Volume within this section. It can be
+	// modified, and will be reserialized when this section is serialized.
+	Volume() *Volume
+}
+
+type volumeSection struct {
+	commonSectionHeader
+	volume *Volume
+}
+
+func (c *volumeSection) Sub() []Section {
+	return nil
+}
+
+func (c *volumeSection) Volume() *Volume {
+	return c.volume
+}
+
+// modified always returns true, as changes to the nested volume are not
+// tracked. Encapsulation sections containing volumes thus always serialize
+// their contents, and fall back to their compressionCache.
+func (c *volumeSection) modified() bool {
+	return true
+}
+
+func (c *volumeSection) Serialize() ([]byte, error) {
+	data, err := c.volume.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("serializing nested volume: %w", err)
+	}
+	c.commonSectionHeader.Size = ToUint24(uint32(4 + len(data)))
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.LittleEndian, c.commonSectionHeader); err != nil {
+		return nil, err
+	}
+	buf.Write(data)
+	return buf.Bytes(), nil
+}
+
 type leafSection struct {
 	commonSectionHeader
 	data []byte
+	// dirty is set when the data of this section is set with SetRaw.
+	dirty bool
 }
 
 func (c *leafSection) Sub() []Section {
 	return nil
 }
 
+func (c *leafSection) modified() bool {
+	return c.dirty
+}
+
 func (c *leafSection) Serialize() ([]byte, error) {
 	c.commonSectionHeader.Size = ToUint24(uint32(4 + len(c.data)))
 	buf := bytes.NewBuffer(nil)
@@ -230,17 +471,131 @@ func (c *leafSection) SetRaw(d []byte) {
 	res := make([]byte, len(d))
 	copy(res, d)
 	c.data = res
+	c.dirty = true
+}
+
+// FreeformSection is implemented by SectionTypeFreeformSubtypeGUID sections.
+// Their data (excluding the subtype GUID) is available through Raw.
+type FreeformSection interface {
+	Section
+	// SubtypeGUID returns the GUID which identifies the contents of this
+	// section.
+	SubtypeGUID() GUID
+}
+
+type freeformSection struct {
+	leafSection
+	subtype GUID
+}
+
+func (c *freeformSection) SubtypeGUID() GUID {
+	return c.subtype
+}
+
+func (c *freeformSection) Serialize() ([]byte, error) {
+	c.commonSectionHeader.Size = ToUint24(uint32(4 + 16 + len(c.data)))
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.LittleEndian, c.commonSectionHeader); err != nil {
+		return nil, err
+	}
+	buf.Write(c.subtype[:])
+	buf.Write(c.data)
+	return buf.Bytes(), nil
+}
+
+// readStandard decompresses the data of a CompressionTypeStandard section and
+// parses its subsections.
+func (c *compressionSection) readStandard(p *parser, data []byte) error {
+	// Try Tiano first, as that's what we usually encounter. If that doesn't
+	// work out, try EFI 1.1. Both share the same format and differ only
+	// subtly, so we might only find out we guessed wrong after decompressing
+	// garbage.
+	sub, decompressed, err := p.readCompressed(data, c.extra.UncompressedLength, compression.Decompress)
+	if err != nil {
+		var errEFI error
+		sub, decompressed, errEFI = p.readCompressed(data, c.extra.UncompressedLength, compression.DecompressEFI)
+		if errEFI != nil {
+			return err
+		}
+		c.efi11 = true
+	} else if glog.V(2) {
+		t, err := compression.Compress(decompressed)
+		if err != nil || len(t) != len(data) {
+			glog.Warningf("Loopback compression failed: %d -> %d", len(data), len(t))
+		}
+	}
+	c.sub = sub
+	c.readSub = append([]Section(nil), sub...)
+	c.cache.uncompressed = decompressed[:c.extra.UncompressedLength]
+	return nil
+}
+
+// readLZMA decompresses the data of an LZMA GUID-defined section and parses
+// its subsections.
+func (c *guidSection) readLZMA(p *parser, compressed []byte) error {
+	decompressed, err := lzma.DecompressLimit(compressed, p.maxDecompressedSize())
+	if err != nil {
+		return fmt.Errorf("lzma decompression failed: %w", err)
+	}
+	c.cache.uncompressed = decompressed
+	sub, err := p.readSections(NewNestedReader(decompressed))
+	if err != nil {
+		return fmt.Errorf("parsing guid defined subsections: %w", err)
+	}
+	c.sub = sub
+	c.readSub = append([]Section(nil), sub...)
+	return nil
+}
+
+// readCompressed decompresses data using a given algorithm and parses the
+// resulting subsections. The decompressed data is also returned.
+func (p *parser) readCompressed(data []byte, length uint32, decompress func([]byte) ([]byte, error)) ([]Section, []byte, error) {
+	decompressed, err := decompress(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decompression failed: %w", err)
+	}
+	if len(decompressed) < int(length) {
+		return nil, nil, fmt.Errorf("decompressed to %d bytes, wanted %d", len(decompressed), length)
+	}
+	// Drop any warnings if we fail to parse, as we might have just guessed
+	// the wrong algorithm.
+	nwarnings := len(p.warnings)
+	sub, err := p.readSections(NewNestedReader(decompressed[:length]))
+	if err != nil {
+		p.warnings = p.warnings[:nwarnings]
+		return nil, nil, fmt.Errorf("parsing compression subsections: %w", err)
+	}
+	return sub, decompressed, nil
 }
 
-func readSection(r *NestedReader) (Section, error) {
+func (p *parser) readSection(r *NestedReader) (Section, error) {
 	var header commonSectionHeader
 	start := r.TellGlobal()
 	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
 		return nil, err
 	}
 	glog.V(1).Infof("Section header @%08x: %+v", start, header)
+	size := header.Size.Uint32()
+	if size < 4 {
+		return nil, fmt.Errorf("section size too small (%d bytes)", size)
+	}
+	if int(size-4) > r.Len() {
+		return nil, fmt.Errorf("section size too large (%d bytes)", size)
+	}
+	switch header.Type {
+	case SectionTypeCompression, SectionTypeGUIDDefined, SectionTypeFirmwareVolumeImage:
+		done, err := p.descend()
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+	}
 	switch header.Type {
 	case SectionTypeCompression:
+		if size < 4+5 {
+			return nil, fmt.Errorf("compression section too short")
+		}
+		defer p.enter(start)()
 		var res compressionSection
 		res.commonSectionHeader = header
 		if err := binary.Read(r, binary.LittleEndian, &res.extra); err != nil {
@@ -250,31 +605,53 @@ func readSection(r *NestedReader) (Section, error) {
 		if _, err := io.ReadFull(r, data); err != nil {
 			return nil, fmt.Errorf("reading compression data: %w", err)
 		}
-		if res.extra.CompressionType != 1 {
+		switch res.extra.CompressionType {
+		case CompressionTypeNone:
+			if len(data) != int(res.extra.UncompressedLength) {
+				return nil, fmt.Errorf("uncompressed section length mismatch")
+			}
+			sub, err := p.readSections(NewNestedReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("parsing uncompressed subsections: %w", err)
+			}
+			res.sub = sub
+		case CompressionTypeStandard:
+			if err := p.checkDecompressedSize(uint64(res.extra.UncompressedLength)); err != nil {
+				return nil, err
+			}
+			if len(data) < 8 {
+				return nil, fmt.Errorf("compressed data too short")
+			}
+			if err := p.checkDecompressedSize(uint64(binary.LittleEndian.Uint32(data[4:8]))); err != nil {
+				return nil, err
+			}
+			res.cache.compressed = data
+			if p.opts.Lazy {
+				res.lazy.deferLoad(p, func(p *parser) error {
+					return res.readStandard(p, data)
+				})
+				return &res, nil
+			}
+			if err := res.readStandard(p, data); err != nil {
+				return nil, err
+			}
+		default:
 			return nil, fmt.Errorf("unsupported compression type %d", res.extra.CompressionType)
 		}
-		decompressed, err := compression.Decompress(data)
-		if err != nil {
-			return nil, fmt.Errorf("decompression failed: %w", err)
-		}
-		t, err := compression.Compress(decompressed)
-		if err != nil || len(t) != len(data) {
-			glog.Warningf("Loopback compression failed: %d -> %d", len(data), len(t))
-		}
-		decompressed = decompressed[:res.extra.UncompressedLength]
-		//fmt.Println(hex.Dump(decompressed))
-		sub, err := readSections(NewNestedReader(decompressed))
-		if err != nil {
-			return nil, fmt.Errorf("parsing compression subsections: %w", err)
-		}
-		res.sub = sub
+		res.readSub = append([]Section(nil), res.sub...)
 		return &res, nil
 	case SectionTypeGUIDDefined:
 		var res guidSection
 		res.commonSectionHeader = header
+		if size < 4+20 {
+			return nil, fmt.Errorf("guid defined section too short")
+		}
 		if err := binary.Read(r, binary.LittleEndian, &res.extra); err != nil {
 			return nil, err
 		}
+		if res.extra.DataOffset < 4+20 || uint32(res.extra.DataOffset) > size {
+			return nil, fmt.Errorf("guid defined section data offset 0x%x out of bounds", res.extra.DataOffset)
+		}
 		customLength := int(res.extra.DataOffset - (4 + 20))
 		custom := make([]byte, customLength)
 		r.Read(custom)
@@ -285,13 +662,72 @@ func readSection(r *NestedReader) (Section, error) {
 		dataSub := r.Sub(0, dataLength)
 		r.Advance(dataLength)
 
-		sub, err := readSections(dataSub)
+		switch res.extra.SectionDefinitionGUID {
+		case guidCRC32:
+			if len(res.custom) != 4 {
+				return nil, fmt.Errorf("crc32 section has %d bytes of custom data, wanted 4", len(res.custom))
+			}
+			data := make([]byte, dataLength)
+			if _, err := io.ReadFull(dataSub.Sub(0, dataLength), data); err != nil {
+				return nil, fmt.Errorf("reading crc32 section data: %w", err)
+			}
+			want := binary.LittleEndian.Uint32(res.custom)
+			if got := crc32.ChecksumIEEE(data); want != got {
+				return nil, fmt.Errorf("crc32 mismatch: header says %08x, data is %08x", want, got)
+			}
+		case guidLZMA:
+			defer p.enter(start)()
+			compressed := make([]byte, dataLength)
+			if _, err := io.ReadFull(dataSub, compressed); err != nil {
+				return nil, fmt.Errorf("reading lzma data: %w", err)
+			}
+			res.cache.compressed = compressed
+			if p.opts.Lazy {
+				res.lazy.deferLoad(p, func(p *parser) error {
+					return res.readLZMA(p, compressed)
+				})
+				return &res, nil
+			}
+			if err := res.readLZMA(p, compressed); err != nil {
+				return nil, err
+			}
+			return &res, nil
+		}
+
+		sub, err := p.readSections(dataSub)
 		if err != nil {
 			return nil, fmt.Errorf("parsing guid defined subsections: %w", err)
 		}
 		res.sub = sub
+		res.readSub = append([]Section(nil), sub...)
+		return &res, nil
+	case SectionTypeFirmwareVolumeImage:
+		length := int(header.Size.Uint32() - 4)
+		sub := r.Sub(0, length)
+		r.Advance(length)
+		volume, err := p.readVolume(sub, nil)
+		if err != nil {
+			return nil, fmt.Errorf("parsing nested volume: %w", err)
+		}
+		return &volumeSection{
+			commonSectionHeader: header,
+			volume:              volume,
+		}, nil
+	case SectionTypeFreeformSubtypeGUID:
+		if header.Size.Uint32() < 4+16 {
+			return nil, fmt.Errorf("freeform section too short")
+		}
+		var res freeformSection
+		res.commonSectionHeader = header
+		if _, err := io.ReadFull(r, res.subtype[:]); err != nil {
+			return nil, fmt.Errorf("reading subtype: %w", err)
+		}
+		res.data = make([]byte, header.Size.Uint32()-(4+16))
+		if _, err := io.ReadFull(r, res.data); err != nil {
+			return nil, fmt.Errorf("reading data: %w", err)
+		}
 		return &res, nil
-	case SectionTypePE32, SectionTypeTE, SectionTypeRaw, SectionTypeDXEDEPEX:
+	case SectionTypePE32, SectionTypeTE, SectionTypeRaw, SectionTypeDXEDEPEX, SectionTypeVersion, SectionTypeUserInterface:
 		data := make([]byte, header.Size.Uint32()-(4))
 		if _, err := io.ReadFull(r, data); err != nil {
 			return nil, fmt.Errorf("reading data: %w", err)
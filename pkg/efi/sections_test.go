@@ -0,0 +1,269 @@
+package efi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"testing"
+)
+
+// makeCRC32Section builds a CRC32 GUID-defined section containing a single raw
+// section with the given data.
+func makeCRC32Section(payload []byte) []byte {
+	raw := bytes.NewBuffer(nil)
+	binary.Write(raw, binary.LittleEndian, commonSectionHeader{
+		Size: ToUint24(uint32(4 + len(payload))),
+		Type: SectionTypeRaw,
+	})
+	raw.Write(payload)
+
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, commonSectionHeader{
+		Size: ToUint24(uint32(4 + 20 + 4 + raw.Len())),
+		Type: SectionTypeGUIDDefined,
+	})
+	buf.Write(guidCRC32[:])
+	binary.Write(buf, binary.LittleEndian, uint16(4+20+4))
+	binary.Write(buf, binary.LittleEndian, GUIDedSectionAuthStatusValid)
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(raw.Bytes()))
+	buf.Write(raw.Bytes())
+	return buf.Bytes()
+}
+
+func TestCRC32Section(t *testing.T) {
+	data := makeCRC32Section([]byte("hello, world"))
+
+	section, err := newParser(nil).readSection(NewNestedReader(data))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	gs, ok := section.(GUIDDefinedSection)
+	if !ok {
+		t.Fatalf("section is not a GUIDDefinedSection")
+	}
+	if want, got := guidCRC32, gs.DefinitionGUID(); want != got {
+		t.Errorf("wanted GUID %s, got %s", want, got)
+	}
+	if want, got := "hello, world", string(section.Sub()[0].Raw()); want != got {
+		t.Errorf("wanted raw data %q, got %q", want, got)
+	}
+
+	// Unmodified sections should serialize back to the same data.
+	serialized, err := section.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, serialized) {
+		t.Errorf("serialized section differs from original")
+	}
+
+	// Modified sections should have their CRC rebuilt.
+	section.Sub()[0].SetRaw([]byte("goodbye, world"))
+	serialized, err = section.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := makeCRC32Section([]byte("goodbye, world")), serialized; !bytes.Equal(want, got) {
+		t.Errorf("modified section serialized to %x, wanted %x", got, want)
+	}
+
+	// Corrupted sections should fail to parse.
+	data[len(data)-1] ^= 0xff
+	if _, err := newParser(nil).readSection(NewNestedReader(data)); err == nil {
+		t.Errorf("corrupted section parsed without error")
+	}
+}
+
+// TestLZMASectionReserialization ensures that unmodified LZMA sections
+// compressed by another encoder serialize back to the exact same bytes.
+func TestLZMASectionReserialization(t *testing.T) {
+	// A raw section, compressed by the reference LZMA SDK encoder.
+	compressed, _ := hex.DecodeString("5d00008000ffffffffffffffff0058002c323f2850fef121ebd7f62acb4380de6436f9d2088d721b6014b6ac0ba058b2b78db3bd710b2f4a5fb2e0f36d1fa411385690e74076e3fcacc3d1776d4975c642f1cabc17fffeccd51ab38441137c8d67ef27433c527ffe7aaa80")
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, commonSectionHeader{
+		Size: ToUint24(uint32(4 + 20 + len(compressed))),
+		Type: SectionTypeGUIDDefined,
+	})
+	buf.Write(guidLZMA[:])
+	binary.Write(buf, binary.LittleEndian, uint16(4+20))
+	binary.Write(buf, binary.LittleEndian, GUIDedSectionProcessingRequired)
+	buf.Write(compressed)
+	data := buf.Bytes()
+
+	section, err := newParser(nil).readSection(NewNestedReader(data))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		serialized, err := section.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize: %v", err)
+		}
+		if !bytes.Equal(data, serialized) {
+			t.Errorf("serialization %d differs from original", i)
+		}
+	}
+}
+
+func TestSectionModified(t *testing.T) {
+	raw, _ := NewLeafSection(SectionTypeRaw, []byte("hello"))
+	lzma, _ := NewGUIDDefinedSection(SectionDefinitionLZMA, raw)
+	if !sectionModified(lzma) {
+		t.Errorf("new section not modified")
+	}
+	data, err := lzma.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	section, err := newParser(nil).readSection(NewNestedReader(data))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	if sectionModified(section) {
+		t.Errorf("read section modified")
+	}
+	// Unmodified sections serialize to their compressed data as read, without
+	// going through the compressor again.
+	section.(*guidSection).cache.uncompressed = nil
+	serialized, err := section.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, serialized) {
+		t.Errorf("serialization differs from original")
+	}
+
+	section.Sub()[0].SetRaw([]byte("world"))
+	if !sectionModified(section) {
+		t.Errorf("section not modified after changing nested section")
+	}
+	serialized, err = section.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	reread, err := newParser(nil).readSection(NewNestedReader(serialized))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	if want, got := "world", string(reread.Sub()[0].Raw()); want != got {
+		t.Errorf("nested section contains %q, wanted %q", got, want)
+	}
+}
+
+func TestUserInterfaceSection(t *testing.T) {
+	data := []byte{0x10, 0x00, 0x00, byte(SectionTypeUserInterface), 'w', 0, 'I', 0, 'n', 0, 'd', 0, '3', 0, 0, 0}
+	s, err := newParser(nil).readSection(NewNestedReader(data))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	name, err := SectionName(s)
+	if err != nil {
+		t.Fatalf("SectionName: %v", err)
+	}
+	if want, got := "wInd3", name; want != got {
+		t.Errorf("wanted name %q, got %q", want, got)
+	}
+	if err := SetSectionName(s, "Überdriver"); err != nil {
+		t.Fatalf("SetSectionName: %v", err)
+	}
+	f := &FirmwareFile{Sections: []Section{s}}
+	if want, got := "Überdriver", f.UIName(); want != got {
+		t.Errorf("wanted file name %q, got %q", want, got)
+	}
+}
+
+func TestRegisterNames(t *testing.T) {
+	ui, err := NewUserInterfaceSection("AppleTestDxe")
+	if err != nil {
+		t.Fatalf("NewUserInterfaceSection: %v", err)
+	}
+	dxeCoreUI, err := NewUserInterfaceSection("NotDxeCore")
+	if err != nil {
+		t.Fatalf("NewUserInterfaceSection: %v", err)
+	}
+	apple := GUID{0x5a, 0x1e, 0x7e, 0x57}
+	dxeCore, err := ParseGUID("d6a2cb7f-6a18-4e2f-b43b-9920a733700a")
+	if err != nil {
+		t.Fatalf("ParseGUID: %v", err)
+	}
+	inner := &Volume{Files: []*FirmwareFile{NewDriverFile(apple, ui)}}
+	v := &Volume{
+		Files: []*FirmwareFile{
+			NewDriverFile(dxeCore, dxeCoreUI),
+			NewFreeformFile(GUID{0x5a, 0x1e, 0x7e, 0x58}, NewFirmwareVolumeSection(inner)),
+		},
+	}
+	if want, got := 1, v.RegisterNames(); want != got {
+		t.Errorf("wanted %d names registered, got %d", want, got)
+	}
+	if want, got := "AppleTestDxe", apple.Name(); want != got {
+		t.Errorf("nested file: wanted name %q, got %q", want, got)
+	}
+	if want, got := "DxeCore", dxeCore.Name(); want != got {
+		t.Errorf("known GUID: wanted name %q, got %q", want, got)
+	}
+}
+
+func TestVersionSection(t *testing.T) {
+	data := []byte{0x0c, 0x00, 0x00, byte(SectionTypeVersion), 0x39, 0x05, '1', 0, '.', 0, '0', 0, 0, 0}
+	data[0] = byte(len(data))
+	s, err := newParser(nil).readSection(NewNestedReader(data))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	v, err := SectionVersion(s)
+	if err != nil {
+		t.Fatalf("SectionVersion: %v", err)
+	}
+	if want, got := (Version{BuildNumber: 1337, Version: "1.0"}), *v; want != got {
+		t.Errorf("wanted version %v, got %v", want, got)
+	}
+	if err := SetSectionVersion(s, v); err != nil {
+		t.Fatalf("SetSectionVersion: %v", err)
+	}
+	res, err := s.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, res) {
+		t.Errorf("serialized %x, wanted %x", res, data)
+	}
+}
+
+func TestFreeformSection(t *testing.T) {
+	subtype := GUID{0xaa, 0xbb}
+	data := []byte{0x00, 0x00, 0x00, byte(SectionTypeFreeformSubtypeGUID)}
+	data = append(data, subtype[:]...)
+	data = append(data, []byte("blob")...)
+	data[0] = byte(len(data))
+	s, err := newParser(nil).readSection(NewNestedReader(data))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	fs, ok := s.(FreeformSection)
+	if !ok {
+		t.Fatalf("section is %T, wanted FreeformSection", s)
+	}
+	if want, got := subtype, fs.SubtypeGUID(); want != got {
+		t.Errorf("wanted subtype %s, got %s", want, got)
+	}
+	if want, got := "blob", string(fs.Raw()); want != got {
+		t.Errorf("wanted data %q, got %q", want, got)
+	}
+
+	v := &Volume{Files: []*FirmwareFile{{Sections: []Section{s}}}}
+	el, err := v.Lookup("0/subtype:" + subtype.String())
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	el.Section.SetRaw([]byte("BLOB"))
+	res, err := s.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := append(data[:len(data)-4:len(data)-4], "BLOB"...), res; !bytes.Equal(want, got) {
+		t.Errorf("serialized %x, wanted %x", got, want)
+	}
+}
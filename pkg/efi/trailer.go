@@ -0,0 +1,161 @@
+package efi
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+)
+
+// trailerSignatureSize is the size of the RSA signature preceding the
+// certificate chain in the trailing data of Apple firmware volumes.
+const trailerSignatureSize = 0x80
+
+// Trailer is the trailing data after an Apple firmware volume, as found in
+// Volume.Custom: any padding of the IMG1 body, the signature of the body, and
+// the certificate chain used to verify it.
+type Trailer struct {
+	// Padding between the end of the volume and the signature.
+	Padding   []byte
+	Signature []byte
+	// Certificates is the raw DER data of each certificate in the chain.
+	Certificates [][]byte
+	// CertPadding is any data following the certificate chain within the
+	// space reserved for it.
+	CertPadding []byte
+}
+
+// ParsedCertificates returns the parsed certificate chain.
+func (t *Trailer) ParsedCertificates() ([]*x509.Certificate, error) {
+	var res []*x509.Certificate
+	for i, der := range t.Certificates {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", i, err)
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// Serialize returns the trailer as it would be stored in Volume.Custom.
+func (t *Trailer) Serialize() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(t.Padding)
+	buf.Write(t.Signature)
+	for _, c := range t.Certificates {
+		buf.Write(c)
+	}
+	buf.Write(t.CertPadding)
+	return buf.Bytes()
+}
+
+// derLength returns the total length of the DER SEQUENCE at the start of
+// data, or zero if there isn't one.
+func derLength(data []byte) int {
+	if len(data) < 2 || data[0] != 0x30 {
+		return 0
+	}
+	l := int(data[1])
+	if l < 0x80 {
+		return 2 + l
+	}
+	n := l & 0x7f
+	if n == 0 || n > 3 || len(data) < 2+n {
+		return 0
+	}
+	l = 0
+	for _, b := range data[2 : 2+n] {
+		l = l<<8 | int(b)
+	}
+	return 2 + n + l
+}
+
+// parseCertificates splits data into a chain of DER certificates followed by
+// padding. At least one certificate must be present.
+func parseCertificates(data []byte) ([][]byte, []byte, error) {
+	var certs [][]byte
+	for len(data) > 0 {
+		l := derLength(data)
+		if l == 0 || l > len(data) {
+			break
+		}
+		if _, err := x509.ParseCertificate(data[:l]); err != nil {
+			break
+		}
+		certs = append(certs, data[:l])
+		data = data[l:]
+	}
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("no certificates")
+	}
+	return certs, data, nil
+}
+
+// Trailer parses the trailing data of the volume. The certificate chain is
+// located by searching for the first parseable certificate, which is expected
+// to directly follow the signature.
+func (v *Volume) Trailer() (*Trailer, error) {
+	return parseTrailer(v.Custom)
+}
+
+// parseTrailer parses trailing data as a signature followed by a certificate
+// chain, see Volume.Trailer.
+func parseTrailer(data []byte) (*Trailer, error) {
+	for i := trailerSignatureSize; i < len(data); i++ {
+		if data[i] != 0x30 {
+			continue
+		}
+		certs, rest, err := parseCertificates(data[i:])
+		if err != nil {
+			continue
+		}
+		return &Trailer{
+			Padding:      data[:i-trailerSignatureSize],
+			Signature:    data[i-trailerSignatureSize : i],
+			Certificates: certs,
+			CertPadding:  rest,
+		}, nil
+	}
+	return nil, fmt.Errorf("no certificate chain found in %d bytes of trailing data", len(data))
+}
+
+// TrailerLayout describes where the signature and certificates of a volume
+// are, as given by the IMG1 header of the image whose body it is. Offsets are
+// relative to the start of the body, ie. the start of the volume.
+type TrailerLayout struct {
+	BodyLength       uint32
+	FooterCertOffset uint32
+	FooterCertLength uint32
+}
+
+// TrailerWithLayout parses the trailing data of the volume at the locations
+// given by an IMG1 header, validating that they cover the trailing data
+// exactly.
+func (v *Volume) TrailerWithLayout(l TrailerLayout) (*Trailer, error) {
+	if len(v.blockmap) == 0 {
+		return nil, fmt.Errorf("volume was not read from an image")
+	}
+	size := uint64(blockmapSize(v.blockmap))
+	body, certOffset, certLength := uint64(l.BodyLength), uint64(l.FooterCertOffset), uint64(l.FooterCertLength)
+	if body < size {
+		return nil, fmt.Errorf("body length 0x%x smaller than volume (0x%x)", body, size)
+	}
+	if certOffset < body {
+		return nil, fmt.Errorf("certificate offset 0x%x within body (0x%x)", certOffset, body)
+	}
+	if want, got := certOffset+certLength-size, uint64(len(v.Custom)); want != got {
+		return nil, fmt.Errorf("header describes 0x%x bytes of trailing data, volume has 0x%x", want, got)
+	}
+	data := v.Custom
+	t := &Trailer{
+		Padding:   data[:body-size],
+		Signature: data[body-size : certOffset-size],
+	}
+	certs, rest, err := parseCertificates(data[certOffset-size:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate chain: %w", err)
+	}
+	t.Certificates = certs
+	t.CertPadding = rest
+	return t, nil
+}
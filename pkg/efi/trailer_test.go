@@ -0,0 +1,127 @@
+package efi
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wInd3x test"},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	return cert
+}
+
+func TestTrailer(t *testing.T) {
+	cert := testCertificate(t)
+	v, err := NewVolume(0x400)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	padding := bytes.Repeat([]byte{0}, 0x20)
+	signature := bytes.Repeat([]byte{'S'}, 0x80)
+	certs := append(append([]byte{}, cert...), make([]byte, 0x300-len(cert))...)
+	data = append(append(append(data, padding...), signature...), certs...)
+
+	v, err = ReadVolume(NewNestedReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	tr, err := v.Trailer()
+	if err != nil {
+		t.Fatalf("Trailer: %v", err)
+	}
+	if !bytes.Equal(tr.Padding, padding) || !bytes.Equal(tr.Signature, signature) {
+		t.Errorf("unexpected padding/signature split")
+	}
+	parsed, err := tr.ParsedCertificates()
+	if err != nil {
+		t.Fatalf("ParsedCertificates: %v", err)
+	}
+	if len(parsed) != 1 || parsed[0].Subject.CommonName != "wInd3x test" {
+		t.Errorf("unexpected certificates %v", parsed)
+	}
+	if !bytes.Equal(tr.Serialize(), v.Custom) {
+		t.Errorf("serialized trailer differs from trailing data")
+	}
+
+	layout := TrailerLayout{
+		BodyLength:       0x420,
+		FooterCertOffset: 0x4a0,
+		FooterCertLength: 0x300,
+	}
+	tr2, err := v.TrailerWithLayout(layout)
+	if err != nil {
+		t.Fatalf("TrailerWithLayout: %v", err)
+	}
+	if !bytes.Equal(tr2.Signature, signature) || len(tr2.Certificates) != 1 {
+		t.Errorf("unexpected trailer from layout")
+	}
+	layout.FooterCertLength = 0x200
+	if _, err := v.TrailerWithLayout(layout); err == nil {
+		t.Errorf("TrailerWithLayout with wrong cert length succeeded")
+	}
+}
+
+func TestStrictTrailer(t *testing.T) {
+	v, err := NewVolume(0x400)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	cert := testCertificate(t)
+	trailer := append(bytes.Repeat([]byte{'S'}, 0x80), cert...)
+	trailer = append(trailer, make([]byte, 0x320-len(trailer))...)
+	signed := append(append([]byte{}, data...), trailer...)
+	junk := append(append([]byte{}, data...), bytes.Repeat([]byte{0x42}, 0x20)...)
+
+	for _, strict := range []bool{false, true} {
+		v, err := ReadVolume(NewNestedReader(signed), &ParseOptions{Strict: strict})
+		if err != nil {
+			t.Fatalf("strict %v: ReadVolume with trailer: %v", strict, err)
+		}
+		if len(v.Warnings) != 0 {
+			t.Errorf("strict %v: unexpected warnings %v", strict, v.Warnings)
+		}
+		if !bytes.Equal(v.Custom, trailer) {
+			t.Errorf("strict %v: trailer not kept as custom data", strict)
+		}
+	}
+
+	v, err = ReadVolume(NewNestedReader(junk), nil)
+	if err != nil {
+		t.Fatalf("ReadVolume with trailing data: %v", err)
+	}
+	if len(v.Warnings) != 1 {
+		t.Errorf("wanted one warning about trailing data, got %v", v.Warnings)
+	}
+	if _, err := ReadVolume(NewNestedReader(junk), &ParseOptions{Strict: true}); err == nil {
+		t.Errorf("strict ReadVolume with trailing data succeeded")
+	}
+}
@@ -0,0 +1,125 @@
+package efi
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// unknownOffset is used when printing elements whose offset within the
+// printed volume is not known, eg. sections within compressed data.
+const unknownOffset = -1
+
+// PrintTree writes a human readable tree of the volume's contents to w,
+// similar to the output of UEFITool/UEFIExtract. Every line contains the
+// offset and size of an element, followed by its description. Offsets of
+// elements within compressed data are not shown.
+func (v *Volume) PrintTree(w io.Writer) {
+	fmt.Fprintf(w, "%-8s %-8s %s\n", "Offset", "Size", "Element")
+	v.printTree(w, 0, 0)
+}
+
+func printTreeLine(w io.Writer, offset int, size uint64, depth int, format string, args ...interface{}) {
+	offsetStr := "-"
+	if offset != unknownOffset {
+		offsetStr = fmt.Sprintf("%08x", offset)
+	}
+	fmt.Fprintf(w, "%-8s %08x %s%s\n", offsetStr, size, strings.Repeat("  ", depth), fmt.Sprintf(format, args...))
+}
+
+// describeGUID returns a GUID and its well-known name, if any.
+func describeGUID(g GUID) string {
+	if name := guidName(g); name != "" {
+		return fmt.Sprintf("%s (%s)", g, name)
+	}
+	return g.String()
+}
+
+func (v *Volume) printTree(w io.Writer, depth int, offset int) {
+	size := uint64(blockmapSize(v.blockmap))
+	if len(v.blockmap) == 0 {
+		size = v.Length
+	}
+	printTreeLine(w, offset, size, depth, "Volume %s", describeGUID(v.GUID))
+
+	fileOffset := unknownOffset
+	if offset != unknownOffset {
+		fileOffset = offset + int(v.HeaderLength)
+	}
+	for _, f := range v.Files {
+		desc := fmt.Sprintf("File %s, %s", describeGUID(f.GUID), f.FileType)
+		if name := f.UIName(); name != "" {
+			desc += fmt.Sprintf(" %q", name)
+		}
+		printTreeLine(w, fileOffset, f.TotalSize(), depth+1, "%s", desc)
+		sectionOffset := unknownOffset
+		if fileOffset != unknownOffset {
+			sectionOffset = fileOffset + int(f.headerSize())
+		}
+		printSectionsTree(w, f.Sections, depth+2, sectionOffset)
+
+		if fileOffset != unknownOffset {
+			fileOffset += int(f.TotalSize())
+			if fileOffset%8 != 0 {
+				fileOffset += 8 - (fileOffset % 8)
+			}
+		}
+	}
+}
+
+func printSectionsTree(w io.Writer, sections []Section, depth int, offset int) {
+	for _, s := range sections {
+		header := s.Header()
+		size := header.Size.Uint32()
+		desc := header.Type.String()
+		subOffset := unknownOffset
+		switch header.Type {
+		case SectionTypeDXEDEPEX:
+			if depex, err := ParseDepex(s.Raw()); err == nil {
+				desc += ": " + depex.String()
+			}
+		case SectionTypeVersion:
+			if version, err := SectionVersion(s); err == nil {
+				desc += ": " + version.String()
+			}
+		case SectionTypeUserInterface:
+			if name, err := SectionName(s); err == nil {
+				desc += fmt.Sprintf(": %q", name)
+			}
+		}
+		switch s := s.(type) {
+		case GUIDDefinedSection:
+			desc += " " + describeGUID(s.DefinitionGUID())
+			if gs, ok := s.(*guidSection); ok && offset != unknownOffset && gs.extra.SectionDefinitionGUID != guidLZMA {
+				subOffset = offset + int(gs.extra.DataOffset)
+			}
+		case FreeformSection:
+			desc += " " + describeGUID(s.SubtypeGUID())
+		case FirmwareVolumeSection:
+			printTreeLine(w, offset, uint64(size), depth, "%s", desc)
+			volumeOffset := unknownOffset
+			if offset != unknownOffset {
+				volumeOffset = offset + 4
+			}
+			s.Volume().printTree(w, depth+1, volumeOffset)
+			offset = nextSectionOffset(offset, size)
+			continue
+		}
+		printTreeLine(w, offset, uint64(size), depth, "%s", desc)
+		printSectionsTree(w, s.Sub(), depth+1, subOffset)
+		offset = nextSectionOffset(offset, size)
+	}
+}
+
+// nextSectionOffset returns the offset of the section following one at offset
+// of the given size, taking into account 4-byte alignment.
+func nextSectionOffset(offset int, size uint32) int {
+	if offset == unknownOffset {
+		return unknownOffset
+	}
+	offset += int(size)
+	if offset%4 != 0 {
+		offset += 4 - (offset % 4)
+	}
+	return offset
+}
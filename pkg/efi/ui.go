@@ -0,0 +1,103 @@
+package efi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/freemyipod/wInd3x/pkg/efi/guids"
+)
+
+// decodeUCS2 decodes a NUL-terminated little-endian UCS-2 string.
+func decodeUCS2(data []byte) (string, error) {
+	if len(data)%2 != 0 {
+		return "", fmt.Errorf("odd length %d", len(data))
+	}
+	var chars []uint16
+	for i := 0; i < len(data); i += 2 {
+		c := binary.LittleEndian.Uint16(data[i:])
+		if c == 0 {
+			return string(utf16.Decode(chars)), nil
+		}
+		chars = append(chars, c)
+	}
+	return "", fmt.Errorf("missing NUL terminator")
+}
+
+// encodeUCS2 encodes a string as a NUL-terminated little-endian UCS-2 string.
+func encodeUCS2(s string) ([]byte, error) {
+	var res []byte
+	for _, r := range s {
+		if r > 0xffff {
+			return nil, fmt.Errorf("character %q cannot be represented in UCS-2", r)
+		}
+		if r == 0 {
+			return nil, fmt.Errorf("string contains NUL")
+		}
+		res = append(res, byte(r), byte(r>>8))
+	}
+	return append(res, 0, 0), nil
+}
+
+// SectionName returns the name contained in a user interface section.
+func SectionName(s Section) (string, error) {
+	if t := s.Header().Type; t != SectionTypeUserInterface {
+		return "", fmt.Errorf("%s section is not a user interface section", t)
+	}
+	name, err := decodeUCS2(s.Raw())
+	if err != nil {
+		return "", fmt.Errorf("invalid name: %w", err)
+	}
+	return name, nil
+}
+
+// SetSectionName sets the name contained in a user interface section.
+func SetSectionName(s Section, name string) error {
+	if t := s.Header().Type; t != SectionTypeUserInterface {
+		return fmt.Errorf("%s section is not a user interface section", t)
+	}
+	data, err := encodeUCS2(name)
+	if err != nil {
+		return fmt.Errorf("invalid name: %w", err)
+	}
+	s.SetRaw(data)
+	return nil
+}
+
+// RegisterNames registers the names of all files (including those of nested
+// volumes) given by their user interface sections as names of their GUIDs, so
+// that eg. references to Apple-specific modules in dependency expressions
+// show up by name. Already known GUIDs are left as they are. The number of
+// registered names is returned.
+func (v *Volume) RegisterNames() int {
+	n := 0
+	v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		if section != nil {
+			return nil
+		}
+		s := file.GUID.String()
+		if guids.Name(s) != "" {
+			return nil
+		}
+		if name := file.UIName(); name != "" {
+			guids.Register(s, name)
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// UIName returns the name of a file as specified in its user interface
+// section, or an empty string if the file has no (valid) such section.
+func (f *FirmwareFile) UIName() string {
+	_, s := findSection(nil, f.Sections, SectionTypeUserInterface.String())
+	if s == nil {
+		return ""
+	}
+	name, err := SectionName(s)
+	if err != nil {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,216 @@
+package efi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/freemyipod/wInd3x/pkg/efi/checksum"
+)
+
+// Check is the result of a single integrity check performed by Verify.
+type Check struct {
+	// Path of the checked file or section, as passed to a WalkFunc by
+	// Volume.Walk. Nil for the top-level volume.
+	Path []int
+	// What is a short description of what was checked, eg. "header
+	// checksum".
+	What string
+	// Err describes why the check failed, or is nil if it passed.
+	Err error
+}
+
+// Passed returns whether the check passed.
+func (c *Check) Passed() bool {
+	return c.Err == nil
+}
+
+func (c *Check) String() string {
+	res := fmt.Sprintf("%s: %s", c.pathString(), c.What)
+	if c.Err != nil {
+		res += ": " + c.Err.Error()
+	}
+	return res
+}
+
+func (c *Check) pathString() string {
+	if len(c.Path) == 0 {
+		return "volume"
+	}
+	var buf bytes.Buffer
+	for i, p := range c.Path {
+		if i != 0 {
+			buf.WriteByte('/')
+		}
+		fmt.Fprintf(&buf, "%d", p)
+	}
+	return buf.String()
+}
+
+// Report is the result of Verify.
+type Report struct {
+	// Volume as parsed. It has been read with ParseOptions.PreserveLayout,
+	// and thus serializes back to the verified image if unmodified.
+	Volume *Volume
+	Checks []Check
+}
+
+// Passed returns whether all checks passed.
+func (r *Report) Passed() bool {
+	return len(r.Failed()) == 0
+}
+
+// Failed returns all checks which did not pass.
+func (r *Report) Failed() []Check {
+	var res []Check
+	for _, c := range r.Checks {
+		if !c.Passed() {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (r *Report) add(path []int, what string, err error) {
+	r.Checks = append(r.Checks, Check{Path: path, What: what, Err: err})
+}
+
+// Verify parses a volume and checks its integrity without modifying it: the
+// checksums of all volume headers, files and CRC32 sections, the consistency
+// of all blockmaps, the alignment of all files and their state bits. Any
+// failure is recorded in the returned Report instead of failing parsing.
+//
+// An error is only returned if the volume cannot be parsed at all. Strict and
+// PreserveLayout in opts are ignored.
+func Verify(r *NestedReader, opts *ParseOptions) (*Report, error) {
+	var o ParseOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Strict = false
+	o.PreserveLayout = true
+	v, err := ReadVolume(r, &o)
+	if err != nil {
+		return nil, err
+	}
+	res := &Report{Volume: v}
+	res.verifyVolume(nil, v)
+	// Offsets of the volumes containing each file, which file alignment is
+	// relative to.
+	volumeOffsets := make(map[*FirmwareFile]int)
+	for _, f := range v.Files {
+		volumeOffsets[f] = v.readOffset
+	}
+	err = v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		switch s := section.(type) {
+		case nil:
+			res.verifyFile(path, file, volumeOffsets[file])
+		case FirmwareVolumeSection:
+			nested := s.Volume()
+			res.verifyVolume(path, nested)
+			for _, f := range nested.Files {
+				volumeOffsets[f] = nested.readOffset
+			}
+		case *guidSection:
+			if s.extra.SectionDefinitionGUID == guidCRC32 {
+				res.add(path, "crc32", s.verifyCRC32())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// verifyVolume checks the header and blockmap of a volume as read.
+func (r *Report) verifyVolume(path []int, v *Volume) {
+	bmap := append(append([]blockmap{}, v.blockmap...), blockmap{})
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, v.FirmwareVolumeHeader)
+	binary.Write(buf, binary.LittleEndian, bmap)
+	var err error
+	if sum := checksum.Checksum16(buf.Bytes()); sum != 0 {
+		err = fmt.Errorf("sums to %04x", sum)
+	}
+	r.add(path, "volume header checksum", err)
+	r.add(path, "blockmap", v.verifyBlockmap())
+}
+
+func (v *Volume) verifyBlockmap() error {
+	if want := 0x38 + 8*(len(v.blockmap)+1); int(v.HeaderLength) != want {
+		return fmt.Errorf("header length is 0x%x, blockmap requires 0x%x", v.HeaderLength, want)
+	}
+	for i, entry := range v.blockmap {
+		if entry.BlockCount == 0 || entry.BlockSize == 0 {
+			return fmt.Errorf("entry %d describes no blocks", i)
+		}
+	}
+	size := blockmapSize(v.blockmap)
+	dataSize := v.Quirks.DataSize(&v.FirmwareVolumeHeader, size)
+	if want := v.Quirks.Length(v.HeaderLength, uint64(dataSize)); v.Length != want {
+		return fmt.Errorf("length is 0x%x, blockmap describes 0x%x", v.Length, want)
+	}
+	return nil
+}
+
+// verifyFile checks the checksums, alignment and state of a file as read.
+func (r *Report) verifyFile(path []int, f *FirmwareFile, volumeOffset int) {
+	if f.original == nil {
+		r.add(path, "file", errors.New("not read from image"))
+		return
+	}
+	data := f.original[f.headerSize():f.readSize]
+	checksumHeader, checksumData := f.computeChecksums(checksum.Checksum8(data), fixedChecksum(f.fileSystem))
+	var err error
+	if checksumHeader != f.ChecksumHeader {
+		err = fmt.Errorf("is %02x, should be %02x", f.ChecksumHeader, checksumHeader)
+	}
+	r.add(path, "header checksum", err)
+	err = nil
+	if checksumData != f.ChecksumData {
+		err = fmt.Errorf("is %02x, should be %02x", f.ChecksumData, checksumData)
+	}
+	r.add(path, "data checksum", err)
+
+	err = nil
+	if !erased(f.original[f.readSize:], f.erasedByte()) {
+		err = errors.New("alignment padding is not erased")
+	}
+	offset := f.ReadOffset - volumeOffset
+	if align := int(f.Attributes.DataAlignment()); (offset+int(f.headerSize()))%align != 0 {
+		err = fmt.Errorf("data at 0x%x, not aligned to %d bytes", offset+int(f.headerSize()), align)
+	}
+	if offset%8 != 0 {
+		err = fmt.Errorf("file at 0x%x, not aligned to 8 bytes", offset)
+	}
+	r.add(path, "alignment", err)
+
+	err = nil
+	state := f.StateBits()
+	switch {
+	case state&FileStateHeaderInvalid != 0:
+		err = fmt.Errorf("header marked invalid (%02x)", f.State)
+	case state&FileStateDeleted != 0:
+		err = fmt.Errorf("file marked deleted (%02x)", f.State)
+	case state&(FileStateHeaderValid|FileStateDataValid) != FileStateHeaderValid|FileStateDataValid:
+		err = fmt.Errorf("header or data not marked valid (%02x)", f.State)
+	}
+	r.add(path, "state", err)
+}
+
+// verifyCRC32 checks the CRC32 of a section as read.
+func (c *guidSection) verifyCRC32() error {
+	data, err := concatSections(c.sub)
+	if err != nil {
+		return err
+	}
+	want := binary.LittleEndian.Uint32(c.custom)
+	if got := crc32.ChecksumIEEE(data); want != got {
+		return fmt.Errorf("header says %08x, data is %08x", want, got)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package efi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	v, err := NewVolume(0x400)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	raw, _ := NewLeafSection(SectionTypeRaw, []byte("hello"))
+	crc, _ := NewGUIDDefinedSection(SectionDefinitionCRC32, raw)
+	if err := v.InsertFile(0, NewFreeformFile(GUID{0x01}, crc)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	if err := v.InsertFile(1, testRawFile(GUID{0x02}, 0x10)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	report, err := Verify(NewNestedReader(data), nil)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !report.Passed() {
+		t.Errorf("Verify failed: %v", report.Failed())
+	}
+	if len(report.Checks) == 0 {
+		t.Errorf("Verify performed no checks")
+	}
+
+	// Mark the second file deleted and break its data checksum.
+	offset := report.Volume.Files[1].ReadOffset
+	data[offset+0x11] ^= 0x01
+	data[offset+0x17] &^= FileStateDeleted
+	report, err = Verify(NewNestedReader(data), nil)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	var failed []string
+	for _, c := range report.Failed() {
+		failed = append(failed, c.pathString()+" "+c.What)
+	}
+	if want := []string{"1 data checksum", "1 state"}; !reflect.DeepEqual(want, failed) {
+		t.Errorf("failed checks %v, wanted %v", failed, want)
+	}
+}
@@ -0,0 +1,50 @@
+package efi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Version is the contents of a version section.
+type Version struct {
+	BuildNumber uint16
+	Version     string
+}
+
+func (v *Version) String() string {
+	return fmt.Sprintf("%s (build %d)", v.Version, v.BuildNumber)
+}
+
+// SectionVersion parses the contents of a version section.
+func SectionVersion(s Section) (*Version, error) {
+	if t := s.Header().Type; t != SectionTypeVersion {
+		return nil, fmt.Errorf("%s section is not a version section", t)
+	}
+	data := s.Raw()
+	if len(data) < 2 {
+		return nil, fmt.Errorf("version section too short")
+	}
+	version, err := decodeUCS2(data[2:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid version string: %w", err)
+	}
+	return &Version{
+		BuildNumber: binary.LittleEndian.Uint16(data),
+		Version:     version,
+	}, nil
+}
+
+// SetSectionVersion sets the contents of a version section.
+func SetSectionVersion(s Section, v *Version) error {
+	if t := s.Header().Type; t != SectionTypeVersion {
+		return fmt.Errorf("%s section is not a version section", t)
+	}
+	str, err := encodeUCS2(v.Version)
+	if err != nil {
+		return fmt.Errorf("invalid version string: %w", err)
+	}
+	data := make([]byte, 2, 2+len(str))
+	binary.LittleEndian.PutUint16(data, v.BuildNumber)
+	s.SetRaw(append(data, str...))
+	return nil
+}
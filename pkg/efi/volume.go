@@ -5,7 +5,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"os"
 
+	"github.com/freemyipod/wInd3x/pkg/efi/checksum"
 	"github.com/golang/glog"
 )
 
@@ -16,7 +18,7 @@ type FirmwareVolumeHeader struct {
 	// Length is recalculated when Serialize is called.
 	Length        uint64
 	Signature     [4]byte
-	AttributeMask uint32
+	AttributeMask VolumeAttributes
 	HeaderLength  uint16
 	// Checksum is recalculated when Serialize is called.
 	Checksum        uint16
@@ -25,8 +27,16 @@ type FirmwareVolumeHeader struct {
 	Revision        uint8
 }
 
+var (
+	// guidFFS1 is EFI_FIRMWARE_FILE_SYSTEM_GUID, as used by Apple.
+	guidFFS1 = MustParseGUID("7a9354d9-0468-444a-81ce-0bf617d890df")
+	// guidFFS2 is EFI_FIRMWARE_FILE_SYSTEM2_GUID, as used by EDK2, eg. in
+	// nested volumes.
+	guidFFS2 = MustParseGUID("8c8ce578-8a3d-4f1c-9935-896185c32dd3")
+)
+
 func (h *FirmwareVolumeHeader) check() error {
-	if h.GUID.String() != "7a9354d9-0468-444a-81ce-0bf617d890df" {
+	if h.GUID != guidFFS1 && h.GUID != guidFFS2 {
 		return fmt.Errorf("unknown GUID (%s)", h.GUID.String())
 	}
 	if !bytes.Equal(h.Signature[:], []byte("_FVH")) {
@@ -38,6 +48,74 @@ func (h *FirmwareVolumeHeader) check() error {
 	return nil
 }
 
+// VolumeExtHeader is the EFI_FIRMWARE_VOLUME_EXT_HEADER of a volume. It is
+// stored within a padding file at the beginning of the volume data.
+type VolumeExtHeader struct {
+	// Name is the FvName GUID of the volume.
+	Name    GUID
+	Entries []VolumeExtEntry
+}
+
+// VolumeExtEntry is an EFI_FIRMWARE_VOLUME_EXT_ENTRY within a volume's
+// extended header.
+type VolumeExtEntry struct {
+	Type uint16
+	// Data is the entry data, without the entry header.
+	Data []byte
+}
+
+func readVolumeExtHeader(r *NestedReader) (*VolumeExtHeader, error) {
+	var hdr struct {
+		Name GUID
+		Size uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if hdr.Size < 20 || int(hdr.Size-20) > r.Len() {
+		return nil, fmt.Errorf("invalid size %d", hdr.Size)
+	}
+	res := &VolumeExtHeader{
+		Name: hdr.Name,
+	}
+	r = r.Sub(0, int(hdr.Size-20))
+	for r.Len() != 0 {
+		var entry struct {
+			Size uint16
+			Type uint16
+		}
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			return nil, fmt.Errorf("entry %d: reading header: %w", len(res.Entries), err)
+		}
+		if entry.Size < 4 || int(entry.Size-4) > r.Len() {
+			return nil, fmt.Errorf("entry %d: invalid size %d", len(res.Entries), entry.Size)
+		}
+		data := make([]byte, entry.Size-4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("entry %d: reading data: %w", len(res.Entries), err)
+		}
+		res.Entries = append(res.Entries, VolumeExtEntry{
+			Type: entry.Type,
+			Data: data,
+		})
+	}
+	return res, nil
+}
+
+func (e *VolumeExtHeader) serialize() []byte {
+	entries := bytes.NewBuffer(nil)
+	for _, entry := range e.Entries {
+		binary.Write(entries, binary.LittleEndian, uint16(4+len(entry.Data)))
+		binary.Write(entries, binary.LittleEndian, entry.Type)
+		entries.Write(entry.Data)
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.Write(e.Name[:])
+	binary.Write(buf, binary.LittleEndian, uint32(20+entries.Len()))
+	buf.Write(entries.Bytes())
+	return buf.Bytes()
+}
+
 // Volume is an EFI Firmware Volume. It contains an array of Files, all of
 // which contain recursively nested Sections.
 type Volume struct {
@@ -45,6 +123,56 @@ type Volume struct {
 	Files []*FirmwareFile
 	// Custom is trailing data at the end of the Volume.
 	Custom []byte
+	// ExtHeader is the extended header of the volume, if any. When set, it
+	// will be emitted within a padding file at the beginning of the volume
+	// on Serialize, and ExtHeaderOffset will be updated accordingly.
+	ExtHeader *VolumeExtHeader
+
+	// blockmap is the blockmap (without terminator) that the volume was read
+	// with, if any.
+	blockmap []blockmap
+	// Warnings encountered when parsing this volume in lenient mode.
+	Warnings []Warning
+
+	// freeSpace is set if the volume was read with erased free space after
+	// its last file. If so, and the volume has no padding file, free space
+	// is used to stretch the volume instead of creating a padding file.
+	freeSpace bool
+
+	// Quirks used to parse this volume, and which will be used to serialize
+	// it. If nil, the volume is serialized as per the EFI spec.
+	Quirks Quirks
+
+	// BlockSize is the size of the blocks described by the blockmap of the
+	// serialized volume. If zero, the block size of the blockmap the volume
+	// was read with is kept, or 256 byte blocks are used for new volumes.
+	// The size of the volume is always a multiple of the block size.
+	BlockSize int
+
+	// preserve is set if the volume was read with ParseOptions.PreserveLayout,
+	// and readOffset is then the offset it was read from, as used for the
+	// ReadOffset of its files.
+	preserve   bool
+	readOffset int
+
+	// MaxSize is the maximum size of the serialized volume including any
+	// trailing data, eg. the size of the flash partition it's written to. If
+	// non-zero, Serialize fails with a *BudgetError instead of producing a
+	// larger volume.
+	MaxSize int
+
+	// erase is the value of erased bytes as read, and eraseFromState is set
+	// if that was determined by the State bits of its files, as they
+	// contradict the volume attributes.
+	erase          byte
+	eraseFromState bool
+
+	// AlignData makes Serialize align the data of all files to at least 16
+	// bytes, ie. place file headers at odd multiples of 8 bytes, inserting
+	// padding files as needed. This is what the PI specification intends,
+	// while by default files are only aligned to 8 bytes (or as required by
+	// their attributes). It does not apply to nested volumes.
+	AlignData bool
 }
 
 type blockmap struct {
@@ -52,11 +180,89 @@ type blockmap struct {
 	BlockSize  uint32
 }
 
+// blockmapSize returns the total size in bytes described by a blockmap.
+func blockmapSize(bmap []blockmap) uint32 {
+	var res uint32
+	for _, entry := range bmap {
+		res += entry.BlockCount * entry.BlockSize
+	}
+	return res
+}
+
+// defaultBlockSize is the block size used for volumes which have not been read
+// from an image.
+const defaultBlockSize = 256
+
+// blockSize returns the block size to be used when serializing the volume.
+func (v *Volume) blockSize() int {
+	if v.BlockSize > 0 {
+		return v.BlockSize
+	}
+	if len(v.blockmap) > 0 && v.blockmap[0].BlockSize > 0 {
+		return int(v.blockmap[0].BlockSize)
+	}
+	return defaultBlockSize
+}
+
+// reuseBlockmap returns whether the blockmap the volume was read with can be
+// kept, ie. there is one and BlockSize was not changed.
+func (v *Volume) reuseBlockmap() bool {
+	if len(v.blockmap) == 0 {
+		return false
+	}
+	if v.BlockSize == 0 {
+		return true
+	}
+	for _, entry := range v.blockmap {
+		if int(entry.BlockSize) != v.BlockSize {
+			return false
+		}
+	}
+	return true
+}
+
+// makeBlockmap returns a blockmap (including terminator) for a volume
+// containing filesSize bytes of files. The blockmap the volume was read with
+// is reused if it still describes the volume and its block size, otherwise a
+// single-entry blockmap is generated.
+func (v *Volume) makeBlockmap(filesSize int) []blockmap {
+	blockSize := v.blockSize()
+	if v.reuseBlockmap() {
+		headerLength := 0x38 + 8*(len(v.blockmap)+1)
+		if int(blockmapSize(v.blockmap)) == filesSize+headerLength {
+			bmap := append([]blockmap{}, v.blockmap...)
+			return append(bmap, blockmap{BlockCount: 0, BlockSize: 0})
+		}
+	}
+	totalSize := filesSize + 0x38 + 0x10
+	return []blockmap{
+		{BlockCount: uint32(totalSize / blockSize), BlockSize: uint32(blockSize)},
+		{BlockCount: 0, BlockSize: 0},
+	}
+}
+
 // Parse an EFI Firmware Volume from a NestedReader. After parsing, all files
 // and sections within them will be available. These can then be arbitrarily
 // modified, and Serialize can be called on the resulting Volume to rebuild a
 // binary.
-func ReadVolume(r *NestedReader) (*Volume, error) {
+//
+// If opts is nil, the volume is parsed in lenient mode with automatically
+// selected quirks.
+func ReadVolume(r *NestedReader, opts *ParseOptions) (*Volume, error) {
+	p := newParser(opts)
+	v, err := p.readVolume(r, p.opts.Quirks)
+	if err != nil {
+		return nil, err
+	}
+	v.Warnings = p.warnings
+	for _, w := range v.Warnings {
+		glog.V(1).Infof("Warning: %s", w)
+	}
+	return v, nil
+}
+
+func (p *parser) readVolume(r *NestedReader, quirks Quirks) (*Volume, error) {
+	start := r.TellGlobal()
 	var header FirmwareVolumeHeader
 	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
 		return nil, fmt.Errorf("reading volume header failed: %w", err)
@@ -65,17 +271,24 @@ func ReadVolume(r *NestedReader) (*Volume, error) {
 	if err := header.check(); err != nil {
 		return nil, fmt.Errorf("volume header invalid: %w", err)
 	}
+	// Restore the file system and erase polarity of any outer volume when
+	// done.
+	defer func(fs GUID, erase byte) {
+		p.fileSystem = fs
+		p.erase = erase
+	}(p.fileSystem, p.erase)
+	p.fileSystem = header.GUID
 
-	blockmapSize := header.HeaderLength - 0x38
-	if blockmapSize%8 != 0 {
+	bmapBytes := header.HeaderLength - 0x38
+	if bmapBytes%8 != 0 {
 		return nil, fmt.Errorf("blockmap size not a multiple of 8")
 	}
-	bmapCount := blockmapSize / 8
+	bmapCount := bmapBytes / 8
 	var bmap []blockmap
 	for i := 0; i < int(bmapCount); i++ {
 		var entry blockmap
 		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
-			glog.Exit(err)
+			return nil, fmt.Errorf("reading blockmap failed: %w", err)
 		}
 		bmap = append(bmap, entry)
 	}
@@ -84,169 +297,694 @@ func ReadVolume(r *NestedReader) (*Volume, error) {
 		return nil, fmt.Errorf("blockmap does not end in (0, 0)")
 	}
 
-	if len(bmap) != 2 {
-		return nil, fmt.Errorf("unsupported count of blockmaps (%d, wanted 2)", len(bmap))
+	checkBuf := bytes.NewBuffer(nil)
+	binary.Write(checkBuf, binary.LittleEndian, header)
+	binary.Write(checkBuf, binary.LittleEndian, bmap)
+	if sum := checksum.Checksum16(checkBuf.Bytes()); sum != 0 {
+		if err := p.warnf(start, "volume header checksum invalid (sums to %04x)", sum); err != nil {
+			return nil, err
+		}
+	}
+
+	// Drop terminator.
+	bmap = bmap[:len(bmap)-1]
+	if len(bmap) == 0 {
+		return nil, fmt.Errorf("blockmap is empty")
 	}
 
 	glog.V(1).Infof("Blockmap: %+v", bmap)
 
-	dataSize := bmap[0].BlockCount * bmap[0].BlockSize
-	// This doesn't make sense, but otherwise that section is just too large. I
-	// think it's an implementation bug in the iPod firmware.
-	dataSize -= 0x28 + 0x20
+	var total uint64
+	for _, entry := range bmap {
+		total += uint64(entry.BlockCount) * uint64(entry.BlockSize)
+	}
+	if total > 0xffffffff || total < uint64(header.HeaderLength) {
+		return nil, fmt.Errorf("blockmap size (%d bytes) invalid", total)
+	}
+
+	if quirks == nil {
+		quirks = selectQuirks(&header, blockmapSize(bmap))
+	}
+	glog.V(1).Infof("Quirks: %s", quirks.Name())
+	dataSize := quirks.DataSize(&header, blockmapSize(bmap))
+	if int(dataSize) > r.Len() {
+		return nil, fmt.Errorf("data size (%d bytes) larger than volume", dataSize)
+	}
 
 	dataSub := r.Sub(0, int(dataSize))
 	r.Advance(int(dataSize))
 
+	p.erase = header.AttributeMask.ErasePolarity()
+	eraseFromState := false
+	if erase, ok := stateErasePolarity(dataSub.Sub(0, dataSub.Len())); ok && erase != p.erase {
+		glog.V(1).Infof("File state bits imply erased bytes of %02x, contradicting volume attributes", erase)
+		p.erase = erase
+		eraseFromState = true
+	}
+
 	glog.V(1).Infof("Data size: %d bytes", dataSize)
 
 	// Currently always 928 bytes of trailing data. That's the signature / cert
-	// chain. We should also be able to recover this size from the IMG1 header.
+	// chain, see Volume.Trailer and Volume.TrailerWithLayout. Only other
+	// trailing data is unexpected.
 	rest, err := io.ReadAll(r)
 	if err != nil {
 		return nil, fmt.Errorf("reading rest failed: %v", err)
 	}
-	//if len(rest) != 928 {
-	//	return nil, fmt.Errorf("trailing data of %d bytes", len(rest))
-	//}
+	if len(rest) != 0 {
+		if _, err := parseTrailer(rest); err == nil {
+			glog.V(1).Infof("%d bytes of signature/certificate trailer", len(rest))
+		} else if err := p.warnf(start, "%d bytes of trailing data after volume", len(rest)); err != nil {
+			return nil, err
+		}
+	}
+
+	var extHeader *VolumeExtHeader
+	if header.ExtHeaderOffset != 0 {
+		offset := int(header.ExtHeaderOffset) - int(header.HeaderLength)
+		if offset < 0 || offset >= dataSub.Len() {
+			return nil, fmt.Errorf("extended header offset 0x%x out of bounds", header.ExtHeaderOffset)
+		}
+		extHeader, err = readVolumeExtHeader(dataSub.Sub(offset, dataSub.Len()-offset))
+		if err != nil {
+			return nil, fmt.Errorf("reading extended header failed: %w", err)
+		}
+		glog.V(1).Infof("Extended header: %+v", extHeader)
+	}
 
 	var files []*FirmwareFile
+	freeSpace := false
+	// Files of the top-level volume may be parsed in parallel. Their headers
+	// are read here, and their sections are parsed by forked parsers after
+	// all files have been found.
+	parallel := p.opts.Parallelism > 1 && p.depth == 0
+	var pending []pendingFile
+	nwarnings := len(p.warnings)
 	for dataSub.Len() != 0 {
-		file, err := readFile(dataSub)
+		// Erased data instead of a file header means we've reached free
+		// space at the end of the volume.
+		hdr := make([]byte, 0x18)
+		n, _ := dataSub.Sub(0, dataSub.Len()).Read(hdr)
+		if n < len(hdr) || erased(hdr, p.erase) {
+			offset := dataSub.TellGlobal()
+			free, _ := io.ReadAll(dataSub)
+			if !erased(free, p.erase) {
+				if err := p.warnf(offset, "free space at end of volume is not erased"); err != nil {
+					return nil, err
+				}
+			}
+			freeSpace = true
+			break
+		}
+		if parallel {
+			fp := p.fork()
+			file, data, err := fp.readFileHeader(dataSub)
+			if err != nil {
+				return nil, fmt.Errorf("reading file %d failed: %v", len(files), err)
+			}
+			pending = append(pending, pendingFile{p: fp, index: len(files), file: file, data: data})
+			files = append(files, file)
+			continue
+		}
+		file, err := p.readFile(dataSub)
 		if err != nil {
 			return nil, fmt.Errorf("reading file %d failed: %v", len(files), err)
 		}
 		files = append(files, file)
 	}
+	if parallel {
+		warnings, err := p.readPendingFiles(pending)
+		if err != nil {
+			return nil, err
+		}
+		// Put the warnings of the files before any about free space, as if
+		// they had been parsed sequentially.
+		p.warnings = append(p.warnings[:nwarnings], append(warnings, p.warnings[nwarnings:]...)...)
+	}
 	glog.V(1).Infof("%d files", len(files))
 
+	if extHeader != nil {
+		// Find the padding file that contains the extended header, so that
+		// we can put it back there on Serialize.
+		offset := start + int(header.ExtHeaderOffset)
+		found := false
+		for _, f := range files {
+			dataStart := f.ReadOffset + int(f.headerSize())
+			if offset < dataStart || offset >= f.ReadOffset+int(f.TotalSize()) {
+				continue
+			}
+			if f.FileType != FileTypePadding || offset != dataStart {
+				return nil, fmt.Errorf("extended header not at start of padding file")
+			}
+			f.extHeader = true
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("extended header not within any file")
+		}
+	}
+
+	markAlignmentPadding(files)
+
 	return &Volume{
 		FirmwareVolumeHeader: header,
 		Files:                files,
 		Custom:               rest,
+		ExtHeader:            extHeader,
+		blockmap:             bmap,
+		freeSpace:            freeSpace,
+		Quirks:               quirks,
+		preserve:             p.opts.PreserveLayout,
+		readOffset:           start,
+		erase:                p.erase,
+		eraseFromState:       eraseFromState,
 	}, nil
 }
 
-func (v *Volume) Serialize() ([]byte, error) {
-	// Find all padding files, pick last one to stretch image.
-	havePadding := false
-	paddingFileNumber := 0
-	for i, f := range v.Files {
-		if f.FileType != FileTypePadding {
-			continue
+// prepareExtHeader ensures that the volume has a padding file containing its
+// extended header, creating one at the beginning of the volume if needed.
+func (v *Volume) prepareExtHeader() {
+	var carrier *FirmwareFile
+	for _, f := range v.Files {
+		if f.extHeader {
+			carrier = f
+			break
 		}
-		havePadding = true
-		paddingFileNumber = i
 	}
-	// No padding file? Create our own.
-	if !havePadding {
-		panic("unimplemented")
-		v.Files = append(v.Files, &FirmwareFile{
+	if carrier == nil {
+		carrier = &FirmwareFile{
 			FirmwareFileHeader: FirmwareFileHeader{
-				//GUID:       uuid.UUID4(),
-				ChecksumHeader: 0,
-				ChecksumData:   0,
-				FileType:       FileTypePadding,
-				Attributes:     0x40,
-				Size:           ToUint24(0),
-				State:          0xf8,
+				GUID:     GUID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+				FileType: FileTypePadding,
+				State:    0xf8,
 			},
-		})
-		paddingFileNumber = len(v.Files) - 1
+			extHeader: true,
+		}
+		v.Files = append([]*FirmwareFile{carrier}, v.Files...)
+	}
+	carrier.padContents = v.ExtHeader.serialize()
+	// Keep the existing size of the padding file if the extended header
+	// still fits, so that unmodified volumes serialize back the same way.
+	size := carrier.headerSize() + uint64(len(carrier.padContents))
+	if carrier.TotalSize() < size {
+		carrier.Size = ToUint24(uint32(size))
+	}
+}
+
+// markAlignmentPadding marks padding files which were likely inserted only to
+// align the data of the following file: erased padding files smaller than the
+// alignment plus a file header.
+func markAlignmentPadding(files []*FirmwareFile) {
+	for i := 0; i+1 < len(files); i++ {
+		f, next := files[i], files[i+1]
+		align := next.Attributes.DataAlignment()
+		if f.FileType != FileTypePadding || f.extHeader || f.padContents != nil || align <= 8 {
+			continue
+		}
+		if f.TotalSize() < align+0x18 {
+			f.alignPad = true
+		}
+	}
+}
+
+// alignmentPadding returns the size of the padding file required before a file
+// at offset so that its data (following a header of headerSize) is aligned to
+// align bytes. Zero means no padding file is required.
+func alignmentPadding(offset, headerSize int, align int) int {
+	pad := (align - (offset+headerSize)%align) % align
+	for pad != 0 && pad < 0x18 {
+		pad += align
+	}
+	return pad
+}
+
+// stretchFile returns the padding file which gets stretched to fill the volume
+// to its final size, or nil if there is none. This is the last padding file
+// which does not contain the extended header and does not serve to align
+// another file.
+func (v *Volume) stretchFile() *FirmwareFile {
+	var padding *FirmwareFile
+	for _, f := range v.Files {
+		if f.FileType != FileTypePadding || f.extHeader || f.alignPad {
+			continue
+		}
+		padding = f
+	}
+	return padding
+}
+
+// erasePolarity returns the value of erased bytes within the volume, as
+// determined by its attributes or, if they were contradicted by the State bits
+// of the files read, by those.
+func (v *Volume) erasePolarity() byte {
+	if v.eraseFromState {
+		return v.erase
+	}
+	return v.AttributeMask.ErasePolarity()
+}
+
+// stateErasePolarity returns the value of erased bytes implied by the State
+// bits of the first file within the volume data read by r. State bits are
+// set by programming erased bits, and only the low bits are defined, so the
+// high bit is set in volumes erased to 0xff. If there are no files, false is
+// returned.
+func stateErasePolarity(r *NestedReader) (byte, bool) {
+	hdr := make([]byte, 0x18)
+	if n, _ := r.Read(hdr); n < len(hdr) || erased(hdr, 0xff) || erased(hdr, 0x00) {
+		return 0, false
+	}
+	if hdr[0x17]&0x80 != 0 {
+		return 0xff, true
 	}
+	return 0x00, true
+}
+
+// adopt prepares f to be serialized within this volume, setting the file system
+// it's in and converting its State bits to the volume's erase polarity.
+func (v *Volume) adopt(f *FirmwareFile) {
+	f.fileSystem = v.GUID
+	f.setErasePolarity(v.erasePolarity())
+}
+
+func newPaddingFile() *FirmwareFile {
+	return &FirmwareFile{
+		FirmwareFileHeader: FirmwareFileHeader{
+			GUID:       GUID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			FileType:   FileTypePadding,
+			Attributes: FileAttributeChecksum,
+			State:      0xf8,
+		},
+	}
+}
+
+// volumeLayout is the result of laying out all files of a volume apart from
+// the padding file used to stretch it.
+type volumeLayout struct {
+	// fileSizes are the sizes of the serialized and aligned data of all
+	// files apart from the stretched padding file, by index.
+	fileSizes map[int]int
+	// filesSize is the total size of all files in fileSizes.
+	filesSize int
+	// paddingMinimum is the minimum size of the stretched padding file, or
+	// zero if free space is used instead.
+	paddingMinimum int
+	// headerLength is the size of the volume header, including blockmap.
+	headerLength int
+	// totalSize is the size of the volume, including its header.
+	totalSize int
+}
+
+// free returns how many bytes can be added to files without growing the
+// volume.
+func (l *volumeLayout) free() int {
+	return l.totalSize - l.headerLength - l.filesSize - l.paddingMinimum
+}
+
+// layout serializes all files apart from the given padding file (or free
+// space, if nil) to determine their sizes, and the size of the volume. If out
+// is not nil, the serialized files are written to it.
+func (v *Volume) layout(padding *FirmwareFile, out spool) (*volumeLayout, error) {
+	// We attempt to keep the size of the volume as it was read. If the files
+	// don't fit anymore, grow the volume to the next block boundary.
+	headerLength := 0x38 + 0x10
+	if v.reuseBlockmap() {
+		headerLength = 0x38 + 8*(len(v.blockmap)+1)
+	}
+	l, err := v.layoutFiles(padding, headerLength, out)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.blockmap) > 0 {
+		l.totalSize = int(blockmapSize(v.blockmap))
+		// With a changed block size, the volume must still consist of
+		// whole blocks.
+		if blockSize := v.blockSize(); !v.reuseBlockmap() && l.totalSize%blockSize != 0 {
+			l.totalSize += blockSize - (l.totalSize % blockSize)
+		}
+	}
+	if padding != nil {
+		l.paddingMinimum = int(padding.headerSize())
+	}
+	if minimum := l.headerLength + l.filesSize + l.paddingMinimum; minimum > l.totalSize {
+		if l.headerLength != 0x38+0x10 {
+			// File alignment depends on the header length, so lay out
+			// the files again, discarding what was already written.
+			paddingMinimum := l.paddingMinimum
+			if out != nil {
+				if err := out.reset(); err != nil {
+					return nil, err
+				}
+			}
+			l, err = v.layoutFiles(padding, 0x38+0x10, out)
+			if err != nil {
+				return nil, err
+			}
+			l.paddingMinimum = paddingMinimum
+		}
+		l.totalSize = l.headerLength + l.filesSize + l.paddingMinimum
+		if blockSize := v.blockSize(); l.totalSize%blockSize != 0 {
+			l.totalSize += blockSize - (l.totalSize % blockSize)
+		}
+	}
+	return l, nil
+}
 
-	// First, serialize all files apart from used padding file so that we know
-	// how much data we're dealing with here.
-	filesSize := 0
-	fileData := make(map[int][]byte)
+// layoutFiles serializes all files apart from the stretched padding file so
+// that we know how much data we're dealing with here. Files whose attributes
+// require their data to be aligned to more than 8 bytes are preceded by
+// padding files as needed. If out is not nil, the data of every file is
+// written to it as soon as it is serialized, so that only one file at a time
+// is held in memory.
+func (v *Volume) layoutFiles(padding *FirmwareFile, headerLength int, out io.Writer) (*volumeLayout, error) {
+	l := &volumeLayout{
+		fileSizes:    make(map[int]int),
+		headerLength: headerLength,
+	}
+	offset := headerLength
+	// Offsets past the stretched padding file are not known until it is
+	// stretched.
+	offsetKnown := true
+	var alignPad *FirmwareFile
 	for i, f := range v.Files {
-		_ = paddingFileNumber
-		//if i == paddingFileNumber {
-		//	filesSize += 24
-		//	continue
-		//}
+		v.adopt(f)
+		if f == padding {
+			offsetKnown = false
+			continue
+		}
+		if f.alignPad {
+			alignPad = f
+			continue
+		}
 		data, err := f.Serialize()
 		if err != nil {
 			return nil, fmt.Errorf("file %d: %w", i, err)
 		}
-		// Align all files to 8 bytes. I think generally we should align the
-		// content to start at 16 bytes, with the header being an odd multiple
-		// of 8, but this works for now?
+		start := offset
+		var pre []byte
+		if v.preserve {
+			data = f.preservedData(data)
+			if offsetKnown && alignPad == nil {
+				if pre, err = v.preserveOffset(offset, f); err != nil {
+					return nil, fmt.Errorf("file %d offset padding: %w", i, err)
+				}
+				offset += len(pre)
+			}
+		}
+		if align := v.dataAlignment(f); align > 8 {
+			if !offsetKnown {
+				if int(f.Attributes.DataAlignment()) == align {
+					glog.Warningf("File %s follows padding file, cannot guarantee its %d byte alignment", f.GUID, align)
+				}
+			} else if pad, err := v.alignFile(offset, f, alignPad); err != nil {
+				return nil, fmt.Errorf("file %d alignment padding: %w", i, err)
+			} else {
+				data = append(pad, data...)
+			}
+		}
+		data = append(pre, data...)
+		alignPad = nil
+		// Align all files to 8 bytes. Aligning their data to 16 bytes is up
+		// to AlignData.
 		if len(data)%8 != 0 {
 			pad := 8 - (len(data) % 8)
-			data = append(data, bytes.Repeat([]byte{0xff}, pad)...)
+			data = append(data, bytes.Repeat([]byte{v.erasePolarity()}, pad)...)
+		}
+		if out != nil {
+			if _, err := out.Write(data); err != nil {
+				return nil, fmt.Errorf("file %d: %w", i, err)
+			}
 		}
-		fileData[i] = data
-		filesSize += len(data)
+		l.fileSizes[i] = len(data)
+		l.filesSize += len(data)
+		offset = start + len(data)
 	}
-	// Now that we have a size, make a blockmap.
-	totalSize := filesSize + 0x38 + 0x10
-	nblocks := uint32(totalSize / 256)
-	bmap := []blockmap{
-		{BlockCount: nblocks, BlockSize: 256},
-		{BlockCount: 0, BlockSize: 0},
+	return l, nil
+}
+
+// preserveOffset returns the serialized padding file required to keep f at
+// the offset it was read from, if it would otherwise be placed before it at
+// offset. If f cannot be kept in place, nil is returned.
+func (v *Volume) preserveOffset(offset int, f *FirmwareFile) ([]byte, error) {
+	if f.original == nil {
+		return nil, nil
+	}
+	gap := f.ReadOffset - v.readOffset - offset
+	if gap == 0 {
+		return nil, nil
+	}
+	if gap < 0x18 {
+		glog.V(1).Infof("File %s moved by %d bytes, cannot keep it at its original offset", f.GUID, -gap)
+		return nil, nil
+	}
+	pad := newPaddingFile()
+	v.adopt(pad)
+	pad.setTotalSize(uint64(gap))
+	return pad.Serialize()
+}
+
+// dataAlignment returns the alignment required for the data of f within this
+// volume.
+func (v *Volume) dataAlignment(f *FirmwareFile) int {
+	align := int(f.Attributes.DataAlignment())
+	if v.AlignData && align < 16 && f.FileType != FileTypePadding {
+		align = 16
 	}
+	return align
+}
 
-	// Update the padding file with padding data.
-	paddingNeeded := 0
-	if filesSize%256 != 0 {
-		paddingNeeded = 256 - (filesSize % 256)
+// alignFile returns the serialized padding file required so that the data of
+// f, placed at offset, is aligned as required by its attributes. If the file
+// was preceded by an alignment padding file when read, its size is kept if
+// possible.
+func (v *Volume) alignFile(offset int, f, previous *FirmwareFile) ([]byte, error) {
+	align := v.dataAlignment(f)
+	size := alignmentPadding(offset, int(f.headerSize()), align)
+	if previous != nil {
+		if prev := int(previous.TotalSize()); (offset+prev+int(f.headerSize()))%align == 0 {
+			size = prev
+		}
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	pad := previous
+	if pad == nil {
+		pad = newPaddingFile()
+		pad.alignPad = true
 	}
-	//v.files[paddingFileNumber].sections = []Section{&leafSection{
-	//	commonSectionHeader: commonSectionHeader{
-	//		// Doesn't matter, will get updated on next serialize.
-	//		Size: ToUint24(0),
-	//		Type: SectionTypeRaw,
-	//	},
-	//	data: bytes.Repeat([]byte{0xff}, paddingNeeded),
-	//}}
+	v.adopt(pad)
+	pad.setTotalSize(uint64(size))
+	return pad.Serialize()
+}
+
+// SpaceUsage describes how space is used within a Volume.
+type SpaceUsage struct {
+	// Total size of the volume, including its header.
+	Total int
+	// Header is the size of the volume header, including the blockmap.
+	Header int
+	// Files are the sizes of all files within the volume, in order, including
+	// alignment. The padding file used to stretch the volume is included with
+	// its stretched size.
+	Files []int
+	// Free is the amount of bytes that can be added to files before the
+	// volume has to grow.
+	Free int
+}
 
-	// Do final serialization pass into buffer.
+// SpaceUsage returns how space is used within this volume, were it serialized
+// now.
+func (v *Volume) SpaceUsage() (*SpaceUsage, error) {
+	padding := v.stretchFile()
+	if padding == nil && !v.freeSpace {
+		// Serialize would create a padding file, account for it.
+		padding = newPaddingFile()
+	}
+	l, err := v.layout(padding, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := &SpaceUsage{
+		Total:  l.totalSize,
+		Header: l.headerLength,
+		Free:   l.free(),
+	}
+	for i, f := range v.Files {
+		if f == padding {
+			res.Files = append(res.Files, l.paddingMinimum+res.Free)
+		} else {
+			res.Files = append(res.Files, l.fileSizes[i])
+		}
+	}
+	return res, nil
+}
+
+// FreeSpace returns how many bytes can be added to files within this volume
+// (eg. by inserting a new file) before it has to grow.
+func (v *Volume) FreeSpace() (int, error) {
+	usage, err := v.SpaceUsage()
+	if err != nil {
+		return 0, err
+	}
+	return usage.Free, nil
+}
+
+func (v *Volume) Serialize() ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
-	// Header size.
-	v.Length = 0
-	// Blockmap size.
-	//v.Length += uint64(8 * len(bmap))
-	// Data size.
-	v.Length += uint64(filesSize + paddingNeeded)
+	if err := v.serializeTo(buf, &memorySpool{}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// spool holds serialized files until the volume header, which depends on
+// their sizes, has been written.
+type spool interface {
+	io.Writer
+	io.ReaderAt
+	// reset discards all data written so far.
+	reset() error
+}
+
+type memorySpool struct {
+	data []byte
+}
+
+func (m *memorySpool) Write(p []byte) (int, error) {
+	m.data = append(m.data, p...)
+	return len(p), nil
+}
+
+func (m *memorySpool) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(m.data).ReadAt(p, off)
+}
+
+func (m *memorySpool) reset() error {
+	m.data = m.data[:0]
+	return nil
+}
+
+type fileSpool struct {
+	*os.File
+}
+
+func (f *fileSpool) reset() error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// SerializeTo writes the serialized volume to w. Files are serialized only
+// once, one at a time, into a temporary file, from which they are copied to w
+// once the volume header is written. This way only a single file's data is
+// ever held in memory.
+func (v *Volume) SerializeTo(w io.Writer) error {
+	f, err := os.CreateTemp("", "wInd3x-volume-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	return v.serializeTo(w, &fileSpool{f})
+}
+
+func (v *Volume) serializeTo(w io.Writer, files spool) error {
+	if v.ExtHeader != nil {
+		v.prepareExtHeader()
+	}
+
+	// No padding file? Create our own, unless we can use free space.
+	padding := v.stretchFile()
+	if padding == nil && !v.freeSpace {
+		padding = newPaddingFile()
+		v.Files = append(v.Files, padding)
+	}
+
+	l, err := v.layout(padding, files)
+	if err != nil {
+		return err
+	}
+	if err := v.checkBudget(l, padding); err != nil {
+		return err
+	}
+	filesSize := l.filesSize
+
+	// Now stretch the padding file or free space to fill the volume.
+	freeSpace := 0
+	if padding != nil {
+		padding.setTotalSize(uint64(l.totalSize - l.headerLength - filesSize))
+		filesSize += int(padding.TotalSize())
+	} else {
+		freeSpace = l.totalSize - l.headerLength - filesSize
+		filesSize += freeSpace
+	}
+
+	// Now that we have a size, make a blockmap.
+	bmap := v.makeBlockmap(filesSize)
+
 	v.HeaderLength = uint16(0x38 + 8*len(bmap))
+	quirks := v.Quirks
+	if quirks == nil {
+		quirks = QuirksNone
+	}
+	v.Length = quirks.Length(v.HeaderLength, uint64(filesSize))
 	v.ExtHeaderOffset = 0
+	if v.ExtHeader != nil {
+		offset := int(v.HeaderLength)
+		for i, f := range v.Files {
+			if f.extHeader {
+				v.ExtHeaderOffset = uint16(offset + int(f.headerSize()))
+				break
+			}
+			if f == padding {
+				offset += int(f.TotalSize())
+			} else {
+				offset += l.fileSizes[i]
+			}
+		}
+	}
 	// TODO Reserved2/Revision?
 
 	v.Checksum = 0
 	checkBuf := bytes.NewBuffer(nil)
 	binary.Write(checkBuf, binary.LittleEndian, v.FirmwareVolumeHeader)
 	binary.Write(checkBuf, binary.LittleEndian, bmap)
-	v.Checksum = checksum16(checkBuf.Bytes())
+	v.Checksum = checksum.Checksum16(checkBuf.Bytes())
 
-	if err := binary.Write(buf, binary.LittleEndian, v.FirmwareVolumeHeader); err != nil {
-		// Shouldn't happen.
-		panic(err)
+	// Do final serialization pass into writer.
+	if err := binary.Write(w, binary.LittleEndian, v.FirmwareVolumeHeader); err != nil {
+		return err
 	}
-	if err := binary.Write(buf, binary.LittleEndian, bmap); err != nil {
-		// Shouldn't happen.
-		panic(err)
+	if err := binary.Write(w, binary.LittleEndian, bmap); err != nil {
+		return err
 	}
+	// Copy the files over, writing the stretched padding file in its place.
+	paddingAt := l.filesSize
 	for i, f := range v.Files {
-		if data, ok := fileData[i]; ok {
-			if _, err := buf.Write(data); err != nil {
-				// Shouldn't happen.
-				panic(err)
-			}
-		} else {
-			// Padding file.
-			data, err := f.Serialize()
-			if err != nil {
-				// Shouldn't happen.
-				panic(err)
-			}
-			if _, err := buf.Write(data); err != nil {
-				// Shouldn't happen.
-				panic(err)
+		if f == padding {
+			paddingAt = 0
+			for j := 0; j < i; j++ {
+				paddingAt += l.fileSizes[j]
 			}
+			break
 		}
 	}
-
-	buf.Write(v.Custom)
-	return buf.Bytes(), nil
+	if _, err := io.Copy(w, io.NewSectionReader(files, 0, int64(paddingAt))); err != nil {
+		return err
+	}
+	if padding != nil {
+		if err := padding.SerializeTo(w); err != nil {
+			return fmt.Errorf("padding file: %w", err)
+		}
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(files, int64(paddingAt), int64(l.filesSize-paddingAt))); err != nil {
+		return err
+	}
+	if err := writeFill(w, v.erasePolarity(), freeSpace); err != nil {
+		return err
+	}
+	_, err = w.Write(v.Custom)
+	return err
 }
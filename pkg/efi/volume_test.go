@@ -0,0 +1,643 @@
+package efi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestVolumeSerializeCreatesPadding(t *testing.T) {
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			{
+				FirmwareFileHeader: FirmwareFileHeader{
+					FileType:   FileTypeDriver,
+					Attributes: FileAttributeChecksum,
+					State:      0xf8,
+				},
+				Sections: []Section{
+					&leafSection{
+						commonSectionHeader: commonSectionHeader{Type: SectionTypeRaw},
+						data:                bytes.Repeat([]byte("wInd3x"), 100),
+					},
+				},
+			},
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if len(data)%256 != 0 {
+		t.Errorf("volume is %d bytes, wanted multiple of block size", len(data))
+	}
+	if want, got := 2, len(v.Files); want != got {
+		t.Fatalf("wanted %d files after serialization, got %d", want, got)
+	}
+	if want, got := FileTypePadding, v.Files[1].FileType; want != got {
+		t.Errorf("wanted last file to be %s, got %s", want, got)
+	}
+	free, err := v.FreeSpace()
+	if err != nil {
+		t.Fatalf("FreeSpace: %v", err)
+	}
+	if want, got := int(v.Files[1].TotalSize()-0x18), free; want != got {
+		t.Errorf("wanted %d bytes of free space, got %d", want, got)
+	}
+
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	data2, err := v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+}
+
+func TestVolumeSerializeAlignsFiles(t *testing.T) {
+	aligned := testRawFile(GUID{0x02}, 0x10)
+	if err := aligned.Attributes.SetDataAlignment(4096); err != nil {
+		t.Fatalf("SetDataAlignment: %v", err)
+	}
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			testRawFile(GUID{0x01}, 0x123),
+			aligned,
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	var found bool
+	for _, f := range v2.Files {
+		if f.GUID != aligned.GUID {
+			continue
+		}
+		found = true
+		if offset := f.ReadOffset + int(f.headerSize()); offset%4096 != 0 {
+			t.Errorf("file data at 0x%x, wanted 4096 byte alignment", offset)
+		}
+	}
+	if !found {
+		t.Fatalf("aligned file missing after serialization")
+	}
+	data2, err := v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+}
+
+func TestVolumeSerializeAlignData(t *testing.T) {
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			testRawFile(GUID{0x01}, 0x10),
+			testRawFile(GUID{0x02}, 0x123),
+			testRawFile(GUID{0x03}, 0x8),
+		},
+		AlignData: true,
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	// Files must still be found by walking the volume in 8 byte steps.
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	found := 0
+	for _, f := range v2.Files {
+		if f.FileType == FileTypePadding {
+			continue
+		}
+		found++
+		if f.ReadOffset%16 != 8 {
+			t.Errorf("file %s header at 0x%x, wanted odd multiple of 8", f.GUID, f.ReadOffset)
+		}
+	}
+	if found != 3 {
+		t.Fatalf("found %d files after serialization, wanted 3", found)
+	}
+	v2.AlignData = true
+	data2, err := v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+}
+
+func TestVolumeSerializeErasePolarity(t *testing.T) {
+	v, err := NewVolume(0x200)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	v.AttributeMask &^= VolumeErasePolarity
+	file := testRawFile(GUID{0x01}, 0x11)
+	if err := v.InsertFile(0, file); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := uint8(0x07), file.State; want != got {
+		t.Errorf("file state is %02x, wanted %02x", got, want)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	for _, f := range v2.Files {
+		if f.FileType != FileTypePadding {
+			continue
+		}
+		if data := data[f.ReadOffset+int(f.headerSize()) : f.ReadOffset+int(f.TotalSize())]; !erased(data, 0x00) {
+			t.Errorf("padding file not filled with zeroes")
+		}
+	}
+
+	// State bits take precedence over attributes contradicting them.
+	v.AttributeMask |= VolumeErasePolarity
+	if data, err = v.Serialize(); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := uint8(0xf8), file.State; want != got {
+		t.Errorf("file state is %02x, wanted %02x", got, want)
+	}
+	binary.LittleEndian.PutUint32(data[0x2c:], uint32(v.AttributeMask&^VolumeErasePolarity))
+	v3, err := ReadVolume(NewNestedReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	data3, err := v3.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data[0x48:], data3[0x48:]) {
+		t.Errorf("reserialized files differ from original")
+	}
+}
+
+func TestReadVolumeReaderAt(t *testing.T) {
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			testRawFile(GUID{0x01}, 0x123),
+			testRawFile(GUID{0x02}, 0x456),
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v2, err := ReadVolume(NewNestedReaderAt(bytes.NewReader(data), int64(len(data))), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	data2, err := v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+}
+
+func TestVolumeSerializeTo(t *testing.T) {
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			testRawFile(GUID{0x01}, 0x123),
+		},
+	}
+	want, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := v.SerializeTo(buf); err != nil {
+		t.Fatalf("SerializeTo: %v", err)
+	}
+	if !bytes.Equal(want, buf.Bytes()) {
+		t.Errorf("SerializeTo output differs from Serialize")
+	}
+	if _, err := ReadVolume(NewNestedReader(buf.Bytes()), &ParseOptions{Strict: true}); err != nil {
+		t.Errorf("ReadVolume: %v", err)
+	}
+}
+
+func TestVolumeSerializeBudget(t *testing.T) {
+	v := testEditVolume(t, 0x100)
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v.MaxSize = len(data)
+	v.Files[0] = testRawFile(GUID{0x01}, len(data))
+	_, err = v.Serialize()
+	var be *BudgetError
+	if !errors.As(err, &be) || !errors.Is(err, ErrOverBudget) {
+		t.Fatalf("Serialize of grown volume: got %v, wanted *BudgetError", err)
+	}
+	if len(be.Files) != 1 || be.Files[0].GUID != (GUID{0x01}) || be.Files[0].NewSize <= be.Files[0].OldSize {
+		t.Errorf("unexpected budget report %+v", be.Files)
+	}
+	v.MaxSize = 0
+	if _, err := v.Serialize(); err != nil {
+		t.Errorf("Serialize without budget: %v", err)
+	}
+}
+
+func TestVolumeSerializeBlockSize(t *testing.T) {
+	v, err := NewVolume(0x1000)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	v.BlockSize = 0x1000
+	if err := v.InsertFile(0, testRawFile(GUID{0x01}, 0x80)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if want, got := []blockmap{{BlockCount: 1, BlockSize: 0x1000}}, v2.blockmap; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("blockmap %+v, wanted %+v", got, want)
+	}
+
+	// Growing the volume keeps the block size it was read with.
+	if err := v2.InsertFile(1, testRawFile(GUID{0x02}, 0x1000)); !errors.Is(err, ErrVolumeFull) {
+		t.Fatalf("InsertFile: got %v, wanted ErrVolumeFull", err)
+	}
+	v2.Files = append(v2.Files[:1:1], append([]*FirmwareFile{testRawFile(GUID{0x02}, 0x1000)}, v2.Files[1:]...)...)
+	data, err = v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := 0x2000, len(data); want != got {
+		t.Errorf("grown volume is 0x%x bytes, wanted 0x%x", got, want)
+	}
+}
+
+func TestVolumePreserveLayout(t *testing.T) {
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			testRawFile(GUID{0x01}, 0x123),
+			testRawFile(GUID{0x02}, 0x10),
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	// Non-erased alignment padding after the first file, and a bad data
+	// checksum in the second.
+	data[0x48+0x13f] = 0x00
+	data[0x188+0x11] ^= 0xff
+
+	v, err = ReadVolume(NewNestedReader(data), &ParseOptions{PreserveLayout: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	data2, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+
+	if err := v.ReplaceFile(GUID{0x01}, testRawFile(GUID{0x01}, 0x23)); err != nil {
+		t.Fatalf("ReplaceFile: %v", err)
+	}
+	data2, err = v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data[0x188:0x1b0], data2[0x188:0x1b0]) {
+		t.Errorf("unmodified file moved or changed")
+	}
+	v2, err := ReadVolume(NewNestedReader(data2), nil)
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if want, got := 3, len(v2.Files); want > got {
+		t.Fatalf("wanted at least %d files, got %d", want, got)
+	}
+}
+
+func TestVolumeSerializeNested(t *testing.T) {
+	inner := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			NewRawFile(GUID{0x02}, []byte("nested payload")),
+		},
+	}
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			NewFile(GUID{0x01}, FileTypeFirmwareVolumeImage, NewFirmwareVolumeSection(inner)),
+		},
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	section, ok := v2.Files[0].Sections[0].(FirmwareVolumeSection)
+	if !ok {
+		t.Fatalf("first section is %T, wanted FirmwareVolumeSection", v2.Files[0].Sections[0])
+	}
+	nested := section.Volume()
+	if want, got := []byte("nested payload"), nested.Files[0].Data; !bytes.Equal(want, got) {
+		t.Errorf("wanted nested file data %q, got %q", want, got)
+	}
+	data2, err := v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+}
+
+func TestVolumeExtHeader(t *testing.T) {
+	ext := &VolumeExtHeader{
+		Name: GUID{0xaa},
+		Entries: []VolumeExtEntry{
+			{Type: 1, Data: []byte("entry data")},
+		},
+	}
+	v := &Volume{
+		FirmwareVolumeHeader: FirmwareVolumeHeader{
+			GUID:      guidFFS2,
+			Signature: [4]byte{'_', 'F', 'V', 'H'},
+			Revision:  2,
+		},
+		Files: []*FirmwareFile{
+			testRawFile(GUID{0x01}, 0x123),
+			{
+				FirmwareFileHeader: FirmwareFileHeader{
+					GUID:     GUID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+					FileType: FileTypePadding,
+					State:    0xf8,
+				},
+				extHeader: true,
+			},
+			testRawFile(GUID{0x02}, 0x10),
+		},
+		ExtHeader: ext,
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	// The extended header follows the first file and the header of the
+	// padding file carrying it.
+	if want, got := uint16(0x48+0x140+0x18), v.ExtHeaderOffset; want != got {
+		t.Errorf("extended header at 0x%x, wanted 0x%x", got, want)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if v2.ExtHeader == nil {
+		t.Fatalf("extended header missing after serialization")
+	}
+	if want, got := ext.Name, v2.ExtHeader.Name; want != got {
+		t.Errorf("extended header name %s, wanted %s", got, want)
+	}
+	if len(v2.ExtHeader.Entries) != 1 || v2.ExtHeader.Entries[0].Type != 1 || !bytes.Equal(v2.ExtHeader.Entries[0].Data, []byte("entry data")) {
+		t.Errorf("unexpected extended header entries %+v", v2.ExtHeader.Entries)
+	}
+	if !v2.Files[1].extHeader {
+		t.Errorf("extended header not kept in second file")
+	}
+	data2, err := v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+}
+
+func TestVolumeBlockmapEntries(t *testing.T) {
+	v, err := NewVolume(0x1000)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	if err := v.InsertFile(0, testRawFile(GUID{0x01}, 0x80)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	bmap := []blockmap{
+		{BlockCount: 8, BlockSize: 0x100},
+		{BlockCount: 2, BlockSize: 0x400},
+	}
+	v.blockmap = bmap
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want, got := 0x1000, len(data); want != got {
+		t.Errorf("volume is 0x%x bytes, wanted 0x%x", got, want)
+	}
+	if want, got := uint16(0x38+3*8), v.HeaderLength; want != got {
+		t.Errorf("header length 0x%x, wanted 0x%x", got, want)
+	}
+	v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	if len(v2.blockmap) != len(bmap) || v2.blockmap[0] != bmap[0] || v2.blockmap[1] != bmap[1] {
+		t.Errorf("blockmap %+v, wanted %+v", v2.blockmap, bmap)
+	}
+	data2, err := v2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("reserialized volume differs from original")
+	}
+}
+
+func TestVolumeQuirks(t *testing.T) {
+	for _, quirks := range []Quirks{QuirksApple, QuirksNone} {
+		v := &Volume{
+			FirmwareVolumeHeader: FirmwareVolumeHeader{
+				GUID:      guidFFS1,
+				Signature: [4]byte{'_', 'F', 'V', 'H'},
+				Revision:  2,
+			},
+			Files: []*FirmwareFile{
+				testRawFile(GUID{0x01}, 0x123),
+			},
+			Quirks: quirks,
+		}
+		data, err := v.Serialize()
+		if err != nil {
+			t.Fatalf("%s: Serialize: %v", quirks.Name(), err)
+		}
+		want := uint64(len(data))
+		if quirks == QuirksApple {
+			want -= uint64(v.HeaderLength)
+		}
+		if got := v.Length; want != got {
+			t.Errorf("%s: length field 0x%x, wanted 0x%x", quirks.Name(), got, want)
+		}
+		v2, err := ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+		if err != nil {
+			t.Fatalf("%s: ReadVolume: %v", quirks.Name(), err)
+		}
+		if want, got := quirks, v2.Quirks; want != got {
+			t.Errorf("selected quirks %s, wanted %s", got.Name(), want.Name())
+		}
+		data2, err := v2.Serialize()
+		if err != nil {
+			t.Fatalf("%s: Serialize: %v", quirks.Name(), err)
+		}
+		if !bytes.Equal(data, data2) {
+			t.Errorf("%s: reserialized volume differs from original", quirks.Name())
+		}
+	}
+}
+
+// testSmallVolume returns a serialized and reparsed volume containing a
+// single named driver.
+func testSmallVolume(t *testing.T) *Volume {
+	t.Helper()
+	ui, err := NewUserInterfaceSection("Hello")
+	if err != nil {
+		t.Fatalf("NewUserInterfaceSection: %v", err)
+	}
+	v, err := NewVolume(0x200)
+	if err != nil {
+		t.Fatalf("NewVolume: %v", err)
+	}
+	if err := v.InsertFile(0, NewDriverFile(GUID{0x01}, ui)); err != nil {
+		t.Fatalf("InsertFile: %v", err)
+	}
+	data, err := v.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	v, err = ReadVolume(NewNestedReader(data), &ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadVolume: %v", err)
+	}
+	return v
+}
+
+func TestVolumeMarshalJSON(t *testing.T) {
+	v := testSmallVolume(t)
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got jsonVolume
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if want := guidFFS2.String(); want != got.GUID {
+		t.Errorf("volume GUID %s, wanted %s", got.GUID, want)
+	}
+	if want := uint16(0x48); want != got.HeaderLength {
+		t.Errorf("header length 0x%x, wanted 0x%x", got.HeaderLength, want)
+	}
+	if want := []jsonBlockmap{{BlockCount: 2, BlockSize: 0x100}}; len(got.Blockmap) != 1 || got.Blockmap[0] != want[0] {
+		t.Errorf("blockmap %+v, wanted %+v", got.Blockmap, want)
+	}
+	if want := 2; want != len(got.Files) {
+		t.Fatalf("%d files, wanted %d", len(got.Files), want)
+	}
+	file := got.Files[0]
+	if want := (GUID{0x01}).String(); want != file.GUID {
+		t.Errorf("file GUID %s, wanted %s", file.GUID, want)
+	}
+	if want := "Hello"; want != file.UIName {
+		t.Errorf("file name %q, wanted %q", file.UIName, want)
+	}
+	if want := 0x48; want != file.Offset {
+		t.Errorf("file offset 0x%x, wanted 0x%x", file.Offset, want)
+	}
+	if len(file.Sections) != 1 || file.Sections[0].Type != SectionTypeUserInterface.String() {
+		t.Errorf("unexpected sections %+v", file.Sections)
+	}
+	if want := FileTypePadding.String(); want != got.Files[1].Type {
+		t.Errorf("second file is %s, wanted %s", got.Files[1].Type, want)
+	}
+}
+
+func TestVolumePrintTree(t *testing.T) {
+	v := testSmallVolume(t)
+	buf := bytes.NewBuffer(nil)
+	v.PrintTree(buf)
+	want := `Offset   Size     Element
+00000000 00000200 Volume 8c8ce578-8a3d-4f1c-9935-896185c32dd3 (FirmwareFileSystem2)
+00000048 00000028   File 00000001-0000-0000-0000-000000000000, driver "Hello"
+00000060 00000010     ui: "Hello"
+00000070 00000190   File ffffffff-ffff-ffff-ffff-ffffffffffff, padding
+`
+	if got := buf.String(); want != got {
+		t.Errorf("tree output:\n%s\nwanted:\n%s", got, want)
+	}
+}
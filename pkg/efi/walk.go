@@ -0,0 +1,60 @@
+package efi
+
+// WalkFunc is called by Volume.Walk for every file and section within a
+// volume.
+//
+// The path is the list of indices leading to the visited element: the first
+// element is the index of the file within the volume, and any subsequent
+// elements are indices of sections within their parent's Sub(). For files,
+// section is nil. Sections within nested firmware volumes continue the path
+// with the index of the file within the nested volume, and file is then set to
+// that nested file.
+//
+// Returning an error stops the walk and causes Walk to return that error.
+type WalkFunc func(path []int, file *FirmwareFile, section Section) error
+
+// Walk traverses all files and all (recursively nested) sections within a
+// Volume in order, calling fn for each of them. Files are visited before
+// their sections, and sections before their subsections.
+func (v *Volume) Walk(fn WalkFunc) error {
+	return v.walk(nil, fn)
+}
+
+func (v *Volume) walk(prefix []int, fn WalkFunc) error {
+	for i, file := range v.Files {
+		path := appendPath(prefix, i)
+		if err := fn(path, file, nil); err != nil {
+			return err
+		}
+		for j, section := range file.Sections {
+			if err := walkSection(appendPath(path, j), file, section, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func walkSection(path []int, file *FirmwareFile, section Section, fn WalkFunc) error {
+	if err := fn(path, file, section); err != nil {
+		return err
+	}
+	if vs, ok := section.(FirmwareVolumeSection); ok {
+		return vs.Volume().walk(path, fn)
+	}
+	for i, sub := range section.Sub() {
+		if err := walkSection(appendPath(path, i), file, sub, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendPath returns a copy of path with i appended, so that paths passed to
+// WalkFuncs can be safely retained.
+func appendPath(path []int, i int) []int {
+	res := make([]int, len(path)+1)
+	copy(res, path)
+	res[len(path)] = i
+	return res
+}
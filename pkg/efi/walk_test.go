@@ -0,0 +1,147 @@
+package efi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	section, err := newParser(nil).readSection(NewNestedReader(makeCRC32Section([]byte("hello"))))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	v := &Volume{
+		Files: []*FirmwareFile{
+			{FirmwareFileHeader: FirmwareFileHeader{FileType: FileTypeDriver}, Sections: []Section{section}},
+			{FirmwareFileHeader: FirmwareFileHeader{FileType: FileTypePadding}},
+		},
+	}
+
+	var got []string
+	err = v.Walk(func(path []int, file *FirmwareFile, section Section) error {
+		if section == nil {
+			got = append(got, fmt.Sprintf("%v %s", path, file.FileType))
+		} else {
+			got = append(got, fmt.Sprintf("%v %s", path, section.Header().Type))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	want := []string{
+		"[0] driver",
+		"[0 0] guid",
+		"[0 0 0] raw",
+		"[1] padding",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("walked %v, wanted %v", got, want)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	section, err := newParser(nil).readSection(NewNestedReader(makeCRC32Section([]byte("hello"))))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	v := &Volume{
+		Files: []*FirmwareFile{
+			{FirmwareFileHeader: FirmwareFileHeader{FileType: FileTypePadding}},
+			{FirmwareFileHeader: FirmwareFileHeader{GUID: GUID{0x7f, 0xcb, 0xa2, 0xd6, 0x18, 0x6a, 0x2f, 0x4e, 0xb4, 0x3b, 0x99, 0x20, 0xa7, 0x33, 0x70, 0x0a}, FileType: FileTypeDXECore}, Sections: []Section{section}},
+		},
+	}
+
+	for _, expr := range []string{"1/0/0", "name:DxeCore/section:raw", "name:dxecore/0/section:RAW"} {
+		el, err := v.Lookup(expr)
+		if err != nil {
+			t.Errorf("Lookup(%q): %v", expr, err)
+			continue
+		}
+		if want, got := []int{1, 0, 0}, el.Path; !reflect.DeepEqual(want, got) {
+			t.Errorf("Lookup(%q): wanted path %v, got %v", expr, want, got)
+		}
+		if want, got := "hello", string(el.Section.Raw()); want != got {
+			t.Errorf("Lookup(%q): wanted data %q, got %q", expr, want, got)
+		}
+	}
+	if _, err := v.Lookup("name:PeiCore"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Lookup of missing file: wanted ErrFileNotFound, got %v", err)
+	}
+	if _, err := v.Lookup("1/section:pe32"); !errors.Is(err, ErrSectionNotFound) {
+		t.Errorf("Lookup of missing section: wanted ErrSectionNotFound, got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	section, err := newParser(nil).readSection(NewNestedReader(makeCRC32Section([]byte("hello hello"))))
+	if err != nil {
+		t.Fatalf("readSection: %v", err)
+	}
+	v := &Volume{
+		Files: []*FirmwareFile{
+			NewRawFile(GUID{0x01}, []byte("why hello there")),
+			NewDriverFile(GUID{0x02}, section),
+		},
+	}
+	matches, err := v.Search([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	var got []string
+	for _, m := range matches {
+		got = append(got, fmt.Sprintf("%v@%d", m.Path, m.Offset))
+	}
+	want := []string{"[0]@4", "[1 0 0]@0", "[1 0 0]@6"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("found %v, wanted %v", got, want)
+	}
+}
+
+func TestFind(t *testing.T) {
+	pe, _ := NewLeafSection(SectionTypePE32, []byte("MZ code"))
+	ui, _ := NewUserInterfaceSection("FooDxe")
+	compressed, _ := NewCompressedSection(CompressionTypeNone, pe, ui)
+	driverGUID := MustGUID("5f7a2c6e-2f6d-4a3b-8c1d-9e0f1a2b3c4d")
+	nested := &Volume{Files: []*FirmwareFile{NewDriverFile(driverGUID, compressed)}}
+	v := &Volume{
+		Files: []*FirmwareFile{
+			{FirmwareFileHeader: FirmwareFileHeader{FileType: FileTypePadding}},
+			NewFile(GUID{1}, FileTypeFirmwareVolumeImage, NewFirmwareVolumeSection(nested)),
+		},
+	}
+
+	byGUID, err := v.FileByGUID(driverGUID)
+	if err != nil {
+		t.Fatalf("FileByGUID: %v", err)
+	}
+	byName, err := v.FileByName("FooDxe")
+	if err != nil {
+		t.Fatalf("FileByName: %v", err)
+	}
+	if byGUID != byName {
+		t.Errorf("FileByGUID and FileByName found different files")
+	}
+	if _, err := v.FileByName("BarDxe"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("FileByName(BarDxe) returned %v, wanted ErrFileNotFound", err)
+	}
+
+	code, err := byGUID.Executable()
+	if err != nil {
+		t.Fatalf("Executable: %v", err)
+	}
+	if want := "MZ code"; string(code) != want {
+		t.Errorf("Executable is %q, wanted %q", code, want)
+	}
+	if s, err := byGUID.Section(SectionTypeUserInterface); err != nil || s != ui {
+		t.Errorf("Section(ui) returned %v, %v", s, err)
+	}
+	if got := len(byGUID.SectionsOfType(SectionTypePE32)); got != 1 {
+		t.Errorf("found %d PE32 sections, wanted 1", got)
+	}
+	if _, err := v.Files[1].Section(SectionTypePE32); !errors.Is(err, ErrSectionNotFound) {
+		t.Errorf("Section(pe32) in outer volume returned %v, wanted ErrSectionNotFound", err)
+	}
+}
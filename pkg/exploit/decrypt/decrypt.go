@@ -1,12 +1,14 @@
 package decrypt
 
 import (
+	"context"
 	"fmt"
 
-	"github.com/google/gousb"
+	"github.com/golang/glog"
 
 	"github.com/freemyipod/wInd3x/pkg/dfu"
 	"github.com/freemyipod/wInd3x/pkg/exploit"
+	"github.com/freemyipod/wInd3x/pkg/transport"
 	"github.com/freemyipod/wInd3x/pkg/uasm"
 )
 
@@ -19,7 +21,7 @@ import (
 // reconstructing CBC this way so far, though...
 func Payload(ep exploit.Parameters) ([]byte, error) {
 	insns := ep.DisableICache()
-	insns = append(insns, ep.AESCall()...)
+	insns = append(insns, ep.AESCall(false)...)
 	insns = append(insns, ep.HandlerFooter(ep.DFUBufAddr())...)
 	payload := uasm.Program{
 		Address: ep.ExecAddr(),
@@ -29,7 +31,10 @@ func Payload(ep exploit.Parameters) ([]byte, error) {
 	return payload.Assemble(), nil
 }
 
-func Trigger(usb *gousb.Device, ep exploit.Parameters, data []byte) ([]byte, error) {
+func Trigger(usb transport.Device, ep exploit.Parameters, data []byte) ([]byte, error) {
+	if ep == nil {
+		return nil, exploit.ErrUnsupported
+	}
 	if err := dfu.Clean(usb); err != nil {
 		return nil, fmt.Errorf("clean failed: %w", err)
 	}
@@ -47,3 +52,57 @@ func Trigger(usb *gousb.Device, ep exploit.Parameters, data []byte) ([]byte, err
 
 	return res, nil
 }
+
+// ChunkSize is the amount of plaintext recovered by every call to Trigger
+// made by Body.
+const ChunkSize = 0x30
+
+// Body decrypts an encrypted image body (eg. of a stock DFU image or WTF) with
+// the GID key of a connected device, which cannot be extracted but can be
+// used through the device's AES engine. Decryption is slow, so it can be
+// resumed: done is plaintext already recovered by a previous call and must be
+// a multiple of ChunkSize long, and onChunk (if not nil) is called with every
+// newly recovered chunk, eg. to persist it. The result is padded to
+// ChunkSize.
+func Body(ctx context.Context, usb transport.Device, ep exploit.Parameters, body, done []byte, onChunk func(plaintext []byte) error) ([]byte, error) {
+	if len(done)%ChunkSize != 0 {
+		return nil, fmt.Errorf("already decrypted data has invalid size (%x)", len(done))
+	}
+	res := append([]byte{}, done...)
+	for ix := len(done); ix < len(body); ix += ChunkSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		glog.Infof("Decrypting 0x%x (%.3f%%)...", ix, float64(ix*100)/float64(len(body)))
+
+		// Get ciphertext block, pad to ChunkSize.
+		b := make([]byte, ChunkSize)
+		copy(b, body[ix:])
+
+		data := make([]byte, 0x40)
+		// We need to feed the previous 0x10 bytes of ciphertext for...
+		// some reason. Unless we're the first block.
+		if ix == 0 {
+			copy(data[:0x30], b)
+		} else {
+			copy(data[:0x10], body[ix-0x10:ix])
+			copy(data[0x10:0x40], b)
+		}
+
+		out, err := exploit.TriggerRetry(ctx, "decryption", Trigger, usb, ep, data)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := out[0x10:0x40]
+		if ix == 0 {
+			plaintext = out[0x00:0x30]
+		}
+		if onChunk != nil {
+			if err := onChunk(plaintext); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, plaintext...)
+	}
+	return res, nil
+}
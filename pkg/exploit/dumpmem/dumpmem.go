@@ -3,16 +3,17 @@ package dumpmem
 import (
 	"fmt"
 
-	"github.com/google/gousb"
-
 	"github.com/freemyipod/wInd3x/pkg/dfu"
 	"github.com/freemyipod/wInd3x/pkg/exploit"
+	"github.com/freemyipod/wInd3x/pkg/transport"
 	"github.com/freemyipod/wInd3x/pkg/uasm"
 )
 
-func Trigger(usb *gousb.Device, ep exploit.Parameters, addr uint32) ([]byte, error) {
-	if err := dfu.Clean(usb); err != nil {
-		return nil, fmt.Errorf("clean failed: %w", err)
+// Trigger reads 0x40 bytes of memory at addr, retrying on transient errors
+// according to dfu.Retry.
+func Trigger(usb transport.Device, ep exploit.Parameters, addr uint32) ([]byte, error) {
+	if ep == nil {
+		return nil, exploit.ErrUnsupported
 	}
 	insns := ep.DisableICache()
 	insns = append(insns, ep.HandlerFooter(addr)...)
@@ -21,5 +22,14 @@ func Trigger(usb *gousb.Device, ep exploit.Parameters, addr uint32) ([]byte, err
 		Listing: insns,
 	}
 
-	return exploit.RCE(usb, ep, payload.Assemble(), nil)
+	var res []byte
+	err := dfu.Retry.Do(fmt.Sprintf("read of 0x%08x", addr), func() error {
+		if err := dfu.Clean(usb); err != nil {
+			return fmt.Errorf("clean failed: %w", err)
+		}
+		var err error
+		res, err = exploit.RCE(usb, ep, payload.Assemble(), nil)
+		return err
+	})
+	return res, err
 }
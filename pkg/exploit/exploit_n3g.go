@@ -44,8 +44,8 @@ func (_ *epNano3G) HandlerFooter(addr uint32) []uasm.Statement {
 	}
 }
 
-func (_ *epNano3G) AESCall() []uasm.Statement {
-	return makeCall(0x20001f04, 0x22028220, 0x40, 1, 0, 0)
+func (_ *epNano3G) AESCall(encrypt bool) []uasm.Statement {
+	return makeCall(0x20001f04, 0x22028220, 0x40, aesDirection(encrypt), 0, 0)
 }
 
 func (_ *epNano3G) HaxedDFUPayload() []uasm.Statement {
@@ -127,8 +127,8 @@ func (_ *epNano4G) HandlerFooter(addr uint32) []uasm.Statement {
 	}
 }
 
-func (_ *epNano4G) AESCall() []uasm.Statement {
-	return makeCall(0x200020d4, 0x2202db00, 0x2202db00, 0x40, 1, 0, 0)
+func (_ *epNano4G) AESCall(encrypt bool) []uasm.Statement {
+	return makeCall(0x200020d4, 0x2202db00, 0x2202db00, 0x40, aesDirection(encrypt), 0, 0)
 }
 
 func (_ *epNano4G) DisableICache() []uasm.Statement {
@@ -164,8 +164,8 @@ func (_ *epNano5G) HandlerFooter(addr uint32) []uasm.Statement {
 	}
 }
 
-func (_ *epNano5G) AESCall() []uasm.Statement {
-	return makeCall(0x200020ec, 0x2202db00, 0x2202db00, 0x40, 1, 0, 0)
+func (_ *epNano5G) AESCall(encrypt bool) []uasm.Statement {
+	return makeCall(0x200020ec, 0x2202db00, 0x2202db00, 0x40, aesDirection(encrypt), 0, 0)
 }
 
 func (_ *epNano5G) DisableICache() []uasm.Statement {
@@ -1,15 +1,17 @@
 package haxeddfu
 
 import (
+	"context"
 	"fmt"
 	"unicode/utf16"
 
 	"github.com/golang/glog"
-	"github.com/google/gousb"
 
 	"github.com/freemyipod/wInd3x/pkg/dfu"
 	"github.com/freemyipod/wInd3x/pkg/exploit"
+	"github.com/freemyipod/wInd3x/pkg/transport"
 	"github.com/freemyipod/wInd3x/pkg/uasm"
+	"github.com/freemyipod/wInd3x/pkg/usblog"
 )
 
 const ProductString = "haxed dfu"
@@ -43,12 +45,35 @@ func Payload(ep exploit.Parameters) ([]byte, error) {
 	return payload.Assemble(), nil
 }
 
-func Trigger(usb *gousb.Device, ep exploit.Parameters, force bool) error {
-	p, err := usb.GetStringDescriptor(2)
+// Running returns whether haxed DFU is already running on the device, as
+// indicated by its product string. Transient errors are retried according to
+// dfu.Retry. Errors are returned rather than assuming stock DFU, so that the
+// exploit is never run on a device which might already be running it.
+func Running(usb transport.Device) (bool, error) {
+	var p string
+	err := dfu.Retry.Do("product string", func() (err error) {
+		p, err = usblog.StringDescriptor(usb, 2)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("retrieving string descriptor: %v", err)
+		return false, fmt.Errorf("retrieving string descriptor: %w", err)
+	}
+	return p == ProductString, nil
+}
+
+// Trigger starts haxed DFU on the device, unless it is already running (or
+// force is set), as running the exploit again might wedge the bootrom. ctx is
+// checked before running the exploit, as the exploit itself cannot be
+// interrupted safely.
+func Trigger(ctx context.Context, usb transport.Device, ep exploit.Parameters, force bool) error {
+	if ep == nil {
+		return exploit.ErrUnsupported
 	}
-	if want, got := ProductString, p; want == got {
+	running, err := Running(usb)
+	if err != nil {
+		return err
+	}
+	if running {
 		if force {
 			glog.Infof("Device already running haxed DFU, but forcing re-upload")
 		} else {
@@ -66,13 +91,16 @@ func Trigger(usb *gousb.Device, ep exploit.Parameters, force bool) error {
 	if err := dfu.Clean(usb); err != nil {
 		return fmt.Errorf("clean failed: %w", err)
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	glog.Infof("Running rce....")
 	if _, err := exploit.RCE(usb, ep, payload, nil); err != nil {
 		return fmt.Errorf("failed to execute haxed dfu payload: %w", err)
 	}
 
 	// Check descriptor got changed.
-	p, err = usb.GetStringDescriptor(2)
+	p, err := usblog.StringDescriptor(usb, 2)
 	if err != nil {
 		return fmt.Errorf("retrieving string descriptor: %v", err)
 	}
@@ -0,0 +1,39 @@
+package haxeddfu
+
+import (
+	"context"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/exploit"
+	"github.com/freemyipod/wInd3x/pkg/transport/mock"
+)
+
+func TestTriggerAlreadyRunning(t *testing.T) {
+	d := mock.New()
+	d.Strings = map[int]string{2: ProductString}
+	if err := Trigger(context.Background(), d, exploit.ParametersForKind[devices.Nano4], false); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if len(d.Transfers) != 0 {
+		t.Errorf("exploit run again, transfers: %+v", d.Transfers)
+	}
+}
+
+func TestRunning(t *testing.T) {
+	d := mock.New()
+	d.Strings = map[int]string{2: "Apple Mobile Device (DFU Mode)"}
+	running, err := Running(d)
+	if err != nil {
+		t.Fatalf("Running: %v", err)
+	}
+	if running {
+		t.Errorf("stock DFU reported as haxed")
+	}
+
+	// Probe failures must not be mistaken for stock DFU.
+	d.Strings = nil
+	if _, err := Running(d); err == nil {
+		t.Errorf("wanted error for failed probe")
+	}
+}
@@ -0,0 +1,157 @@
+// Package fwdb is a database of known firmware versions, used to identify
+// which version a given image or dump corresponds to, and thus which patch
+// set applies to it.
+//
+// Entries are matched by SHA-1, either of a whole file or of the body of an
+// IMG1 image (so that an image matches both as shipped and once its body has
+// been extracted). Besides the built-in entries, more can be loaded from JSON
+// files, eg. as collected by users for versions not yet known here.
+//
+// The built-in table is currently empty: entries are only added once their
+// hashes have been verified against real images, so until then all versions
+// come from such files.
+package fwdb
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/efi"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+)
+
+var (
+	// ErrUnknownVersion is returned when data does not match any known
+	// version.
+	ErrUnknownVersion = errors.New("unknown firmware version")
+	// ErrNoPatchSet is returned when a version has no patch set of a given
+	// name.
+	ErrNoPatchSet = errors.New("no such patch set")
+)
+
+// Version of a firmware image.
+type Version struct {
+	Kind devices.Kind `json:"kind"`
+	// Image is the kind of image, eg. "wtf", "firmware", "bootloader", or
+	// "ipsw" for firmware archives.
+	Image string `json:"image"`
+	// Name of the version, eg. "1.0.4".
+	Name string `json:"name"`
+	// SHA1 hashes (as hex) of all known forms of the image, eg. encrypted and
+	// decrypted.
+	SHA1 []string `json:"sha1"`
+	// KeyAvailable is set if the key needed to decrypt the image on the host
+	// is known, ie. it does not need to be decrypted with a device.
+	KeyAvailable bool `json:"key_available,omitempty"`
+	// URL the image can be downloaded from, for firmware archives.
+	URL string `json:"url,omitempty"`
+	// Patches are the patch sets applying to the (decrypted) firmware volume
+	// of this version, by name, eg. "unlock".
+	Patches map[string][]efi.Patch `json:"patches,omitempty"`
+}
+
+func (v *Version) String() string {
+	return fmt.Sprintf("%s %s %s", v.Kind, v.Image, v.Name)
+}
+
+// PatchSetNames returns the names of all patch sets of the version, sorted.
+func (v *Version) PatchSetNames() []string {
+	var names []string
+	for name := range v.Patches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PatchSet returns the patch set data applies to by name, and the version it
+// was identified as.
+func (db *DB) PatchSet(data []byte, name string) (*Version, []efi.Patch, error) {
+	v := db.Identify(data)
+	if v == nil {
+		return nil, nil, ErrUnknownVersion
+	}
+	patches, ok := v.Patches[name]
+	if !ok {
+		return v, nil, fmt.Errorf("%w %q for %s (available: %s)", ErrNoPatchSet, name, v, strings.Join(v.PatchSetNames(), ", "))
+	}
+	return v, patches, nil
+}
+
+// builtin are the versions known to this package. Only versions whose hashes
+// were verified against real images belong here.
+var builtin []*Version
+
+// DB is a firmware version database.
+type DB struct {
+	Versions []*Version
+}
+
+// Default returns a database containing the built-in versions.
+func Default() *DB {
+	return &DB{Versions: append([]*Version{}, builtin...)}
+}
+
+// Load versions from a JSON array, adding them to the database.
+func (db *DB) Load(r io.Reader) error {
+	var versions []*Version
+	if err := json.NewDecoder(r).Decode(&versions); err != nil {
+		return fmt.Errorf("could not parse database: %w", err)
+	}
+	for i, v := range versions {
+		if v.Kind == "" || v.Name == "" || len(v.SHA1) == 0 {
+			return fmt.Errorf("version %d: kind, name and sha1 must be set", i)
+		}
+	}
+	db.Versions = append(db.Versions, versions...)
+	return nil
+}
+
+// ImageArchive is the Image of firmware archives.
+const ImageArchive = "ipsw"
+
+// Archive returns the firmware archive to download for a device, ie. the last
+// version of kind ImageArchive with a URL, or nil if none is known.
+func (db *DB) Archive(kind devices.Kind) *Version {
+	var res *Version
+	for _, v := range db.Versions {
+		if v.Kind == kind && v.Image == ImageArchive && v.URL != "" {
+			res = v
+		}
+	}
+	return res
+}
+
+// Hashes returns the SHA-1 hashes by which data is matched: that of data
+// itself, and that of its body if it is an IMG1 image.
+func Hashes(data []byte) []string {
+	sum := sha1.Sum(data)
+	res := []string{hex.EncodeToString(sum[:])}
+	if img, err := img1.Parse(data); err == nil {
+		sum := sha1.Sum(img.Body)
+		res = append(res, hex.EncodeToString(sum[:]))
+	}
+	return res
+}
+
+// Identify returns the version data corresponds to, or nil if it is unknown.
+func (db *DB) Identify(data []byte) *Version {
+	hashes := Hashes(data)
+	for _, v := range db.Versions {
+		for _, want := range v.SHA1 {
+			for _, got := range hashes {
+				if strings.EqualFold(want, got) {
+					return v
+				}
+			}
+		}
+	}
+	return nil
+}
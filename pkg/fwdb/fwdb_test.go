@@ -0,0 +1,71 @@
+package fwdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+)
+
+func TestIdentify(t *testing.T) {
+	body := []byte("hello, firmware!")
+	data, err := img1.NewUnsigned(devices.Nano4, 0, body).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	bodyHash := Hashes(body)[0]
+
+	db := Default()
+	if v := db.Identify(data); v != nil {
+		t.Errorf("unknown image identified as %s", v)
+	}
+	json := fmt.Sprintf(`[{"kind": "n4g", "image": "wtf", "name": "test", "sha1": [%q], "patches": {"foo": [{"file": "0", "offset": 16, "old": "00", "new": "01"}]}}]`, strings.ToUpper(bodyHash))
+	if err := db.Load(strings.NewReader(json)); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, d := range [][]byte{data, body} {
+		v := db.Identify(d)
+		if v == nil || v.Name != "test" {
+			t.Fatalf("image identified as %v, wanted test", v)
+		}
+		_, patches, err := db.PatchSet(d, "foo")
+		if err != nil {
+			t.Fatalf("PatchSet: %v", err)
+		}
+		if len(patches) != 1 || patches[0].Offset != 16 {
+			t.Errorf("patch set foo is %+v, wanted one patch at 16", patches)
+		}
+	}
+	if _, _, err := db.PatchSet(data, "bar"); !errors.Is(err, ErrNoPatchSet) {
+		t.Errorf("PatchSet of missing set: wanted ErrNoPatchSet, got %v", err)
+	}
+	if _, _, err := db.PatchSet([]byte("unknown"), "foo"); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("PatchSet of unknown data: wanted ErrUnknownVersion, got %v", err)
+	}
+
+	if err := db.Load(strings.NewReader(`[{"kind": "n4g"}]`)); err == nil {
+		t.Errorf("Load of incomplete version succeeded")
+	}
+}
+
+func TestArchive(t *testing.T) {
+	db := Default()
+	json := `[
+		{"kind": "n4g", "image": "ipsw", "name": "1.0", "sha1": ["aa"], "url": "http://example.com/1.0.ipsw"},
+		{"kind": "n4g", "image": "ipsw", "name": "1.1", "sha1": ["bb"], "url": "http://example.com/1.1.ipsw"},
+		{"kind": "n4g", "image": "wtf", "name": "1.2", "sha1": ["cc"], "url": "http://example.com/1.2.dfu"},
+		{"kind": "n5g", "image": "ipsw", "name": "1.0", "sha1": ["dd"]}
+	]`
+	if err := db.Load(strings.NewReader(json)); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if v := db.Archive(devices.Nano4); v == nil || v.Name != "1.1" {
+		t.Errorf("archive for n4g is %v, wanted 1.1", v)
+	}
+	if v := db.Archive(devices.Nano5); v != nil {
+		t.Errorf("archive without URL returned for n5g: %v", v)
+	}
+}
@@ -1,88 +1,26 @@
 package image
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"io"
 
 	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/img1"
 	"github.com/golang/glog"
 )
 
 const (
-	FormatSignedEncrypted byte = 3
-	FormatSigned          byte = 4
+	FormatSignedEncrypted = img1.FormatSignedEncrypted
+	FormatSigned          = img1.FormatSigned
 )
 
 // IMG1Headers are also known as '8900' headers. More info:
 // https://freemyipod.org/wiki/IMG1
-type IMG1Header struct {
-	Magic            [4]byte
-	Version          [3]byte
-	Format           byte
-	Entrypoint       uint32
-	BodyLength       uint32
-	DataLength       uint32
-	FooterCertOffset uint32
-	FooterCertLength uint32
-	Salt             [32]byte
-	Unknown1         uint16
-	SecurityEpoch    uint16
-	HeaderSignature  [16]byte
-}
+type IMG1Header = img1.Header
 
+// MakeUnsigned wraps body into an unsigned image, see img1.NewUnsigned.
 func MakeUnsigned(dk devices.Kind, entrypoint uint32, body []byte) ([]byte, error) {
-	var magic [4]byte
-	copy(magic[:], []byte(dk.SoCCode()))
-
-	buf := bytes.NewBuffer(nil)
-
-	// Align body to 0x10.
-	if (len(body) % 16) != 0 {
-		pad := bytes.Repeat([]byte{0}, 16-(len(body)%16))
-		body = append(body, pad...)
-	}
-
-	var version [3]byte
-	if dk == devices.Nano3 {
-		copy(version[:], []byte("1.0"))
-	} else {
-		copy(version[:], []byte("2.0"))
-	}
-
-	// Start off with the header.
-	hdr := &IMG1Header{
-		Magic:            magic,
-		Version:          version,
-		Format:           FormatSigned,
-		Entrypoint:       entrypoint,
-		BodyLength:       uint32(len(body)),
-		DataLength:       uint32(len(body) + 0x80 + 0x300),
-		FooterCertOffset: uint32(len(body) + 0x80),
-		FooterCertLength: 0x300,
-	}
-	if err := binary.Write(buf, binary.LittleEndian, hdr); err != nil {
-		return nil, fmt.Errorf("could not serialize header: %w", err)
-	}
-
-	// Pad to 0x600/0x800.
-	if dk == devices.Nano3 {
-		buf.Write(bytes.Repeat([]byte{0}, 0x800-buf.Len()))
-	} else {
-		buf.Write(bytes.Repeat([]byte{0}, 0x600-buf.Len()))
-	}
-
-	// Add body.
-	buf.Write(body)
-
-	// Add unused signature.
-	buf.Write(bytes.Repeat([]byte{'S'}, 0x80))
-
-	// Add unused certificates.
-	buf.Write(bytes.Repeat([]byte{'C'}, 0x300))
-
-	return buf.Bytes(), nil
+	return img1.NewUnsigned(dk, entrypoint, body).Serialize()
 }
 
 type IMG1 struct {
@@ -91,58 +29,19 @@ type IMG1 struct {
 	Body       []byte
 }
 
+// Read an encrypted IMG1 image, eg. a stock DFU image.
 func Read(r io.ReadSeeker) (*IMG1, error) {
-	var hdr IMG1Header
-	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
-		return nil, fmt.Errorf("failed to read header: %w", err)
-	}
-	var kind devices.Kind
-	for _, k := range []devices.Kind{devices.Nano3, devices.Nano4, devices.Nano5} {
-		if bytes.Equal(hdr.Magic[:], []byte(k.SoCCode())) {
-			kind = k
-			break
-		}
+	img, err := img1.Read(r)
+	if err != nil {
+		return nil, err
 	}
-	if kind.String() == "UNKNOWN" {
-		return nil, fmt.Errorf("unsupported image magic %v", hdr.Magic)
-	}
-
-	if kind == devices.Nano3 {
-		if !bytes.Equal(hdr.Version[:], []byte("1.0")) {
-			return nil, fmt.Errorf("unsupported image version %q", hdr.Version)
-		}
-	} else {
-		if !bytes.Equal(hdr.Version[:], []byte("2.0")) {
-			return nil, fmt.Errorf("unsupported image version %q", hdr.Version)
-		}
-	}
-
-	if hdr.Format != 3 {
+	if !img.Encrypted() {
 		return nil, fmt.Errorf("can only decrypt encrypted images")
 	}
-
-	if kind == devices.Nano3 {
-		if _, err := r.Seek(0x800, io.SeekStart); err != nil {
-			return nil, fmt.Errorf("could not seek past header")
-		}
-	} else {
-		if _, err := r.Seek(0x600, io.SeekStart); err != nil {
-			return nil, fmt.Errorf("could not seek past header")
-		}
-	}
-
-	glog.Infof("Parsed %s image.", kind)
-
-	body := make([]byte, hdr.BodyLength)
-	if _, err := r.Read(body); err != nil {
-		return nil, fmt.Errorf("could not read body")
-	}
-
-	// Ignore the rest of the fields, whatever.
-
+	glog.Infof("Parsed %s image.", img.Kind)
 	return &IMG1{
-		Header:     hdr,
-		DeviceKind: kind,
-		Body:       body,
+		Header:     img.Header,
+		DeviceKind: img.Kind,
+		Body:       img.Body,
 	}, nil
 }
@@ -0,0 +1,67 @@
+package img1
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+)
+
+// newCBC validates key and iv and returns the AES block cipher to use in CBC
+// mode. A nil iv is treated as all zeroes, as used by the bootrom.
+func newCBC(key, iv []byte) (cipher.Block, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if iv == nil {
+		iv = make([]byte, aes.BlockSize)
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, nil, fmt.Errorf("IV must be %d bytes, is %d", aes.BlockSize, len(iv))
+	}
+	return block, iv, nil
+}
+
+// Decrypt the body of an encrypted image in place with AES-CBC, given the
+// key and IV it was encrypted with, and mark the image as unencrypted. As the
+// format is covered by the signature, the signature will not verify
+// afterwards, but the image can be booted through haxed DFU.
+func (i *Image) Decrypt(key, iv []byte) error {
+	if !i.Encrypted() {
+		return errors.New("image body is not encrypted")
+	}
+	block, iv, err := newCBC(key, iv)
+	if err != nil {
+		return err
+	}
+	if len(i.Body)%aes.BlockSize != 0 {
+		return fmt.Errorf("body length 0x%x not a multiple of the AES block size", len(i.Body))
+	}
+	body := make([]byte, len(i.Body))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(body, i.Body)
+	i.Body = body
+	i.Header.Format = FormatSigned
+	return nil
+}
+
+// Encrypt the body of an unencrypted image in place with AES-CBC, padding it
+// to the AES block size, and mark the image as encrypted. This reverses
+// Decrypt given the same key and IV.
+func (i *Image) Encrypt(key, iv []byte) error {
+	if i.Encrypted() {
+		return errors.New("image body is already encrypted")
+	}
+	block, iv, err := newCBC(key, iv)
+	if err != nil {
+		return err
+	}
+	body := append([]byte{}, i.Body...)
+	if len(body)%aes.BlockSize != 0 {
+		body = append(body, make([]byte, aes.BlockSize-len(body)%aes.BlockSize)...)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(body, body)
+	i.Body = body
+	i.Header.Format = FormatSignedEncrypted
+	return nil
+}
@@ -0,0 +1,51 @@
+package img1
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{0x11}, 16)
+	iv := bytes.Repeat([]byte{0x22}, 16)
+	body := []byte("hello, world! this is a body which is not aligned")
+
+	img, err := Parse(makeImage(FormatSigned, body, 0x300))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := img.Encrypt(key, iv); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !img.Encrypted() {
+		t.Errorf("image not marked encrypted")
+	}
+	if bytes.Contains(img.Body, []byte("hello")) {
+		t.Errorf("body not encrypted")
+	}
+	if err := img.Encrypt(key, iv); err == nil {
+		t.Errorf("Encrypt of encrypted image succeeded")
+	}
+
+	data, err := img.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	img, err = Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := img.Decrypt(key, iv[:8]); err == nil {
+		t.Errorf("Decrypt with short IV succeeded")
+	}
+	if err := img.Decrypt(key, iv); err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	plaintext, err := img.Plaintext()
+	if err != nil {
+		t.Fatalf("Plaintext: %v", err)
+	}
+	if want := append(body, make([]byte, 16-len(body)%16)...); !bytes.Equal(want, plaintext) {
+		t.Errorf("decrypted body differs")
+	}
+}
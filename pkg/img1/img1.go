@@ -0,0 +1,232 @@
+// Package img1 implements the IMG1 container format (also known as '8900'),
+// used by the iPod Nano bootrom for DFU images and firmware stored in NOR.
+//
+// An IMG1 image consists of a header, a (possibly encrypted) body, a
+// signature over the header and body, and a certificate chain used to verify
+// the signature. More info: https://freemyipod.org/wiki/IMG1
+//
+// The closely related 8900 format used by the S5L8900 is supported as well,
+// see Image8900.
+package img1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+const (
+	// FormatSignedEncrypted images have a signed and AES encrypted body.
+	FormatSignedEncrypted byte = 3
+	// FormatSigned images have a signed plaintext body.
+	FormatSigned byte = 4
+)
+
+// SignatureLength is the length of the signature following the body.
+const SignatureLength = 0x80
+
+// ErrEncrypted is returned when the plaintext body of an encrypted image is
+// requested.
+var ErrEncrypted = errors.New("image body is encrypted")
+
+// Header of an IMG1 image.
+type Header struct {
+	Magic   [4]byte
+	Version [3]byte
+	Format  byte
+	// Entrypoint is the offset within the body at which execution starts.
+	Entrypoint uint32
+	// BodyLength is the length of the body, padded to 16 bytes.
+	BodyLength uint32
+	// DataLength is the length of the body, signature and certificates.
+	DataLength uint32
+	// FooterCertOffset is the offset of the certificate chain, relative to
+	// the start of the body.
+	FooterCertOffset uint32
+	FooterCertLength uint32
+	Salt             [32]byte
+	Unknown1         uint16
+	SecurityEpoch    uint16
+	HeaderSignature  [16]byte
+}
+
+// Variant returns the header variant used by the image, as determined by its
+// magic and version.
+func (h *Header) Variant() (*Variant, error) {
+	return DetectVariant(append(h.Magic[:], h.Version[:]...))
+}
+
+// Kind returns the device the image is for, as determined by its magic.
+func (h *Header) Kind() (devices.Kind, error) {
+	v, err := h.Variant()
+	if err != nil {
+		return "", err
+	}
+	if v.Kind == "" {
+		return "", fmt.Errorf("unsupported device for %s image", v)
+	}
+	return v.Kind, nil
+}
+
+// BodyOffset returns the offset of the body within images for a given device,
+// ie. the size of the header including its padding.
+func BodyOffset(k devices.Kind) int {
+	v, err := VariantForKind(k)
+	if err != nil {
+		return 0x600
+	}
+	return v.BodyOffset
+}
+
+// Image is a parsed IMG1 image.
+type Image struct {
+	Header Header
+	// Variant of the header. If nil when building, the variant for Kind is
+	// used.
+	Variant *Variant
+	// Kind of device this image is for. Empty for images for SoCs not used by
+	// any supported device.
+	Kind devices.Kind
+	// Body of the image as stored, ie. encrypted if the image is encrypted.
+	Body []byte
+	// Signature over the header and body.
+	Signature []byte
+	// Certificates is the DER encoded certificate chain used to verify the
+	// signature, as stored.
+	Certificates []byte
+}
+
+// Parse an IMG1 image, checking that all lengths and offsets within its header
+// are consistent.
+func Parse(data []byte) (*Image, error) {
+	var hdr Header
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	variant, err := hdr.Variant()
+	if err != nil {
+		return nil, err
+	}
+	switch hdr.Format {
+	case FormatSignedEncrypted, FormatSigned:
+	default:
+		return nil, fmt.Errorf("unsupported image format %d", hdr.Format)
+	}
+
+	data = data[min(variant.BodyOffset, len(data)):]
+	bodyLength := uint64(hdr.BodyLength)
+	if bodyLength > uint64(len(data)) {
+		return nil, fmt.Errorf("body length 0x%x larger than image", bodyLength)
+	}
+	certStart := uint64(hdr.FooterCertOffset)
+	certEnd := certStart + uint64(hdr.FooterCertLength)
+	if certStart < bodyLength+SignatureLength {
+		return nil, fmt.Errorf("certificate offset 0x%x overlaps body and signature", certStart)
+	}
+	if certEnd > uint64(len(data)) {
+		return nil, fmt.Errorf("certificates at 0x%x-0x%x outside of image", certStart, certEnd)
+	}
+	if uint64(hdr.DataLength) > uint64(len(data)) {
+		return nil, fmt.Errorf("data length 0x%x larger than image", hdr.DataLength)
+	}
+
+	return &Image{
+		Header:       hdr,
+		Variant:      variant,
+		Kind:         variant.Kind,
+		Body:         data[:bodyLength],
+		Signature:    data[bodyLength : bodyLength+SignatureLength],
+		Certificates: data[certStart:certEnd],
+	}, nil
+}
+
+// Read an IMG1 image from r.
+func Read(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return Parse(data)
+}
+
+// Encrypted returns whether the body of the image is encrypted.
+func (i *Image) Encrypted() bool {
+	return i.Header.Format == FormatSignedEncrypted
+}
+
+// Plaintext returns the decrypted body of the image, eg. to be parsed as an
+// EFI firmware volume. ErrEncrypted is returned for encrypted images.
+func (i *Image) Plaintext() ([]byte, error) {
+	if i.Encrypted() {
+		return nil, ErrEncrypted
+	}
+	return i.Body, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// NewUnsigned returns an unsigned and unencrypted image for a given device
+// wrapping body, eg. a rebuilt firmware volume or custom code, to be booted
+// through haxed DFU. Execution starts at entrypoint within the body. The
+// signature and certificates are placeholders, and all lengths are calculated
+// on Serialize.
+func NewUnsigned(kind devices.Kind, entrypoint uint32, body []byte) *Image {
+	return &Image{
+		Header: Header{
+			Format:     FormatSigned,
+			Entrypoint: entrypoint,
+		},
+		Kind:         kind,
+		Body:         body,
+		Signature:    bytes.Repeat([]byte{'S'}, SignatureLength),
+		Certificates: bytes.Repeat([]byte{'C'}, 0x300),
+	}
+}
+
+// Serialize builds the image. The magic, version and all lengths and offsets
+// within the header are calculated from Variant (or Kind), Body, Signature and
+// Certificates, with the body padded to 16 bytes.
+func (i *Image) Serialize() ([]byte, error) {
+	variant := i.Variant
+	if variant == nil {
+		var err error
+		if variant, err = VariantForKind(i.Kind); err != nil {
+			return nil, err
+		}
+	}
+	if len(i.Signature) != SignatureLength {
+		return nil, fmt.Errorf("signature must be %d bytes, is %d", SignatureLength, len(i.Signature))
+	}
+	body := i.Body
+	if len(body)%16 != 0 {
+		body = append(append([]byte{}, body...), make([]byte, 16-len(body)%16)...)
+	}
+
+	hdr := i.Header
+	copy(hdr.Magic[:], variant.Magic)
+	copy(hdr.Version[:], variant.Version)
+	hdr.BodyLength = uint32(len(body))
+	hdr.FooterCertOffset = uint32(len(body) + SignatureLength)
+	hdr.FooterCertLength = uint32(len(i.Certificates))
+	hdr.DataLength = hdr.FooterCertOffset + hdr.FooterCertLength
+
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.LittleEndian, hdr); err != nil {
+		return nil, fmt.Errorf("could not serialize header: %w", err)
+	}
+	buf.Write(make([]byte, variant.BodyOffset-buf.Len()))
+	buf.Write(body)
+	buf.Write(i.Signature)
+	buf.Write(i.Certificates)
+	i.Header = hdr
+	return buf.Bytes(), nil
+}
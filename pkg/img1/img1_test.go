@@ -0,0 +1,152 @@
+package img1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+// makeImage builds a Nano 4G image with the given format and body, followed by
+// a signature and certificates of the given length.
+func makeImage(format byte, body []byte, certLength int) []byte {
+	hdr := Header{
+		Magic:            [4]byte{'8', '7', '2', '0'},
+		Version:          [3]byte{'2', '.', '0'},
+		Format:           format,
+		BodyLength:       uint32(len(body)),
+		DataLength:       uint32(len(body) + SignatureLength + certLength),
+		FooterCertOffset: uint32(len(body) + SignatureLength),
+		FooterCertLength: uint32(certLength),
+	}
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, hdr)
+	buf.Write(make([]byte, 0x600-buf.Len()))
+	buf.Write(body)
+	buf.Write(bytes.Repeat([]byte{'S'}, SignatureLength))
+	buf.Write(bytes.Repeat([]byte{'C'}, certLength))
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	body := bytes.Repeat([]byte{0x42}, 0x20)
+	img, err := Parse(makeImage(FormatSigned, body, 0x300))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if img.Kind != "n4g" {
+		t.Errorf("kind is %s, wanted n4g", img.Kind)
+	}
+	plaintext, err := img.Plaintext()
+	if err != nil {
+		t.Fatalf("Plaintext: %v", err)
+	}
+	if !bytes.Equal(body, plaintext) {
+		t.Errorf("body differs")
+	}
+	if want := bytes.Repeat([]byte{'S'}, SignatureLength); !bytes.Equal(want, img.Signature) {
+		t.Errorf("signature differs")
+	}
+	if want := bytes.Repeat([]byte{'C'}, 0x300); !bytes.Equal(want, img.Certificates) {
+		t.Errorf("certificates differ")
+	}
+
+	img, err = Parse(makeImage(FormatSignedEncrypted, body, 0x300))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if _, err := img.Plaintext(); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Plaintext of encrypted image returned %v, wanted ErrEncrypted", err)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	data := makeImage(FormatSigned, make([]byte, 0x20), 0x300)
+	for i := 0; i < len(data); i += 0x10 {
+		Parse(data[:i])
+	}
+	if _, err := Parse(data[:len(data)-1]); err == nil {
+		t.Errorf("Parse of truncated image succeeded")
+	}
+	// Certificates overlapping the signature.
+	binary.LittleEndian.PutUint32(data[0x14:], 0x20)
+	if _, err := Parse(data); err == nil {
+		t.Errorf("Parse of image with overlapping certificates succeeded")
+	}
+}
+
+func TestNewUnsigned(t *testing.T) {
+	body := bytes.Repeat([]byte{0x42}, 0x123)
+	data, err := NewUnsigned("n5g", 0x100, body).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	img, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if want, got := uint32(0x130), img.Header.BodyLength; want != got {
+		t.Errorf("body length is 0x%x, wanted 0x%x", got, want)
+	}
+	if want, got := uint32(0x100), img.Header.Entrypoint; want != got {
+		t.Errorf("entrypoint is 0x%x, wanted 0x%x", got, want)
+	}
+	if !bytes.Equal(body, img.Body[:len(body)]) || !bytes.Equal(make([]byte, 0xd), img.Body[len(body):]) {
+		t.Errorf("body not zero padded")
+	}
+	if want, got := 0x600+0x130+SignatureLength+0x300, len(data); want != got {
+		t.Errorf("image is 0x%x bytes, wanted 0x%x", got, want)
+	}
+	reserialized, err := img.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, reserialized) {
+		t.Errorf("reserialized image differs")
+	}
+}
+
+func TestVariants(t *testing.T) {
+	for _, test := range []struct {
+		kind       devices.Kind
+		variant    *Variant
+		bodyOffset int
+	}{
+		{devices.Nano3, Variants[0], 0x800},
+		{devices.Nano4, Variants[1], 0x600},
+		{devices.Nano6, Variants[3], 0x600},
+		{devices.Nano7, Variants[4], 0x600},
+	} {
+		img := NewUnsigned(test.kind, 0, []byte("hello"))
+		img.Variant = test.variant
+		data, err := img.Serialize()
+		if err != nil {
+			t.Fatalf("%s: Serialize: %v", test.variant, err)
+		}
+		if want := test.bodyOffset + 0x10 + SignatureLength + 0x300; len(data) != want {
+			t.Errorf("%s: image is 0x%x bytes, wanted 0x%x", test.variant, len(data), want)
+		}
+		img, err = Parse(data)
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", test.variant, err)
+		}
+		if img.Variant != test.variant || img.Kind != test.kind {
+			t.Errorf("%s: detected %s (%q)", test.variant, img.Variant, img.Kind)
+		}
+		if _, err := img.Header.Kind(); (err == nil) != (test.kind != "") {
+			t.Errorf("%s: Header.Kind returned %v", test.variant, err)
+		}
+	}
+
+	if _, err := DetectVariant([]byte("87021.0")); err != nil {
+		t.Errorf("DetectVariant: %v", err)
+	}
+	if _, err := DetectVariant([]byte("87022.0")); err == nil {
+		t.Errorf("DetectVariant with wrong version succeeded")
+	}
+	if _, err := NewUnsigned("", 0, nil).Serialize(); err == nil {
+		t.Errorf("Serialize without variant or kind succeeded")
+	}
+}
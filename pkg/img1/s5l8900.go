@@ -0,0 +1,165 @@
+package img1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+// Magic8900 is the magic of 8900 images, as used by the S5L8900 bootrom of
+// related devices (eg. the first iPhone and iPod Touch). More info:
+// https://www.theiphonewiki.com/wiki/S5L_File_Formats
+const Magic8900 = "8900"
+
+// header8900Length is the size of the header of 8900 images, including its
+// padding.
+const header8900Length = 0x800
+
+// Header8900 of an 8900 image. It is a predecessor of the IMG1 header, with
+// the same format values, but without an entrypoint and with an explicit
+// signature offset.
+type Header8900 struct {
+	Magic    [4]byte
+	Version  [3]byte
+	Format   byte
+	Unknown1 uint32
+	// DataLength is the length of the body.
+	DataLength uint32
+	// FooterSignatureOffset is the offset of the signature, relative to the
+	// start of the body.
+	FooterSignatureOffset uint32
+	// FooterCertOffset is the offset of the certificate chain, relative to
+	// the start of the body.
+	FooterCertOffset uint32
+	FooterCertLength uint32
+	Salt             [32]byte
+	Unknown2         uint16
+	SecurityEpoch    uint16
+	HeaderSignature  [16]byte
+}
+
+// Image8900 is a parsed 8900 image.
+type Image8900 struct {
+	Header Header8900
+	// Body of the image as stored, ie. encrypted if the image is encrypted.
+	Body []byte
+	// Signature over the header and body.
+	Signature []byte
+	// Certificates is the DER encoded certificate chain used to verify the
+	// signature, as stored.
+	Certificates []byte
+}
+
+// Is8900 returns whether data starts with the magic of an 8900 image.
+func Is8900(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic8900))
+}
+
+// Parse8900 parses an 8900 image, checking that all lengths and offsets within
+// its header are consistent.
+func Parse8900(data []byte) (*Image8900, error) {
+	var hdr Header8900
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(hdr.Magic[:]) != Magic8900 {
+		return nil, fmt.Errorf("unsupported image magic %q", hdr.Magic)
+	}
+	if string(hdr.Version[:]) != "1.0" {
+		return nil, fmt.Errorf("unsupported image version %q", hdr.Version)
+	}
+	switch hdr.Format {
+	case FormatSignedEncrypted, FormatSigned:
+	default:
+		return nil, fmt.Errorf("unsupported image format %d", hdr.Format)
+	}
+
+	data = data[min(header8900Length, len(data)):]
+	dataLength := uint64(hdr.DataLength)
+	if dataLength > uint64(len(data)) {
+		return nil, fmt.Errorf("data length 0x%x larger than image", dataLength)
+	}
+	sigStart := uint64(hdr.FooterSignatureOffset)
+	if sigStart < dataLength || sigStart+SignatureLength > uint64(len(data)) {
+		return nil, fmt.Errorf("signature at 0x%x outside of image or overlapping body", sigStart)
+	}
+	certStart := uint64(hdr.FooterCertOffset)
+	certEnd := certStart + uint64(hdr.FooterCertLength)
+	if certStart < sigStart+SignatureLength && certEnd > sigStart {
+		return nil, fmt.Errorf("certificate offset 0x%x overlaps signature", certStart)
+	}
+	if certStart < dataLength || certEnd > uint64(len(data)) {
+		return nil, fmt.Errorf("certificates at 0x%x-0x%x outside of image or overlapping body", certStart, certEnd)
+	}
+
+	return &Image8900{
+		Header:       hdr,
+		Body:         data[:dataLength],
+		Signature:    data[sigStart : sigStart+SignatureLength],
+		Certificates: data[certStart:certEnd],
+	}, nil
+}
+
+// Encrypted returns whether the body of the image is encrypted.
+func (i *Image8900) Encrypted() bool {
+	return i.Header.Format == FormatSignedEncrypted
+}
+
+// NewUnsigned8900 returns an unsigned and unencrypted 8900 image wrapping
+// body. As with NewUnsigned, the signature and certificates are placeholders
+// and all lengths are calculated on Serialize.
+func NewUnsigned8900(body []byte) *Image8900 {
+	return &Image8900{
+		Header: Header8900{
+			Format: FormatSigned,
+		},
+		Body:         body,
+		Signature:    bytes.Repeat([]byte{'S'}, SignatureLength),
+		Certificates: bytes.Repeat([]byte{'C'}, 0x300),
+	}
+}
+
+// Serialize builds the image. The magic, version and all lengths and offsets
+// within the header are calculated from Body, Signature and Certificates,
+// with the body padded to 16 bytes.
+func (i *Image8900) Serialize() ([]byte, error) {
+	if len(i.Signature) != SignatureLength {
+		return nil, fmt.Errorf("signature must be %d bytes, is %d", SignatureLength, len(i.Signature))
+	}
+	body := i.Body
+	if len(body)%16 != 0 {
+		body = append(append([]byte{}, body...), make([]byte, 16-len(body)%16)...)
+	}
+
+	hdr := i.Header
+	copy(hdr.Magic[:], Magic8900)
+	copy(hdr.Version[:], "1.0")
+	hdr.DataLength = uint32(len(body))
+	hdr.FooterSignatureOffset = uint32(len(body))
+	hdr.FooterCertOffset = uint32(len(body) + SignatureLength)
+	hdr.FooterCertLength = uint32(len(i.Certificates))
+
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.LittleEndian, hdr); err != nil {
+		return nil, fmt.Errorf("could not serialize header: %w", err)
+	}
+	buf.Write(make([]byte, header8900Length-buf.Len()))
+	buf.Write(body)
+	buf.Write(i.Signature)
+	buf.Write(i.Certificates)
+	i.Header = hdr
+	return buf.Bytes(), nil
+}
+
+// IMG1 rewraps the body of an unencrypted 8900 image into an unsigned IMG1
+// image for a given device, with execution starting at the beginning of the
+// body. Encrypted images cannot be converted, as they are encrypted with a
+// key the target device does not hold.
+func (i *Image8900) IMG1(kind devices.Kind) (*Image, error) {
+	if i.Encrypted() {
+		return nil, ErrEncrypted
+	}
+	return NewUnsigned(kind, 0, i.Body), nil
+}
@@ -0,0 +1,55 @@
+package img1
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+func Test8900(t *testing.T) {
+	body := []byte("hello, 8900!")
+	data, err := NewUnsigned8900(body).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !Is8900(data) {
+		t.Errorf("serialized image not detected as 8900")
+	}
+	if _, err := Parse(data); err == nil {
+		t.Errorf("8900 image parsed as IMG1")
+	}
+
+	img, err := Parse8900(data)
+	if err != nil {
+		t.Fatalf("Parse8900: %v", err)
+	}
+	if want := append(body, make([]byte, 4)...); !bytes.Equal(want, img.Body) {
+		t.Errorf("body differs")
+	}
+	if want := bytes.Repeat([]byte{'C'}, 0x300); !bytes.Equal(want, img.Certificates) {
+		t.Errorf("certificates differ")
+	}
+
+	converted, err := img.IMG1(devices.Nano5)
+	if err != nil {
+		t.Fatalf("IMG1: %v", err)
+	}
+	data, err = converted.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if _, err := Parse(data); err != nil {
+		t.Errorf("Parse of converted image: %v", err)
+	}
+
+	img.Header.Format = FormatSignedEncrypted
+	if _, err := img.IMG1(devices.Nano5); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("IMG1 of encrypted image returned %v, wanted ErrEncrypted", err)
+	}
+	data, _ = img.Serialize()
+	if _, err := Parse8900(data[:len(data)-1]); err == nil {
+		t.Errorf("Parse8900 of truncated image succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+package img1
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+// Variant describes the IMG1 header used by a generation of S5L SoCs. The
+// layout of the header itself is shared, but its magic, version and padding
+// differ.
+type Variant struct {
+	// Magic of images for this SoC, ie. its number, eg. "8720".
+	Magic string
+	// Version of the header, eg. "2.0".
+	Version string
+	// BodyOffset is the offset of the body within images, ie. the size of
+	// the header including its padding.
+	BodyOffset int
+	// LoadAddress is the address at which the bootrom loads the body of DFU
+	// images, or 0 if unknown.
+	LoadAddress uint32
+	// Kind of device using this SoC, or empty if it is not a supported
+	// device. Images for unsupported devices can be parsed and built, but
+	// not run.
+	Kind devices.Kind
+}
+
+func (v *Variant) String() string {
+	return fmt.Sprintf("S5L%s (header %s)", v.Magic, v.Version)
+}
+
+// Variants of the IMG1 header known to this package.
+var Variants = []*Variant{
+	{Magic: "8702", Version: "1.0", BodyOffset: 0x800, Kind: devices.Nano3},
+	{Magic: "8720", Version: "2.0", BodyOffset: 0x600, LoadAddress: 0x22000000, Kind: devices.Nano4},
+	{Magic: "8730", Version: "2.0", BodyOffset: 0x600, LoadAddress: 0x22000000, Kind: devices.Nano5},
+	// The load address of DFU images on the Nano 6G and 7G is not known yet.
+	{Magic: "8723", Version: "2.0", BodyOffset: 0x600, Kind: devices.Nano6},
+	{Magic: "8740", Version: "2.0", BodyOffset: 0x600, Kind: devices.Nano7},
+}
+
+// VariantForKind returns the header variant used by images for a given
+// device.
+func VariantForKind(k devices.Kind) (*Variant, error) {
+	for _, v := range Variants {
+		if k != "" && v.Kind == k {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported device %q", k)
+}
+
+// DetectVariant returns the header variant used by an image, as determined by
+// its magic and version.
+func DetectVariant(data []byte) (*Variant, error) {
+	if len(data) < 7 {
+		return nil, fmt.Errorf("image too short")
+	}
+	for _, v := range Variants {
+		if !bytes.Equal(data[:4], []byte(v.Magic)) {
+			continue
+		}
+		if !bytes.Equal(data[4:7], []byte(v.Version)) {
+			return nil, fmt.Errorf("unsupported image version %q, S5L%s images are %q", data[4:7], v.Magic, v.Version)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported image magic %q", data[:4])
+}
@@ -0,0 +1,207 @@
+package img1
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Status is the outcome of verifying the signature of an image.
+type Status int
+
+const (
+	// StatusUnsigned images carry no certificate chain, eg. ones built by
+	// NewUnsigned. They only boot through haxed DFU.
+	StatusUnsigned Status = iota
+	// StatusInvalid images carry a certificate chain, but their signature or
+	// the chain itself does not verify, eg. because the image was modified.
+	StatusInvalid
+	// StatusSigned images are correctly signed by a consistent certificate
+	// chain, which however does not lead to any of the trusted roots, eg.
+	// ones signed with a development or custom chain.
+	StatusSigned
+	// StatusStock images are correctly signed by a certificate chain leading
+	// to one of the trusted roots, ie. they are stock Apple images if the
+	// Apple root certificate is trusted.
+	StatusStock
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusUnsigned:
+		return "unsigned"
+	case StatusInvalid:
+		return "invalid signature"
+	case StatusSigned:
+		return "signed (untrusted chain)"
+	case StatusStock:
+		return "signed (trusted chain)"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", int(s))
+	}
+}
+
+// Verification is the result of Image.Verify.
+type Verification struct {
+	Status Status
+	// Chain of certificates used to verify the image, starting with the one
+	// whose key signed the image.
+	Chain []*x509.Certificate
+	// Err describes why the image is not StatusStock, if it isn't.
+	Err error
+}
+
+// signedHeaderLength is the length of the part of the header covered by the
+// signature, ie. everything up to HeaderSignature.
+const signedHeaderLength = 0x40
+
+// SignedData returns the data covered by the signature of the image: the
+// header up to its own signature, followed by the body as stored.
+func (i *Image) SignedData() []byte {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, i.Header)
+	return append(buf.Bytes()[:signedHeaderLength], i.Body...)
+}
+
+// Chain parses the certificate chain of the image, ignoring any padding
+// following it. Nil is returned if the image carries no certificates.
+func (i *Image) Chain() ([]*x509.Certificate, error) {
+	var res []*x509.Certificate
+	data := i.Certificates
+	for len(data) > 0 {
+		var raw asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &raw)
+		if err != nil || raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence {
+			// Padding, eg. the placeholder of unsigned images.
+			break
+		}
+		c, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", len(res), err)
+		}
+		res = append(res, c)
+		data = rest
+	}
+	return res, nil
+}
+
+// ChainPEM returns the certificate chain of the image as PEM, eg. for
+// analysis with other tools. Nil is returned if the image carries no
+// certificates.
+func (i *Image) ChainPEM() ([]byte, error) {
+	certs, err := i.Chain()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, c := range certs {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Verify checks the signature of the image against its certificate chain,
+// and the chain against the given trusted roots (eg. the Apple root
+// certificate). Certificate validity periods are not checked, as firmware
+// stays valid long after its certificates expire.
+func (i *Image) Verify(roots []*x509.Certificate) *Verification {
+	certs, err := i.Chain()
+	if err != nil {
+		return &Verification{Status: StatusInvalid, Err: err}
+	}
+	if len(certs) == 0 {
+		return &Verification{Status: StatusUnsigned, Err: errors.New("no certificate chain")}
+	}
+
+	// Find the certificate whose key signed the image.
+	digest := sha1.Sum(i.SignedData())
+	var leaf *x509.Certificate
+	for _, c := range certs {
+		if key, ok := c.PublicKey.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(key, crypto.SHA1, digest[:], i.Signature) == nil {
+			leaf = c
+			break
+		}
+	}
+	if leaf == nil {
+		return &Verification{Status: StatusInvalid, Err: errors.New("signature does not verify with any certificate in the chain")}
+	}
+
+	// Walk up the chain to a self-signed certificate, or the last one within
+	// the image.
+	chain := []*x509.Certificate{leaf}
+	for c := leaf; !bytes.Equal(c.RawIssuer, c.RawSubject); {
+		parent := findIssuer(c, certs)
+		if parent == nil {
+			break
+		}
+		for _, p := range chain {
+			if p == parent {
+				return &Verification{Status: StatusInvalid, Chain: chain, Err: errors.New("certificate chain loops")}
+			}
+		}
+		chain = append(chain, parent)
+		c = parent
+	}
+
+	top := chain[len(chain)-1]
+	for _, root := range roots {
+		if bytes.Equal(top.Raw, root.Raw) || (bytes.Equal(top.RawIssuer, root.RawSubject) && checkSignature(top, root) == nil) {
+			return &Verification{Status: StatusStock, Chain: chain}
+		}
+	}
+	if !bytes.Equal(top.RawIssuer, top.RawSubject) {
+		return &Verification{Status: StatusSigned, Chain: chain, Err: fmt.Errorf("issuer of %q not in chain or trusted roots", top.Subject)}
+	}
+	if err := checkSignature(top, top); err != nil {
+		return &Verification{Status: StatusInvalid, Chain: chain, Err: fmt.Errorf("root %q: %w", top.Subject, err)}
+	}
+	return &Verification{Status: StatusSigned, Chain: chain, Err: fmt.Errorf("root %q not trusted", top.Subject)}
+}
+
+// findIssuer returns the certificate within certs which issued c.
+func findIssuer(c *x509.Certificate, certs []*x509.Certificate) *x509.Certificate {
+	for _, p := range certs {
+		if p != c && bytes.Equal(c.RawIssuer, p.RawSubject) && checkSignature(c, p) == nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// checkSignature checks that c was signed by parent. Unlike
+// x509.Certificate.CheckSignatureFrom, this accepts SHA-1 signatures, as used
+// by older Apple certificates.
+func checkSignature(c, parent *x509.Certificate) error {
+	key, ok := parent.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported public key type %T", parent.PublicKey)
+	}
+	var h crypto.Hash
+	switch c.SignatureAlgorithm {
+	case x509.SHA1WithRSA:
+		h = crypto.SHA1
+	case x509.SHA256WithRSA:
+		h = crypto.SHA256
+	case x509.SHA384WithRSA:
+		h = crypto.SHA384
+	case x509.SHA512WithRSA:
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", c.SignatureAlgorithm)
+	}
+	if !h.Available() {
+		return fmt.Errorf("hash %s unavailable", h)
+	}
+	hh := h.New()
+	hh.Write(c.RawTBSCertificate)
+	return rsa.VerifyPKCS1v15(key, h, hh.Sum(nil), c.Signature)
+}
@@ -0,0 +1,118 @@
+package img1
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+func makeCertificate(t *testing.T, name string, key *rsa.PrivateKey, parent *x509.Certificate, parentKey *rsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Unix(0, 0),
+		NotAfter:              time.Unix(0, 0).Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return cert
+}
+
+func TestVerify(t *testing.T) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	leafKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	root := makeCertificate(t, "root", rootKey, nil, nil)
+	leaf := makeCertificate(t, "leaf", leafKey, root, rootKey)
+	other := makeCertificate(t, "other", rootKey, nil, nil)
+
+	sign := func(certs ...*x509.Certificate) *Image {
+		img := NewUnsigned(devices.Nano4, 0, []byte("hello, world!!!!"))
+		img.Certificates = nil
+		for _, c := range certs {
+			img.Certificates = append(img.Certificates, c.Raw...)
+		}
+		img.Certificates = append(img.Certificates, make([]byte, 0x10)...)
+		if _, err := img.Serialize(); err != nil {
+			t.Fatalf("Serialize: %v", err)
+		}
+		digest := sha1.Sum(img.SignedData())
+		sig, err := rsa.SignPKCS1v15(rand.Reader, leafKey, crypto.SHA1, digest[:])
+		if err != nil {
+			t.Fatalf("SignPKCS1v15: %v", err)
+		}
+		img.Signature = sig
+		return img
+	}
+
+	tampered := sign(leaf, root)
+	tampered.Body = []byte("hello, world????")
+
+	for i, test := range []struct {
+		img   *Image
+		roots []*x509.Certificate
+		want  Status
+		chain int
+	}{
+		{NewUnsigned(devices.Nano4, 0, nil), nil, StatusUnsigned, 0},
+		{sign(leaf, root), nil, StatusSigned, 2},
+		{sign(leaf, root), []*x509.Certificate{other}, StatusSigned, 2},
+		{sign(leaf, root), []*x509.Certificate{other, root}, StatusStock, 2},
+		{sign(leaf), []*x509.Certificate{root}, StatusStock, 1},
+		{sign(leaf), nil, StatusSigned, 1},
+		{sign(root), []*x509.Certificate{root}, StatusInvalid, 0},
+		{tampered, []*x509.Certificate{root}, StatusInvalid, 0},
+	} {
+		res := test.img.Verify(test.roots)
+		if res.Status != test.want {
+			t.Errorf("%d: status is %s (%v), wanted %s", i, res.Status, res.Err, test.want)
+		}
+		if len(res.Chain) != test.chain {
+			t.Errorf("%d: chain has %d certificates, wanted %d", i, len(res.Chain), test.chain)
+		}
+		if res.Status == StatusStock && res.Err != nil {
+			t.Errorf("%d: stock image with error %v", i, res.Err)
+		}
+	}
+	data, err := sign(leaf, root).ChainPEM()
+	if err != nil {
+		t.Fatalf("ChainPEM: %v", err)
+	}
+	for _, want := range []*x509.Certificate{leaf, root} {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil || !bytes.Equal(block.Bytes, want.Raw) {
+			t.Errorf("ChainPEM: certificate %q missing", want.Subject)
+		}
+	}
+	if data, err := NewUnsigned(devices.Nano4, 0, nil).ChainPEM(); err != nil || data != nil {
+		t.Errorf("ChainPEM of unsigned image returned %q, %v", data, err)
+	}
+}
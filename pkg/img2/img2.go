@@ -0,0 +1,129 @@
+// Package img2 implements the IMG2 container format, used by S5L8900 devices
+// running iPhone OS 1.x for images stored in NOR (eg. the boot logo), in
+// between the IMG1/8900 and IMG3 eras.
+//
+// An IMG2 image consists of a 0x400 byte header, followed by its data. More
+// info: https://www.theiphonewiki.com/wiki/IMG2_File_Format
+package img2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/freemyipod/wInd3x/pkg/img3"
+)
+
+// Magic of IMG2 images.
+var Magic = img3.MakeFourCC("Img2")
+
+// headerLength is the size of the header, including its padding.
+const headerLength = 0x400
+
+// checksummedLength is the length of the part of the header covered by
+// HeaderChecksum.
+const checksummedLength = 0x64
+
+// Header of an IMG2 image.
+type Header struct {
+	Magic img3.FourCC
+	// ImageType is the type of the image, eg. "logo".
+	ImageType     img3.FourCC
+	Unknown1      uint16
+	SecurityEpoch uint16
+	Flags1        uint32
+	// DataLengthPadded is the length of the data, padded to 16 bytes.
+	DataLengthPadded uint32
+	// DataLength is the length of the data.
+	DataLength uint32
+	Unknown3   uint32
+	Flags2     uint32
+	Reserved   [0x40]byte
+	Unknown4   uint32
+	// HeaderChecksum is the CRC32 of the header up to this field.
+	HeaderChecksum uint32
+	Checksum2      uint32
+}
+
+// Image is a parsed IMG2 image.
+type Image struct {
+	Header Header
+	Data   []byte
+}
+
+// Parse an IMG2 image, checking that all lengths within its header are
+// consistent. The header checksum is not checked, see ChecksumValid.
+func Parse(data []byte) (*Image, error) {
+	var hdr Header
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if hdr.Magic != Magic {
+		return nil, fmt.Errorf("unsupported image magic %q", hdr.Magic)
+	}
+	if hdr.DataLength > hdr.DataLengthPadded {
+		return nil, fmt.Errorf("data length 0x%x larger than padded length 0x%x", hdr.DataLength, hdr.DataLengthPadded)
+	}
+	if uint64(hdr.DataLengthPadded)+headerLength > uint64(len(data)) {
+		return nil, fmt.Errorf("data length 0x%x larger than image", hdr.DataLengthPadded)
+	}
+	return &Image{
+		Header: hdr,
+		Data:   data[headerLength : headerLength+hdr.DataLength],
+	}, nil
+}
+
+// Read an IMG2 image from r.
+func Read(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return Parse(data)
+}
+
+// checksum calculates the header checksum of h.
+func (h *Header) checksum() uint32 {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, h)
+	return crc32.ChecksumIEEE(buf.Bytes()[:checksummedLength])
+}
+
+// ChecksumValid returns whether the header checksum of the image is correct.
+func (i *Image) ChecksumValid() bool {
+	return i.Header.checksum() == i.Header.HeaderChecksum
+}
+
+// New returns an image of a given type wrapping data. The header is
+// calculated on Serialize.
+func New(imageType img3.FourCC, data []byte) *Image {
+	return &Image{
+		Header: Header{
+			ImageType: imageType,
+			Unknown4:  0xffffffff,
+		},
+		Data: data,
+	}
+}
+
+// Serialize builds the image. The magic, lengths and header checksum are
+// calculated from Data, which is padded to 16 bytes.
+func (i *Image) Serialize() ([]byte, error) {
+	hdr := i.Header
+	hdr.Magic = Magic
+	hdr.DataLength = uint32(len(i.Data))
+	hdr.DataLengthPadded = uint32((len(i.Data) + 15) &^ 15)
+	hdr.HeaderChecksum = hdr.checksum()
+
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.LittleEndian, hdr); err != nil {
+		return nil, fmt.Errorf("could not serialize header: %w", err)
+	}
+	buf.Write(make([]byte, headerLength-buf.Len()))
+	buf.Write(i.Data)
+	buf.Write(make([]byte, int(hdr.DataLengthPadded)-len(i.Data)))
+	i.Header = hdr
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,44 @@
+package img2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/img3"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data, err := New(img3.MakeFourCC("logo"), []byte("hello, world!")).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want := headerLength + 16; len(data) != want {
+		t.Errorf("image is 0x%x bytes, wanted 0x%x", len(data), want)
+	}
+	if want := []byte("2gmIogol"); !bytes.HasPrefix(data, want) {
+		t.Errorf("image starts with %q, wanted %q", data[:8], want)
+	}
+
+	img, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !img.ChecksumValid() {
+		t.Errorf("checksum invalid")
+	}
+	if want := []byte("hello, world!"); !bytes.Equal(want, img.Data) {
+		t.Errorf("data is %q, wanted %q", img.Data, want)
+	}
+
+	data[0x20] ^= 1
+	img, err = Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if img.ChecksumValid() {
+		t.Errorf("checksum of modified header valid")
+	}
+	if _, err := Parse(data[:len(data)-1]); err == nil {
+		t.Errorf("Parse of truncated image succeeded")
+	}
+}
@@ -0,0 +1,212 @@
+// Package img3 implements the IMG3 container format, used by later S5L SoCs
+// (eg. the iPhone 3G onwards) in place of IMG1.
+//
+// An IMG3 image consists of a short header followed by a list of tagged
+// fields: the image type, its (possibly encrypted) data, key bags, a
+// signature, certificates and so on. More info:
+// https://www.theiphonewiki.com/wiki/IMG3_File_Format
+package img3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FourCC is a four character code as used by IMG2 and IMG3 images. These are
+// stored as little endian integers, ie. reversed on disk.
+type FourCC uint32
+
+// MakeFourCC returns the FourCC for a four character string, eg. "DATA".
+func MakeFourCC(s string) FourCC {
+	var b [4]byte
+	copy(b[:], s)
+	return FourCC(binary.BigEndian.Uint32(b[:]))
+}
+
+func (f FourCC) String() string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(f))
+	return string(b[:])
+}
+
+var (
+	// Magic of IMG3 images.
+	Magic = MakeFourCC("Img3")
+
+	TagType          = MakeFourCC("TYPE")
+	TagData          = MakeFourCC("DATA")
+	TagVersion       = MakeFourCC("VERS")
+	TagSecurityEpoch = MakeFourCC("SEPO")
+	TagBoard         = MakeFourCC("BORD")
+	TagChip          = MakeFourCC("CHIP")
+	TagECID          = MakeFourCC("ECID")
+	TagKeyBag        = MakeFourCC("KBAG")
+	TagSignature     = MakeFourCC("SHSH")
+	TagCertificates  = MakeFourCC("CERT")
+)
+
+// Header of an IMG3 image.
+type Header struct {
+	Magic FourCC
+	// FullSize is the length of the image including this header.
+	FullSize uint32
+	// SizeNoPack is the length of all tags.
+	SizeNoPack uint32
+	// SigCheckArea is the length of all tags covered by the signature, ie.
+	// all tags preceding the signature tag.
+	SigCheckArea uint32
+	// Ident is the type of the image, eg. "illb" or "ibot".
+	Ident FourCC
+}
+
+// headerLength is the size of Header.
+const headerLength = 20
+
+// tagHeader precedes the data of every tag.
+type tagHeader struct {
+	Magic       FourCC
+	TotalLength uint32
+	DataLength  uint32
+}
+
+// tagHeaderLength is the size of tagHeader.
+const tagHeaderLength = 12
+
+// Tag is a single tagged field of an image.
+type Tag struct {
+	Magic FourCC
+	Data  []byte
+	// Padding following the data, as read. Tags are padded to 4 bytes with
+	// zeroes on Serialize if this is too short.
+	Padding []byte
+}
+
+// Image is a parsed IMG3 image.
+type Image struct {
+	Header Header
+	Tags   []*Tag
+}
+
+// Parse an IMG3 image, checking that all lengths within its header and tags
+// are consistent.
+func Parse(data []byte) (*Image, error) {
+	var hdr Header
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if hdr.Magic != Magic {
+		return nil, fmt.Errorf("unsupported image magic %q", hdr.Magic)
+	}
+	if uint64(hdr.FullSize) > uint64(len(data)) || uint64(hdr.SizeNoPack)+headerLength > uint64(hdr.FullSize) {
+		return nil, fmt.Errorf("image size 0x%x (tags: 0x%x) larger than image (0x%x)", hdr.FullSize, hdr.SizeNoPack, len(data))
+	}
+	res := &Image{Header: hdr}
+	data = data[headerLength : headerLength+hdr.SizeNoPack]
+	for offset := 0; len(data) > 0; {
+		var th tagHeader
+		if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &th); err != nil {
+			return nil, fmt.Errorf("tag at 0x%x: failed to read header: %w", offset, err)
+		}
+		if th.TotalLength < tagHeaderLength || uint64(th.TotalLength) > uint64(len(data)) || uint64(th.DataLength)+tagHeaderLength > uint64(th.TotalLength) {
+			return nil, fmt.Errorf("tag %s at 0x%x: invalid length 0x%x (data: 0x%x)", th.Magic, offset, th.TotalLength, th.DataLength)
+		}
+		dataEnd := tagHeaderLength + th.DataLength
+		res.Tags = append(res.Tags, &Tag{
+			Magic:   th.Magic,
+			Data:    data[tagHeaderLength:dataEnd],
+			Padding: data[dataEnd:th.TotalLength],
+		})
+		data = data[th.TotalLength:]
+		offset += int(th.TotalLength)
+	}
+	return res, nil
+}
+
+// Read an IMG3 image from r.
+func Read(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return Parse(data)
+}
+
+// Tag returns the first tag with a given magic, or nil if the image has no
+// such tag.
+func (i *Image) Tag(magic FourCC) *Tag {
+	for _, t := range i.Tags {
+		if t.Magic == magic {
+			return t
+		}
+	}
+	return nil
+}
+
+// Data returns the data of the DATA tag, ie. the payload of the image, or nil
+// if the image has none.
+func (i *Image) Data() []byte {
+	if t := i.Tag(TagData); t != nil {
+		return t.Data
+	}
+	return nil
+}
+
+// New returns an unsigned image of a given type wrapping data. The header is
+// calculated on Serialize.
+func New(ident FourCC, data []byte) *Image {
+	typ := make([]byte, 4)
+	binary.LittleEndian.PutUint32(typ, uint32(ident))
+	return &Image{
+		Header: Header{
+			Ident: ident,
+		},
+		Tags: []*Tag{
+			{Magic: TagType, Data: typ},
+			{Magic: TagData, Data: data},
+		},
+	}
+}
+
+// Serialize builds the image. The magic and all lengths within the header and
+// tags are calculated from Tags.
+func (i *Image) Serialize() ([]byte, error) {
+	tags := bytes.NewBuffer(nil)
+	hdr := i.Header
+	hdr.Magic = Magic
+	sigCheckArea := -1
+	for _, t := range i.Tags {
+		if t.Magic == TagSignature && sigCheckArea == -1 {
+			sigCheckArea = tags.Len()
+		}
+		padding := t.Padding
+		if l := len(t.Data) + len(padding); l%4 != 0 {
+			padding = append(append([]byte{}, padding...), make([]byte, 4-l%4)...)
+		}
+		th := tagHeader{
+			Magic:       t.Magic,
+			TotalLength: uint32(tagHeaderLength + len(t.Data) + len(padding)),
+			DataLength:  uint32(len(t.Data)),
+		}
+		if err := binary.Write(tags, binary.LittleEndian, th); err != nil {
+			return nil, fmt.Errorf("could not serialize tag %s: %w", t.Magic, err)
+		}
+		tags.Write(t.Data)
+		tags.Write(padding)
+	}
+	if sigCheckArea == -1 {
+		sigCheckArea = tags.Len()
+	}
+	hdr.SizeNoPack = uint32(tags.Len())
+	hdr.FullSize = uint32(headerLength + tags.Len())
+	hdr.SigCheckArea = uint32(sigCheckArea)
+
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.LittleEndian, hdr); err != nil {
+		return nil, fmt.Errorf("could not serialize header: %w", err)
+	}
+	buf.Write(tags.Bytes())
+	i.Header = hdr
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+package img3
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFourCC(t *testing.T) {
+	if want, got := "DATA", TagData.String(); want != got {
+		t.Errorf("TagData is %q, wanted %q", got, want)
+	}
+	// FourCCs are stored reversed.
+	img, err := New(MakeFourCC("ibot"), nil).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if want := []byte("3gmI"); !bytes.HasPrefix(img, want) {
+		t.Errorf("image starts with %q, wanted %q", img[:4], want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	img := New(MakeFourCC("ibot"), []byte("hello, world!"))
+	img.Tags = append(img.Tags,
+		&Tag{Magic: TagSignature, Data: bytes.Repeat([]byte{'S'}, 0x80)},
+		&Tag{Magic: TagCertificates, Data: []byte("certs"), Padding: []byte{1, 2, 3}},
+	)
+	data, err := img.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if len(data)%4 != 0 {
+		t.Errorf("image length 0x%x not aligned", len(data))
+	}
+
+	img2, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if img2.Header.Ident.String() != "ibot" {
+		t.Errorf("ident is %q, wanted ibot", img2.Header.Ident)
+	}
+	if want := []byte("hello, world!"); !bytes.Equal(want, img2.Data()) {
+		t.Errorf("data is %q, wanted %q", img2.Data(), want)
+	}
+	// TYPE (12+4) and DATA (12+13+3) precede the signature.
+	if want := uint32(16 + 28); img2.Header.SigCheckArea != want {
+		t.Errorf("sig check area is 0x%x, wanted 0x%x", img2.Header.SigCheckArea, want)
+	}
+	if c := img2.Tag(TagCertificates); c == nil || !bytes.Equal(c.Padding, []byte{1, 2, 3}) {
+		t.Errorf("certificates tag missing or padding not preserved")
+	}
+	data2, err := img2.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Errorf("image changed on round trip")
+	}
+
+	if _, err := Parse(data[:len(data)-4]); err == nil {
+		t.Errorf("Parse of truncated image succeeded")
+	}
+	data[headerLength+4] = 0xff
+	if _, err := Parse(data); err == nil {
+		t.Errorf("Parse of image with invalid tag length succeeded")
+	}
+}
@@ -0,0 +1,119 @@
+// Package ipsw downloads and unpacks iPod firmware archives (IPSWs), which
+// are zip files containing the DFU images (WTFs), Firmware.MSE files and
+// diagnostics for one or more device generations.
+package ipsw
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+// Download an archive from url, verifying that its SHA-1 matches wantSHA1 (a
+// hex string) if not empty. The data and its SHA-1 are returned.
+func Download(url, wantSHA1 string) ([]byte, string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, "", fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("download failed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("download failed: %w", err)
+	}
+	sum := sha1.Sum(data)
+	got := hex.EncodeToString(sum[:])
+	if wantSHA1 != "" && !strings.EqualFold(got, wantSHA1) {
+		return nil, got, fmt.Errorf("SHA-1 mismatch: got %s, wanted %s", got, wantSHA1)
+	}
+	return data, got, nil
+}
+
+// Relevant returns whether a file within an archive is relevant to a given
+// device: its WTF (named after its DFU product ID, eg. WTF.x1225.release.dfu),
+// Firmware.MSE files, and diagnostics.
+func Relevant(name string, kind devices.Kind) bool {
+	base := strings.ToLower(path.Base(name))
+	switch {
+	case strings.HasPrefix(base, "wtf."):
+		for _, d := range devices.Descriptions {
+			if d.Kind == kind && strings.HasPrefix(base, fmt.Sprintf("wtf.x%04x.", uint16(d.DFUPID))) {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(base, "firmware") && strings.HasSuffix(base, ".mse"):
+		return true
+	case strings.Contains(base, "diag"):
+		return true
+	}
+	return false
+}
+
+// Extract all files relevant to a given device from an archive into dir,
+// flattening any directory structure. The paths of all extracted files are
+// returned.
+func Extract(data []byte, kind devices.Kind, dir string) ([]string, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("could not open archive: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create %s: %w", dir, err)
+	}
+	var res []string
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !Relevant(f.Name, kind) {
+			continue
+		}
+		out := filepath.Join(dir, path.Base(f.Name))
+		if err := extractFile(f, out); err != nil {
+			return nil, fmt.Errorf("could not extract %s: %w", f.Name, err)
+		}
+		res = append(res, out)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("archive contains no files for %s", kind)
+	}
+	return res, nil
+}
+
+func extractFile(f *zip.File, out string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// CacheDir returns the directory in which files for a given device are
+// cached, eg. ~/.cache/wInd3x/n4g.
+func CacheDir(kind devices.Kind) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "wInd3x", string(kind)), nil
+}
@@ -0,0 +1,84 @@
+package ipsw
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+)
+
+func makeArchive(t *testing.T, names ...string) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	w := zip.NewWriter(buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		f.Write([]byte(name))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	data := makeArchive(t,
+		"WTF.x1225.release.dfu",
+		"WTF.x1231.release.dfu",
+		"Firmware-31.1.0.4.MSE",
+		"sub/diag.bin",
+		"Manifest.plist",
+	)
+	dir := t.TempDir()
+	files, err := Extract(data, devices.Nano4, dir)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	sort.Strings(names)
+	want := []string{"Firmware-31.1.0.4.MSE", "WTF.x1225.release.dfu", "diag.bin"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("extracted %v, wanted %v", names, want)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "diag.bin"))
+	if err != nil || string(got) != "sub/diag.bin" {
+		t.Errorf("diag.bin contains %q (%v)", got, err)
+	}
+
+	if _, err := Extract(makeArchive(t, "Manifest.plist"), devices.Nano4, dir); err == nil {
+		t.Errorf("Extract of archive without relevant files succeeded")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	// echo -n hello | sha1sum
+	const want = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	data, sum, err := Download(srv.URL, want)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if string(data) != "hello" || sum != want {
+		t.Errorf("downloaded %q (%s)", data, sum)
+	}
+	if _, _, err := Download(srv.URL, "00"); err == nil {
+		t.Errorf("Download with wrong SHA-1 succeeded")
+	}
+}
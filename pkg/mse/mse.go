@@ -0,0 +1,241 @@
+// Package mse implements the Firmware.MSE container used by retail iPod
+// firmware updates, ie. an image of the firmware partition: a header sector,
+// a directory of images (the OS as 'osos', resources as 'rsrc', the updater
+// as 'aupd', diagnostics as 'diag', ...) and the images themselves.
+//
+// The layout follows ipodpatcher from the Rockbox project. Unknown fields are
+// preserved as read.
+package mse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// magicOffset is the offset of the "]ih[" magic within the header.
+	magicOffset = 0x100
+	// sectorSize is the alignment of the directory and of images.
+	sectorSize = 0x200
+	// maxEntries is the maximum number of directory entries read.
+	maxEntries = 10
+)
+
+var magic = []byte("]ih[")
+
+// header follows the magic.
+type header struct {
+	Magic [4]byte
+	// DirectoryOffset is the offset of the directory, relative to the end of
+	// the header sector.
+	DirectoryOffset uint32
+	Unknown         uint16
+	// Version is 3 for files in which image offsets are relative to the end
+	// of the header sector, and 2 for older ones.
+	Version uint16
+}
+
+// Entry in the image directory. Four character codes are stored reversed,
+// eg. "soso" for an 'osos' image.
+type Entry struct {
+	// Device the image is stored on, eg. "!ATA", "DNAN" or "hslf" (for
+	// images stored in NOR flash).
+	Device [4]byte
+	// ID of the image, eg. "soso".
+	ID      [4]byte
+	Unknown uint32
+	// Offset of the image data.
+	Offset uint32
+	// Length of the image data.
+	Length uint32
+	// Address the image is loaded at.
+	Address uint32
+	// EntryOffset is the offset of the entrypoint within the image.
+	EntryOffset uint32
+	// Checksum is the sum of all bytes of the image.
+	Checksum    uint32
+	Version     uint32
+	LoadAddress uint32
+}
+
+// entryLength is the size of Entry.
+const entryLength = 40
+
+// Name returns the image name as commonly written, eg. "osos".
+func (e *Entry) Name() string {
+	return reverse(e.ID[:])
+}
+
+// DeviceName returns the device name as commonly written, eg. "NAND".
+func (e *Entry) DeviceName() string {
+	return reverse(e.Device[:])
+}
+
+func reverse(b []byte) string {
+	res := make([]byte, len(b))
+	for i := range b {
+		res[len(b)-1-i] = b[i]
+	}
+	return string(res)
+}
+
+// Valid returns whether e looks like a directory entry, rather than the end of
+// the directory.
+func (e *Entry) Valid() bool {
+	switch string(e.Device[:]) {
+	case "!ATA", "DNAN", "NOR!", "!RON", "hslf":
+		return true
+	}
+	return false
+}
+
+// Image stored within the container.
+type Image struct {
+	Entry Entry
+	// Data of the image. It can be replaced freely before calling
+	// Serialize.
+	Data []byte
+}
+
+// Checksum calculates the checksum of the image data.
+func (i *Image) Checksum() uint32 {
+	return Checksum(i.Data)
+}
+
+// Checksum calculates the checksum of an image, ie. the sum of its bytes.
+func Checksum(data []byte) uint32 {
+	var sum uint32
+	for _, b := range data {
+		sum += uint32(b)
+	}
+	return sum
+}
+
+// Firmware is a parsed Firmware.MSE file.
+type Firmware struct {
+	// Header is the header sector, preserved as read.
+	Header []byte
+	Images []*Image
+
+	hdr header
+	// base is the offset image offsets are relative to.
+	base int
+	// directory is the offset of the directory.
+	directory int
+	// directoryEnd is the end of the directory, ie. the first unused entry,
+	// which is preserved as read.
+	directoryEnd int
+	// original is the file as read.
+	original []byte
+}
+
+// Parse a Firmware.MSE file.
+func Parse(data []byte) (*Firmware, error) {
+	if len(data) < sectorSize || !bytes.Equal(data[magicOffset:magicOffset+4], magic) {
+		return nil, errors.New("no firmware header")
+	}
+	var hdr header
+	binary.Read(bytes.NewReader(data[magicOffset:]), binary.LittleEndian, &hdr)
+	res := &Firmware{
+		Header:    data[:sectorSize],
+		hdr:       hdr,
+		directory: sectorSize + int(hdr.DirectoryOffset),
+		original:  data,
+	}
+	if hdr.Version >= 3 {
+		res.base = sectorSize
+	}
+
+	offset := res.directory
+	for n := 0; n < maxEntries; n++ {
+		if offset+entryLength > len(data) {
+			return nil, fmt.Errorf("directory at 0x%x outside of file", offset)
+		}
+		var e Entry
+		binary.Read(bytes.NewReader(data[offset:]), binary.LittleEndian, &e)
+		if !e.Valid() {
+			break
+		}
+		start := uint64(res.base) + uint64(e.Offset)
+		end := start + uint64(e.Length)
+		if end > uint64(len(data)) {
+			return nil, fmt.Errorf("image %s at 0x%x-0x%x outside of file", e.Name(), start, end)
+		}
+		res.Images = append(res.Images, &Image{
+			Entry: e,
+			Data:  data[start:end],
+		})
+		offset += entryLength
+	}
+	if len(res.Images) == 0 {
+		return nil, errors.New("no images in directory")
+	}
+	res.directoryEnd = offset
+	return res, nil
+}
+
+// Image returns the image with a given name, eg. "osos", or nil if there is
+// none.
+func (f *Firmware) Image(name string) *Image {
+	for _, i := range f.Images {
+		if i.Entry.Name() == name {
+			return i
+		}
+	}
+	return nil
+}
+
+// Verify checks the checksums of all images, returning an error for each
+// image whose data does not match.
+func (f *Firmware) Verify() []error {
+	var res []error
+	for _, i := range f.Images {
+		if sum := i.Checksum(); sum != i.Entry.Checksum {
+			res = append(res, fmt.Errorf("image %s: checksum is %08x, should be %08x", i.Entry.Name(), i.Entry.Checksum, sum))
+		}
+	}
+	return res
+}
+
+// Serialize builds the file. Everything up to the first image is kept as
+// read, and images are laid out in their original order from there, each
+// aligned to a sector. The offsets, lengths and checksums within the
+// directory are updated accordingly, so an unmodified file serializes back
+// to itself as long as it had valid checksums and no gaps between images.
+func (f *Firmware) Serialize() ([]byte, error) {
+	if f.original == nil {
+		return nil, errors.New("firmware not read from file")
+	}
+	order := make([]*Image, len(f.Images))
+	copy(order, f.Images)
+	sort.SliceStable(order, func(a, b int) bool {
+		return order[a].Entry.Offset < order[b].Entry.Offset
+	})
+	first := f.base + int(order[0].Entry.Offset)
+	if first < f.directoryEnd {
+		return nil, fmt.Errorf("first image at 0x%x overlaps directory", first)
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.Write(f.original[:first])
+
+	for _, i := range order {
+		i.Entry.Offset = uint32(buf.Len() - f.base)
+		i.Entry.Length = uint32(len(i.Data))
+		i.Entry.Checksum = i.Checksum()
+		buf.Write(i.Data)
+		if pad := buf.Len() % sectorSize; pad != 0 {
+			buf.Write(make([]byte, sectorSize-pad))
+		}
+	}
+
+	res := buf.Bytes()
+	for n, i := range f.Images {
+		entry := bytes.NewBuffer(nil)
+		binary.Write(entry, binary.LittleEndian, i.Entry)
+		copy(res[f.directory+n*entryLength:], entry.Bytes())
+	}
+	return res, nil
+}
@@ -0,0 +1,87 @@
+package mse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// makeFirmware builds a version 3 file with the directory right after the
+// header sector, and the given images in consecutive sectors after it.
+func makeFirmware(images map[string][]byte, order ...string) []byte {
+	data := make([]byte, 2*sectorSize)
+	copy(data[magicOffset:], magic)
+	binary.LittleEndian.PutUint16(data[magicOffset+10:], 3)
+	for n, name := range order {
+		img := images[name]
+		e := Entry{
+			Offset:   uint32(len(data) - sectorSize),
+			Length:   uint32(len(img)),
+			Checksum: Checksum(img),
+		}
+		copy(e.Device[:], "DNAN")
+		copy(e.ID[:], reverse([]byte(name)))
+		buf := bytes.NewBuffer(nil)
+		binary.Write(buf, binary.LittleEndian, e)
+		copy(data[sectorSize+n*entryLength:], buf.Bytes())
+		data = append(data, img...)
+		data = append(data, make([]byte, sectorSize-len(img)%sectorSize)...)
+	}
+	return data
+}
+
+func TestFirmware(t *testing.T) {
+	data := makeFirmware(map[string][]byte{
+		"osos": bytes.Repeat([]byte{1}, 0x300),
+		"rsrc": bytes.Repeat([]byte{2}, 0x100),
+	}, "osos", "rsrc")
+
+	fw, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(fw.Images) != 2 {
+		t.Fatalf("got %d images, wanted 2", len(fw.Images))
+	}
+	if errs := fw.Verify(); len(errs) != 0 {
+		t.Errorf("Verify: %v", errs)
+	}
+	if fw.Image("rsrc") == nil || fw.Images[0].Entry.DeviceName() != "NAND" {
+		t.Errorf("images not parsed correctly")
+	}
+	res, err := fw.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if !bytes.Equal(data, res) {
+		t.Errorf("unmodified firmware changed on serialization")
+	}
+
+	fw.Image("osos").Data = bytes.Repeat([]byte{3}, 0x500)
+	res, err = fw.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	fw, err = Parse(res)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if errs := fw.Verify(); len(errs) != 0 {
+		t.Errorf("Verify: %v", errs)
+	}
+	if want := bytes.Repeat([]byte{3}, 0x500); !bytes.Equal(want, fw.Image("osos").Data) {
+		t.Errorf("osos not replaced")
+	}
+	if want := bytes.Repeat([]byte{2}, 0x100); !bytes.Equal(want, fw.Image("rsrc").Data) {
+		t.Errorf("rsrc not moved correctly")
+	}
+
+	data[len(data)-sectorSize] ^= 0xff
+	fw, err = Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if errs := fw.Verify(); len(errs) != 1 {
+		t.Errorf("Verify of corrupted firmware returned %v, wanted one error", errs)
+	}
+}
@@ -0,0 +1,204 @@
+// Package nor parses full dumps of the SPI NOR flash of iPods (eg. as read by
+// the nor read command): the IMG1 bootloader at its start, and the 'flsh'
+// directory describing all other images stored in it (eg. diagnostics or the
+// disk mode image).
+package nor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/freemyipod/wInd3x/pkg/img1"
+	"github.com/freemyipod/wInd3x/pkg/mse"
+)
+
+const (
+	// directoryAlignment is the alignment at which the directory is searched
+	// for.
+	directoryAlignment = 0x200
+	// entryLength is the size of a directory entry.
+	entryLength = 40
+	// maxEntries is the maximum number of directory entries read.
+	maxEntries = 32
+	// erased is the value of erased NOR flash.
+	erased = 0xff
+)
+
+const (
+	// RegionBootloader is the name of the region holding the IMG1
+	// bootloader at the start of the flash.
+	RegionBootloader = "boot"
+	// RegionDirectory is the name of the region holding the directory.
+	RegionDirectory = "dir"
+)
+
+// Region of the flash.
+type Region struct {
+	// Name of the region, either the name of an image in the directory (eg.
+	// "diag"), or RegionBootloader or RegionDirectory.
+	Name   string
+	Offset int
+	Length int
+	// Entry describing the region in the directory, or nil for the
+	// bootloader and the directory itself.
+	Entry *mse.Entry
+	// index of Entry within the directory.
+	index int
+}
+
+// End returns the offset following the region.
+func (r *Region) End() int {
+	return r.Offset + r.Length
+}
+
+// NOR is a parsed NOR flash dump.
+type NOR struct {
+	// Data is the whole dump, modified in place by Replace.
+	Data []byte
+	// Regions of the flash, sorted by offset.
+	Regions []*Region
+
+	directory int
+}
+
+// Parse a NOR flash dump.
+func Parse(data []byte) (*NOR, error) {
+	res := &NOR{Data: data, directory: -1}
+	if img, err := img1.Parse(data); err == nil {
+		res.Regions = append(res.Regions, &Region{
+			Name:   RegionBootloader,
+			Length: img.Variant.BodyOffset + int(img.Header.DataLength),
+		})
+	}
+
+	for offset := 0; offset+entryLength <= len(data); offset += directoryAlignment {
+		if bytes.Equal(data[offset:offset+4], []byte("hslf")) {
+			res.directory = offset
+			break
+		}
+	}
+	if res.directory == -1 {
+		if len(res.Regions) == 0 {
+			return nil, errors.New("neither bootloader nor directory found")
+		}
+		return res, nil
+	}
+
+	offset := res.directory
+	for n := 0; n < maxEntries && offset+entryLength <= len(data); n++ {
+		var e mse.Entry
+		binary.Read(bytes.NewReader(data[offset:]), binary.LittleEndian, &e)
+		if !e.Valid() {
+			break
+		}
+		if uint64(e.Offset)+uint64(e.Length) > uint64(len(data)) {
+			return nil, fmt.Errorf("image %s at 0x%x (0x%x bytes) outside of flash", e.Name(), e.Offset, e.Length)
+		}
+		res.Regions = append(res.Regions, &Region{
+			Name:   e.Name(),
+			Offset: int(e.Offset),
+			Length: int(e.Length),
+			Entry:  &e,
+			index:  n,
+		})
+		offset += entryLength
+	}
+	res.Regions = append(res.Regions, &Region{
+		Name:   RegionDirectory,
+		Offset: res.directory,
+		Length: offset - res.directory,
+	})
+	sort.SliceStable(res.Regions, func(a, b int) bool {
+		return res.Regions[a].Offset < res.Regions[b].Offset
+	})
+	return res, nil
+}
+
+// Region returns the region with a given name, or nil if there is none.
+func (n *NOR) Region(name string) *Region {
+	for _, r := range n.Regions {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Read returns the data of the region with a given name.
+func (n *NOR) Read(name string) ([]byte, error) {
+	r := n.Region(name)
+	if r == nil {
+		return nil, fmt.Errorf("no region %q", name)
+	}
+	return n.Data[r.Offset:r.End()], nil
+}
+
+// Capacity returns the maximum length of a region, ie. the space until the
+// next region or the end of the flash.
+func (n *NOR) Capacity(r *Region) int {
+	end := len(n.Data)
+	for _, o := range n.Regions {
+		if o.Offset > r.Offset && o.Offset < end {
+			end = o.Offset
+		}
+	}
+	return end - r.Offset
+}
+
+// Verify checks the checksums of all images in the directory and the
+// bootloader, returning an error for each region which does not verify.
+func (n *NOR) Verify() []error {
+	var res []error
+	for _, r := range n.Regions {
+		switch {
+		case r.Entry != nil:
+			if sum := mse.Checksum(n.Data[r.Offset:r.End()]); sum != r.Entry.Checksum {
+				res = append(res, fmt.Errorf("region %s: checksum is %08x, should be %08x", r.Name, r.Entry.Checksum, sum))
+			}
+		case r.Name == RegionBootloader:
+			if _, err := img1.Parse(n.Data[r.Offset:r.End()]); err != nil {
+				res = append(res, fmt.Errorf("region %s: %w", r.Name, err))
+			}
+		}
+	}
+	return res
+}
+
+// Replace the data of the region with a given name, which must fit within
+// its capacity. The rest of the capacity is erased, and the length and
+// checksum of images in the directory are updated. The bootloader must be a
+// valid IMG1 image.
+func (n *NOR) Replace(name string, data []byte) error {
+	r := n.Region(name)
+	if r == nil {
+		return fmt.Errorf("no region %q", name)
+	}
+	switch {
+	case r.Name == RegionDirectory:
+		return errors.New("directory cannot be replaced")
+	case r.Name == RegionBootloader:
+		if _, err := img1.Parse(data); err != nil {
+			return fmt.Errorf("bootloader must be an IMG1 image: %w", err)
+		}
+	}
+	capacity := n.Capacity(r)
+	if len(data) > capacity {
+		return fmt.Errorf("0x%x bytes do not fit into region %s (0x%x bytes available)", len(data), r.Name, capacity)
+	}
+	copy(n.Data[r.Offset:], data)
+	for i := r.Offset + len(data); i < r.Offset+capacity; i++ {
+		n.Data[i] = erased
+	}
+	r.Length = len(data)
+	if r.Entry != nil {
+		r.Entry.Length = uint32(len(data))
+		r.Entry.Checksum = mse.Checksum(data)
+		buf := bytes.NewBuffer(nil)
+		binary.Write(buf, binary.LittleEndian, r.Entry)
+		copy(n.Data[n.directory+r.index*entryLength:], buf.Bytes())
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package nor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+	"github.com/freemyipod/wInd3x/pkg/mse"
+)
+
+func makeDump(t *testing.T) []byte {
+	t.Helper()
+	data := bytes.Repeat([]byte{erased}, 0x20000)
+	boot, err := img1.NewUnsigned(devices.Nano4, 0, []byte("bootloader")).Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	copy(data, boot)
+	for n, image := range []struct {
+		name   string
+		offset int
+		data   []byte
+	}{
+		{"diag", 0x11000, bytes.Repeat([]byte{1}, 0x100)},
+		{"disk", 0x12000, bytes.Repeat([]byte{2}, 0x200)},
+	} {
+		e := mse.Entry{
+			Offset:   uint32(image.offset),
+			Length:   uint32(len(image.data)),
+			Checksum: mse.Checksum(image.data),
+		}
+		copy(e.Device[:], "hslf")
+		for i := 0; i < 4; i++ {
+			e.ID[i] = image.name[3-i]
+		}
+		buf := bytes.NewBuffer(nil)
+		binary.Write(buf, binary.LittleEndian, e)
+		copy(data[0x10000+n*entryLength:], buf.Bytes())
+		copy(data[image.offset:], image.data)
+	}
+	return data
+}
+
+func TestNOR(t *testing.T) {
+	n, err := Parse(makeDump(t))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var names []string
+	for _, r := range n.Regions {
+		names = append(names, r.Name)
+	}
+	if want := []string{"boot", "dir", "diag", "disk"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("regions are %v, wanted %v", names, want)
+	}
+	if errs := n.Verify(); len(errs) != 0 {
+		t.Errorf("Verify: %v", errs)
+	}
+	if got, want := n.Capacity(n.Region("diag")), 0x1000; got != want {
+		t.Errorf("diag capacity is 0x%x, wanted 0x%x", got, want)
+	}
+
+	if err := n.Replace("diag", bytes.Repeat([]byte{3}, 0x1001)); err == nil {
+		t.Errorf("Replace with too much data succeeded")
+	}
+	if err := n.Replace("diag", bytes.Repeat([]byte{3}, 0x80)); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	n, err = Parse(n.Data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if errs := n.Verify(); len(errs) != 0 {
+		t.Errorf("Verify after Replace: %v", errs)
+	}
+	diag, err := n.Read("diag")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if want := bytes.Repeat([]byte{3}, 0x80); !bytes.Equal(want, diag) {
+		t.Errorf("diag not replaced")
+	}
+	if n.Data[0x11080] != erased {
+		t.Errorf("rest of diag not erased")
+	}
+	if err := n.Replace("boot", []byte("not an image")); err == nil {
+		t.Errorf("Replace of bootloader with invalid image succeeded")
+	}
+}
@@ -0,0 +1,87 @@
+// Package recovery implements the command interface of recovery mode, which
+// later (iBoot-style) boot stages expose instead of DFU. Commands are sent as
+// strings over control transfers, while files are uploaded over a bulk
+// endpoint, to then be used by commands (eg. booted with "go").
+package recovery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/transport"
+	"github.com/freemyipod/wInd3x/pkg/usblog"
+)
+
+const (
+	// MaxCommand is the maximum length of a command, including its NUL
+	// terminator.
+	MaxCommand = 0x100
+	// ChunkSize is the size of bulk transfers when sending files.
+	ChunkSize = 0x8000
+	// maxResponse is the maximum length of a response, eg. to getenv.
+	maxResponse = 0xff
+)
+
+// USB interface of the bulk endpoint files are sent to.
+const (
+	Config     = 1
+	Interface  = 0
+	AltSetting = 0
+	Endpoint   = 4
+)
+
+// Command sends a command (eg. "setenv auto-boot false" or "go") to a device.
+func Command(usb transport.Device, cmd string) error {
+	if len(cmd)+1 > MaxCommand {
+		return fmt.Errorf("command too long (%d > %d)", len(cmd)+1, MaxCommand)
+	}
+	data := append([]byte(cmd), 0)
+	if _, err := usblog.Control(usb, 0x40, 0, 0, 0, data); err != nil {
+		return fmt.Errorf("command %q failed: %w", cmd, err)
+	}
+	return nil
+}
+
+// GetEnv returns the value of an environment variable of a device.
+func GetEnv(usb transport.Device, name string) (string, error) {
+	if err := Command(usb, "getenv "+name); err != nil {
+		return "", err
+	}
+	buf := make([]byte, maxResponse)
+	n, err := usblog.Control(usb, 0xc0, 0, 0, 0, buf)
+	if err != nil {
+		return "", fmt.Errorf("could not read %s: %w", name, err)
+	}
+	return string(bytes.TrimRight(buf[:n], "\x00")), nil
+}
+
+// Send uploads a file to a device, calling progress (if not nil) after every
+// chunk.
+func Send(ctx context.Context, usb transport.Bulk, data []byte, progress dfu.Progress) error {
+	if _, err := usblog.Control(usb, 0x41, 0, 0, 0, nil); err != nil {
+		return fmt.Errorf("could not start upload: %w", err)
+	}
+	for sent := 0; sent < len(data); {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := sent + ChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		n, err := usblog.Bulk(usb, data[sent:end])
+		if err != nil {
+			return fmt.Errorf("upload failed at 0x%x: %w", sent, err)
+		}
+		if n == 0 {
+			return fmt.Errorf("upload stalled at 0x%x", sent)
+		}
+		sent += n
+		if progress != nil {
+			progress(sent, len(data))
+		}
+	}
+	return nil
+}
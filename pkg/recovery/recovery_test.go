@@ -0,0 +1,86 @@
+package recovery
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/transport/mock"
+)
+
+// device is a mock device in recovery mode.
+type device struct {
+	*mock.Device
+	env     map[string]string
+	pending string
+	bulk    []byte
+}
+
+func newDevice() *device {
+	d := &device{Device: mock.New(), env: map[string]string{"build-version": "iBoot-204"}}
+	d.Handler = func(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+		switch rType {
+		case 0x40:
+			d.pending = string(bytes.TrimRight(data, "\x00"))
+			return len(data), nil
+		case 0xc0:
+			if len(d.pending) > 7 && d.pending[:7] == "getenv " {
+				return copy(data, append([]byte(d.env[d.pending[7:]]), 0)), nil
+			}
+			return 0, nil
+		case 0x41:
+			d.bulk = nil
+			return 0, nil
+		}
+		return 0, nil
+	}
+	return d
+}
+
+func (d *device) WriteBulk(data []byte) (int, error) {
+	d.bulk = append(d.bulk, data...)
+	return len(data), nil
+}
+
+func TestCommand(t *testing.T) {
+	d := newDevice()
+	if err := Command(d, "setenv auto-boot false"); err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	tr := d.Transfers[len(d.Transfers)-1]
+	if want, got := "setenv auto-boot false\x00", string(tr.Data); want != got {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+	if err := Command(d, string(make([]byte, MaxCommand))); err == nil {
+		t.Errorf("overlong command accepted")
+	}
+}
+
+func TestGetEnv(t *testing.T) {
+	d := newDevice()
+	got, err := GetEnv(d, "build-version")
+	if err != nil {
+		t.Fatalf("GetEnv: %v", err)
+	}
+	if want := "iBoot-204"; want != got {
+		t.Errorf("wanted %q, got %q", want, got)
+	}
+}
+
+func TestSend(t *testing.T) {
+	d := newDevice()
+	data := bytes.Repeat([]byte{0x42}, 2*ChunkSize+0x10)
+	var chunks int
+	err := Send(context.Background(), d, data, func(sent, total int) {
+		chunks += 1
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if !bytes.Equal(d.bulk, data) {
+		t.Errorf("received data differs")
+	}
+	if want, got := 3, chunks; want != got {
+		t.Errorf("wanted %d chunks, got %d", want, got)
+	}
+}
@@ -0,0 +1,111 @@
+// Package sniff detects the format of files handled by wInd3x, so that
+// commands can point users at the right tool instead of failing with header
+// errors when given the wrong kind of file.
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/freemyipod/wInd3x/pkg/img1"
+	"github.com/freemyipod/wInd3x/pkg/img2"
+	"github.com/freemyipod/wInd3x/pkg/img3"
+)
+
+// Format of a file.
+type Format int
+
+const (
+	// Raw is any data not recognized as another format, presumably a raw
+	// payload.
+	Raw Format = iota
+	// FirmwareVolume is a bare EFI firmware volume.
+	FirmwareVolume
+	// IMG1 is an unencrypted IMG1 image whose body is not a firmware volume,
+	// eg. a decrypted WTF or a wrapped payload.
+	IMG1
+	// IMG1Encrypted is an encrypted IMG1 image, eg. a stock WTF or firmware.
+	IMG1Encrypted
+	// IMG1Firmware is an unencrypted IMG1 image whose body is a firmware
+	// volume, eg. decrypted firmware.
+	IMG1Firmware
+	// Image8900 is an 8900 image.
+	Image8900
+	// IMG2 is an IMG2 image.
+	IMG2
+	// IMG3 is an IMG3 image.
+	IMG3
+	// MSE is a Firmware.MSE file.
+	MSE
+)
+
+func (f Format) String() string {
+	switch f {
+	case Raw:
+		return "raw data"
+	case FirmwareVolume:
+		return "firmware volume"
+	case IMG1:
+		return "IMG1 image"
+	case IMG1Encrypted:
+		return "encrypted IMG1 image"
+	case IMG1Firmware:
+		return "IMG1 firmware image"
+	case Image8900:
+		return "8900 image"
+	case IMG2:
+		return "IMG2 image"
+	case IMG3:
+		return "IMG3 image"
+	case MSE:
+		return "Firmware.MSE file"
+	}
+	return "UNKNOWN"
+}
+
+// IsIMG1 returns whether the format is any kind of IMG1 image.
+func (f Format) IsIMG1() bool {
+	return f == IMG1 || f == IMG1Encrypted || f == IMG1Firmware
+}
+
+// volumeSignatureOffset is the offset of the signature within an EFI
+// firmware volume header.
+const volumeSignatureOffset = 0x28
+
+// isVolume returns whether data starts with a firmware volume header.
+func isVolume(data []byte) bool {
+	return len(data) >= volumeSignatureOffset+4 && bytes.Equal(data[volumeSignatureOffset:volumeSignatureOffset+4], []byte("_FVH"))
+}
+
+// Sniff detects the format of data by its magic. Only as much is parsed as
+// needed to tell formats apart, so data might still fail to parse as the
+// detected format.
+func Sniff(data []byte) Format {
+	switch {
+	case img1.Is8900(data):
+		return Image8900
+	case isVolume(data):
+		return FirmwareVolume
+	case len(data) >= 0x104 && bytes.Equal(data[0x100:0x104], []byte("]ih[")):
+		return MSE
+	}
+	if _, err := img1.DetectVariant(data); err == nil {
+		// The magic and version only take 7 bytes, the format follows.
+		if len(data) > 7 && data[7] == img1.FormatSignedEncrypted {
+			return IMG1Encrypted
+		}
+		if img, err := img1.Parse(data); err == nil && isVolume(img.Body) {
+			return IMG1Firmware
+		}
+		return IMG1
+	}
+	if len(data) >= 4 {
+		switch img3.FourCC(binary.LittleEndian.Uint32(data)) {
+		case img2.Magic:
+			return IMG2
+		case img3.Magic:
+			return IMG3
+		}
+	}
+	return Raw
+}
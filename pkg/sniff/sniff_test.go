@@ -0,0 +1,49 @@
+package sniff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+	"github.com/freemyipod/wInd3x/pkg/img2"
+	"github.com/freemyipod/wInd3x/pkg/img3"
+)
+
+func TestSniff(t *testing.T) {
+	volume := make([]byte, 0x40)
+	copy(volume[volumeSignatureOffset:], "_FVH")
+	build := func(img interface{ Serialize() ([]byte, error) }) []byte {
+		data, err := img.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize: %v", err)
+		}
+		return data
+	}
+	firmware := make([]byte, 0x200)
+	copy(firmware[0x100:], "]ih[")
+	encrypted := img1.NewUnsigned(devices.Nano5, 0, []byte("payload"))
+	encrypted.Header.Format = img1.FormatSignedEncrypted
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		want Format
+	}{
+		{"empty", nil, Raw},
+		{"raw", bytes.Repeat([]byte{0xe5}, 0x100), Raw},
+		{"volume", volume, FirmwareVolume},
+		{"img1", build(img1.NewUnsigned(devices.Nano4, 0, []byte("payload"))), IMG1},
+		{"img1 encrypted", build(encrypted), IMG1Encrypted},
+		{"img1 truncated", []byte("87202.0"), IMG1},
+		{"img1 firmware", build(img1.NewUnsigned(devices.Nano4, 0, volume)), IMG1Firmware},
+		{"8900", build(img1.NewUnsigned8900([]byte("payload"))), Image8900},
+		{"img2", build(img2.New(img3.MakeFourCC("logo"), nil)), IMG2},
+		{"img3", build(img3.New(img3.MakeFourCC("ibot"), nil)), IMG3},
+		{"mse", firmware, MSE},
+	} {
+		if got := Sniff(test.data); got != test.want {
+			t.Errorf("%s: detected %s, wanted %s", test.name, got, test.want)
+		}
+	}
+}
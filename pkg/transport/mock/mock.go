@@ -0,0 +1,210 @@
+// Package mock implements an in-memory transport.Device, which simulates a
+// device in DFU mode for tests.
+package mock
+
+import (
+	"time"
+
+	"github.com/google/gousb"
+)
+
+// DFU requests and states, as defined by the DFU 1.1 specification. These are
+// duplicated from package dfu, so that its tests can use this package.
+const (
+	requestDetach    = 0
+	requestDnload    = 1
+	requestUpload    = 2
+	requestGetStatus = 3
+	requestClrStatus = 4
+	requestGetState  = 5
+	requestAbort     = 6
+
+	requestGetDescriptor = 6
+
+	stateIdle         = 2
+	stateDnloadSync   = 3
+	stateDnloadIdle   = 5
+	stateManifestSync = 6
+	stateManifest     = 7
+	stateUploadIdle   = 9
+	stateError        = 10
+
+	statusOK         = 0x00
+	statusStalledPkt = 0x0f
+)
+
+// Transfer is a control transfer made to a Device.
+type Transfer struct {
+	RequestType uint8
+	Request     uint8
+	Value       uint16
+	Index       uint16
+	// Data sent with the request, or received from the device.
+	Data []byte
+}
+
+// Device is an in-memory device in DFU mode, implementing the DFU state
+// machine closely enough for the dfu package.
+type Device struct {
+	// Strings returned by GetStringDescriptor.
+	Strings map[int]string
+	// Config is the configuration descriptor returned by GET_DESCRIPTOR.
+	Config []byte
+	// Upload is the data sent to the host with DFU_UPLOAD.
+	Upload []byte
+	// Received is the image downloaded with DFU_DNLOAD, once manifested.
+	Received []byte
+	// ManifestationTolerant devices return to dfuIDLE after manifestation,
+	// and then send the received image with DFU_UPLOAD.
+	ManifestationTolerant bool
+	// Fail, if set, is called before every control transfer, and the error
+	// it returns (if any) is returned instead of performing the transfer, eg.
+	// to simulate flaky devices.
+	Fail func(request uint8) error
+	// Handler, if set, handles requests which are not implemented by Device,
+	// eg. to simulate exploits.
+	Handler func(rType, request uint8, val, idx uint16, data []byte) (int, error)
+	// Transfers made to the device.
+	Transfers []Transfer
+	// Timeout last set with SetControlTimeout.
+	Timeout time.Duration
+	// Resets is the number of times Reset was called.
+	Resets int
+
+	state    uint8
+	status   uint8
+	download []byte
+	uploaded int
+}
+
+// New returns a Device in dfuIDLE.
+func New() *Device {
+	return &Device{
+		state:  stateIdle,
+		status: statusOK,
+	}
+}
+
+// State returns the current DFU state of the device.
+func (d *Device) State() uint8 {
+	return d.state
+}
+
+// stall moves the device into dfuERROR, as real devices do on unexpected
+// requests.
+func (d *Device) stall() (int, error) {
+	d.state = stateError
+	d.status = statusStalledPkt
+	return 0, gousb.ErrorPipe
+}
+
+func (d *Device) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if d.Fail != nil {
+		if err := d.Fail(request); err != nil {
+			return 0, err
+		}
+	}
+	n, err := d.control(rType, request, val, idx, data)
+	t := Transfer{RequestType: rType, Request: request, Value: val, Index: idx}
+	if rType&0x80 == 0 {
+		t.Data = append([]byte{}, data...)
+	} else if n > 0 {
+		t.Data = append([]byte{}, data[:n]...)
+	}
+	d.Transfers = append(d.Transfers, t)
+	return n, err
+}
+
+func (d *Device) control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	switch {
+	case rType == 0x80 && request == requestGetDescriptor && val>>8 == uint16(gousb.DescriptorTypeConfig):
+		return copy(data, d.Config), nil
+	case rType == 0x21 && request == requestDnload:
+		switch {
+		case len(data) > 0 && (d.state == stateIdle || d.state == stateDnloadIdle):
+			d.download = append(d.download, data...)
+			d.state = stateDnloadSync
+			return len(data), nil
+		case len(data) == 0 && d.state == stateDnloadIdle:
+			d.state = stateManifestSync
+			return 0, nil
+		}
+		return d.stall()
+	case rType == 0xa1 && request == requestUpload:
+		if d.state != stateIdle && d.state != stateUploadIdle {
+			return d.stall()
+		}
+		n := copy(data, d.Upload[d.uploaded:])
+		d.uploaded += n
+		d.state = stateUploadIdle
+		if n < len(data) {
+			d.uploaded = 0
+			d.state = stateIdle
+		}
+		return n, nil
+	case rType == 0xa1 && request == requestGetStatus:
+		switch d.state {
+		case stateDnloadSync:
+			d.state = stateDnloadIdle
+		case stateManifestSync:
+			d.Received = d.download
+			d.download = nil
+			d.state = stateManifest
+		case stateManifest:
+			if d.ManifestationTolerant {
+				d.Upload = d.Received
+				d.state = stateIdle
+			}
+		}
+		return copy(data, []byte{d.status, 0, 0, 0, d.state, 0}), nil
+	case rType == 0x21 && request == requestClrStatus:
+		if d.state == stateError {
+			d.state = stateIdle
+		}
+		d.status = statusOK
+		return 0, nil
+	case rType == 0xa1 && request == requestGetState:
+		return copy(data, []byte{d.state}), nil
+	case rType == 0x21 && request == requestDetach:
+		return 0, nil
+	case rType == 0x21 && request == requestAbort:
+		d.state = stateIdle
+		d.download = nil
+		d.uploaded = 0
+		return 0, nil
+	}
+	if d.Handler != nil {
+		return d.Handler(rType, request, val, idx, data)
+	}
+	return d.stall()
+}
+
+func (d *Device) GetStringDescriptor(index int) (string, error) {
+	s, ok := d.Strings[index]
+	if !ok {
+		return "", gousb.ErrorPipe
+	}
+	return s, nil
+}
+
+// Reset returns the device to dfuIDLE, and counts the reset in Resets.
+func (d *Device) Reset() error {
+	d.Resets += 1
+	d.state = stateIdle
+	d.status = statusOK
+	d.download = nil
+	d.uploaded = 0
+	return nil
+}
+
+func (d *Device) SetControlTimeout(timeout time.Duration) {
+	d.Timeout = timeout
+}
+
+func (d *Device) String() string {
+	return "mock"
+}
+
+func (d *Device) Close() error {
+	return nil
+}
@@ -0,0 +1,268 @@
+// Package remote forwards the operations of a transport.Device over a network
+// connection, so that a device plugged into one machine (eg. a headless SBC
+// running Serve) can be driven from another one (using Dial).
+//
+// The protocol is a simple exchange of JSON messages: the server first sends
+// a Hello describing the device, after which every request of the client is
+// answered by exactly one response. It is neither authenticated nor
+// encrypted, and should only be used on trusted networks.
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gousb"
+
+	"github.com/freemyipod/wInd3x/pkg/transport"
+)
+
+// Hello describes the device served, and is sent by the server when a client
+// connects.
+type Hello struct {
+	Vendor  uint16 `json:"vendor"`
+	Product uint16 `json:"product"`
+	// Kind of the device, as identified by the server.
+	Kind string `json:"kind"`
+	// Name of the device on the server, eg. its bus and address.
+	Name string `json:"name"`
+}
+
+const (
+	opControl = "control"
+	opString  = "string"
+	opReset   = "reset"
+	opTimeout = "timeout"
+	opClose   = "close"
+)
+
+// maxControlLength is the maximum length of control transfers, as given by
+// wLength.
+const maxControlLength = 0xffff
+
+type request struct {
+	Op          string `json:"op"`
+	RequestType uint8  `json:"request_type,omitempty"`
+	Request     uint8  `json:"request,omitempty"`
+	Value       uint16 `json:"value,omitempty"`
+	Index       uint16 `json:"index,omitempty"`
+	// Data sent with OUT control transfers.
+	Data []byte `json:"data,omitempty"`
+	// Length of IN control transfers.
+	Length int `json:"length,omitempty"`
+	// StringIndex of string descriptors.
+	StringIndex int           `json:"string_index,omitempty"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+}
+
+type response struct {
+	N      int        `json:"n,omitempty"`
+	Data   []byte     `json:"data,omitempty"`
+	String string     `json:"string,omitempty"`
+	Error  *wireError `json:"error,omitempty"`
+}
+
+// wireError is an error as sent over the network. gousb errors are kept
+// distinct, so that clients can still tell eg. timeouts and transient errors
+// apart.
+type wireError struct {
+	USB     *int   `json:"usb,omitempty"`
+	Status  *int   `json:"status,omitempty"`
+	Message string `json:"message"`
+}
+
+func encodeError(err error) *wireError {
+	if err == nil {
+		return nil
+	}
+	res := &wireError{Message: err.Error()}
+	var usbErr gousb.Error
+	var status gousb.TransferStatus
+	switch {
+	case errors.As(err, &usbErr):
+		code := int(usbErr)
+		res.USB = &code
+	case errors.As(err, &status):
+		code := int(status)
+		res.Status = &code
+	}
+	return res
+}
+
+func (w *wireError) err() error {
+	switch {
+	case w == nil:
+		return nil
+	case w.USB != nil:
+		return gousb.Error(*w.USB)
+	case w.Status != nil:
+		return gousb.TransferStatus(*w.Status)
+	}
+	return errors.New(w.Message)
+}
+
+// Serve serves a device to a client connected over conn, until the client
+// closes the device or disconnects.
+func Serve(conn io.ReadWriter, dev transport.Device, hello Hello) error {
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+	if err := enc.Encode(hello); err != nil {
+		return fmt.Errorf("sending hello: %w", err)
+	}
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("receiving request: %w", err)
+		}
+		if err := enc.Encode(handle(dev, &req)); err != nil {
+			return fmt.Errorf("sending response: %w", err)
+		}
+		if req.Op == opClose {
+			return nil
+		}
+	}
+}
+
+func handle(dev transport.Device, req *request) *response {
+	res := &response{}
+	var err error
+	switch req.Op {
+	case opControl:
+		data := req.Data
+		if req.RequestType&0x80 != 0 {
+			if req.Length < 0 || req.Length > maxControlLength {
+				err = fmt.Errorf("invalid control transfer length %d", req.Length)
+				break
+			}
+			data = make([]byte, req.Length)
+		}
+		res.N, err = dev.Control(req.RequestType, req.Request, req.Value, req.Index, data)
+		if req.RequestType&0x80 != 0 && res.N > 0 && res.N <= len(data) {
+			res.Data = data[:res.N]
+		}
+	case opString:
+		res.String, err = dev.GetStringDescriptor(req.StringIndex)
+	case opReset:
+		err = dev.Reset()
+	case opTimeout:
+		dev.SetControlTimeout(req.Timeout)
+	case opClose:
+	default:
+		err = fmt.Errorf("unknown operation %q", req.Op)
+	}
+	res.Error = encodeError(err)
+	return res
+}
+
+// Client is a transport.Device served by a remote server.
+type Client struct {
+	Hello Hello
+
+	addr string
+	conn io.ReadWriteCloser
+	enc  *json.Encoder
+	dec  *json.Decoder
+	mu   sync.Mutex
+}
+
+// Dial connects to a server at addr (host:port).
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient returns a client talking to a server over an established
+// connection, named addr in logs.
+func NewClient(conn io.ReadWriteCloser, addr string) (*Client, error) {
+	c := &Client{
+		addr: addr,
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(conn),
+	}
+	if err := c.dec.Decode(&c.Hello); err != nil {
+		return nil, fmt.Errorf("receiving hello: %w", err)
+	}
+	return c, nil
+}
+
+func (c *Client) call(req *request) (*response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(req); err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+	var res response
+	if err := c.dec.Decode(&res); err != nil {
+		return nil, fmt.Errorf("receiving response: %w", err)
+	}
+	return &res, res.Error.err()
+}
+
+func (c *Client) Control(rType, bRequest uint8, val, idx uint16, data []byte) (int, error) {
+	req := &request{
+		Op:          opControl,
+		RequestType: rType,
+		Request:     bRequest,
+		Value:       val,
+		Index:       idx,
+	}
+	if rType&0x80 != 0 {
+		req.Length = len(data)
+	} else {
+		req.Data = data
+	}
+	res, err := c.call(req)
+	if res == nil {
+		return 0, err
+	}
+	if rType&0x80 != 0 {
+		copy(data, res.Data)
+	}
+	return res.N, err
+}
+
+func (c *Client) GetStringDescriptor(index int) (string, error) {
+	res, err := c.call(&request{Op: opString, StringIndex: index})
+	if err != nil {
+		return "", err
+	}
+	return res.String, nil
+}
+
+func (c *Client) Reset() error {
+	_, err := c.call(&request{Op: opReset})
+	return err
+}
+
+func (c *Client) SetControlTimeout(timeout time.Duration) {
+	// Errors are surfaced by the next transfer, as the connection is then
+	// broken as well.
+	c.call(&request{Op: opTimeout, Timeout: timeout})
+}
+
+func (c *Client) String() string {
+	return fmt.Sprintf("%s/%s", c.addr, c.Hello.Name)
+}
+
+// Close releases the device on the server, and disconnects.
+func (c *Client) Close() error {
+	c.call(&request{Op: opClose})
+	return c.conn.Close()
+}
@@ -0,0 +1,112 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gousb"
+
+	"github.com/freemyipod/wInd3x/pkg/dfu"
+	"github.com/freemyipod/wInd3x/pkg/transport/mock"
+)
+
+func serve(t *testing.T, d *mock.Device) *Client {
+	t.Helper()
+	server, client := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(server, d, Hello{Vendor: 0x05ac, Product: 0x1225, Kind: "n4g", Name: "1.5"})
+		server.Close()
+	}()
+	c, err := NewClient(client, "pipe")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return c
+}
+
+func TestSendImage(t *testing.T) {
+	d := mock.New()
+	c := serve(t, d)
+	if want, got := "n4g", c.Hello.Kind; want != got {
+		t.Errorf("kind: wanted %q, got %q", want, got)
+	}
+	image := bytes.Repeat([]byte{0x42}, 0x900)
+	if err := dfu.SendImage(context.Background(), c, image, dfu.ProtoVersion2); err != nil {
+		t.Fatalf("SendImage: %v", err)
+	}
+	if !bytes.Equal(image, d.Received[:len(image)]) {
+		t.Errorf("received image differs")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	d := mock.New()
+	c := serve(t, d)
+	d.Fail = func(request uint8) error {
+		return gousb.ErrorTimeout
+	}
+	// The exploit relies on telling timeouts apart.
+	if _, err := c.Control(0xa1, 2, 0, 0, make([]byte, 0x40)); err != gousb.ErrorTimeout {
+		t.Errorf("wanted %v, got %v", gousb.ErrorTimeout, err)
+	}
+	d.Fail = nil
+
+	d.Strings = map[int]string{2: "haxed dfu"}
+	if s, err := c.GetStringDescriptor(2); err != nil || s != "haxed dfu" {
+		t.Errorf("GetStringDescriptor: got %q, %v", s, err)
+	}
+	c.SetControlTimeout(time.Second)
+	if want, got := time.Second, d.Timeout; want != got {
+		t.Errorf("timeout: wanted %s, got %s", want, got)
+	}
+}
+
+func TestInvalidLength(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(server, mock.New(), Hello{})
+		server.Close()
+	}()
+	enc := json.NewEncoder(client)
+	dec := json.NewDecoder(client)
+	var hello Hello
+	if err := dec.Decode(&hello); err != nil {
+		t.Fatalf("receiving hello: %v", err)
+	}
+	for _, length := range []int{-1, 0x10000, 1 << 40} {
+		if err := enc.Encode(&request{Op: opControl, RequestType: 0xa1, Length: length}); err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+		var res response
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("receiving response: %v", err)
+		}
+		if err := res.Error.err(); err == nil || !strings.Contains(err.Error(), "length") {
+			t.Errorf("length %d: wanted length error, got %v", length, err)
+		}
+	}
+	if err := enc.Encode(&request{Op: opClose}); err != nil {
+		t.Fatalf("sending close: %v", err)
+	}
+	var res response
+	if err := dec.Decode(&res); err != nil {
+		t.Fatalf("receiving close response: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package transport abstracts the USB operations used to talk to devices in
+// DFU mode, so that the dfu and exploit packages can be used with backends
+// other than gousb, eg. the in-memory device in package mock.
+package transport
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/gousb"
+)
+
+// Device is a USB device in DFU mode. All communication with the bootrom
+// (and the exploit running on it) happens through control transfers.
+type Device interface {
+	// Control performs a control transfer, returning the number of bytes
+	// transferred. Errors should be gousb errors where applicable, so that
+	// transient ones can be retried.
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+	// GetStringDescriptor retrieves and decodes a string descriptor.
+	GetStringDescriptor(index int) (string, error)
+	// Reset performs a USB port reset, after which the device re-enumerates
+	// and must be opened again.
+	Reset() error
+	// SetControlTimeout sets the timeout of subsequent control transfers.
+	SetControlTimeout(timeout time.Duration)
+	// String identifies the device in logs.
+	String() string
+	Close() error
+}
+
+// GoUSB is a Device backed by gousb.
+type GoUSB struct {
+	*gousb.Device
+}
+
+func (g GoUSB) SetControlTimeout(timeout time.Duration) {
+	g.ControlTimeout = timeout
+}
+
+func (g GoUSB) String() string {
+	return fmt.Sprintf("%d.%d", g.Desc.Bus, g.Desc.Address)
+}
+
+// Bulk is a Device which also takes data over a bulk OUT endpoint, eg. a
+// device in recovery mode, to which files are uploaded that way.
+type Bulk interface {
+	Device
+	// WriteBulk writes data to the bulk OUT endpoint, returning the number of
+	// bytes written.
+	WriteBulk(data []byte) (int, error)
+}
+
+// GoUSBBulk is a Bulk backed by gousb, with the interface of its bulk OUT
+// endpoint claimed.
+type GoUSBBulk struct {
+	GoUSB
+	cfg  *gousb.Config
+	intf *gousb.Interface
+	out  *gousb.OutEndpoint
+}
+
+// OpenBulk claims an interface (and alternate setting) of a device within a
+// configuration, and returns a Bulk writing to one of its OUT endpoints.
+func OpenBulk(dev *gousb.Device, config, intf, alt, ep int) (*GoUSBBulk, error) {
+	cfg, err := dev.Config(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not set configuration %d: %w", config, err)
+	}
+	i, err := cfg.Interface(intf, alt)
+	if err != nil {
+		cfg.Close()
+		return nil, fmt.Errorf("could not claim interface %d: %w", intf, err)
+	}
+	out, err := i.OutEndpoint(ep)
+	if err != nil {
+		i.Close()
+		cfg.Close()
+		return nil, fmt.Errorf("could not open endpoint %d: %w", ep, err)
+	}
+	return &GoUSBBulk{GoUSB: GoUSB{Device: dev}, cfg: cfg, intf: i, out: out}, nil
+}
+
+func (g *GoUSBBulk) WriteBulk(data []byte) (int, error) {
+	return g.out.Write(data)
+}
+
+// Close releases the claimed interface and closes the device.
+func (g *GoUSBBulk) Close() error {
+	g.intf.Close()
+	g.cfg.Close()
+	return g.Device.Close()
+}
@@ -0,0 +1,151 @@
+// Package usblog records USB transfers made to devices as JSON lines, so that
+// protocol issues and exploit behaviour can be analyzed after the fact.
+//
+// All transfers made by wInd3x go through Control, StringDescriptor and Bulk,
+// which forward to the transport and record the transfer if logging was
+// started.
+package usblog
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/gousb"
+
+	"github.com/freemyipod/wInd3x/pkg/transport"
+)
+
+// Record of a single transfer.
+type Record struct {
+	Time time.Time `json:"time"`
+	// Device identifies the device, eg. its bus and address (1.5).
+	Device string `json:"device"`
+	// Direction is 'in' (device to host) or 'out' (host to device).
+	Direction   string `json:"direction"`
+	RequestType uint8  `json:"request_type"`
+	Request     uint8  `json:"request"`
+	Value       uint16 `json:"value"`
+	Index       uint16 `json:"index"`
+	// Bulk is set for bulk transfers, which have no request fields.
+	Bulk bool `json:"bulk,omitempty"`
+	// Length requested, and actually transferred.
+	Length      int `json:"length"`
+	Transferred int `json:"transferred"`
+	// Data sent or received, as hex. For string descriptors, this is the
+	// UTF-8 encoding of the decoded string.
+	Data       string `json:"data"`
+	DurationUS int64  `json:"duration_us"`
+	Error      string `json:"error,omitempty"`
+}
+
+var (
+	mu  sync.Mutex
+	enc *json.Encoder
+)
+
+// Start logging all transfers to w, until Stop is called.
+func Start(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	enc = json.NewEncoder(w)
+}
+
+// Stop logging transfers.
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+	enc = nil
+}
+
+func log(r *Record) {
+	mu.Lock()
+	defer mu.Unlock()
+	if enc == nil {
+		return
+	}
+	// Logging is best effort, and should never break a transfer.
+	enc.Encode(r)
+}
+
+func enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enc != nil
+}
+
+func newRecord(usb transport.Device, rType, request uint8, val, idx uint16, length int, start time.Time, err error) *Record {
+	r := &Record{
+		Time:        start,
+		Direction:   "out",
+		RequestType: rType,
+		Request:     request,
+		Value:       val,
+		Index:       idx,
+		Length:      length,
+		DurationUS:  time.Since(start).Microseconds(),
+	}
+	if rType&0x80 != 0 {
+		r.Direction = "in"
+	}
+	if usb != nil {
+		r.Device = usb.String()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Control performs a control transfer, like transport.Device.Control, and
+// records it.
+func Control(usb transport.Device, rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if !enabled() {
+		return usb.Control(rType, request, val, idx, data)
+	}
+	start := time.Now()
+	n, err := usb.Control(rType, request, val, idx, data)
+	r := newRecord(usb, rType, request, val, idx, len(data), start, err)
+	r.Transferred = n
+	if r.Direction == "out" {
+		r.Data = hex.EncodeToString(data)
+	} else if n > 0 && n <= len(data) {
+		r.Data = hex.EncodeToString(data[:n])
+	}
+	log(r)
+	return n, err
+}
+
+// StringDescriptor retrieves a string descriptor, like
+// transport.Device.GetStringDescriptor, and records it as a GET_DESCRIPTOR
+// request.
+func StringDescriptor(usb transport.Device, index int) (string, error) {
+	if !enabled() {
+		return usb.GetStringDescriptor(index)
+	}
+	start := time.Now()
+	s, err := usb.GetStringDescriptor(index)
+	r := newRecord(usb, 0x80, 0x06, uint16(gousb.DescriptorTypeString)<<8|uint16(index), 0, 0, start, err)
+	r.Transferred = len(s)
+	r.Data = hex.EncodeToString([]byte(s))
+	log(r)
+	return s, err
+}
+
+// Bulk writes data to the bulk OUT endpoint of a device, like
+// transport.Bulk.WriteBulk, and records it.
+func Bulk(usb transport.Bulk, data []byte) (int, error) {
+	if !enabled() {
+		return usb.WriteBulk(data)
+	}
+	start := time.Now()
+	n, err := usb.WriteBulk(data)
+	r := newRecord(usb, 0, 0, 0, 0, len(data), start, err)
+	r.Bulk = true
+	r.Transferred = n
+	r.Data = hex.EncodeToString(data)
+	log(r)
+	return n, err
+}
@@ -0,0 +1,36 @@
+package usblog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	Start(&buf)
+	defer Stop()
+
+	r := newRecord(nil, 0xa1, 3, 0, 0, 6, time.Now(), errors.New("pipe error"))
+	r.Transferred = 6
+	log(r)
+	Stop()
+	log(r)
+
+	var got Record
+	dec := json.NewDecoder(&buf)
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if want, got := "in", got.Direction; want != got {
+		t.Errorf("Direction: wanted %q, got %q", want, got)
+	}
+	if want, got := "pipe error", got.Error; want != got {
+		t.Errorf("Error: wanted %q, got %q", want, got)
+	}
+	if dec.More() {
+		t.Errorf("record logged after Stop")
+	}
+}
@@ -0,0 +1,106 @@
+// Package wtf implements WTF ("What's The Firmware") images: the recovery
+// mode loader sent to the bootrom over DFU, which in turn accepts the main
+// firmware. A WTF is an IMG1 image whose body is a flat ARM binary (the
+// payload), loaded by the bootrom at the DFU load address.
+//
+// Stock WTFs are encrypted and have to be decrypted (eg. with the decrypt
+// command) before their payload can be modified. Modified WTFs are rebuilt as
+// unsigned images, to be run through haxed DFU.
+package wtf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/freemyipod/wInd3x/pkg/binpatch"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+)
+
+// WTF is a parsed, plaintext WTF image.
+type WTF struct {
+	// Image is the container the WTF was parsed from.
+	Image *img1.Image
+	// Payload is the ARM binary loaded by the bootrom. It can be modified
+	// freely before calling Serialize.
+	Payload []byte
+}
+
+// Parse a WTF image. Encrypted images return an error wrapping
+// img1.ErrEncrypted.
+func Parse(data []byte) (*WTF, error) {
+	img, err := img1.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := img.Plaintext()
+	if err != nil {
+		return nil, fmt.Errorf("WTF must be decrypted first: %w", err)
+	}
+	return &WTF{
+		Image:   img,
+		Payload: append([]byte{}, body...),
+	}, nil
+}
+
+// LoadAddress returns the address at which the payload is loaded.
+func (w *WTF) LoadAddress() (uint32, error) {
+	if w.Image.Variant.LoadAddress == 0 {
+		return 0, fmt.Errorf("load address of %s images unknown", w.Image.Variant)
+	}
+	return w.Image.Variant.LoadAddress, nil
+}
+
+// offset returns the offset within the payload of n bytes at a given address.
+func (w *WTF) offset(addr uint32, n int) (int, error) {
+	load, err := w.LoadAddress()
+	if err != nil {
+		return 0, err
+	}
+	if addr < load || uint64(addr-load)+uint64(n) > uint64(len(w.Payload)) {
+		return 0, fmt.Errorf("0x%x bytes at 0x%08x outside of payload at 0x%08x-0x%08x", n, addr, load, load+uint32(len(w.Payload)))
+	}
+	return int(addr - load), nil
+}
+
+// Peek returns n bytes of the payload at a given address.
+func (w *WTF) Peek(addr uint32, n int) ([]byte, error) {
+	offset, err := w.offset(addr, n)
+	if err != nil {
+		return nil, err
+	}
+	return w.Payload[offset : offset+n], nil
+}
+
+// Poke overwrites the payload at a given address with data, eg. to patch a
+// single instruction.
+func (w *WTF) Poke(addr uint32, data []byte) error {
+	offset, err := w.offset(addr, len(data))
+	if err != nil {
+		return err
+	}
+	copy(w.Payload[offset:], data)
+	return nil
+}
+
+// ApplyPatch applies an IPS or BPS patch to the payload.
+func (w *WTF) ApplyPatch(patch []byte) error {
+	res, err := binpatch.Apply(w.Payload, patch)
+	if err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return errors.New("patch results in empty payload")
+	}
+	w.Payload = res
+	return nil
+}
+
+// Serialize rebuilds the WTF as an unsigned plaintext image around the
+// current payload, keeping the header variant, entrypoint and security epoch
+// of the original image.
+func (w *WTF) Serialize() ([]byte, error) {
+	img := img1.NewUnsigned(w.Image.Kind, w.Image.Header.Entrypoint, w.Payload)
+	img.Variant = w.Image.Variant
+	img.Header.SecurityEpoch = w.Image.Header.SecurityEpoch
+	return img.Serialize()
+}
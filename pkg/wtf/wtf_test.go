@@ -0,0 +1,68 @@
+package wtf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/freemyipod/wInd3x/pkg/binpatch"
+	"github.com/freemyipod/wInd3x/pkg/devices"
+	"github.com/freemyipod/wInd3x/pkg/img1"
+)
+
+func TestWTF(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xaa}, 0x40)
+	img := img1.NewUnsigned(devices.Nano4, 0x10, payload)
+	img.Header.SecurityEpoch = 3
+	data, err := img.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	w, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := w.Poke(0x22000004, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatalf("Poke: %v", err)
+	}
+	if err := w.Poke(0x2200003e, []byte{1, 2, 3, 4}); err == nil {
+		t.Errorf("Poke past end of payload succeeded")
+	}
+	if err := w.Poke(0x21fffffe, []byte{1, 2}); err == nil {
+		t.Errorf("Poke before payload succeeded")
+	}
+	modified := append([]byte{}, w.Payload...)
+	modified[0x20] = 0x55
+	if err := w.ApplyPatch(binpatch.CreateBPS(w.Payload, modified)); err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	got, err := w.Peek(0x22000004, 4)
+	if err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	if want := []byte{1, 2, 3, 4}; !bytes.Equal(want, got) {
+		t.Errorf("peeked %x, wanted %x", got, want)
+	}
+
+	data, err = w.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	w2, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !bytes.Equal(w2.Payload, modified) {
+		t.Errorf("payload differs after rebuild")
+	}
+	if h := w2.Image.Header; h.Entrypoint != 0x10 || h.SecurityEpoch != 3 {
+		t.Errorf("header not preserved: entrypoint 0x%x, epoch %d", h.Entrypoint, h.SecurityEpoch)
+	}
+
+	img.Header.Format = img1.FormatSignedEncrypted
+	data, _ = img.Serialize()
+	if _, err := Parse(data); !errors.Is(err, img1.ErrEncrypted) {
+		t.Errorf("Parse of encrypted WTF returned %v, wanted ErrEncrypted", err)
+	}
+}
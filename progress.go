@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressBar renders transfer progress, with rate and ETA, on a single
+// terminal line.
+type progressBar struct {
+	w     io.Writer
+	start time.Time
+	last  time.Time
+	done  bool
+}
+
+// newProgressBar returns a progress bar rendering to stderr, or nil if stderr
+// is not a terminal.
+func newProgressBar() *progressBar {
+	st, err := os.Stderr.Stat()
+	if err != nil || st.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progressBar{w: os.Stderr, start: time.Now()}
+}
+
+// update renders the progress bar, at most every 100ms unless the transfer is
+// complete. It can be passed as a dfu.Progress.
+func (p *progressBar) update(sent, total int) {
+	if p == nil || p.done {
+		return
+	}
+	now := time.Now()
+	if sent < total && now.Sub(p.last) < 100*time.Millisecond {
+		return
+	}
+	p.last = now
+	fmt.Fprintf(p.w, "\r%s", p.render(sent, total, now.Sub(p.start)))
+	if sent >= total {
+		fmt.Fprintf(p.w, "\n")
+		p.done = true
+	}
+}
+
+// render formats a progress line, eg.:
+// [=========>          ]  48% 0x12000/0x25000 86.2 KiB/s ETA 0:01
+func (p *progressBar) render(sent, total int, elapsed time.Duration) string {
+	const width = 20
+	frac := 1.0
+	if total > 0 {
+		frac = float64(sent) / float64(total)
+	}
+	filled := int(frac * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	rate := 0.0
+	if s := elapsed.Seconds(); s > 0 {
+		rate = float64(sent) / s
+	}
+	eta := "?"
+	if rate > 0 {
+		left := time.Duration(float64(total-sent) / rate * float64(time.Second))
+		eta = fmt.Sprintf("%d:%02d", int(left.Minutes()), int(left.Seconds())%60)
+	}
+	return fmt.Sprintf("[%s] %3d%% 0x%x/0x%x %.1f KiB/s ETA %s", bar, int(frac*100), sent, total, rate/1024, eta)
+}